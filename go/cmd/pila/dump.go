@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// dumpArgs gives the usage and argument counts of the dump commands.
+var dumpArgs = map[string]struct {
+	usage    string
+	min, max int
+}{
+	"dumpprivkey":    {"dumpprivkey <address>", 1, 1},
+	"dumpwallet":     {"dumpwallet <file>", 1, 1},
+	"dumpwalletseed": {"dumpwalletseed", 0, 0},
+	"importprivkey":  {"importprivkey <wif> [label]", 1, 2},
+	"importwallet":   {"importwallet <file>", 1, 1},
+	"backupwallet":   {"backupwallet <destination>", 1, 1},
+}
+
+// dumpCommand implements the key export, import and backup commands
+// against the wallet at walletPath. Imports have no chain to rescan here;
+// the wallet rescans from the birth of the imported keys when it next
+// syncs.
+func dumpCommand(name, walletPath, passphrase string, args []string) error {
+	if n := dumpArgs[name]; len(args) < n.min || len(args) > n.max {
+		return errors.New("usage: " + n.usage)
+	}
+	w, closeWallet, err := openWallet(walletPath, passphrase)
+	if err != nil {
+		return err
+	}
+	defer closeWallet()
+
+	switch name {
+	case "dumpprivkey":
+		wif, err := w.DumpPrivKey(args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Println(wif)
+	case "dumpwalletseed":
+		seed, err := w.DumpWalletSeed()
+		if err != nil {
+			return err
+		}
+		fmt.Println(seed)
+	case "dumpwallet":
+		f, err := os.OpenFile(args[0], os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if err != nil {
+			return err
+		}
+		if err := w.DumpWallet(f); err != nil {
+			f.Close()
+			os.Remove(args[0])
+			return err
+		}
+		return f.Close()
+	case "importprivkey":
+		label := ""
+		if len(args) == 2 {
+			label = args[1]
+		}
+		return w.ImportPrivKey(args[0], label, nil, time.Time{})
+	case "importwallet":
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return w.ImportWallet(f, nil)
+	case "backupwallet":
+		path, err := w.Backup(args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Println(path)
+	}
+	return nil
+}
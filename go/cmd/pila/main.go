@@ -4,6 +4,7 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"strings"
 
 	"pila/pkg/coin"
 	"pila/pkg/database"
@@ -12,8 +13,21 @@ import (
 func main() {
 	list := flag.Bool("list", false, "list blocks")
 	dbPath := flag.String("db", "./db", "database path")
+	walletPath := flag.String("wallet", "./wallet", "wallet database path")
+	stdinPass := flag.Bool("stdinpass", false, "read the wallet passphrase from the first line of stdin instead of $"+passphraseEnv)
 	flag.Parse()
 
+	passphrase, err := walletPassphrase(*stdinPass)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if ran, err := runCommand(*walletPath, passphrase); ran {
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	db, err := database.Open(*dbPath)
 	if err != nil {
 		log.Fatal(err)
@@ -34,7 +48,7 @@ func main() {
 	tx := coin.Transaction{
 		Version: 1,
 		Inputs: []coin.TxIn{{
-			PreviousOut: coin.PointOut{Hash: "prev", Index: 0},
+			PreviousOut: coin.PointOut{Hash: coin.Transaction{}.Hash(), Index: 0},
 			ScriptSig:   []byte("sig"),
 			Sequence:    0xffffffff,
 		}},
@@ -47,7 +61,7 @@ func main() {
 	blk := coin.Block{
 		Header: coin.BlockHeader{
 			Version:   1,
-			PrevHash:  "0",
+			PrevHash:  strings.Repeat("0", 64),
 			Timestamp: 0,
 			Bits:      0,
 			Nonce:     0,
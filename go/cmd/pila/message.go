@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+
+	"pila/pkg/coin/keys"
+	"pila/pkg/wallet"
+)
+
+// passphraseEnv names the environment variable holding the wallet
+// passphrase. It is not taken as a flag, which would show it in the
+// process list and the shell history.
+const passphraseEnv = "PILA_WALLET_PASSPHRASE"
+
+// walletPassphrase returns the wallet passphrase: the first line of stdin
+// with fromStdin, otherwise $PILA_WALLET_PASSPHRASE, which may be empty.
+func walletPassphrase(fromStdin bool) (string, error) {
+	if !fromStdin {
+		return os.Getenv(passphraseEnv), nil
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", fmt.Errorf("reading the passphrase from stdin: %w", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// openWallet opens the existing wallet at walletPath, unlocking it with
+// passphrase when one is given, and keeps its key pool topped up. The
+// returned function stops the refill, locks the wallet and closes it.
+func openWallet(walletPath, passphrase string) (*wallet.Wallet, func(), error) {
+	// Opening a mistyped path would silently create an empty wallet.
+	if _, err := os.Stat(walletPath); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil, fmt.Errorf("no wallet at %s", walletPath)
+		}
+		return nil, nil, err
+	}
+	db, err := wallet.OpenDB(walletPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	w, err := wallet.New(db, wallet.DefaultConfig())
+	if err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+	stop, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		w.Run(stop)
+		close(done)
+	}()
+	closeWallet := func() {
+		close(stop)
+		<-done
+		w.Lock()
+		db.Close()
+	}
+	if passphrase != "" {
+		if err := w.Unlock(passphrase, time.Minute); err != nil {
+			closeWallet()
+			return nil, nil, err
+		}
+	}
+	return w, closeWallet, nil
+}
+
+// signMessage implements "signmessage <address> <message>" against the
+// wallet at walletPath, unlocking it with passphrase when one is given.
+func signMessage(walletPath, passphrase string, args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: signmessage <address> <message>")
+	}
+	w, closeWallet, err := openWallet(walletPath, passphrase)
+	if err != nil {
+		return err
+	}
+	defer closeWallet()
+	sig, err := w.SignMessage(args[0], args[1])
+	if err != nil {
+		return err
+	}
+	fmt.Println(sig)
+	return nil
+}
+
+// verifyMessage implements "verifymessage <address> <signature> <message>".
+func verifyMessage(args []string) error {
+	if len(args) != 3 {
+		return errors.New("usage: verifymessage <address> <signature> <message>")
+	}
+	ok, err := keys.VerifyMessage(args[0], args[1], args[2])
+	if err != nil {
+		return err
+	}
+	fmt.Println(ok)
+	return nil
+}
+
+// runCommand runs the subcommand named by the first argument, reporting
+// whether there was one.
+func runCommand(walletPath, passphrase string) (bool, error) {
+	switch flag.Arg(0) {
+	case "signmessage":
+		return true, signMessage(walletPath, passphrase, flag.Args()[1:])
+	case "verifymessage":
+		return true, verifyMessage(flag.Args()[1:])
+	case "dumpprivkey", "dumpwallet", "dumpwalletseed", "importprivkey", "importwallet", "backupwallet":
+		return true, dumpCommand(flag.Arg(0), walletPath, passphrase, flag.Args()[1:])
+	}
+	return false, nil
+}
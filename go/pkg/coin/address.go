@@ -1,7 +1,9 @@
 package coin
 
-// Placeholder identifier types. Real implementation would use cryptographic hashes.
+// IDKey is the Hash160 of a serialized public key (see keys.PublicKey.ID).
 type IDKey [20]byte
+
+// IDScript is the Hash160 of a redeem script.
 type IDScript [20]byte
 
 type None struct{}
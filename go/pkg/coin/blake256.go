@@ -0,0 +1,201 @@
+package coin
+
+import (
+	"encoding/binary"
+	"hash"
+	"runtime"
+	"sync"
+)
+
+const (
+	// Blake256Size is the size of a BLAKE-256 digest in bytes.
+	Blake256Size = 32
+	// Blake256BlockSize is the block size of BLAKE-256 in bytes.
+	Blake256BlockSize = 64
+)
+
+// blake256 is the streaming state of the 8-round BLAKE-256 hash.
+type blake256 struct {
+	h [8]uint32
+	s [4]uint32
+	// t counts the message bits compressed so far.
+	t  uint64
+	x  [Blake256BlockSize]byte
+	nx int
+}
+
+// NewBlake256 returns a hash.Hash computing the 8-round BLAKE-256 digest
+// of Blake256EightRound.
+func NewBlake256() hash.Hash {
+	d := new(blake256)
+	d.Reset()
+	return d
+}
+
+func (d *blake256) Size() int { return Blake256Size }
+
+func (d *blake256) BlockSize() int { return Blake256BlockSize }
+
+func (d *blake256) Reset() {
+	*d = blake256{h: [8]uint32{
+		0x6a09e667, 0xbb67ae85, 0x3c6ef372, 0xa54ff53a,
+		0x510e527f, 0x9b05688c, 0x1f83d9ab, 0x5be0cd19,
+	}}
+}
+
+// Write absorbs p. Every block is compressed as soon as it is full, so
+// the padding always goes into a block of its own after them.
+func (d *blake256) Write(p []byte) (int, error) {
+	n := len(p)
+	if d.nx > 0 {
+		c := copy(d.x[d.nx:], p)
+		d.nx += c
+		p = p[c:]
+		if d.nx < Blake256BlockSize {
+			return n, nil
+		}
+		d.t += 512
+		compress8(&d.h, &d.s, d.x[:], d.t)
+		d.nx = 0
+	}
+	for len(p) >= Blake256BlockSize {
+		d.t += 512
+		compress8(&d.h, &d.s, p[:Blake256BlockSize], d.t)
+		p = p[Blake256BlockSize:]
+	}
+	d.nx = copy(d.x[:], p)
+	return n, nil
+}
+
+func (d *blake256) Sum(b []byte) []byte {
+	d0 := *d
+	sum := d0.checkSum()
+	return append(b, sum[:]...)
+}
+
+// checkSum pads the buffered tail and returns the digest. It leaves d
+// finalized.
+func (d *blake256) checkSum() [32]byte {
+	data := d.x[:d.nx]
+	block := &d.x
+	counter := d.t
+
+	// Finalize with padding per the BLAKE specification.
+	msgBits := counter + uint64(len(data))<<3
+	if len(data) < 55 {
+		block[len(data)] = 0x80
+		for i := len(data) + 1; i < 55; i++ {
+			block[i] = 0
+		}
+		block[55] = 0x01
+		binary.BigEndian.PutUint64(block[56:], msgBits)
+		compress8(&d.h, &d.s, block[:], msgBits)
+	} else {
+		block[len(data)] = 0x80
+		for i := len(data) + 1; i < 64; i++ {
+			block[i] = 0
+		}
+		counter += uint64(64-len(data)) << 3
+		compress8(&d.h, &d.s, block[:], counter)
+
+		for i := 0; i < 55; i++ {
+			block[i] = 0
+		}
+		block[55] = 0x01
+		binary.BigEndian.PutUint64(block[56:], msgBits)
+		compress8(&d.h, &d.s, block[:], 0)
+	}
+
+	var out [32]byte
+	for i, v := range d.h {
+		binary.BigEndian.PutUint32(out[4*i:], v)
+	}
+	return out
+}
+
+// Blake256Midstate is the BLAKE-256 state after the leading whole blocks
+// of a message. Hashing messages that only differ past that prefix, like
+// block headers at different nonces, then costs the tail blocks alone.
+type Blake256Midstate struct {
+	h [8]uint32
+	t uint64
+}
+
+// NewBlake256Midstate compresses prefix, whose length must be a multiple
+// of Blake256BlockSize.
+func NewBlake256Midstate(prefix []byte) Blake256Midstate {
+	if len(prefix)%Blake256BlockSize != 0 {
+		panic("blake256: midstate prefix is not a whole number of blocks")
+	}
+	var d blake256
+	d.Reset()
+	d.Write(prefix)
+	return Blake256Midstate{h: d.h, t: d.t}
+}
+
+// Sum returns the digest of the prefix followed by tail.
+func (m *Blake256Midstate) Sum(tail []byte) [32]byte {
+	d := blake256{h: m.h, t: m.t}
+	d.Write(tail)
+	return d.checkSum()
+}
+
+// Blake256Batch hashes msgs[i] into out[i] with Blake256EightRound. Large
+// batches, like a run of headers during sync, are spread over the CPUs.
+func Blake256Batch(out [][32]byte, msgs [][]byte) {
+	const minChunk = 256
+	workers := runtime.GOMAXPROCS(0)
+	if n := len(msgs) / minChunk; n < workers {
+		workers = n
+	}
+	if workers <= 1 {
+		for i, m := range msgs {
+			out[i] = Blake256EightRound(m)
+		}
+		return
+	}
+	chunk := (len(msgs) + workers - 1) / workers
+	var wg sync.WaitGroup
+	for start := 0; start < len(msgs); start += chunk {
+		end := min(start+chunk, len(msgs))
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			for i := start; i < end; i++ {
+				out[i] = Blake256EightRound(msgs[i])
+			}
+		}(start, end)
+	}
+	wg.Wait()
+}
+
+// HeaderHasher hashes one block header at many nonces. For BLAKE-256
+// headers the first 64 bytes, which the nonce does not touch, are
+// compressed only once.
+type HeaderHasher struct {
+	header BlockHeader
+	mid    Blake256Midstate
+	tail   [16]byte
+}
+
+// NewHeaderHasher prepares hashing h at any nonce.
+func NewHeaderHasher(h BlockHeader) *HeaderHasher {
+	hh := &HeaderHasher{header: h}
+	if h.Version >= 5 {
+		b := h.bytes()
+		hh.mid = NewBlake256Midstate(b[:64])
+		copy(hh.tail[:], b[64:])
+	}
+	return hh
+}
+
+// Hash returns the digest of the header with nonce, the one
+// CheckProofOfWork compares to the target.
+func (hh *HeaderHasher) Hash(nonce uint32) [32]byte {
+	if hh.header.Version < 5 {
+		hh.header.Nonce = nonce
+		return hh.header.digest()
+	}
+	binary.LittleEndian.PutUint32(hh.tail[12:], nonce)
+	return hh.mid.Sum(hh.tail[:])
+}
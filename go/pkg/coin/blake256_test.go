@@ -0,0 +1,124 @@
+package coin
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// blakeVectors are Blake256EightRound digests of the bytes 0, 1, 2, ...
+var blakeVectors = map[int]string{
+	0:   "e991872e042cec0d854e12ce657be3efbe39555dea42930ff6dd3a599e76b806",
+	3:   "d0e2ed94b6cd0d2ee403ca28f0fb14a3107247ae6225ce765004657adbd758d1",
+	55:  "30db8adf94bf2823a78f6d0a35f446633bd591e40a3a578e3fc1205ff28ce19b",
+	64:  "a6d7ea3b4106d0cbebd8940196e442080897a7018a19a3169456049a2e6d8acd",
+	80:  "a39b3dd6f7c2c6eff52e1a170c55eb15c852dcbd0a98231e0a008d35e1d7b739",
+	128: "228a429290a223f90f35cc975df4b28f1d6346bf4c83e6a9c7495faf00a3ee54",
+	200: "b6bd991bdc15b1ba119c30408b500969069e3153b3947d4331c394ca808388bc",
+}
+
+func counting(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i)
+	}
+	return b
+}
+
+func TestBlake256(t *testing.T) {
+	for n, want := range blakeVectors {
+		data := counting(n)
+		if got := Blake256EightRound(data); hex.EncodeToString(got[:]) != want {
+			t.Fatalf("Blake256EightRound of %d bytes = %x", n, got)
+		}
+		// Feed the hash.Hash in uneven pieces.
+		h := NewBlake256()
+		for rest := data; len(rest) > 0; {
+			k := min(len(rest), 1+len(rest)%7*9)
+			h.Write(rest[:k])
+			rest = rest[k:]
+		}
+		if got := hex.EncodeToString(h.Sum(nil)); got != want {
+			t.Fatalf("hash.Hash of %d bytes = %s", n, got)
+		}
+		// Sum does not finalize the state.
+		h.Write(nil)
+		if got := hex.EncodeToString(h.Sum(nil)); got != want {
+			t.Fatalf("second Sum of %d bytes = %s", n, got)
+		}
+		if n >= 64 {
+			mid := NewBlake256Midstate(data[:64])
+			if got := mid.Sum(data[64:]); hex.EncodeToString(got[:]) != want {
+				t.Fatalf("midstate digest of %d bytes = %x", n, got)
+			}
+		}
+	}
+}
+
+func TestHeaderHasher(t *testing.T) {
+	for _, version := range []uint32{4, CurrentBlockVersion} {
+		h := BlockHeader{Version: version, PrevHash: strings.Repeat("ab", 32), MerkleRoot: strings.Repeat("cd", 32), Timestamp: 1500000000, Bits: 0x1d00ffff}
+		hh := NewHeaderHasher(h)
+		for _, nonce := range []uint32{0, 1, 0xdeadbeef} {
+			h.Nonce = nonce
+			if got := hh.Hash(nonce); got != h.digest() {
+				t.Fatalf("version %d nonce %d: %x, want %x", version, nonce, got, h.digest())
+			}
+		}
+	}
+}
+
+func TestBlake256Batch(t *testing.T) {
+	msgs := make([][]byte, 1000)
+	for i := range msgs {
+		msgs[i] = counting(i % 150)
+	}
+	out := make([][32]byte, len(msgs))
+	Blake256Batch(out, msgs)
+	for i, m := range msgs {
+		if out[i] != Blake256EightRound(m) {
+			t.Fatalf("batch digest %d differs", i)
+		}
+	}
+}
+
+func benchHeader() BlockHeader {
+	return BlockHeader{Version: CurrentBlockVersion, PrevHash: strings.Repeat("ab", 32), MerkleRoot: strings.Repeat("cd", 32), Timestamp: 1500000000, Bits: 0x1d00ffff}
+}
+
+// BenchmarkBlake256EightRound hashes a serialized header at successive
+// nonces the way the miner did before midstates.
+func BenchmarkBlake256EightRound(b *testing.B) {
+	header := benchHeader().bytes()
+	for i := 0; i < b.N; i++ {
+		header[76] = byte(i)
+		Blake256EightRound(header)
+	}
+}
+
+func BenchmarkHeaderDigest(b *testing.B) {
+	h := benchHeader()
+	for i := 0; i < b.N; i++ {
+		h.Nonce = uint32(i)
+		h.digest()
+	}
+}
+
+func BenchmarkHeaderHasher(b *testing.B) {
+	hh := NewHeaderHasher(benchHeader())
+	for i := 0; i < b.N; i++ {
+		hh.Hash(uint32(i))
+	}
+}
+
+func BenchmarkBlake256Batch(b *testing.B) {
+	msgs := make([][]byte, 2000)
+	for i := range msgs {
+		msgs[i] = benchHeader().bytes()
+	}
+	out := make([][32]byte, len(msgs))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Blake256Batch(out, msgs)
+	}
+}
@@ -1,11 +1,15 @@
 package coin
 
 import (
+	"bytes"
 	"encoding/binary"
 	"encoding/hex"
 	"fmt"
 )
 
+// CurrentBlockVersion is the version of newly created blocks.
+const CurrentBlockVersion uint32 = 6
+
 // BlockHeader mirrors the basic Bitcoin block header structure.
 type BlockHeader struct {
 	Version    uint32 `json:"version"`
@@ -34,20 +38,60 @@ func (h BlockHeader) bytes() []byte {
 // compatibility with the original C++ code, blocks with version < 5 use a
 // Whirlpool-based hash while newer versions use Blake-256.
 func (h BlockHeader) Hash() string {
-	var digest [32]byte
+	digest := h.digest()
+	return hex.EncodeToString(digest[:])
+}
+
+func (h BlockHeader) digest() [32]byte {
 	header := h.bytes()
 	if h.Version < 5 {
-		digest = WhirlpoolX(header)
-	} else {
-		digest = Blake256EightRound(header)
+		return WhirlpoolX(header)
 	}
-	return hex.EncodeToString(digest[:])
+	return Blake256EightRound(header)
 }
 
 // Block groups a header with a list of transactions.
 type Block struct {
 	Header       BlockHeader   `json:"header"`
 	Transactions []Transaction `json:"tx"`
+	// Signature is the DER signature of the header hash by the staker,
+	// set on proof-of-stake blocks.
+	Signature []byte `json:"signature,omitempty"`
+}
+
+// IsProofOfStake reports whether the second transaction of the block is a
+// coinstake.
+func (b Block) IsProofOfStake() bool {
+	return len(b.Transactions) > 1 && b.Transactions[1].IsCoinStake()
+}
+
+// SignatureHash returns the hash a block signature commits to.
+func (b Block) SignatureHash() [32]byte { return b.Header.digest() }
+
+// CheckSignature verifies the block signature of a proof-of-stake block
+// against the key its coinstake pays to (block::check_signature).
+// Proof-of-work blocks carry no signature.
+func (b Block) CheckSignature() bool {
+	if !b.IsProofOfStake() {
+		return len(b.Signature) == 0
+	}
+	class, data := ExtractScript(b.Transactions[1].Outputs[1].ScriptPubKey)
+	if class != PubKeyTy || len(b.Signature) == 0 {
+		return false
+	}
+	return VerifySignature(b.SignatureHash(), b.Signature, data[0], false)
+}
+
+// TxOffset returns the byte offset of transaction i in the serialized
+// block, which the stake kernel commits to.
+func (b Block) TxOffset(i int) uint32 {
+	var header bytes.Buffer
+	b.Header.Encode(&header)
+	n := header.Len() + int(GetVarIntSize(uint64(len(b.Transactions))))
+	for _, tx := range b.Transactions[:i] {
+		n += tx.SerializeSize()
+	}
+	return uint32(n)
 }
 
 // BuildMerkleRoot calculates the merkle root of the block transactions.
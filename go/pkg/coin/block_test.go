@@ -31,3 +31,20 @@ func TestBlockValidateDuplicateTx(t *testing.T) {
 		t.Fatalf("expected error")
 	}
 }
+
+func TestBlockSignatureRoundTrip(t *testing.T) {
+	blk := Block{Header: BlockHeader{Version: CurrentBlockVersion}, Transactions: []Transaction{{Version: 1}}, Signature: []byte{0x30, 0x01}}
+	data, err := blk.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := DeserializeBlock(data)
+	if err != nil || string(out.Signature) != string(blk.Signature) {
+		t.Fatalf("signature %x: %v", out.Signature, err)
+	}
+	// Blocks encoded before signatures decode without one.
+	old, err := DeserializeBlock(data[:len(data)-3])
+	if err != nil || old.Signature != nil {
+		t.Fatalf("unsigned block: %v", err)
+	}
+}
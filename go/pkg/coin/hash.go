@@ -110,60 +110,10 @@ func WhirlpoolX(data []byte) [32]byte {
 // original C++ code.  This implementation is self-contained and processes
 // the input in 512-bit blocks following the standard padding rules.
 func Blake256EightRound(data []byte) [32]byte {
-	var h = [8]uint32{
-		0x6a09e667, 0xbb67ae85, 0x3c6ef372, 0xa54ff53a,
-		0x510e527f, 0x9b05688c, 0x1f83d9ab, 0x5be0cd19,
-	}
-	var s [4]uint32
-	var counter uint64
-	var block [64]byte
-
-	// Process full 64-byte blocks.
-	for len(data) >= 64 {
-		copy(block[:], data[:64])
-		counter += 512
-		compress8(&h, &s, block[:], counter)
-		data = data[64:]
-	}
-
-	// Finalize with padding per the BLAKE specification.
-	msgBits := counter + uint64(len(data))<<3
-	if len(data) < 55 {
-		copy(block[:], data)
-		block[len(data)] = 0x80
-		for i := len(data) + 1; i < 55; i++ {
-			block[i] = 0
-		}
-		block[55] = 0x01
-		binary.BigEndian.PutUint64(block[56:], msgBits)
-		compress8(&h, &s, block[:], msgBits)
-	} else {
-		copy(block[:], data)
-		block[len(data)] = 0x80
-		for i := len(data) + 1; i < 64; i++ {
-			block[i] = 0
-		}
-		counter += uint64(64-len(data)) << 3
-		compress8(&h, &s, block[:], counter)
-
-		for i := 0; i < 55; i++ {
-			block[i] = 0
-		}
-		block[55] = 0x01
-		binary.BigEndian.PutUint64(block[56:], msgBits)
-		compress8(&h, &s, block[:], 0)
-	}
-
-	var out [32]byte
-	binary.BigEndian.PutUint32(out[28:], h[7])
-	binary.BigEndian.PutUint32(out[24:], h[6])
-	binary.BigEndian.PutUint32(out[20:], h[5])
-	binary.BigEndian.PutUint32(out[16:], h[4])
-	binary.BigEndian.PutUint32(out[12:], h[3])
-	binary.BigEndian.PutUint32(out[8:], h[2])
-	binary.BigEndian.PutUint32(out[4:], h[1])
-	binary.BigEndian.PutUint32(out[0:], h[0])
-	return out
+	var d blake256
+	d.Reset()
+	d.Write(data)
+	return d.checkSum()
 }
 
 // g is the quarter round function for BLAKE-256.
@@ -0,0 +1,99 @@
+package coin
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/big"
+)
+
+const (
+	// StakeSplitAge is the age below which a staked output is split in
+	// two by the coinstake.
+	StakeSplitAge = 60 * 60 * 24 * 30
+	// MaxStakeSearchInterval bounds how many seconds back from the current
+	// time a kernel search tries.
+	MaxStakeSearchInterval = 60
+)
+
+var (
+	// ErrStakeTimeViolation is returned for a coinstake older than the
+	// output it stakes.
+	ErrStakeTimeViolation = errors.New("stake kernel time violation")
+	// ErrStakeMinAge is returned for an output younger than MinStakeAge.
+	ErrStakeMinAge = errors.New("stake kernel minimum age violation")
+	// ErrStakeTarget is returned when the kernel hash misses the target.
+	ErrStakeTarget = errors.New("stake kernel hash above target")
+)
+
+// StakeInput is an output staked by a coinstake together with the context
+// the kernel hash commits to.
+type StakeInput struct {
+	PrevOut PointOut
+	Value   int64
+	// BlockTime is the timestamp of the block holding the output.
+	BlockTime uint32
+	// TxTime is the time of the transaction holding the output.
+	TxTime uint32
+	// TxOffset is the offset of that transaction in its block.
+	TxOffset uint32
+}
+
+// StakeKernelHash returns the proof-of-stake hash of staking in at timeTx
+// under the stake modifier of the block holding it.
+func StakeKernelHash(modifier uint64, in StakeInput, timeTx uint32) [32]byte {
+	var buf [28]byte
+	binary.LittleEndian.PutUint64(buf[0:], modifier)
+	binary.LittleEndian.PutUint32(buf[8:], in.BlockTime)
+	binary.LittleEndian.PutUint32(buf[12:], in.TxOffset)
+	binary.LittleEndian.PutUint32(buf[16:], in.TxTime)
+	binary.LittleEndian.PutUint32(buf[20:], in.PrevOut.Index)
+	binary.LittleEndian.PutUint32(buf[24:], timeTx)
+	return DoubleSHA256(buf[:])
+}
+
+// CheckStakeKernelHash checks that staking in at timeTx meets the target
+// bits scaled by the coin-day weight of the output
+// (kernel::check_stake_kernel_hash). The weight starts from zero at
+// MinStakeAge and stops growing at MaxStakeAge. It returns the kernel
+// hash, and ErrStakeTarget when it is above the weighted target.
+func CheckStakeKernelHash(bits uint32, modifier uint64, in StakeInput, timeTx uint32) ([32]byte, error) {
+	if timeTx < in.TxTime {
+		return [32]byte{}, ErrStakeTimeViolation
+	}
+	if int64(in.BlockTime)+MinStakeAge > int64(timeTx) {
+		return [32]byte{}, ErrStakeMinAge
+	}
+	age := int64(timeTx) - int64(in.TxTime)
+	if age > MaxStakeAge {
+		age = MaxStakeAge
+	}
+	weight := big.NewInt(in.Value)
+	weight.Mul(weight, big.NewInt(age-MinStakeAge))
+	weight.Div(weight, big.NewInt(Coin))
+	weight.Div(weight, big.NewInt(24*60*60))
+
+	hash := StakeKernelHash(modifier, in, timeTx)
+	target := weight.Mul(weight, CompactToBig(bits))
+	if HashToBig(hash).Cmp(target) > 0 {
+		return hash, ErrStakeTarget
+	}
+	return hash, nil
+}
+
+// CoinAge returns the coin-days a coinstake at timeTx consumes from its
+// inputs (transaction::get_coin_age). Inputs younger than MinStakeAge do
+// not count.
+func CoinAge(inputs []StakeInput, timeTx uint32) uint64 {
+	centSeconds := new(big.Int)
+	for _, in := range inputs {
+		if timeTx < in.TxTime || int64(in.BlockTime)+MinStakeAge > int64(timeTx) {
+			continue
+		}
+		n := big.NewInt(in.Value)
+		n.Mul(n, big.NewInt(int64(timeTx-in.TxTime)))
+		centSeconds.Add(centSeconds, n.Div(n, big.NewInt(Cent)))
+	}
+	centSeconds.Mul(centSeconds, big.NewInt(Cent))
+	centSeconds.Div(centSeconds, big.NewInt(Coin*24*60*60))
+	return centSeconds.Uint64()
+}
@@ -0,0 +1,59 @@
+package coin
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestCompactRoundTrip(t *testing.T) {
+	for _, bits := range []uint32{0x1d00ffff, 0x1b0404cb, 0x207fffff, 0x03123456} {
+		if got := BigToCompact(CompactToBig(bits)); got != bits {
+			t.Fatalf("compact %08x round trips to %08x", bits, got)
+		}
+	}
+	want, _ := new(big.Int).SetString("00000000ffff0000000000000000000000000000000000000000000000000000", 16)
+	if CompactToBig(0x1d00ffff).Cmp(want) != 0 {
+		t.Fatalf("0x1d00ffff expands to %x", CompactToBig(0x1d00ffff))
+	}
+}
+
+func TestCheckStakeKernelHash(t *testing.T) {
+	in := StakeInput{PrevOut: PointOut{Index: 1}, Value: 100 * Coin, BlockTime: 1000, TxTime: 1000, TxOffset: 81}
+	if _, err := CheckStakeKernelHash(0x207fffff, 1, in, 999); err != ErrStakeTimeViolation {
+		t.Fatalf("stake before its output: %v", err)
+	}
+	if _, err := CheckStakeKernelHash(0x207fffff, 1, in, 1000+MinStakeAge-1); err != ErrStakeMinAge {
+		t.Fatalf("stake below minimum age: %v", err)
+	}
+	// No weight at exactly the minimum age, so nothing meets the target.
+	if _, err := CheckStakeKernelHash(0x207fffff, 1, in, 1000+MinStakeAge); err != ErrStakeTarget {
+		t.Fatalf("zero weight stake: %v", err)
+	}
+	if _, err := CheckStakeKernelHash(0x207fffff, 1, in, 1000+MinStakeAge+24*60*60); err != nil {
+		t.Fatalf("stake at the easiest target: %v", err)
+	}
+	if StakeKernelHash(1, in, 5000) == StakeKernelHash(2, in, 5000) {
+		t.Fatalf("kernel hash ignores the stake modifier")
+	}
+	if age := CoinAge([]StakeInput{in}, 1000+2*24*60*60); age != 200 {
+		t.Fatalf("coin age %d, want 200 coin-days", age)
+	}
+}
+
+func TestProofOfWorkReward(t *testing.T) {
+	for _, c := range []struct {
+		height int32
+		want   int64
+	}{
+		{0, 128 * Coin},
+		{136400, 1},
+		{50000, 128*Coin - 128*Coin/6},
+	} {
+		if got := ProofOfWorkReward(c.height); got != c.want {
+			t.Fatalf("reward at %d is %d, want %d", c.height, got, c.want)
+		}
+	}
+	if r := ProofOfWorkReward(1000000); r < Coin {
+		t.Fatalf("reward at 1000000 is %d, below one coin", r)
+	}
+}
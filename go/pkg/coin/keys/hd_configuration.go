@@ -0,0 +1,62 @@
+package keys
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"pila/pkg/coin"
+)
+
+const (
+	// HDConfigurationVersionHardened is the version of chains created by
+	// the C++ wallet, which derive key n as m/0'/0'/n'.
+	HDConfigurationVersionHardened uint32 = 1
+	// HDConfigurationVersion is the current HDConfiguration record version.
+	// Its chains derive key n as m/0'/0'/0/n, so the extended public key of
+	// m/0'/0' follows every key.
+	HDConfigurationVersion uint32 = 2
+)
+
+// hdConfigurationSize is the length of an encoded HDConfiguration without
+// a chain code.
+const hdConfigurationSize = 4 + 4 + 20
+
+// HDConfiguration records the master key of a deterministic wallet and the
+// next child index of its key chain (hd_configuration).
+type HDConfiguration struct {
+	Version     uint32
+	Index       uint32
+	IDKeyMaster coin.IDKey
+	// ChainCode is the chain code of a master key derived from a BIP39
+	// seed. It is nil when the chain is derived from the secret of the
+	// master key, as in the C++ wallet.
+	ChainCode []byte
+}
+
+// IsEmpty reports whether no master key is set, i.e. the wallet is not
+// deterministic.
+func (c HDConfiguration) IsEmpty() bool { return c.IDKeyMaster == coin.IDKey{} }
+
+// Encode returns the wallet record encoding.
+func (c HDConfiguration) Encode() []byte {
+	buf := make([]byte, 0, hdConfigurationSize+len(c.ChainCode))
+	buf = binary.LittleEndian.AppendUint32(buf, c.Version)
+	buf = binary.LittleEndian.AppendUint32(buf, c.Index)
+	buf = append(buf, c.IDKeyMaster[:]...)
+	return append(buf, c.ChainCode...)
+}
+
+// Decode parses a record written by Encode.
+func (c *HDConfiguration) Decode(b []byte) error {
+	if len(b) != hdConfigurationSize && len(b) != hdConfigurationSize+32 {
+		return errors.New("invalid hd configuration record")
+	}
+	c.Version = binary.LittleEndian.Uint32(b[0:4])
+	c.Index = binary.LittleEndian.Uint32(b[4:8])
+	copy(c.IDKeyMaster[:], b[8:hdConfigurationSize])
+	c.ChainCode = nil
+	if len(b) > hdConfigurationSize {
+		c.ChainCode = append([]byte(nil), b[hdConfigurationSize:]...)
+	}
+	return nil
+}
@@ -0,0 +1,285 @@
+package keys
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+
+	"pila/pkg/coin"
+)
+
+// BIP32 serialization versions (xprv and xpub).
+const (
+	HDPrivateVersion uint32 = 0x0488ADE4
+	HDPublicVersion  uint32 = 0x0488B21E
+)
+
+// HardenedKeyStart is the first hardened child index.
+const HardenedKeyStart uint32 = 0x80000000
+
+// extendedKeySize is the length of a serialized extended key without its
+// checksum.
+const extendedKeySize = 78
+
+// masterSeedKey is the HMAC key used to derive a master key from a seed.
+var masterSeedKey = []byte("Bitcoin seed")
+
+var (
+	// ErrInvalidChild is returned for the (very unlikely) child indexes
+	// that do not produce a valid key; callers should skip to the next.
+	ErrInvalidChild = errors.New("derived key is invalid")
+	// ErrDeriveHardenedFromPublic is returned when deriving a hardened
+	// child from a public extended key.
+	ErrDeriveHardenedFromPublic = errors.New("cannot derive a hardened key from a public key")
+)
+
+// ExtendedKey is a BIP32 extended private or public key (hd_keychain).
+type ExtendedKey struct {
+	depth             uint8
+	parentFingerprint uint32
+	childNum          uint32
+	chainCode         []byte
+	// key is the 32 byte secret for private keys and the 33 byte
+	// compressed point for public keys.
+	key       []byte
+	isPrivate bool
+}
+
+// NewMasterKey derives the master extended key from a seed of 16 to 64
+// bytes.
+func NewMasterKey(seed []byte) (*ExtendedKey, error) {
+	if len(seed) < 16 || len(seed) > 64 {
+		return nil, errors.New("seed length must be between 16 and 64 bytes")
+	}
+	mac := hmac.New(sha512.New, masterSeedKey)
+	mac.Write(seed)
+	sum := mac.Sum(nil)
+	if _, err := PrivateKeyFromBytes(sum[:32], true); err != nil {
+		return nil, ErrInvalidChild
+	}
+	return &ExtendedKey{chainCode: sum[32:], key: sum[:32], isPrivate: true}, nil
+}
+
+// MasterKeyFromPrivateKey derives the master extended key the way the wallet
+// does, using the secret of k as the seed.
+func MasterKeyFromPrivateKey(k *PrivateKey) (*ExtendedKey, error) {
+	return NewMasterKey(k.Bytes())
+}
+
+// MasterKeyFromChainCode rebuilds a master extended key from its private
+// key and chain code, e.g. one NewMasterKey derived from a BIP39 seed.
+func MasterKeyFromChainCode(k *PrivateKey, chainCode []byte) (*ExtendedKey, error) {
+	if len(chainCode) != 32 {
+		return nil, errors.New("chain code must be 32 bytes")
+	}
+	return &ExtendedKey{
+		chainCode: append([]byte(nil), chainCode...),
+		key:       k.Bytes(),
+		isPrivate: true,
+	}, nil
+}
+
+// IsPrivate reports whether the key holds a secret.
+func (k *ExtendedKey) IsPrivate() bool { return k.isPrivate }
+
+// Depth returns the number of derivation steps from the master key.
+func (k *ExtendedKey) Depth() uint8 { return k.depth }
+
+// ChildIndex returns the index this key was derived with.
+func (k *ExtendedKey) ChildIndex() uint32 { return k.childNum }
+
+// ParentFingerprint returns the fingerprint of the parent key.
+func (k *ExtendedKey) ParentFingerprint() uint32 { return k.parentFingerprint }
+
+// ChainCode returns the chain code.
+func (k *ExtendedKey) ChainCode() []byte { return append([]byte(nil), k.chainCode...) }
+
+func (k *ExtendedKey) pubKeyBytes() []byte {
+	if !k.isPrivate {
+		return k.key
+	}
+	priv, _ := btcec.PrivKeyFromBytes(k.key)
+	return priv.PubKey().SerializeCompressed()
+}
+
+// Fingerprint returns the first four bytes of the key's Hash160.
+func (k *ExtendedKey) Fingerprint() uint32 {
+	id := coin.SHA256RIPEMD160(k.pubKeyBytes())
+	return binary.BigEndian.Uint32(id[:4])
+}
+
+// PrivateKey returns the compressed private key.
+func (k *ExtendedKey) PrivateKey() (*PrivateKey, error) {
+	if !k.isPrivate {
+		return nil, errors.New("extended key is public")
+	}
+	return PrivateKeyFromBytes(k.key, true)
+}
+
+// PublicKey returns the compressed public key.
+func (k *ExtendedKey) PublicKey() (*PublicKey, error) {
+	return ParsePublicKey(k.pubKeyBytes())
+}
+
+// Public returns the public (neutered) form of the key.
+func (k *ExtendedKey) Public() *ExtendedKey {
+	if !k.isPrivate {
+		return k
+	}
+	return &ExtendedKey{
+		depth:             k.depth,
+		parentFingerprint: k.parentFingerprint,
+		childNum:          k.childNum,
+		chainCode:         k.chainCode,
+		key:               k.pubKeyBytes(),
+	}
+}
+
+// Child derives the child at index. Indexes from HardenedKeyStart up derive
+// hardened children, which require a private key.
+func (k *ExtendedKey) Child(index uint32) (*ExtendedKey, error) {
+	if k.depth == 255 {
+		return nil, errors.New("maximum derivation depth reached")
+	}
+	var data []byte
+	if index >= HardenedKeyStart {
+		if !k.isPrivate {
+			return nil, ErrDeriveHardenedFromPublic
+		}
+		data = append([]byte{0}, k.key...)
+	} else {
+		data = append([]byte(nil), k.pubKeyBytes()...)
+	}
+	data = binary.BigEndian.AppendUint32(data, index)
+
+	mac := hmac.New(sha512.New, k.chainCode)
+	mac.Write(data)
+	sum := mac.Sum(nil)
+
+	var il btcec.ModNScalar
+	if overflow := il.SetByteSlice(sum[:32]); overflow {
+		return nil, ErrInvalidChild
+	}
+	child := &ExtendedKey{
+		depth:             k.depth + 1,
+		parentFingerprint: k.Fingerprint(),
+		childNum:          index,
+		chainCode:         sum[32:],
+		isPrivate:         k.isPrivate,
+	}
+	if k.isPrivate {
+		var parent btcec.ModNScalar
+		parent.SetByteSlice(k.key)
+		il.Add(&parent)
+		if il.IsZero() {
+			return nil, ErrInvalidChild
+		}
+		b := il.Bytes()
+		child.key = b[:]
+		return child, nil
+	}
+
+	parent, err := btcec.ParsePubKey(k.key)
+	if err != nil {
+		return nil, err
+	}
+	var point, parentPoint, result btcec.JacobianPoint
+	btcec.ScalarBaseMultNonConst(&il, &point)
+	parent.AsJacobian(&parentPoint)
+	btcec.AddNonConst(&point, &parentPoint, &result)
+	if (result.X.IsZero() && result.Y.IsZero()) || result.Z.IsZero() {
+		return nil, ErrInvalidChild
+	}
+	result.ToAffine()
+	child.key = btcec.NewPublicKey(&result.X, &result.Y).SerializeCompressed()
+	return child, nil
+}
+
+// Derive follows a path such as "m/0'/0'/1" (or "M/..." for public keys).
+// Both ' and h mark hardened steps.
+func (k *ExtendedKey) Derive(path string) (*ExtendedKey, error) {
+	parts := strings.Split(path, "/")
+	if parts[0] != "m" && parts[0] != "M" {
+		return nil, fmt.Errorf("invalid derivation path %q", path)
+	}
+	key := k
+	for _, p := range parts[1:] {
+		var index uint32
+		if strings.HasSuffix(p, "'") || strings.HasSuffix(p, "h") {
+			index = HardenedKeyStart
+			p = p[:len(p)-1]
+		}
+		n, err := strconv.ParseUint(p, 10, 31)
+		if err != nil {
+			return nil, fmt.Errorf("invalid derivation path %q", path)
+		}
+		if key, err = key.Child(index | uint32(n)); err != nil {
+			return nil, err
+		}
+	}
+	if parts[0] == "M" {
+		key = key.Public()
+	}
+	return key, nil
+}
+
+// String returns the Base58 xprv or xpub serialization.
+func (k *ExtendedKey) String() string {
+	buf := make([]byte, 0, extendedKeySize)
+	if k.isPrivate {
+		buf = binary.BigEndian.AppendUint32(buf, HDPrivateVersion)
+	} else {
+		buf = binary.BigEndian.AppendUint32(buf, HDPublicVersion)
+	}
+	buf = append(buf, k.depth)
+	buf = binary.BigEndian.AppendUint32(buf, k.parentFingerprint)
+	buf = binary.BigEndian.AppendUint32(buf, k.childNum)
+	buf = append(buf, k.chainCode...)
+	if k.isPrivate {
+		buf = append(buf, 0)
+	}
+	buf = append(buf, k.key...)
+
+	var b coin.Base58
+	b.SetData(buf[0], buf[1:])
+	return b.ToString(true)
+}
+
+// ParseExtendedKey decodes an xprv or xpub string.
+func ParseExtendedKey(s string) (*ExtendedKey, error) {
+	var b coin.Base58
+	if !b.SetString(s) || len(b.Data) != extendedKeySize-1 {
+		return nil, errors.New("invalid extended key encoding")
+	}
+	buf := append([]byte{b.Version}, b.Data...)
+	k := &ExtendedKey{
+		depth:             buf[4],
+		parentFingerprint: binary.BigEndian.Uint32(buf[5:9]),
+		childNum:          binary.BigEndian.Uint32(buf[9:13]),
+		chainCode:         buf[13:45],
+	}
+	switch binary.BigEndian.Uint32(buf[:4]) {
+	case HDPrivateVersion:
+		if buf[45] != 0 {
+			return nil, errors.New("invalid extended private key")
+		}
+		if _, err := PrivateKeyFromBytes(buf[46:], true); err != nil {
+			return nil, err
+		}
+		k.key, k.isPrivate = buf[46:], true
+	case HDPublicVersion:
+		if _, err := btcec.ParsePubKey(buf[45:]); err != nil {
+			return nil, err
+		}
+		k.key = buf[45:]
+	default:
+		return nil, errors.New("unknown extended key version")
+	}
+	return k, nil
+}
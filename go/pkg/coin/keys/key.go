@@ -0,0 +1,77 @@
+// Package keys implements secp256k1 key management for the coin package:
+// private and public keys, WIF encoded secrets and transaction signatures.
+// It ports key.cpp, key_public.cpp and secret.cpp.
+package keys
+
+import (
+	"errors"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+
+	"pila/pkg/coin"
+)
+
+// PrivateKeySize is the length of a serialized private key.
+const PrivateKeySize = 32
+
+// PrivateKey is a secp256k1 private key together with the form of its
+// public key.
+type PrivateKey struct {
+	key        *btcec.PrivateKey
+	compressed bool
+}
+
+// NewPrivateKey generates a fresh random key.
+func NewPrivateKey(compressed bool) (*PrivateKey, error) {
+	k, err := btcec.NewPrivateKey()
+	if err != nil {
+		return nil, err
+	}
+	return &PrivateKey{key: k, compressed: compressed}, nil
+}
+
+// PrivateKeyFromBytes loads a 32 byte secret.
+func PrivateKeyFromBytes(b []byte, compressed bool) (*PrivateKey, error) {
+	if len(b) != PrivateKeySize {
+		return nil, errors.New("invalid private key length")
+	}
+	var s btcec.ModNScalar
+	if overflow := s.SetByteSlice(b); overflow || s.IsZero() {
+		return nil, errors.New("private key out of range")
+	}
+	return &PrivateKey{key: btcec.PrivKeyFromScalar(&s), compressed: compressed}, nil
+}
+
+// Bytes returns the 32 byte secret.
+func (k *PrivateKey) Bytes() []byte { return k.key.Serialize() }
+
+// IsCompressed reports whether the public key uses the compressed form.
+func (k *PrivateKey) IsCompressed() bool { return k.compressed }
+
+// PubKey returns the matching public key.
+func (k *PrivateKey) PubKey() *PublicKey {
+	return &PublicKey{key: k.key.PubKey(), compressed: k.compressed}
+}
+
+// BTCEC returns the underlying btcec key.
+func (k *PrivateKey) BTCEC() *btcec.PrivateKey { return k.key }
+
+// Sign returns a DER encoded deterministic (RFC 6979) signature of hash. The
+// S value is always normalized to the lower half of the curve order.
+func (k *PrivateKey) Sign(hash [32]byte) []byte {
+	return ecdsa.Sign(k.key, hash[:]).Serialize()
+}
+
+// SignTx returns the signature of input n of tx spending subScript, with the
+// hash type byte appended as expected in a script signature.
+func (k *PrivateKey) SignTx(tx coin.Transaction, n int, subScript []byte, hashType uint32) ([]byte, error) {
+	hash, err := coin.SignatureHash(subScript, tx, n, hashType)
+	if err != nil {
+		return nil, err
+	}
+	return append(k.Sign(hash), byte(hashType)), nil
+}
+
+// Zero clears the secret from memory.
+func (k *PrivateKey) Zero() { k.key.Zero() }
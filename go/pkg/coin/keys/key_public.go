@@ -0,0 +1,62 @@
+package keys
+
+import (
+	"github.com/btcsuite/btcd/btcec/v2"
+
+	"pila/pkg/coin"
+)
+
+// PublicKey is a secp256k1 public key in compressed or uncompressed form.
+type PublicKey struct {
+	key        *btcec.PublicKey
+	compressed bool
+}
+
+// ParsePublicKey decodes a 33 byte compressed or 65 byte uncompressed key.
+func ParsePublicKey(b []byte) (*PublicKey, error) {
+	k, err := btcec.ParsePubKey(b)
+	if err != nil {
+		return nil, err
+	}
+	return &PublicKey{key: k, compressed: len(b) == btcec.PubKeyBytesLenCompressed}, nil
+}
+
+// Bytes returns the serialized key in its own form.
+func (p *PublicKey) Bytes() []byte {
+	if p.compressed {
+		return p.key.SerializeCompressed()
+	}
+	return p.key.SerializeUncompressed()
+}
+
+// Compressed returns the 33 byte compressed serialization.
+func (p *PublicKey) Compressed() []byte { return p.key.SerializeCompressed() }
+
+// Uncompressed returns the 65 byte uncompressed serialization.
+func (p *PublicKey) Uncompressed() []byte { return p.key.SerializeUncompressed() }
+
+// IsCompressed reports whether Bytes returns the compressed form.
+func (p *PublicKey) IsCompressed() bool { return p.compressed }
+
+// ID returns the Hash160 of the serialized key used in addresses.
+func (p *PublicKey) ID() coin.IDKey {
+	return coin.IDKey(coin.SHA256RIPEMD160(p.Bytes()))
+}
+
+// Address returns the pay-to-pubkey-hash address of the key.
+func (p *PublicKey) Address() coin.Address {
+	var a coin.Address
+	a.SetIDKey(p.ID())
+	return a
+}
+
+// Verify checks a DER signature of hash, requiring the low-S form.
+func (p *PublicKey) Verify(hash [32]byte, der []byte) bool {
+	return coin.VerifySignature(hash, der, p.key.SerializeCompressed(), true)
+}
+
+// BTCEC returns the underlying btcec key.
+func (p *PublicKey) BTCEC() *btcec.PublicKey { return p.key }
+
+// IsEqual reports whether both keys are the same point.
+func (p *PublicKey) IsEqual(o *PublicKey) bool { return p.key.IsEqual(o.key) }
@@ -0,0 +1,108 @@
+package keys
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"pila/pkg/coin"
+)
+
+func keyOne(t *testing.T, compressed bool) *PrivateKey {
+	t.Helper()
+	b := make([]byte, PrivateKeySize)
+	b[PrivateKeySize-1] = 1
+	k, err := PrivateKeyFromBytes(b, compressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestPublicKeyID(t *testing.T) {
+	for _, tc := range []struct {
+		compressed bool
+		id         string
+	}{
+		{true, "751e76e8199196d454941c45d1b3a323f1433bd6"},
+		{false, "91b24bf9f5288532960ac687abb035127b1d28a5"},
+	} {
+		pub := keyOne(t, tc.compressed).PubKey()
+		id := pub.ID()
+		if got := hex.EncodeToString(id[:]); got != tc.id {
+			t.Fatalf("compressed=%v: id %s, want %s", tc.compressed, got, tc.id)
+		}
+		addr := pub.Address()
+		if got, ok := addr.GetIDKey(); !ok || got != id || !addr.IsValid() {
+			t.Fatalf("address does not carry the key id")
+		}
+		parsed, err := ParsePublicKey(pub.Bytes())
+		if err != nil || !parsed.IsEqual(pub) || parsed.IsCompressed() != tc.compressed {
+			t.Fatalf("public key round trip failed: %v", err)
+		}
+	}
+}
+
+func TestSecretRoundTrip(t *testing.T) {
+	for _, compressed := range []bool{true, false} {
+		k, err := NewPrivateKey(compressed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wif := EncodeWIF(k)
+		got, err := DecodeWIF(wif)
+		if err != nil {
+			t.Fatalf("decode %s: %v", wif, err)
+		}
+		if hex.EncodeToString(got.Bytes()) != hex.EncodeToString(k.Bytes()) || got.IsCompressed() != compressed {
+			t.Fatalf("secret round trip mismatch")
+		}
+		var s Secret
+		s.SetString(wif)
+		if s.Version != SecretPrefix() {
+			t.Fatalf("unexpected prefix %d", s.Version)
+		}
+	}
+	var a coin.Address
+	a.SetIDKey(coin.IDKey{})
+	if _, err := DecodeWIF(a.String()); err == nil {
+		t.Fatalf("address accepted as secret")
+	}
+}
+
+func TestSignLowS(t *testing.T) {
+	k := keyOne(t, true)
+	hash := sha256.Sum256([]byte("message"))
+	for i := 0; i < 8; i++ {
+		hash[0] = byte(i)
+		sig := k.Sign(hash)
+		if !k.PubKey().Verify(hash, sig) {
+			t.Fatalf("signature %d does not verify", i)
+		}
+	}
+	other, _ := NewPrivateKey(true)
+	if other.PubKey().Verify(hash, k.Sign(hash)) {
+		t.Fatalf("signature verified with the wrong key")
+	}
+}
+
+func TestSignMessage(t *testing.T) {
+	for _, compressed := range []bool{true, false} {
+		k := keyOne(t, compressed)
+		address := k.PubKey().Address().String()
+		sig := SignMessage(k, "hello")
+		if ok, err := VerifyMessage(address, sig, "hello"); err != nil || !ok {
+			t.Fatalf("compressed=%v: signature rejected: %v", compressed, err)
+		}
+		if ok, _ := VerifyMessage(address, sig, "hello!"); ok {
+			t.Fatalf("tampered message accepted")
+		}
+		other, _ := NewPrivateKey(compressed)
+		if ok, _ := VerifyMessage(other.PubKey().Address().String(), sig, "hello"); ok {
+			t.Fatalf("signature accepted for another address")
+		}
+	}
+	if _, err := VerifyMessage("nonsense", "", "hello"); err == nil {
+		t.Fatalf("invalid address accepted")
+	}
+}
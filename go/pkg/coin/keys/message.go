@@ -0,0 +1,78 @@
+package keys
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+
+	"pila/pkg/coin"
+)
+
+// MessageMagic prefixes signed messages so a message signature can never be
+// a valid transaction signature.
+const MessageMagic = "Pila Signed Message:\n"
+
+// CompactSignatureSize is the length of a recoverable signature.
+const CompactSignatureSize = 65
+
+// ErrInvalidSignature is returned for malformed compact signatures.
+var ErrInvalidSignature = errors.New("invalid signature")
+
+// MessageHash returns the double SHA-256 of the var_int length prefixed
+// magic followed by the length prefixed message.
+func MessageHash(message string) [32]byte {
+	var buf bytes.Buffer
+	coin.WriteVarBytes(&buf, []byte(MessageMagic))
+	coin.WriteVarBytes(&buf, []byte(message))
+	return coin.DoubleSHA256(buf.Bytes())
+}
+
+// SignCompact returns a 65 byte recoverable signature of hash whose header
+// byte records the recovery id and whether the key is compressed.
+func (k *PrivateKey) SignCompact(hash [32]byte) []byte {
+	return ecdsa.SignCompact(k.key, hash[:], k.compressed)
+}
+
+// RecoverCompact returns the public key that produced the compact signature
+// sig of hash.
+func RecoverCompact(hash [32]byte, sig []byte) (*PublicKey, error) {
+	if len(sig) != CompactSignatureSize {
+		return nil, ErrInvalidSignature
+	}
+	pub, compressed, err := ecdsa.RecoverCompact(sig, hash[:])
+	if err != nil {
+		return nil, ErrInvalidSignature
+	}
+	return &PublicKey{key: pub, compressed: compressed}, nil
+}
+
+// SignMessage returns the base64 compact signature of message
+// (signmessage).
+func SignMessage(k *PrivateKey, message string) string {
+	return base64.StdEncoding.EncodeToString(k.SignCompact(MessageHash(message)))
+}
+
+// VerifyMessage reports whether signature, as returned by SignMessage, was
+// made by the key of address (verifymessage). Malformed addresses and
+// signatures are errors; a valid signature by another key is not.
+func VerifyMessage(address, signature, message string) (bool, error) {
+	var a coin.Address
+	if !a.SetString(address) || !a.IsValid() {
+		return false, errors.New("invalid address")
+	}
+	id, ok := a.GetIDKey()
+	if !ok {
+		return false, errors.New("address does not refer to a key")
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return false, errors.New("malformed base64 encoding")
+	}
+	pub, err := RecoverCompact(MessageHash(message), sig)
+	if err != nil {
+		return false, nil
+	}
+	return pub.ID() == id, nil
+}
@@ -0,0 +1,119 @@
+package keys
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/text/unicode/norm"
+)
+
+// mnemonicSeedIterations is the PBKDF2 round count fixed by BIP39.
+const mnemonicSeedIterations = 2048
+
+var wordIndex = func() map[string]int {
+	m := make(map[string]int, len(englishWordList))
+	for i, w := range englishWordList {
+		m[w] = i
+	}
+	return m
+}()
+
+// ErrInvalidMnemonic is returned for phrases with unknown words, a bad length
+// or a checksum mismatch.
+var ErrInvalidMnemonic = errors.New("invalid mnemonic")
+
+// NewEntropy returns bits of random entropy suitable for a mnemonic. bits
+// must be a multiple of 32 between 128 and 256.
+func NewEntropy(bits int) ([]byte, error) {
+	if bits%32 != 0 || bits < 128 || bits > 256 {
+		return nil, fmt.Errorf("invalid entropy size %d", bits)
+	}
+	b := make([]byte, bits/8)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// NewMnemonic returns a fresh BIP39 phrase of bits entropy.
+func NewMnemonic(bits int) (string, error) {
+	entropy, err := NewEntropy(bits)
+	if err != nil {
+		return "", err
+	}
+	return EntropyToMnemonic(entropy)
+}
+
+// EntropyToMnemonic encodes entropy with its checksum as English words.
+func EntropyToMnemonic(entropy []byte) (string, error) {
+	bits := len(entropy) * 8
+	if bits%32 != 0 || bits < 128 || bits > 256 {
+		return "", fmt.Errorf("invalid entropy size %d", bits)
+	}
+	csBits := uint(bits / 32)
+	sum := sha256.Sum256(entropy)
+
+	n := new(big.Int).SetBytes(entropy)
+	n.Lsh(n, csBits)
+	n.Or(n, big.NewInt(int64(sum[0]>>(8-csBits))))
+
+	words := make([]string, (bits+int(csBits))/11)
+	mask := big.NewInt(2047)
+	for i := len(words) - 1; i >= 0; i-- {
+		words[i] = englishWordList[new(big.Int).And(n, mask).Int64()]
+		n.Rsh(n, 11)
+	}
+	return strings.Join(words, " "), nil
+}
+
+// MnemonicToEntropy decodes a phrase and verifies its checksum.
+func MnemonicToEntropy(mnemonic string) ([]byte, error) {
+	words := strings.Fields(mnemonic)
+	if len(words)%3 != 0 || len(words) < 12 || len(words) > 24 {
+		return nil, ErrInvalidMnemonic
+	}
+	n := new(big.Int)
+	for _, w := range words {
+		i, ok := wordIndex[w]
+		if !ok {
+			return nil, ErrInvalidMnemonic
+		}
+		n.Lsh(n, 11)
+		n.Or(n, big.NewInt(int64(i)))
+	}
+	csBits := uint(len(words) * 11 / 33)
+	cs := new(big.Int).And(n, big.NewInt(1<<csBits-1)).Int64()
+	n.Rsh(n, csBits)
+
+	entropy := n.FillBytes(make([]byte, csBits*4))
+	sum := sha256.Sum256(entropy)
+	if int64(sum[0]>>(8-csBits)) != cs {
+		return nil, ErrInvalidMnemonic
+	}
+	return entropy, nil
+}
+
+// IsValidMnemonic reports whether mnemonic is a valid BIP39 phrase.
+func IsValidMnemonic(mnemonic string) bool {
+	_, err := MnemonicToEntropy(mnemonic)
+	return err == nil
+}
+
+// MnemonicSeed returns the 64 byte seed for a phrase and optional
+// passphrase. Both are NFKD normalized as BIP39 asks, which matters for
+// passphrases outside ASCII.
+func MnemonicSeed(mnemonic, passphrase string) ([]byte, error) {
+	if !IsValidMnemonic(mnemonic) {
+		return nil, ErrInvalidMnemonic
+	}
+	phrase := norm.NFKD.String(strings.Join(strings.Fields(mnemonic), " "))
+	salt := norm.NFKD.String("mnemonic" + passphrase)
+	return pbkdf2.Key([]byte(phrase), []byte(salt),
+		mnemonicSeedIterations, 64, sha512.New), nil
+}
@@ -0,0 +1,73 @@
+package keys
+
+import (
+	"errors"
+
+	"pila/pkg/coin"
+)
+
+// SecretPrefix returns the base58 version byte of WIF secrets on the
+// current network.
+func SecretPrefix() uint8 {
+	if coin.TestNet {
+		return 128 + coin.TypePubKeyTest
+	}
+	return 128 + coin.TypePubKey
+}
+
+// Secret is a WIF (wallet import format) encoded private key.
+type Secret struct {
+	coin.Base58
+}
+
+// SetKey stores k in the secret.
+func (s *Secret) SetKey(k *PrivateKey) {
+	data := k.Bytes()
+	if k.IsCompressed() {
+		data = append(data, 1)
+	}
+	s.SetData(SecretPrefix(), data)
+}
+
+// IsValid reports whether the secret holds a key for either network.
+func (s Secret) IsValid() bool {
+	switch s.Version {
+	case 128 + coin.TypePubKey, 128 + coin.TypePubKeyTest:
+	default:
+		return false
+	}
+	return len(s.Data) == PrivateKeySize ||
+		(len(s.Data) == PrivateKeySize+1 && s.Data[PrivateKeySize] == 1)
+}
+
+// SetString decodes a WIF string.
+func (s *Secret) SetString(val string) bool {
+	return s.Base58.SetString(val) && s.IsValid()
+}
+
+// String returns the WIF encoding.
+func (s Secret) String() string { return s.ToString(true) }
+
+// PrivateKey returns the key held by the secret.
+func (s Secret) PrivateKey() (*PrivateKey, error) {
+	if !s.IsValid() {
+		return nil, errors.New("invalid secret")
+	}
+	return PrivateKeyFromBytes(s.Data[:PrivateKeySize], len(s.Data) > PrivateKeySize)
+}
+
+// EncodeWIF returns the WIF string of k.
+func EncodeWIF(k *PrivateKey) string {
+	var s Secret
+	s.SetKey(k)
+	return s.String()
+}
+
+// DecodeWIF parses a WIF string.
+func DecodeWIF(val string) (*PrivateKey, error) {
+	var s Secret
+	if !s.SetString(val) {
+		return nil, errors.New("invalid private key encoding")
+	}
+	return s.PrivateKey()
+}
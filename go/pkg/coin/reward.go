@@ -0,0 +1,50 @@
+package coin
+
+import "math"
+
+// ProofOfWorkReward returns the subsidy of a proof-of-work block at height
+// (reward::get_proof_of_work_vanilla). Fees are destroyed and not part of
+// it.
+func ProofOfWorkReward(height int32) int64 {
+	if height >= 136400 && height <= 136400+1000 {
+		return 1
+	}
+	s := 1111.0 * math.Pow(float64(height)+1, 2)
+	if s > 128 {
+		s = 128
+	}
+	if s < 1 {
+		s = 1
+	}
+	subsidy := int64(s) * 1000000
+
+	// The C++ code mixes single precision and integer arithmetic here; the
+	// conversions below follow it exactly.
+	decay := func(step int32) {
+		d := float64(float32(10000.0) / float32(height))
+		for i := step; i <= height; i += step {
+			subsidy = int64(float64(subsidy) - (float64(subsidy/28) - d*d))
+			subsidy -= (subsidy / 28 * 4) / 28
+		}
+	}
+	switch {
+	case height < 325000:
+		for i := int32(50000); i <= height; i += 50000 {
+			subsidy -= subsidy / 6
+		}
+	case height < 385000:
+		decay(10000)
+	default:
+		decay(7000)
+	}
+	if float32(subsidy)/1000000.0 < 1.0 {
+		subsidy = 1000000
+	}
+	return subsidy
+}
+
+// ProofOfStakeReward returns the reward for staking coinAge coin-days
+// (reward::get_proof_of_stake_vanilla).
+func ProofOfStakeReward(coinAge uint64) int64 {
+	return int64(coinAge) * MaxMintProofOfStake / 365
+}
@@ -0,0 +1,1213 @@
+package coin
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"golang.org/x/crypto/ripemd160"
+)
+
+// Opcodes of the script language. The values match the C++ script.hpp
+// enumeration.
+const (
+	OP_0         = 0x00
+	OP_FALSE     = OP_0
+	OP_PUSHDATA1 = 0x4c
+	OP_PUSHDATA2 = 0x4d
+	OP_PUSHDATA4 = 0x4e
+	OP_1NEGATE   = 0x4f
+	OP_RESERVED  = 0x50
+	OP_1         = 0x51
+	OP_TRUE      = OP_1
+	OP_16        = 0x60
+
+	OP_NOP      = 0x61
+	OP_VER      = 0x62
+	OP_IF       = 0x63
+	OP_NOTIF    = 0x64
+	OP_VERIF    = 0x65
+	OP_VERNOTIF = 0x66
+	OP_ELSE     = 0x67
+	OP_ENDIF    = 0x68
+	OP_VERIFY   = 0x69
+	OP_RETURN   = 0x6a
+
+	OP_TOALTSTACK   = 0x6b
+	OP_FROMALTSTACK = 0x6c
+	OP_2DROP        = 0x6d
+	OP_2DUP         = 0x6e
+	OP_3DUP         = 0x6f
+	OP_2OVER        = 0x70
+	OP_2ROT         = 0x71
+	OP_2SWAP        = 0x72
+	OP_IFDUP        = 0x73
+	OP_DEPTH        = 0x74
+	OP_DROP         = 0x75
+	OP_DUP          = 0x76
+	OP_NIP          = 0x77
+	OP_OVER         = 0x78
+	OP_PICK         = 0x79
+	OP_ROLL         = 0x7a
+	OP_ROT          = 0x7b
+	OP_SWAP         = 0x7c
+	OP_TUCK         = 0x7d
+
+	OP_CAT    = 0x7e
+	OP_SUBSTR = 0x7f
+	OP_LEFT   = 0x80
+	OP_RIGHT  = 0x81
+	OP_SIZE   = 0x82
+
+	OP_INVERT      = 0x83
+	OP_AND         = 0x84
+	OP_OR          = 0x85
+	OP_XOR         = 0x86
+	OP_EQUAL       = 0x87
+	OP_EQUALVERIFY = 0x88
+	OP_RESERVED1   = 0x89
+	OP_RESERVED2   = 0x8a
+
+	OP_1ADD               = 0x8b
+	OP_1SUB               = 0x8c
+	OP_2MUL               = 0x8d
+	OP_2DIV               = 0x8e
+	OP_NEGATE             = 0x8f
+	OP_ABS                = 0x90
+	OP_NOT                = 0x91
+	OP_0NOTEQUAL          = 0x92
+	OP_ADD                = 0x93
+	OP_SUB                = 0x94
+	OP_MUL                = 0x95
+	OP_DIV                = 0x96
+	OP_MOD                = 0x97
+	OP_LSHIFT             = 0x98
+	OP_RSHIFT             = 0x99
+	OP_BOOLAND            = 0x9a
+	OP_BOOLOR             = 0x9b
+	OP_NUMEQUAL           = 0x9c
+	OP_NUMEQUALVERIFY     = 0x9d
+	OP_NUMNOTEQUAL        = 0x9e
+	OP_LESSTHAN           = 0x9f
+	OP_GREATERTHAN        = 0xa0
+	OP_LESSTHANOREQUAL    = 0xa1
+	OP_GREATERTHANOREQUAL = 0xa2
+	OP_MIN                = 0xa3
+	OP_MAX                = 0xa4
+	OP_WITHIN             = 0xa5
+
+	OP_RIPEMD160           = 0xa6
+	OP_SHA1                = 0xa7
+	OP_SHA256              = 0xa8
+	OP_HASH160             = 0xa9
+	OP_HASH256             = 0xaa
+	OP_CODESEPARATOR       = 0xab
+	OP_CHECKSIG            = 0xac
+	OP_CHECKSIGVERIFY      = 0xad
+	OP_CHECKMULTISIG       = 0xae
+	OP_CHECKMULTISIGVERIFY = 0xaf
+
+	OP_NOP1  = 0xb0
+	OP_NOP10 = 0xb9
+)
+
+// Signature hash types.
+const (
+	SigHashAll          = 0x01
+	SigHashNone         = 0x02
+	SigHashSingle       = 0x03
+	SigHashAnyoneCanPay = 0x80
+)
+
+// ScriptFlags select optional verification rules.
+type ScriptFlags uint32
+
+const (
+	// ScriptVerifyP2SH evaluates pay-to-script-hash redeem scripts.
+	ScriptVerifyP2SH ScriptFlags = 1 << iota
+	// ScriptVerifyLowS rejects signatures with a high S value.
+	ScriptVerifyLowS
+)
+
+// StandardScriptFlags are the rules applied to relayed transactions and
+// blocks.
+const StandardScriptFlags = ScriptVerifyP2SH
+
+const (
+	maxScriptSize       = 10000
+	maxScriptElement    = 520
+	maxScriptOps        = 201
+	maxStackSize        = 1000
+	maxPubKeysPerMulti  = 20
+	maxStandardMultiSig = 3
+)
+
+// ScriptClass identifies the standard script templates.
+type ScriptClass int
+
+const (
+	NonStandardTy ScriptClass = iota
+	PubKeyTy
+	PubKeyHashTy
+	ScriptHashTy
+	MultiSigTy
+	NullDataTy
+)
+
+func (c ScriptClass) String() string {
+	switch c {
+	case PubKeyTy:
+		return "pubkey"
+	case PubKeyHashTy:
+		return "pubkeyhash"
+	case ScriptHashTy:
+		return "scripthash"
+	case MultiSigTy:
+		return "multisig"
+	case NullDataTy:
+		return "nulldata"
+	default:
+		return "nonstandard"
+	}
+}
+
+// ScriptOp is a single parsed opcode with its pushed data, if any.
+type ScriptOp struct {
+	Code byte
+	Data []byte
+}
+
+// ParseScript splits script into opcodes.
+func ParseScript(script []byte) ([]ScriptOp, error) {
+	var ops []ScriptOp
+	for i := 0; i < len(script); {
+		op := script[i]
+		i++
+		var n int
+		switch {
+		case op > OP_0 && op < OP_PUSHDATA1:
+			n = int(op)
+		case op == OP_PUSHDATA1:
+			if i+1 > len(script) {
+				return nil, errors.New("truncated pushdata1")
+			}
+			n = int(script[i])
+			i++
+		case op == OP_PUSHDATA2:
+			if i+2 > len(script) {
+				return nil, errors.New("truncated pushdata2")
+			}
+			n = int(binary.LittleEndian.Uint16(script[i:]))
+			i += 2
+		case op == OP_PUSHDATA4:
+			if i+4 > len(script) {
+				return nil, errors.New("truncated pushdata4")
+			}
+			n = int(binary.LittleEndian.Uint32(script[i:]))
+			i += 4
+		default:
+			ops = append(ops, ScriptOp{Code: op})
+			continue
+		}
+		if n < 0 || i+n > len(script) {
+			return nil, errors.New("push past end of script")
+		}
+		ops = append(ops, ScriptOp{Code: op, Data: script[i : i+n]})
+		i += n
+	}
+	return ops, nil
+}
+
+// IsPushOnly reports whether script contains only data pushes.
+func IsPushOnly(script []byte) bool {
+	ops, err := ParseScript(script)
+	if err != nil {
+		return false
+	}
+	for _, op := range ops {
+		if op.Code > OP_16 {
+			return false
+		}
+	}
+	return true
+}
+
+// ScriptBuilder assembles scripts using canonical pushes.
+type ScriptBuilder struct {
+	buf bytes.Buffer
+}
+
+// AddOp appends an opcode.
+func (b *ScriptBuilder) AddOp(op byte) *ScriptBuilder {
+	b.buf.WriteByte(op)
+	return b
+}
+
+// AddInt64 pushes a small integer using the shortest encoding.
+func (b *ScriptBuilder) AddInt64(v int64) *ScriptBuilder {
+	switch {
+	case v == 0:
+		return b.AddOp(OP_0)
+	case v == -1:
+		return b.AddOp(OP_1NEGATE)
+	case v >= 1 && v <= 16:
+		return b.AddOp(byte(OP_1 + v - 1))
+	}
+	return b.AddData(scriptNum(v))
+}
+
+// AddData pushes data with the smallest push opcode.
+func (b *ScriptBuilder) AddData(data []byte) *ScriptBuilder {
+	n := len(data)
+	switch {
+	case n < OP_PUSHDATA1:
+		b.buf.WriteByte(byte(n))
+	case n <= 0xff:
+		b.buf.WriteByte(OP_PUSHDATA1)
+		b.buf.WriteByte(byte(n))
+	case n <= 0xffff:
+		b.buf.WriteByte(OP_PUSHDATA2)
+		var l [2]byte
+		binary.LittleEndian.PutUint16(l[:], uint16(n))
+		b.buf.Write(l[:])
+	default:
+		b.buf.WriteByte(OP_PUSHDATA4)
+		var l [4]byte
+		binary.LittleEndian.PutUint32(l[:], uint32(n))
+		b.buf.Write(l[:])
+	}
+	b.buf.Write(data)
+	return b
+}
+
+// Script returns a copy of the assembled script.
+func (b *ScriptBuilder) Script() []byte {
+	return append([]byte(nil), b.buf.Bytes()...)
+}
+
+// PayToPubKeyHashScript returns the standard script paying to id.
+func PayToPubKeyHashScript(id IDKey) []byte {
+	var b ScriptBuilder
+	return b.AddOp(OP_DUP).AddOp(OP_HASH160).AddData(id[:]).
+		AddOp(OP_EQUALVERIFY).AddOp(OP_CHECKSIG).Script()
+}
+
+// PayToPubKeyScript returns the script paying directly to a public key.
+func PayToPubKeyScript(pubKey []byte) []byte {
+	var b ScriptBuilder
+	return b.AddData(pubKey).AddOp(OP_CHECKSIG).Script()
+}
+
+// PayToScriptHashScript returns the standard script paying to id.
+func PayToScriptHashScript(id IDScript) []byte {
+	var b ScriptBuilder
+	return b.AddOp(OP_HASH160).AddData(id[:]).AddOp(OP_EQUAL).Script()
+}
+
+// MultiSigScript returns an m-of-n bare multisig script.
+func MultiSigScript(m int, pubKeys [][]byte) ([]byte, error) {
+	if m < 1 || m > len(pubKeys) || len(pubKeys) > 16 {
+		return nil, fmt.Errorf("invalid multisig %d of %d", m, len(pubKeys))
+	}
+	var b ScriptBuilder
+	b.AddInt64(int64(m))
+	for _, pk := range pubKeys {
+		b.AddData(pk)
+	}
+	return b.AddInt64(int64(len(pubKeys))).AddOp(OP_CHECKMULTISIG).Script(), nil
+}
+
+// PayToDestinationScript returns the output script for an address
+// destination.
+func PayToDestinationScript(dest DestinationTx) ([]byte, error) {
+	switch d := dest.(type) {
+	case IDKey:
+		return PayToPubKeyHashScript(d), nil
+	case IDScript:
+		return PayToScriptHashScript(d), nil
+	default:
+		return nil, errors.New("unsupported destination")
+	}
+}
+
+// ScriptHash returns the IDScript of a redeem script.
+func ScriptHash(script []byte) IDScript {
+	return IDScript(SHA256RIPEMD160(script))
+}
+
+func isSmallInt(op byte) bool {
+	return op == OP_0 || (op >= OP_1 && op <= OP_16)
+}
+
+func smallInt(op byte) int {
+	if op == OP_0 {
+		return 0
+	}
+	return int(op-OP_1) + 1
+}
+
+// ExtractScript classifies script and returns the data items of the
+// template: the public key, the key or script hash, or the multisig public
+// keys preceded by the required signature count as a one byte slice.
+func ExtractScript(script []byte) (ScriptClass, [][]byte) {
+	ops, err := ParseScript(script)
+	if err != nil {
+		return NonStandardTy, nil
+	}
+	switch {
+	case len(ops) == 2 && ops[1].Code == OP_CHECKSIG &&
+		(len(ops[0].Data) == 33 || len(ops[0].Data) == 65):
+		return PubKeyTy, [][]byte{ops[0].Data}
+	case len(ops) == 5 && ops[0].Code == OP_DUP && ops[1].Code == OP_HASH160 &&
+		len(ops[2].Data) == 20 && ops[3].Code == OP_EQUALVERIFY && ops[4].Code == OP_CHECKSIG:
+		return PubKeyHashTy, [][]byte{ops[2].Data}
+	case len(script) == 23 && ops[0].Code == OP_HASH160 && len(ops) == 3 &&
+		len(ops[1].Data) == 20 && ops[2].Code == OP_EQUAL:
+		return ScriptHashTy, [][]byte{ops[1].Data}
+	case len(ops) >= 1 && ops[0].Code == OP_RETURN:
+		if len(ops) == 1 || (len(ops) == 2 && ops[1].Code <= OP_PUSHDATA4 && len(ops[1].Data) <= 80) {
+			return NullDataTy, nil
+		}
+	case len(ops) >= 4 && ops[len(ops)-1].Code == OP_CHECKMULTISIG:
+		mOp, nOp := ops[0].Code, ops[len(ops)-2].Code
+		if !isSmallInt(mOp) || !isSmallInt(nOp) {
+			break
+		}
+		m, n := smallInt(mOp), smallInt(nOp)
+		keys := ops[1 : len(ops)-2]
+		if m < 1 || m > n || n != len(keys) {
+			break
+		}
+		out := [][]byte{{byte(m)}}
+		for _, k := range keys {
+			if len(k.Data) != 33 && len(k.Data) != 65 {
+				return NonStandardTy, nil
+			}
+			out = append(out, k.Data)
+		}
+		return MultiSigTy, out
+	}
+	return NonStandardTy, nil
+}
+
+// ExtractDestination returns the address destination paid by script.
+func ExtractDestination(script []byte) (DestinationTx, bool) {
+	class, data := ExtractScript(script)
+	switch class {
+	case PubKeyTy:
+		return IDKey(SHA256RIPEMD160(data[0])), true
+	case PubKeyHashTy:
+		var id IDKey
+		copy(id[:], data[0])
+		return id, true
+	case ScriptHashTy:
+		var id IDScript
+		copy(id[:], data[0])
+		return id, true
+	}
+	return None{}, false
+}
+
+// IsStandardScript reports whether an output script uses one of the
+// relayable templates.
+func IsStandardScript(script []byte) bool {
+	class, data := ExtractScript(script)
+	switch class {
+	case NonStandardTy:
+		return false
+	case MultiSigTy:
+		return len(data)-1 <= maxStandardMultiSig
+	}
+	return true
+}
+
+// removeOp returns script with every occurrence of the opcode removed.
+func removeOp(script []byte, code byte) []byte {
+	ops, err := ParseScript(script)
+	if err != nil {
+		return script
+	}
+	var b ScriptBuilder
+	for _, op := range ops {
+		if op.Code == code {
+			continue
+		}
+		b.buf.Write(encodeOp(op))
+	}
+	return b.Script()
+}
+
+// removeData returns script with every canonical push of data removed.
+func removeData(script, data []byte) []byte {
+	ops, err := ParseScript(script)
+	if err != nil {
+		return script
+	}
+	var b ScriptBuilder
+	for _, op := range ops {
+		if op.Code <= OP_PUSHDATA4 && bytes.Equal(op.Data, data) {
+			continue
+		}
+		b.buf.Write(encodeOp(op))
+	}
+	return b.Script()
+}
+
+func encodeOp(op ScriptOp) []byte {
+	var b ScriptBuilder
+	switch {
+	case op.Code == OP_0 || op.Code > OP_PUSHDATA4:
+		b.buf.WriteByte(op.Code)
+	case op.Code < OP_PUSHDATA1:
+		b.buf.WriteByte(op.Code)
+		b.buf.Write(op.Data)
+	case op.Code == OP_PUSHDATA1:
+		b.buf.WriteByte(op.Code)
+		b.buf.WriteByte(byte(len(op.Data)))
+		b.buf.Write(op.Data)
+	case op.Code == OP_PUSHDATA2:
+		var l [2]byte
+		binary.LittleEndian.PutUint16(l[:], uint16(len(op.Data)))
+		b.buf.WriteByte(op.Code)
+		b.buf.Write(l[:])
+		b.buf.Write(op.Data)
+	default:
+		var l [4]byte
+		binary.LittleEndian.PutUint32(l[:], uint32(len(op.Data)))
+		b.buf.WriteByte(op.Code)
+		b.buf.Write(l[:])
+		b.buf.Write(op.Data)
+	}
+	return b.buf.Bytes()
+}
+
+// SignatureHash computes the digest signed by input n of tx. The inputs and
+// outputs are blanked per hash type as in the C++ script::signature_hash,
+// but the digest is taken over this package's transaction encoding, whose
+// hashes are length prefixed, so it does not match C++ signature hashes.
+func SignatureHash(subScript []byte, tx Transaction, n int, hashType uint32) ([32]byte, error) {
+	if n < 0 || n >= len(tx.Inputs) {
+		return [32]byte{}, fmt.Errorf("input %d out of range", n)
+	}
+	tmp := Transaction{
+		Version:  tx.Version,
+		Inputs:   make([]TxIn, len(tx.Inputs)),
+		Outputs:  append([]TxOut(nil), tx.Outputs...),
+		LockTime: tx.LockTime,
+	}
+	copy(tmp.Inputs, tx.Inputs)
+	for i := range tmp.Inputs {
+		tmp.Inputs[i].ScriptSig = nil
+	}
+	tmp.Inputs[n].ScriptSig = removeOp(subScript, OP_CODESEPARATOR)
+
+	switch hashType & 0x1f {
+	case SigHashNone:
+		tmp.Outputs = nil
+		for i := range tmp.Inputs {
+			if i != n {
+				tmp.Inputs[i].Sequence = 0
+			}
+		}
+	case SigHashSingle:
+		if n >= len(tmp.Outputs) {
+			// The C++ code returns the number one rather than failing,
+			// and signatures over it are valid.
+			return [32]byte{1}, nil
+		}
+		tmp.Outputs = tmp.Outputs[:n+1]
+		for i := 0; i < n; i++ {
+			tmp.Outputs[i] = TxOut{Value: -1}
+		}
+		for i := range tmp.Inputs {
+			if i != n {
+				tmp.Inputs[i].Sequence = 0
+			}
+		}
+	}
+	if hashType&SigHashAnyoneCanPay != 0 {
+		tmp.Inputs = []TxIn{tmp.Inputs[n]}
+	}
+
+	var buf bytes.Buffer
+	if err := tmp.Encode(&buf); err != nil {
+		return [32]byte{}, err
+	}
+	var ht [4]byte
+	binary.LittleEndian.PutUint32(ht[:], hashType)
+	buf.Write(ht[:])
+	return DoubleSHA256(buf.Bytes()), nil
+}
+
+// scriptNum encodes v as a minimal little-endian sign-magnitude number.
+func scriptNum(v int64) []byte {
+	if v == 0 {
+		return nil
+	}
+	neg := v < 0
+	if neg {
+		v = -v
+	}
+	var out []byte
+	for v > 0 {
+		out = append(out, byte(v&0xff))
+		v >>= 8
+	}
+	if out[len(out)-1]&0x80 != 0 {
+		if neg {
+			out = append(out, 0x80)
+		} else {
+			out = append(out, 0)
+		}
+	} else if neg {
+		out[len(out)-1] |= 0x80
+	}
+	return out
+}
+
+func castToBool(v []byte) bool {
+	for i := range v {
+		if v[i] != 0 {
+			// Negative zero is still false.
+			if i == len(v)-1 && v[i] == 0x80 {
+				return false
+			}
+			return true
+		}
+	}
+	return false
+}
+
+func decodeScriptNum(v []byte) (int64, error) {
+	if len(v) > 4 {
+		return 0, errors.New("script number overflow")
+	}
+	if len(v) == 0 {
+		return 0, nil
+	}
+	var r int64
+	for i, b := range v {
+		r |= int64(b) << (8 * uint(i))
+	}
+	if v[len(v)-1]&0x80 != 0 {
+		r &^= int64(0x80) << (8 * uint(len(v)-1))
+		return -r, nil
+	}
+	return r, nil
+}
+
+var (
+	errStackUnderflow = errors.New("stack underflow")
+	errVerifyFailed   = errors.New("verify failed")
+	errEvalFalse      = errors.New("script evaluated to false")
+)
+
+// scriptEngine executes scripts for one input of a transaction.
+type scriptEngine struct {
+	tx    Transaction
+	n     int
+	flags ScriptFlags
+	cache *SignatureCache
+	stack [][]byte
+	alt   [][]byte
+}
+
+func (e *scriptEngine) push(v []byte) { e.stack = append(e.stack, v) }
+
+func (e *scriptEngine) pop() ([]byte, error) {
+	if len(e.stack) == 0 {
+		return nil, errStackUnderflow
+	}
+	v := e.stack[len(e.stack)-1]
+	e.stack = e.stack[:len(e.stack)-1]
+	return v, nil
+}
+
+func (e *scriptEngine) peek(i int) ([]byte, error) {
+	if i >= len(e.stack) {
+		return nil, errStackUnderflow
+	}
+	return e.stack[len(e.stack)-1-i], nil
+}
+
+func boolBytes(b bool) []byte {
+	if b {
+		return []byte{1}
+	}
+	return nil
+}
+
+// eval runs script against the engine stack.
+func (e *scriptEngine) eval(script []byte) error {
+	if len(script) > maxScriptSize {
+		return errors.New("script too large")
+	}
+	ops, err := ParseScript(script)
+	if err != nil {
+		return err
+	}
+	var exec []bool
+	executing := func() bool {
+		for _, b := range exec {
+			if !b {
+				return false
+			}
+		}
+		return true
+	}
+	opCount := 0
+	codeStart := 0
+	for pc, op := range ops {
+		if len(op.Data) > maxScriptElement {
+			return errors.New("push exceeds element size")
+		}
+		if op.Code > OP_16 {
+			opCount++
+			if opCount > maxScriptOps {
+				return errors.New("too many operations")
+			}
+		}
+		if disabledOp(op.Code) {
+			return fmt.Errorf("disabled opcode 0x%02x", op.Code)
+		}
+		run := executing()
+		if op.Code <= OP_PUSHDATA4 {
+			if run {
+				e.push(op.Data)
+			}
+			continue
+		}
+		if !run && (op.Code < OP_IF || op.Code > OP_ENDIF) {
+			continue
+		}
+		switch {
+		case op.Code == OP_1NEGATE:
+			e.push(scriptNum(-1))
+		case op.Code >= OP_1 && op.Code <= OP_16:
+			e.push(scriptNum(int64(smallInt(op.Code))))
+		case op.Code == OP_NOP || op.Code >= OP_NOP1 && op.Code <= OP_NOP10:
+		case op.Code == OP_IF || op.Code == OP_NOTIF:
+			val := false
+			if run {
+				v, err := e.pop()
+				if err != nil {
+					return err
+				}
+				val = castToBool(v)
+				if op.Code == OP_NOTIF {
+					val = !val
+				}
+			}
+			exec = append(exec, val)
+		case op.Code == OP_ELSE:
+			if len(exec) == 0 {
+				return errors.New("else without if")
+			}
+			exec[len(exec)-1] = !exec[len(exec)-1]
+		case op.Code == OP_ENDIF:
+			if len(exec) == 0 {
+				return errors.New("endif without if")
+			}
+			exec = exec[:len(exec)-1]
+		case op.Code == OP_VERIFY:
+			v, err := e.pop()
+			if err != nil {
+				return err
+			}
+			if !castToBool(v) {
+				return errVerifyFailed
+			}
+		case op.Code == OP_RETURN:
+			return errors.New("op_return encountered")
+		case op.Code == OP_TOALTSTACK:
+			v, err := e.pop()
+			if err != nil {
+				return err
+			}
+			e.alt = append(e.alt, v)
+		case op.Code == OP_FROMALTSTACK:
+			if len(e.alt) == 0 {
+				return errStackUnderflow
+			}
+			e.push(e.alt[len(e.alt)-1])
+			e.alt = e.alt[:len(e.alt)-1]
+		case op.Code == OP_2DROP:
+			if len(e.stack) < 2 {
+				return errStackUnderflow
+			}
+			e.stack = e.stack[:len(e.stack)-2]
+		case op.Code == OP_2DUP:
+			if len(e.stack) < 2 {
+				return errStackUnderflow
+			}
+			e.stack = append(e.stack, e.stack[len(e.stack)-2], e.stack[len(e.stack)-1])
+		case op.Code == OP_3DUP:
+			if len(e.stack) < 3 {
+				return errStackUnderflow
+			}
+			e.stack = append(e.stack, e.stack[len(e.stack)-3:]...)
+		case op.Code == OP_2OVER:
+			if len(e.stack) < 4 {
+				return errStackUnderflow
+			}
+			e.stack = append(e.stack, e.stack[len(e.stack)-4:len(e.stack)-2]...)
+		case op.Code == OP_2ROT:
+			if len(e.stack) < 6 {
+				return errStackUnderflow
+			}
+			s := e.stack[len(e.stack)-6:]
+			s[0], s[1], s[2], s[3], s[4], s[5] = s[2], s[3], s[4], s[5], s[0], s[1]
+		case op.Code == OP_2SWAP:
+			if len(e.stack) < 4 {
+				return errStackUnderflow
+			}
+			s := e.stack[len(e.stack)-4:]
+			s[0], s[1], s[2], s[3] = s[2], s[3], s[0], s[1]
+		case op.Code == OP_IFDUP:
+			v, err := e.peek(0)
+			if err != nil {
+				return err
+			}
+			if castToBool(v) {
+				e.push(v)
+			}
+		case op.Code == OP_DEPTH:
+			e.push(scriptNum(int64(len(e.stack))))
+		case op.Code == OP_DROP:
+			if _, err := e.pop(); err != nil {
+				return err
+			}
+		case op.Code == OP_DUP:
+			v, err := e.peek(0)
+			if err != nil {
+				return err
+			}
+			e.push(v)
+		case op.Code == OP_NIP:
+			if len(e.stack) < 2 {
+				return errStackUnderflow
+			}
+			e.stack = append(e.stack[:len(e.stack)-2], e.stack[len(e.stack)-1])
+		case op.Code == OP_OVER:
+			v, err := e.peek(1)
+			if err != nil {
+				return err
+			}
+			e.push(v)
+		case op.Code == OP_PICK || op.Code == OP_ROLL:
+			if len(e.stack) < 2 {
+				return errStackUnderflow
+			}
+			n, err := e.popNum()
+			if err != nil {
+				return err
+			}
+			if n < 0 || n >= int64(len(e.stack)) {
+				return errStackUnderflow
+			}
+			i := len(e.stack) - 1 - int(n)
+			v := e.stack[i]
+			if op.Code == OP_ROLL {
+				e.stack = append(e.stack[:i], e.stack[i+1:]...)
+			}
+			e.push(v)
+		case op.Code == OP_ROT:
+			if len(e.stack) < 3 {
+				return errStackUnderflow
+			}
+			s := e.stack[len(e.stack)-3:]
+			s[0], s[1], s[2] = s[1], s[2], s[0]
+		case op.Code == OP_SWAP:
+			if len(e.stack) < 2 {
+				return errStackUnderflow
+			}
+			s := e.stack[len(e.stack)-2:]
+			s[0], s[1] = s[1], s[0]
+		case op.Code == OP_TUCK:
+			if len(e.stack) < 2 {
+				return errStackUnderflow
+			}
+			top := e.stack[len(e.stack)-1]
+			e.stack = append(e.stack[:len(e.stack)-2], top, e.stack[len(e.stack)-2], top)
+		case op.Code == OP_SIZE:
+			v, err := e.peek(0)
+			if err != nil {
+				return err
+			}
+			e.push(scriptNum(int64(len(v))))
+		case op.Code == OP_EQUAL || op.Code == OP_EQUALVERIFY:
+			a, err := e.pop()
+			if err != nil {
+				return err
+			}
+			b, err := e.pop()
+			if err != nil {
+				return err
+			}
+			eq := bytes.Equal(a, b)
+			if op.Code == OP_EQUALVERIFY {
+				if !eq {
+					return errVerifyFailed
+				}
+			} else {
+				e.push(boolBytes(eq))
+			}
+		case op.Code >= OP_1ADD && op.Code <= OP_0NOTEQUAL:
+			if len(e.stack) < 1 {
+				return errStackUnderflow
+			}
+			a, err := e.popNum()
+			if err != nil {
+				return err
+			}
+			e.push(scriptNum(unaryNumOp(op.Code, a)))
+		case op.Code >= OP_ADD && op.Code <= OP_MAX:
+			if len(e.stack) < 2 {
+				return errStackUnderflow
+			}
+			b, err := e.popNum()
+			if err != nil {
+				return err
+			}
+			a, err := e.popNum()
+			if err != nil {
+				return err
+			}
+			v := binaryNumOp(op.Code, a, b)
+			if op.Code == OP_NUMEQUALVERIFY {
+				if v == 0 {
+					return errVerifyFailed
+				}
+			} else {
+				e.push(scriptNum(v))
+			}
+		case op.Code == OP_WITHIN:
+			if len(e.stack) < 3 {
+				return errStackUnderflow
+			}
+			hi, err := e.popNum()
+			if err != nil {
+				return err
+			}
+			lo, err := e.popNum()
+			if err != nil {
+				return err
+			}
+			x, err := e.popNum()
+			if err != nil {
+				return err
+			}
+			e.push(boolBytes(lo <= x && x < hi))
+		case op.Code == OP_RIPEMD160 || op.Code == OP_SHA1 || op.Code == OP_SHA256 ||
+			op.Code == OP_HASH160 || op.Code == OP_HASH256:
+			v, err := e.pop()
+			if err != nil {
+				return err
+			}
+			e.push(hashOp(op.Code, v))
+		case op.Code == OP_CODESEPARATOR:
+			codeStart = pc + 1
+		case op.Code == OP_CHECKSIG || op.Code == OP_CHECKSIGVERIFY:
+			pub, err := e.pop()
+			if err != nil {
+				return err
+			}
+			sig, err := e.pop()
+			if err != nil {
+				return err
+			}
+			sub := removeData(opsScript(ops[codeStart:]), sig)
+			ok := e.checkSig(sig, pub, sub)
+			if op.Code == OP_CHECKSIGVERIFY {
+				if !ok {
+					return errVerifyFailed
+				}
+			} else {
+				e.push(boolBytes(ok))
+			}
+		case op.Code == OP_CHECKMULTISIG || op.Code == OP_CHECKMULTISIGVERIFY:
+			ok, err := e.checkMultiSig(opsScript(ops[codeStart:]), &opCount)
+			if err != nil {
+				return err
+			}
+			if op.Code == OP_CHECKMULTISIGVERIFY {
+				if !ok {
+					return errVerifyFailed
+				}
+			} else {
+				e.push(boolBytes(ok))
+			}
+		default:
+			// OP_RESERVED, OP_VER, OP_VERIF, OP_VERNOTIF, OP_RESERVED1,
+			// OP_RESERVED2 and the undefined opcodes.
+			return fmt.Errorf("invalid opcode 0x%02x", op.Code)
+		}
+		if len(e.stack)+len(e.alt) > maxStackSize {
+			return errors.New("stack size limit exceeded")
+		}
+	}
+	if len(exec) != 0 {
+		return errors.New("unbalanced conditional")
+	}
+	return nil
+}
+
+// disabledOp reports whether code is one of the opcodes the C++ code
+// refuses even in an unexecuted branch.
+func disabledOp(code byte) bool {
+	switch code {
+	case OP_CAT, OP_SUBSTR, OP_LEFT, OP_RIGHT, OP_INVERT, OP_AND, OP_OR,
+		OP_XOR, OP_2MUL, OP_2DIV, OP_MUL, OP_DIV, OP_MOD, OP_LSHIFT, OP_RSHIFT:
+		return true
+	}
+	return false
+}
+
+// popNum pops a script number of at most four bytes.
+func (e *scriptEngine) popNum() (int64, error) {
+	v, err := e.pop()
+	if err != nil {
+		return 0, err
+	}
+	return decodeScriptNum(v)
+}
+
+func boolNum(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// unaryNumOp applies one of OP_1ADD to OP_0NOTEQUAL to a.
+func unaryNumOp(code byte, a int64) int64 {
+	switch code {
+	case OP_1ADD:
+		return a + 1
+	case OP_1SUB:
+		return a - 1
+	case OP_NEGATE:
+		return -a
+	case OP_ABS:
+		if a < 0 {
+			return -a
+		}
+		return a
+	case OP_NOT:
+		return boolNum(a == 0)
+	default:
+		return boolNum(a != 0)
+	}
+}
+
+// binaryNumOp applies one of OP_ADD to OP_MAX to a and b, b having been
+// on top of the stack.
+func binaryNumOp(code byte, a, b int64) int64 {
+	switch code {
+	case OP_ADD:
+		return a + b
+	case OP_SUB:
+		return a - b
+	case OP_BOOLAND:
+		return boolNum(a != 0 && b != 0)
+	case OP_BOOLOR:
+		return boolNum(a != 0 || b != 0)
+	case OP_NUMEQUAL, OP_NUMEQUALVERIFY:
+		return boolNum(a == b)
+	case OP_NUMNOTEQUAL:
+		return boolNum(a != b)
+	case OP_LESSTHAN:
+		return boolNum(a < b)
+	case OP_GREATERTHAN:
+		return boolNum(a > b)
+	case OP_LESSTHANOREQUAL:
+		return boolNum(a <= b)
+	case OP_GREATERTHANOREQUAL:
+		return boolNum(a >= b)
+	case OP_MIN:
+		return min(a, b)
+	default:
+		return max(a, b)
+	}
+}
+
+func opsScript(ops []ScriptOp) []byte {
+	var b bytes.Buffer
+	for _, op := range ops {
+		b.Write(encodeOp(op))
+	}
+	return b.Bytes()
+}
+
+func hashOp(code byte, v []byte) []byte {
+	switch code {
+	case OP_RIPEMD160:
+		return ripemd160Sum(v)
+	case OP_SHA1:
+		h := sha1.Sum(v)
+		return h[:]
+	case OP_SHA256:
+		h := sha256Sum(v)
+		return h[:]
+	case OP_HASH160:
+		return Hash160(v)
+	default:
+		h := DoubleSHA256(v)
+		return h[:]
+	}
+}
+
+func (e *scriptEngine) checkMultiSig(script []byte, opCount *int) (bool, error) {
+	nv, err := e.pop()
+	if err != nil {
+		return false, err
+	}
+	n, err := decodeScriptNum(nv)
+	if err != nil {
+		return false, err
+	}
+	if n < 0 || n > maxPubKeysPerMulti {
+		return false, errors.New("invalid public key count")
+	}
+	*opCount += int(n)
+	if *opCount > maxScriptOps {
+		return false, errors.New("too many operations")
+	}
+	pubs := make([][]byte, n)
+	for i := range pubs {
+		if pubs[i], err = e.pop(); err != nil {
+			return false, err
+		}
+	}
+	mv, err := e.pop()
+	if err != nil {
+		return false, err
+	}
+	m, err := decodeScriptNum(mv)
+	if err != nil {
+		return false, err
+	}
+	if m < 0 || m > n {
+		return false, errors.New("invalid signature count")
+	}
+	sigs := make([][]byte, m)
+	for i := range sigs {
+		if sigs[i], err = e.pop(); err != nil {
+			return false, err
+		}
+	}
+	// The original implementation pops one extra element.
+	if _, err := e.pop(); err != nil {
+		return false, err
+	}
+	for _, sig := range sigs {
+		script = removeData(script, sig)
+	}
+	// Signatures and keys are in the same order, consumed from the top of
+	// the stack, so a signature can only match a key at or after the
+	// previous match.
+	k := 0
+	for _, sig := range sigs {
+		for k < len(pubs) && !e.checkSig(sig, pubs[k], script) {
+			k++
+		}
+		if k == len(pubs) {
+			return false, nil
+		}
+		k++
+	}
+	return true, nil
+}
+
+// VerifyScript runs scriptSig followed by scriptPubKey for input n of tx and,
+// with ScriptVerifyP2SH, the serialized redeem script of a pay-to-script-hash
+// output. Verified signatures are recorded in cache, which may be nil.
+func VerifyScript(scriptSig, scriptPubKey []byte, tx Transaction, n int, flags ScriptFlags, cache *SignatureCache) error {
+	e := &scriptEngine{tx: tx, n: n, flags: flags, cache: cache}
+	if err := e.eval(scriptSig); err != nil {
+		return err
+	}
+	copied := append([][]byte(nil), e.stack...)
+	e.alt = nil
+	if err := e.eval(scriptPubKey); err != nil {
+		return err
+	}
+	if len(e.stack) == 0 || !castToBool(e.stack[len(e.stack)-1]) {
+		return errEvalFalse
+	}
+
+	class, _ := ExtractScript(scriptPubKey)
+	if flags&ScriptVerifyP2SH == 0 || class != ScriptHashTy {
+		return nil
+	}
+	if !IsPushOnly(scriptSig) {
+		return errors.New("pay to script hash signature is not push only")
+	}
+	if len(copied) == 0 {
+		return errStackUnderflow
+	}
+	redeem := copied[len(copied)-1]
+	e.stack = copied[:len(copied)-1]
+	e.alt = nil
+	if err := e.eval(redeem); err != nil {
+		return err
+	}
+	if len(e.stack) == 0 || !castToBool(e.stack[len(e.stack)-1]) {
+		return errEvalFalse
+	}
+	return nil
+}
+
+func ripemd160Sum(v []byte) []byte {
+	r := ripemd160.New()
+	r.Write(v)
+	return r.Sum(nil)
+}
+
+func sha256Sum(v []byte) [32]byte { return sha256.Sum256(v) }
+
+// checkSig verifies a signature with its trailing hash type byte against
+// pub for the engine's input.
+func (e *scriptEngine) checkSig(sig, pub, subScript []byte) bool {
+	if len(sig) == 0 {
+		return false
+	}
+	hashType := uint32(sig[len(sig)-1])
+	hash, err := SignatureHash(subScript, e.tx, e.n, hashType)
+	if err != nil {
+		return false
+	}
+	if e.cache != nil && e.cache.Exists(hash, sig, pub, e.flags) {
+		return true
+	}
+	if !VerifySignature(hash, sig[:len(sig)-1], pub, e.flags&ScriptVerifyLowS != 0) {
+		return false
+	}
+	if e.cache != nil {
+		e.cache.Add(hash, sig, pub, e.flags)
+	}
+	return true
+}
+
+// VerifySignature checks a DER encoded ECDSA signature over hash. With lowS
+// only signatures in the canonical low-S form are accepted.
+func VerifySignature(hash [32]byte, der, pub []byte, lowS bool) bool {
+	key, err := btcec.ParsePubKey(pub)
+	if err != nil {
+		return false
+	}
+	sig, err := ecdsa.ParseDERSignature(der)
+	if err != nil {
+		return false
+	}
+	// Serialize always produces the low-S form.
+	if lowS && !bytes.Equal(sig.Serialize(), der) {
+		return false
+	}
+	return sig.Verify(hash[:], key)
+}
@@ -0,0 +1,167 @@
+package coin
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// ScriptChecker verifies the script of a single transaction input, like the
+// C++ script_checker.
+type ScriptChecker struct {
+	Tx           Transaction
+	Index        int
+	ScriptPubKey []byte
+	Flags        ScriptFlags
+}
+
+// Check runs the input scripts using cache, which may be nil.
+func (c ScriptChecker) Check(cache *SignatureCache) error {
+	in := c.Tx.Inputs[c.Index]
+	if err := VerifyScript(in.ScriptSig, c.ScriptPubKey, c.Tx, c.Index, c.Flags, cache); err != nil {
+		return fmt.Errorf("script verification failed for input %d of %s: %v",
+			c.Index, c.Tx.Hash(), err)
+	}
+	return nil
+}
+
+// TransactionScriptChecks returns one checker per input of tx, resolving
+// the spent outputs through view.
+func TransactionScriptChecks(tx Transaction, view UtxoView, flags ScriptFlags) ([]ScriptChecker, error) {
+	if tx.IsCoinBase() {
+		return nil, nil
+	}
+	checks := make([]ScriptChecker, 0, len(tx.Inputs))
+	for i, in := range tx.Inputs {
+		e, ok := view.LookupUtxo(in.PreviousOut)
+		if !ok {
+			return nil, &MissingInputError{Out: in.PreviousOut}
+		}
+		checks = append(checks, ScriptChecker{
+			Tx:           tx,
+			Index:        i,
+			ScriptPubKey: e.Output.ScriptPubKey,
+			Flags:        flags,
+		})
+	}
+	return checks, nil
+}
+
+// blockView overlays the outputs created earlier in a block on a UtxoView.
+type blockView struct {
+	base    UtxoView
+	created map[PointOut]UtxoEntry
+}
+
+func (v *blockView) LookupUtxo(out PointOut) (UtxoEntry, bool) {
+	if e, ok := v.created[out]; ok {
+		return e, true
+	}
+	return v.base.LookupUtxo(out)
+}
+
+// BlockScriptChecks returns the checkers for every input of b. Inputs may
+// spend outputs of earlier transactions in the same block.
+func BlockScriptChecks(b Block, view UtxoView, flags ScriptFlags) ([]ScriptChecker, error) {
+	bv := &blockView{base: view, created: make(map[PointOut]UtxoEntry)}
+	var checks []ScriptChecker
+	for _, tx := range b.Transactions {
+		c, err := TransactionScriptChecks(tx, bv, flags)
+		if err != nil {
+			return nil, err
+		}
+		checks = append(checks, c...)
+		hash := tx.Hash()
+		for i, out := range tx.Outputs {
+			bv.created[PointOut{Hash: hash, Index: uint32(i)}] = UtxoEntry{Output: out}
+		}
+	}
+	return checks, nil
+}
+
+// ScriptCheckerQueue verifies batches of script checks on a pool of
+// goroutines. The first failure aborts the remaining checks of the batch.
+type ScriptCheckerQueue struct {
+	workers int
+	cache   *SignatureCache
+}
+
+// NewScriptCheckerQueue returns a queue with the given number of workers,
+// defaulting to the number of CPUs, sharing cache with its callers.
+func NewScriptCheckerQueue(workers int, cache *SignatureCache) *ScriptCheckerQueue {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	return &ScriptCheckerQueue{workers: workers, cache: cache}
+}
+
+// Cache returns the signature cache used by the queue.
+func (q *ScriptCheckerQueue) Cache() *SignatureCache { return q.cache }
+
+// Verify runs all checks and returns the first error encountered.
+func (q *ScriptCheckerQueue) Verify(checks []ScriptChecker) error {
+	if len(checks) == 0 {
+		return nil
+	}
+	workers := q.workers
+	if workers > len(checks) {
+		workers = len(checks)
+	}
+	if workers == 1 {
+		for _, c := range checks {
+			if err := c.Check(q.cache); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var (
+		next    atomic.Int64
+		failed  atomic.Bool
+		errOnce sync.Once
+		first   error
+		wg      sync.WaitGroup
+	)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for !failed.Load() {
+				i := next.Add(1) - 1
+				if i >= int64(len(checks)) {
+					return
+				}
+				if err := checks[i].Check(q.cache); err != nil {
+					errOnce.Do(func() { first = err })
+					failed.Store(true)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return first
+}
+
+// CheckBlockScripts verifies the scripts of every input in b concurrently.
+func (q *ScriptCheckerQueue) CheckBlockScripts(b Block, view UtxoView, flags ScriptFlags) error {
+	checks, err := BlockScriptChecks(b, view, flags)
+	if err != nil {
+		return err
+	}
+	return q.Verify(checks)
+}
+
+// ConnectBlock verifies the scripts of b against view and, when they all
+// pass, applies b to view at height. Signatures verified when the
+// transactions entered the pool are found in the shared cache and not
+// checked again.
+func (q *ScriptCheckerQueue) ConnectBlock(view *UtxoSet, b Block, height int32, flags ScriptFlags) error {
+	if err := q.CheckBlockScripts(b, view, flags); err != nil {
+		return err
+	}
+	view.ConnectBlock(b, height)
+	return nil
+}
@@ -0,0 +1,206 @@
+package coin
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+)
+
+// signedSpend returns a transaction spending a P2PKH output of priv.
+func signedSpend(t *testing.T, priv *btcec.PrivateKey, prev PointOut, pkScript []byte) Transaction {
+	t.Helper()
+	tx := Transaction{
+		Version: 1,
+		Inputs:  []TxIn{{PreviousOut: prev, Sequence: 0xffffffff}},
+		Outputs: []TxOut{{Value: Coin, ScriptPubKey: pkScript}},
+	}
+	hash, err := SignatureHash(pkScript, tx, 0, SigHashAll)
+	if err != nil {
+		t.Fatalf("sighash: %v", err)
+	}
+	sig := append(ecdsa.Sign(priv, hash[:]).Serialize(), SigHashAll)
+	var b ScriptBuilder
+	tx.Inputs[0].ScriptSig = b.AddData(sig).AddData(priv.PubKey().SerializeCompressed()).Script()
+	return tx
+}
+
+func TestVerifyPayToPubKeyHash(t *testing.T) {
+	priv, err := btcec.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkScript := PayToPubKeyHashScript(IDKey(SHA256RIPEMD160(priv.PubKey().SerializeCompressed())))
+	if class, _ := ExtractScript(pkScript); class != PubKeyHashTy {
+		t.Fatalf("unexpected class %v", class)
+	}
+	prev := PointOut{Hash: Transaction{Version: 9}.Hash(), Index: 0}
+	tx := signedSpend(t, priv, prev, pkScript)
+
+	cache := NewSignatureCache(10)
+	if err := VerifyScript(tx.Inputs[0].ScriptSig, pkScript, tx, 0, StandardScriptFlags, cache); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if cache.Len() != 1 {
+		t.Fatalf("expected signature to be cached")
+	}
+	tx.Outputs[0].Value++
+	if err := VerifyScript(tx.Inputs[0].ScriptSig, pkScript, tx, 0, StandardScriptFlags, cache); err == nil {
+		t.Fatalf("expected failure after changing the transaction")
+	}
+}
+
+func TestVerifyPayToScriptHashMultiSig(t *testing.T) {
+	var privs []*btcec.PrivateKey
+	var pubs [][]byte
+	for i := 0; i < 3; i++ {
+		p, _ := btcec.NewPrivateKey()
+		privs = append(privs, p)
+		pubs = append(pubs, p.PubKey().SerializeCompressed())
+	}
+	redeem, err := MultiSigScript(2, pubs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkScript := PayToScriptHashScript(ScriptHash(redeem))
+	tx := Transaction{
+		Version: 1,
+		Inputs:  []TxIn{{PreviousOut: PointOut{Hash: "aa", Index: 1}}},
+		Outputs: []TxOut{{Value: Coin, ScriptPubKey: []byte{OP_TRUE}}},
+	}
+	hash, _ := SignatureHash(redeem, tx, 0, SigHashAll)
+	var b ScriptBuilder
+	b.AddOp(OP_0)
+	for _, p := range []*btcec.PrivateKey{privs[0], privs[2]} {
+		b.AddData(append(ecdsa.Sign(p, hash[:]).Serialize(), SigHashAll))
+	}
+	tx.Inputs[0].ScriptSig = b.AddData(redeem).Script()
+	if err := VerifyScript(tx.Inputs[0].ScriptSig, pkScript, tx, 0, StandardScriptFlags, nil); err != nil {
+		t.Fatalf("verify p2sh multisig: %v", err)
+	}
+}
+
+func TestScriptCheckerQueue(t *testing.T) {
+	priv, _ := btcec.NewPrivateKey()
+	pkScript := PayToPubKeyHashScript(IDKey(SHA256RIPEMD160(priv.PubKey().SerializeCompressed())))
+	view := NewUtxoSet()
+	var blk Block
+	for i := 0; i < 20; i++ {
+		prev := PointOut{Hash: Transaction{Version: uint32(100 + i)}.Hash(), Index: 0}
+		view.Add(prev, UtxoEntry{Output: TxOut{Value: 2 * Coin, ScriptPubKey: pkScript}})
+		blk.Transactions = append(blk.Transactions, signedSpend(t, priv, prev, pkScript))
+	}
+	q := NewScriptCheckerQueue(4, NewSignatureCache(0))
+	if err := q.CheckBlockScripts(blk, view, StandardScriptFlags); err != nil {
+		t.Fatalf("valid block: %v", err)
+	}
+	if q.Cache().Len() != 20 {
+		t.Fatalf("expected 20 cached signatures, got %d", q.Cache().Len())
+	}
+	blk.Transactions[7].Inputs[0].ScriptSig = []byte{OP_0}
+	if err := q.CheckBlockScripts(blk, view, StandardScriptFlags); err == nil {
+		t.Fatalf("expected invalid script to fail the block")
+	}
+}
+
+func TestSignatureCacheSkipsVerification(t *testing.T) {
+	priv, _ := btcec.NewPrivateKey()
+	pub := priv.PubKey().SerializeCompressed()
+	pkScript := PayToPubKeyHashScript(IDKey(SHA256RIPEMD160(pub)))
+	prev := PointOut{Hash: Transaction{Version: 9}.Hash(), Index: 0}
+	tx := signedSpend(t, priv, prev, pkScript)
+	// Replace the signature with one that does not verify.
+	bogus := []byte{0x30, 0x01, 0x02, SigHashAll}
+	var b ScriptBuilder
+	tx.Inputs[0].ScriptSig = b.AddData(bogus).AddData(pub).Script()
+
+	if err := VerifyScript(tx.Inputs[0].ScriptSig, pkScript, tx, 0, StandardScriptFlags, nil); err == nil {
+		t.Fatal("bogus signature verified")
+	}
+	// A cached triple is trusted without running ECDSA again.
+	cache := NewSignatureCache(10)
+	hash, _ := SignatureHash(pkScript, tx, 0, SigHashAll)
+	cache.Add(hash, bogus, pub, StandardScriptFlags)
+	if err := VerifyScript(tx.Inputs[0].ScriptSig, pkScript, tx, 0, StandardScriptFlags, cache); err != nil {
+		t.Fatalf("cache hit verified again: %v", err)
+	}
+	// It is not trusted under stricter signature rules.
+	if err := VerifyScript(tx.Inputs[0].ScriptSig, pkScript, tx, 0, StandardScriptFlags|ScriptVerifyLowS, cache); err == nil {
+		t.Fatal("triple cached without LowS passed a LowS check")
+	}
+}
+
+func TestQueueConnectBlock(t *testing.T) {
+	priv, _ := btcec.NewPrivateKey()
+	pkScript := PayToPubKeyHashScript(IDKey(SHA256RIPEMD160(priv.PubKey().SerializeCompressed())))
+	view := NewUtxoSet()
+	prev := PointOut{Hash: Transaction{Version: 9}.Hash(), Index: 0}
+	view.Add(prev, UtxoEntry{Output: TxOut{Value: 2 * Coin, ScriptPubKey: pkScript}})
+	tx := signedSpend(t, priv, prev, pkScript)
+
+	// Checking the transaction on pool entry warms the shared cache.
+	cache := NewSignatureCache(0)
+	checks, err := TransactionScriptChecks(tx, view, StandardScriptFlags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checks[0].Check(cache); err != nil {
+		t.Fatal(err)
+	}
+	q := NewScriptCheckerQueue(2, cache)
+
+	bad := tx
+	bad.Inputs = []TxIn{{PreviousOut: prev, ScriptSig: []byte{OP_0}}}
+	if err := q.ConnectBlock(view, Block{Transactions: []Transaction{bad}}, 1, StandardScriptFlags); err == nil {
+		t.Fatal("connected a block with an invalid script")
+	}
+	if _, ok := view.LookupUtxo(prev); !ok {
+		t.Fatal("failed block changed the view")
+	}
+	if err := q.ConnectBlock(view, Block{Transactions: []Transaction{tx}}, 1, StandardScriptFlags); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := view.LookupUtxo(prev); ok {
+		t.Fatal("spent output still in the view")
+	}
+	if cache.Len() != 1 {
+		t.Fatalf("cache holds %d signatures", cache.Len())
+	}
+}
+
+func TestArithmeticOpcodes(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		script []byte
+		ok     bool
+	}{
+		{"add", []byte{OP_1, OP_1 + 1, OP_ADD, OP_1 + 2, OP_NUMEQUAL}, true},
+		{"sub", []byte{OP_1 + 4, OP_1 + 1, OP_SUB, OP_1 + 2, OP_NUMEQUALVERIFY, OP_TRUE}, true},
+		{"within", []byte{OP_1 + 2, OP_1, OP_1 + 4, OP_WITHIN}, true},
+		{"not within", []byte{OP_1 + 4, OP_1, OP_1 + 4, OP_WITHIN}, false},
+		{"negate abs", []byte{OP_1 + 2, OP_NEGATE, OP_ABS, OP_1 + 2, OP_NUMEQUAL}, true},
+		{"min max", []byte{OP_1, OP_1 + 8, OP_MAX, OP_1 + 3, OP_MIN, OP_1 + 3, OP_EQUAL}, true},
+		{"pick roll", []byte{OP_1, OP_1 + 1, OP_1 + 2, OP_1 + 1, OP_PICK, OP_1, OP_EQUALVERIFY, OP_1 + 1, OP_ROLL, OP_1, OP_EQUALVERIFY, OP_1 + 2, OP_EQUAL}, true},
+		{"tuck", []byte{OP_1, OP_1 + 1, OP_TUCK, OP_DROP, OP_DROP, OP_1 + 1, OP_EQUAL}, true},
+		{"2swap", []byte{OP_1, OP_1 + 1, OP_1 + 2, OP_1 + 3, OP_2SWAP, OP_1 + 1, OP_EQUAL}, true},
+		{"sha1", []byte{OP_0, OP_SHA1, OP_SIZE, 0x01, 20, OP_EQUALVERIFY, OP_DROP, OP_TRUE}, true},
+		{"nop10", []byte{OP_NOP10, OP_TRUE}, true},
+		{"disabled unexecuted", []byte{OP_0, OP_IF, OP_CAT, OP_ENDIF, OP_TRUE}, false},
+		{"reserved unexecuted", []byte{OP_0, OP_IF, OP_RESERVED, OP_ENDIF, OP_TRUE}, true},
+		{"verif", []byte{OP_0, OP_IF, OP_VERIF, OP_ENDIF, OP_TRUE}, false},
+		{"overflow", []byte{0x05, 1, 2, 3, 4, 5, OP_1ADD}, false},
+	} {
+		err := VerifyScript(nil, c.script, Transaction{Inputs: []TxIn{{}}}, 0, 0, nil)
+		if (err == nil) != c.ok {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+	}
+}
+
+func TestSigHashSingleWithoutOutput(t *testing.T) {
+	tx := Transaction{Inputs: []TxIn{{}, {}}, Outputs: []TxOut{{Value: Coin}}}
+	hash, err := SignatureHash(nil, tx, 1, SigHashSingle)
+	if err != nil || hash != [32]byte{1} {
+		t.Fatalf("sighash single past the outputs: %x %v", hash, err)
+	}
+}
@@ -0,0 +1,420 @@
+package coin
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// maxVarBytes bounds the length prefix accepted when decoding so a corrupt
+// buffer cannot trigger huge allocations.
+const maxVarBytes = 32 * 1024 * 1024
+
+// WriteVarInt writes n using the Bitcoin compact size encoding.
+func WriteVarInt(w io.Writer, n uint64) error {
+	var buf [9]byte
+	switch {
+	case n < 253:
+		buf[0] = byte(n)
+		_, err := w.Write(buf[:1])
+		return err
+	case n <= math.MaxUint16:
+		buf[0] = 253
+		binary.LittleEndian.PutUint16(buf[1:], uint16(n))
+		_, err := w.Write(buf[:3])
+		return err
+	case n <= math.MaxUint32:
+		buf[0] = 254
+		binary.LittleEndian.PutUint32(buf[1:], uint32(n))
+		_, err := w.Write(buf[:5])
+		return err
+	default:
+		buf[0] = 255
+		binary.LittleEndian.PutUint64(buf[1:], n)
+		_, err := w.Write(buf[:9])
+		return err
+	}
+}
+
+// ReadVarInt reads a compact size encoded integer.
+func ReadVarInt(r io.Reader) (uint64, error) {
+	var buf [8]byte
+	if _, err := io.ReadFull(r, buf[:1]); err != nil {
+		return 0, err
+	}
+	switch buf[0] {
+	case 253:
+		if _, err := io.ReadFull(r, buf[:2]); err != nil {
+			return 0, err
+		}
+		return uint64(binary.LittleEndian.Uint16(buf[:2])), nil
+	case 254:
+		if _, err := io.ReadFull(r, buf[:4]); err != nil {
+			return 0, err
+		}
+		return uint64(binary.LittleEndian.Uint32(buf[:4])), nil
+	case 255:
+		if _, err := io.ReadFull(r, buf[:8]); err != nil {
+			return 0, err
+		}
+		return binary.LittleEndian.Uint64(buf[:8]), nil
+	default:
+		return uint64(buf[0]), nil
+	}
+}
+
+// WriteVarBytes writes a length prefixed byte slice.
+func WriteVarBytes(w io.Writer, b []byte) error {
+	if err := WriteVarInt(w, uint64(len(b))); err != nil {
+		return err
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+// ReadVarBytes reads a length prefixed byte slice.
+func ReadVarBytes(r io.Reader) ([]byte, error) {
+	n, err := ReadVarInt(r)
+	if err != nil {
+		return nil, err
+	}
+	return readBytes(r, n)
+}
+
+// readBytes reads the n bytes following a length prefix.
+func readBytes(r io.Reader, n uint64) ([]byte, error) {
+	if n > maxVarBytes {
+		return nil, fmt.Errorf("variable length field too large: %d", n)
+	}
+	if n == 0 {
+		return nil, nil
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// legacyHashTag takes the place of the length prefix of a hash string that
+// is not lower case hex, like the "0" and "prev" placeholders written by
+// the JSON based releases. No real length is ever that large.
+const legacyHashTag = math.MaxUint64
+
+// writeHash encodes a hex hash string as length prefixed raw bytes so the
+// original string (including the empty null hash) survives a round trip.
+// Other strings are kept verbatim behind legacyHashTag.
+func writeHash(w io.Writer, h string) error {
+	raw, ok := hashBytes(h)
+	if !ok {
+		if err := WriteVarInt(w, legacyHashTag); err != nil {
+			return err
+		}
+		return WriteVarBytes(w, []byte(h))
+	}
+	return WriteVarBytes(w, raw)
+}
+
+// hashSize returns the number of bytes writeHash writes for h.
+func hashSize(h string) int {
+	raw, ok := hashBytes(h)
+	if !ok {
+		return int(GetVarIntSize(legacyHashTag)) + int(GetVarIntSize(uint64(len(h)))) + len(h)
+	}
+	return int(GetVarIntSize(uint64(len(raw)))) + len(raw)
+}
+
+// hashBytes decodes h, reporting false when it is not lower case hex and
+// has to be written behind legacyHashTag.
+func hashBytes(h string) ([]byte, bool) {
+	raw, err := hex.DecodeString(h)
+	return raw, err == nil && hex.EncodeToString(raw) == h
+}
+
+func readHash(r io.Reader) (string, error) {
+	n, err := ReadVarInt(r)
+	if err != nil {
+		return "", err
+	}
+	if n == legacyHashTag {
+		raw, err := ReadVarBytes(r)
+		return string(raw), err
+	}
+	raw, err := readBytes(r, n)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(raw), nil
+}
+
+func writeUint32(w io.Writer, v uint32) error {
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], v)
+	_, err := w.Write(buf[:])
+	return err
+}
+
+func readUint32(r io.Reader) (uint32, error) {
+	var buf [4]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(buf[:]), nil
+}
+
+func writeInt64(w io.Writer, v int64) error {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], uint64(v))
+	_, err := w.Write(buf[:])
+	return err
+}
+
+func readInt64(r io.Reader) (int64, error) {
+	var buf [8]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return 0, err
+	}
+	return int64(binary.LittleEndian.Uint64(buf[:])), nil
+}
+
+// Encode writes the binary form of the transaction to w.
+func (tx Transaction) Encode(w io.Writer) error {
+	if err := writeUint32(w, tx.Version); err != nil {
+		return err
+	}
+	if err := WriteVarInt(w, uint64(len(tx.Inputs))); err != nil {
+		return err
+	}
+	for _, in := range tx.Inputs {
+		if err := writeHash(w, in.PreviousOut.Hash); err != nil {
+			return err
+		}
+		if err := writeUint32(w, in.PreviousOut.Index); err != nil {
+			return err
+		}
+		if err := WriteVarBytes(w, in.ScriptSig); err != nil {
+			return err
+		}
+		if err := writeUint32(w, in.Sequence); err != nil {
+			return err
+		}
+	}
+	if err := WriteVarInt(w, uint64(len(tx.Outputs))); err != nil {
+		return err
+	}
+	for _, out := range tx.Outputs {
+		if err := writeInt64(w, out.Value); err != nil {
+			return err
+		}
+		if err := WriteVarBytes(w, out.ScriptPubKey); err != nil {
+			return err
+		}
+	}
+	return writeUint32(w, tx.LockTime)
+}
+
+// Decode reads the binary form of a transaction from r.
+func (tx *Transaction) Decode(r io.Reader) error {
+	var err error
+	if tx.Version, err = readUint32(r); err != nil {
+		return err
+	}
+	n, err := ReadVarInt(r)
+	if err != nil {
+		return err
+	}
+	if n > maxVarBytes/41 {
+		return errors.New("too many transaction inputs")
+	}
+	tx.Inputs = nil
+	if n > 0 {
+		tx.Inputs = make([]TxIn, n)
+	}
+	for i := range tx.Inputs {
+		in := &tx.Inputs[i]
+		if in.PreviousOut.Hash, err = readHash(r); err != nil {
+			return err
+		}
+		if in.PreviousOut.Index, err = readUint32(r); err != nil {
+			return err
+		}
+		if in.ScriptSig, err = ReadVarBytes(r); err != nil {
+			return err
+		}
+		if in.Sequence, err = readUint32(r); err != nil {
+			return err
+		}
+	}
+	if n, err = ReadVarInt(r); err != nil {
+		return err
+	}
+	if n > maxVarBytes/9 {
+		return errors.New("too many transaction outputs")
+	}
+	tx.Outputs = nil
+	if n > 0 {
+		tx.Outputs = make([]TxOut, n)
+	}
+	for i := range tx.Outputs {
+		out := &tx.Outputs[i]
+		if out.Value, err = readInt64(r); err != nil {
+			return err
+		}
+		if out.ScriptPubKey, err = ReadVarBytes(r); err != nil {
+			return err
+		}
+	}
+	tx.LockTime, err = readUint32(r)
+	return err
+}
+
+// Serialize returns the binary encoding of the transaction.
+func (tx Transaction) Serialize() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := tx.Encode(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// SerializeSize returns the number of bytes of the binary encoding without
+// allocating it.
+func (tx Transaction) SerializeSize() int {
+	n := 4 + int(GetVarIntSize(uint64(len(tx.Inputs))))
+	for _, in := range tx.Inputs {
+		n += hashSize(in.PreviousOut.Hash) + 4
+		n += int(GetVarIntSize(uint64(len(in.ScriptSig)))) + len(in.ScriptSig) + 4
+	}
+	n += int(GetVarIntSize(uint64(len(tx.Outputs))))
+	for _, out := range tx.Outputs {
+		n += 8 + int(GetVarIntSize(uint64(len(out.ScriptPubKey)))) + len(out.ScriptPubKey)
+	}
+	return n + 4
+}
+
+// DeserializeTransaction decodes a transaction previously produced by
+// Serialize.
+func DeserializeTransaction(data []byte) (Transaction, error) {
+	var tx Transaction
+	r := bytes.NewReader(data)
+	if err := tx.Decode(r); err != nil {
+		return Transaction{}, err
+	}
+	if r.Len() != 0 {
+		return Transaction{}, fmt.Errorf("%d trailing bytes after transaction", r.Len())
+	}
+	return tx, nil
+}
+
+// Encode writes the binary form of the header to w.
+func (h BlockHeader) Encode(w io.Writer) error {
+	if err := writeUint32(w, h.Version); err != nil {
+		return err
+	}
+	if err := writeHash(w, h.PrevHash); err != nil {
+		return err
+	}
+	if err := writeHash(w, h.MerkleRoot); err != nil {
+		return err
+	}
+	if err := writeUint32(w, h.Timestamp); err != nil {
+		return err
+	}
+	if err := writeUint32(w, h.Bits); err != nil {
+		return err
+	}
+	return writeUint32(w, h.Nonce)
+}
+
+// Decode reads the binary form of a header from r.
+func (h *BlockHeader) Decode(r io.Reader) error {
+	var err error
+	if h.Version, err = readUint32(r); err != nil {
+		return err
+	}
+	if h.PrevHash, err = readHash(r); err != nil {
+		return err
+	}
+	if h.MerkleRoot, err = readHash(r); err != nil {
+		return err
+	}
+	if h.Timestamp, err = readUint32(r); err != nil {
+		return err
+	}
+	if h.Bits, err = readUint32(r); err != nil {
+		return err
+	}
+	h.Nonce, err = readUint32(r)
+	return err
+}
+
+// Encode writes the binary form of the block to w.
+func (b Block) Encode(w io.Writer) error {
+	if err := b.Header.Encode(w); err != nil {
+		return err
+	}
+	if err := WriteVarInt(w, uint64(len(b.Transactions))); err != nil {
+		return err
+	}
+	for _, tx := range b.Transactions {
+		if err := tx.Encode(w); err != nil {
+			return err
+		}
+	}
+	return WriteVarBytes(w, b.Signature)
+}
+
+// Decode reads the binary form of a block from r.
+func (b *Block) Decode(r io.Reader) error {
+	if err := b.Header.Decode(r); err != nil {
+		return err
+	}
+	n, err := ReadVarInt(r)
+	if err != nil {
+		return err
+	}
+	if n > maxVarBytes/10 {
+		return errors.New("too many block transactions")
+	}
+	b.Transactions = nil
+	if n > 0 {
+		b.Transactions = make([]Transaction, n)
+	}
+	for i := range b.Transactions {
+		if err := b.Transactions[i].Decode(r); err != nil {
+			return err
+		}
+	}
+	// Blocks stored before signatures were encoded end here.
+	b.Signature, err = ReadVarBytes(r)
+	if err == io.EOF {
+		b.Signature, err = nil, nil
+	}
+	return err
+}
+
+// Serialize returns the binary encoding of the block.
+func (b Block) Serialize() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := b.Encode(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DeserializeBlock decodes a block previously produced by Serialize.
+func DeserializeBlock(data []byte) (Block, error) {
+	var b Block
+	r := bytes.NewReader(data)
+	if err := b.Decode(r); err != nil {
+		return Block{}, err
+	}
+	if r.Len() != 0 {
+		return Block{}, fmt.Errorf("%d trailing bytes after block", r.Len())
+	}
+	return b, nil
+}
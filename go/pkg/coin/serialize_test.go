@@ -0,0 +1,75 @@
+package coin
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTransactionSerializeRoundTrip(t *testing.T) {
+	prev := Transaction{Version: 7}
+	tx := Transaction{
+		Version: 1,
+		Inputs: []TxIn{{
+			PreviousOut: PointOut{Hash: prev.Hash(), Index: 3},
+			ScriptSig:   []byte{0x01, 0x02},
+			Sequence:    0xffffffff,
+		}},
+		Outputs:  []TxOut{{Value: 5 * Coin, ScriptPubKey: []byte{0x51}}},
+		LockTime: 42,
+	}
+	data, err := tx.Serialize()
+	if err != nil {
+		t.Fatalf("serialize: %v", err)
+	}
+	if len(data) != tx.SerializeSize() {
+		t.Fatalf("size mismatch %d != %d", len(data), tx.SerializeSize())
+	}
+	out, err := DeserializeTransaction(data)
+	if err != nil {
+		t.Fatalf("deserialize: %v", err)
+	}
+	if out.Hash() != tx.Hash() {
+		t.Fatalf("hash changed after round trip")
+	}
+	if _, err := DeserializeTransaction(append(data, 0)); err == nil {
+		t.Fatalf("expected trailing bytes error")
+	}
+}
+
+func TestTransactionCheck(t *testing.T) {
+	cb := Transaction{
+		Inputs:  []TxIn{{PreviousOut: PointOut{Index: 0xffffffff}, ScriptSig: []byte{1, 2}}},
+		Outputs: []TxOut{{Value: Coin, ScriptPubKey: []byte{0x51}}},
+	}
+	if !cb.IsCoinBase() || cb.Check() != nil {
+		t.Fatalf("expected valid coinbase")
+	}
+	dup := Transaction{
+		Inputs:  []TxIn{{PreviousOut: PointOut{Hash: "ab", Index: 1}}, {PreviousOut: PointOut{Hash: "ab", Index: 1}}},
+		Outputs: []TxOut{{Value: Coin, ScriptPubKey: []byte{0x51}}},
+	}
+	if dup.Check() == nil {
+		t.Fatalf("expected duplicate input error")
+	}
+}
+
+func TestLegacyHashRoundTrip(t *testing.T) {
+	tx := Transaction{Version: 1, Inputs: []TxIn{{PreviousOut: PointOut{Hash: "prev"}}}}
+	for _, h := range []string{"", "0", "prev", "ABCD", strings.Repeat("AB", 32), strings.Repeat("ab", 32)} {
+		tx.Inputs[0].PreviousOut.Hash = h
+		data, err := tx.Serialize()
+		if err != nil {
+			t.Fatalf("%q: %v", h, err)
+		}
+		if len(data) != tx.SerializeSize() {
+			t.Fatalf("%q: size %d, serialized %d bytes", h, tx.SerializeSize(), len(data))
+		}
+		out, err := DeserializeTransaction(data)
+		if err != nil {
+			t.Fatalf("%q: %v", h, err)
+		}
+		if out.Inputs[0].PreviousOut.Hash != h {
+			t.Fatalf("hash %q decoded as %q", h, out.Inputs[0].PreviousOut.Hash)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package coin
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"sync"
+)
+
+// DefaultSignatureCacheSize mirrors max_cache_size of the C++
+// signature_cache.
+const DefaultSignatureCacheSize = 50000
+
+// SignatureCache remembers (sighash, signature, public key) triples that
+// have already been verified so a transaction checked on mempool entry is
+// not verified again when its block connects. It is safe for concurrent
+// use.
+type SignatureCache struct {
+	mu      sync.RWMutex
+	max     int
+	entries map[[32]byte]struct{}
+}
+
+// NewSignatureCache returns a cache holding at most max entries.
+func NewSignatureCache(max int) *SignatureCache {
+	if max <= 0 {
+		max = DefaultSignatureCacheSize
+	}
+	return &SignatureCache{max: max, entries: make(map[[32]byte]struct{})}
+}
+
+// sigCacheFlags are the script flags that change whether a signature
+// verifies. A triple verified under some of them is only trusted again
+// under the same ones.
+const sigCacheFlags = ScriptVerifyLowS
+
+func sigCacheKey(hash [32]byte, sig, pub []byte, flags ScriptFlags) [32]byte {
+	h := sha256.New()
+	h.Write(hash[:])
+	WriteVarInt(h, uint64(len(sig)))
+	h.Write(sig)
+	h.Write(pub)
+	h.Write(binary.LittleEndian.AppendUint32(nil, uint32(flags&sigCacheFlags)))
+	var k [32]byte
+	copy(k[:], h.Sum(nil))
+	return k
+}
+
+// Exists reports whether the triple was previously added under the same
+// validity affecting flags.
+func (c *SignatureCache) Exists(hash [32]byte, sig, pub []byte, flags ScriptFlags) bool {
+	k := sigCacheKey(hash, sig, pub, flags)
+	c.mu.RLock()
+	_, ok := c.entries[k]
+	c.mu.RUnlock()
+	return ok
+}
+
+// Add records a triple verified under flags. When the cache is full a
+// random entry is evicted so attackers cannot predict the cache contents.
+func (c *SignatureCache) Add(hash [32]byte, sig, pub []byte, flags ScriptFlags) {
+	k := sigCacheKey(hash, sig, pub, flags)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.entries) >= c.max {
+		for old := range c.entries {
+			delete(c.entries, old)
+			break
+		}
+	}
+	c.entries[k] = struct{}{}
+}
+
+// Len returns the number of cached entries.
+func (c *SignatureCache) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.entries)
+}
@@ -0,0 +1,65 @@
+package coin
+
+import "math/big"
+
+// CompactToBig expands the compact target encoding used in block headers
+// (big_number::set_compact).
+func CompactToBig(bits uint32) *big.Int {
+	size := bits >> 24
+	word := int64(bits & 0x007fffff)
+	n := big.NewInt(word)
+	if size <= 3 {
+		n.Rsh(n, 8*uint(3-size))
+	} else {
+		n.Lsh(n, 8*uint(size-3))
+	}
+	if bits&0x00800000 != 0 {
+		n.Neg(n)
+	}
+	return n
+}
+
+// BigToCompact returns the compact encoding of n (big_number::get_compact).
+func BigToCompact(n *big.Int) uint32 {
+	if n.Sign() == 0 {
+		return 0
+	}
+	b := new(big.Int).Abs(n).Bytes()
+	size := uint32(len(b))
+	var word uint32
+	if size <= 3 {
+		word = uint32(new(big.Int).Abs(n).Uint64()) << (8 * (3 - size))
+	} else {
+		word = uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+	}
+	// The sign bit is taken, so shift the mantissa down a byte.
+	if word&0x00800000 != 0 {
+		word >>= 8
+		size++
+	}
+	bits := size<<24 | word
+	if n.Sign() < 0 {
+		bits |= 0x00800000
+	}
+	return bits
+}
+
+// HashToBig interprets a hash digest as the little-endian 256-bit number
+// it is compared to targets as.
+func HashToBig(h [32]byte) *big.Int {
+	var be [32]byte
+	for i := range h {
+		be[31-i] = h[i]
+	}
+	return new(big.Int).SetBytes(be[:])
+}
+
+// CheckProofOfWork reports whether the header hash is within the target
+// encoded by bits.
+func CheckProofOfWork(h BlockHeader) bool {
+	target := CompactToBig(h.Bits)
+	if target.Sign() <= 0 {
+		return false
+	}
+	return HashToBig(h.digest()).Cmp(target) <= 0
+}
@@ -3,6 +3,9 @@ package coin
 import (
 	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"math"
+	"strings"
 )
 
 // PointOut references a previous transaction output.
@@ -45,3 +48,100 @@ func (tx Transaction) hashBytes() []byte {
 func (tx Transaction) Hash() string {
 	return hex.EncodeToString(tx.hashBytes())
 }
+
+// MaxTransactionSize is the largest serialized transaction accepted by
+// Check.
+const MaxTransactionSize = 300000
+
+// IsNull reports whether the outpoint is the null reference used by
+// coinbase inputs.
+func (p PointOut) IsNull() bool {
+	return p.Index == math.MaxUint32 && strings.Trim(p.Hash, "0") == ""
+}
+
+// IsEmpty reports whether the output is the empty marker that starts a
+// coinstake transaction.
+func (o TxOut) IsEmpty() bool {
+	return o.Value == 0 && len(o.ScriptPubKey) == 0
+}
+
+// IsCoinBase reports whether the transaction is a coinbase.
+func (tx Transaction) IsCoinBase() bool {
+	return len(tx.Inputs) == 1 && tx.Inputs[0].PreviousOut.IsNull()
+}
+
+// IsCoinStake reports whether the transaction is a proof-of-stake coinstake,
+// which spends at least one input and starts with an empty output.
+func (tx Transaction) IsCoinStake() bool {
+	return len(tx.Inputs) > 0 && !tx.Inputs[0].PreviousOut.IsNull() &&
+		len(tx.Outputs) >= 2 && tx.Outputs[0].IsEmpty()
+}
+
+// ValueOut returns the sum of all output values.
+func (tx Transaction) ValueOut() int64 {
+	var total int64
+	for _, out := range tx.Outputs {
+		total += out.Value
+	}
+	return total
+}
+
+// Check performs the context free sanity checks of the C++
+// transaction::check.
+func (tx Transaction) Check() error {
+	if len(tx.Inputs) == 0 {
+		return errors.New("transaction has no inputs")
+	}
+	if len(tx.Outputs) == 0 {
+		return errors.New("transaction has no outputs")
+	}
+	if tx.SerializeSize() > MaxTransactionSize {
+		return errors.New("transaction size limits failed")
+	}
+	coinBase, coinStake := tx.IsCoinBase(), tx.IsCoinStake()
+	var valueOut int64
+	for _, out := range tx.Outputs {
+		if out.IsEmpty() && !coinBase && !coinStake {
+			return errors.New("empty output in user transaction")
+		}
+		if out.Value < 0 {
+			return errors.New("negative output value")
+		}
+		if out.Value > MaxMoneySupply {
+			return errors.New("output value too high")
+		}
+		valueOut += out.Value
+		if !MoneyRange(valueOut) {
+			return errors.New("total output value out of range")
+		}
+	}
+	seen := make(map[PointOut]struct{}, len(tx.Inputs))
+	for _, in := range tx.Inputs {
+		if _, ok := seen[in.PreviousOut]; ok {
+			return errors.New("duplicate inputs")
+		}
+		seen[in.PreviousOut] = struct{}{}
+	}
+	if coinBase {
+		if n := len(tx.Inputs[0].ScriptSig); n < 2 || n > 100 {
+			return errors.New("coinbase script size out of range")
+		}
+		return nil
+	}
+	for _, in := range tx.Inputs {
+		if in.PreviousOut.IsNull() {
+			return errors.New("null previous output")
+		}
+	}
+	return nil
+}
+
+// MinimumFee returns the relay fee required for a transaction of the given
+// serialized size: MinRelayTxFee for every started kilobyte.
+func MinimumFee(size int) int64 {
+	fee := (1 + int64(size)/1000) * MinRelayTxFee
+	if !MoneyRange(fee) {
+		fee = MaxMoneySupply
+	}
+	return fee
+}
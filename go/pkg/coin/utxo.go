@@ -0,0 +1,160 @@
+package coin
+
+import (
+	"fmt"
+	"sync"
+)
+
+// UtxoEntry is an unspent transaction output together with the context
+// needed to validate a spend of it.
+type UtxoEntry struct {
+	Output    TxOut
+	Height    int32
+	Time      uint32
+	CoinBase  bool
+	CoinStake bool
+}
+
+// IsMature reports whether a coinbase or coinstake output may be spent by a
+// transaction included at height.
+func (e UtxoEntry) IsMature(height int32) bool {
+	if !e.CoinBase && !e.CoinStake {
+		return true
+	}
+	maturity := int32(CoinbaseMaturity)
+	if TestNet {
+		maturity = CoinbaseMaturityTestNetwork
+	}
+	return height-e.Height >= maturity
+}
+
+// UtxoView provides read access to a set of unspent outputs.
+type UtxoView interface {
+	LookupUtxo(out PointOut) (UtxoEntry, bool)
+}
+
+// UtxoSet is a simple thread-safe in-memory UtxoView.
+type UtxoSet struct {
+	mu      sync.RWMutex
+	entries map[PointOut]UtxoEntry
+}
+
+// NewUtxoSet returns an empty set.
+func NewUtxoSet() *UtxoSet {
+	return &UtxoSet{entries: make(map[PointOut]UtxoEntry)}
+}
+
+// LookupUtxo implements UtxoView.
+func (s *UtxoSet) LookupUtxo(out PointOut) (UtxoEntry, bool) {
+	s.mu.RLock()
+	e, ok := s.entries[out]
+	s.mu.RUnlock()
+	return e, ok
+}
+
+// Add inserts a single entry.
+func (s *UtxoSet) Add(out PointOut, e UtxoEntry) {
+	s.mu.Lock()
+	s.entries[out] = e
+	s.mu.Unlock()
+}
+
+// Spend removes the entry for out and returns it.
+func (s *UtxoSet) Spend(out PointOut) (UtxoEntry, bool) {
+	s.mu.Lock()
+	e, ok := s.entries[out]
+	delete(s.entries, out)
+	s.mu.Unlock()
+	return e, ok
+}
+
+// Len returns the number of unspent outputs.
+func (s *UtxoSet) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.entries)
+}
+
+// ForEach calls fn for every entry until fn returns false.
+func (s *UtxoSet) ForEach(fn func(PointOut, UtxoEntry) bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for out, e := range s.entries {
+		if !fn(out, e) {
+			return
+		}
+	}
+}
+
+// ConnectTransaction spends the inputs of tx and adds its outputs at height.
+func (s *UtxoSet) ConnectTransaction(tx Transaction, height int32, time uint32) {
+	hash := tx.Hash()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !tx.IsCoinBase() {
+		for _, in := range tx.Inputs {
+			delete(s.entries, in.PreviousOut)
+		}
+	}
+	for i, out := range tx.Outputs {
+		if out.IsEmpty() {
+			continue
+		}
+		s.entries[PointOut{Hash: hash, Index: uint32(i)}] = UtxoEntry{
+			Output:    out,
+			Height:    height,
+			Time:      time,
+			CoinBase:  tx.IsCoinBase(),
+			CoinStake: tx.IsCoinStake(),
+		}
+	}
+}
+
+// ConnectBlock applies every transaction of b at height.
+func (s *UtxoSet) ConnectBlock(b Block, height int32) {
+	for _, tx := range b.Transactions {
+		s.ConnectTransaction(tx, height, b.Header.Timestamp)
+	}
+}
+
+// MissingInputError is returned by CheckTransactionInputs when a previous
+// output cannot be found in the view.
+type MissingInputError struct {
+	Out PointOut
+}
+
+func (e *MissingInputError) Error() string {
+	return fmt.Sprintf("missing input %s:%d", e.Out.Hash, e.Out.Index)
+}
+
+// CheckTransactionInputs performs the contextual input checks of the C++
+// transaction::connect_inputs for a transaction to be included at height and
+// returns the fee it pays. Script verification is not performed here.
+func CheckTransactionInputs(tx Transaction, view UtxoView, height int32) (int64, error) {
+	if tx.IsCoinBase() {
+		return 0, nil
+	}
+	var valueIn int64
+	for _, in := range tx.Inputs {
+		e, ok := view.LookupUtxo(in.PreviousOut)
+		if !ok {
+			return 0, &MissingInputError{Out: in.PreviousOut}
+		}
+		if !e.IsMature(height) {
+			return 0, fmt.Errorf("tried to spend immature output %s:%d",
+				in.PreviousOut.Hash, in.PreviousOut.Index)
+		}
+		valueIn += e.Output.Value
+		if !MoneyRange(e.Output.Value) || !MoneyRange(valueIn) {
+			return 0, fmt.Errorf("input values out of range")
+		}
+	}
+	if tx.IsCoinStake() {
+		return 0, nil
+	}
+	valueOut := tx.ValueOut()
+	if valueIn < valueOut {
+		return 0, fmt.Errorf("value in %d below value out %d", valueIn, valueOut)
+	}
+	return valueIn - valueOut, nil
+}
@@ -1,39 +1,56 @@
 package database
 
 import (
-	"encoding/json"
-
-	"github.com/syndtr/goleveldb/leveldb"
-	"github.com/syndtr/goleveldb/leveldb/util"
-
 	"pila/pkg/coin"
 )
 
-// DB wraps a LevelDB instance.
+const blockPrefix = "block:"
+
+// DB stores blocks and arbitrary values on top of a Store.
 type DB struct {
-	db *leveldb.DB
+	store Store
 }
 
-// Open opens a LevelDB database located at path.
+// Open opens a LevelDB database located at path and upgrades it to the
+// current schema.
 func Open(path string) (*DB, error) {
-	d, err := leveldb.OpenFile(path, nil)
+	s, err := OpenLevelStore(path)
 	if err != nil {
 		return nil, err
 	}
-	return &DB{db: d}, nil
+	d, err := New(s)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+// OpenMemory returns a database backed by a fresh MemStore.
+func OpenMemory() (*DB, error) { return New(NewMemStore()) }
+
+// New wraps an existing store, migrating it to the current schema first.
+func New(s Store) (*DB, error) {
+	if err := upgrade(s); err != nil {
+		return nil, err
+	}
+	return &DB{store: s}, nil
 }
 
+// Store returns the underlying storage backend.
+func (d *DB) Store() Store { return d.store }
+
 // Close closes the underlying database.
-func (d *DB) Close() error { return d.db.Close() }
+func (d *DB) Close() error { return d.store.Close() }
 
 // Put stores an arbitrary value under the given key.
 func (d *DB) Put(key string, val []byte) error {
-	return d.db.Put([]byte(key), val, nil)
+	return d.store.Put([]byte(key), val)
 }
 
 // Get retrieves the raw value for key.
 func (d *DB) Get(key string) ([]byte, error) {
-	return d.db.Get([]byte(key), nil)
+	return d.store.Get([]byte(key))
 }
 
 // PutBlock serializes and stores the block using its hash as the key.
@@ -41,21 +58,21 @@ func (d *DB) PutBlock(b coin.Block) error {
 	if err := b.Validate(); err != nil {
 		return err
 	}
-	data, err := json.Marshal(b)
+	data, err := b.Serialize()
 	if err != nil {
 		return err
 	}
-	return d.Put("block:"+b.Header.Hash(), data)
+	return d.Put(blockPrefix+b.Header.Hash(), data)
 }
 
 // GetBlock loads the block identified by hash.
 func (d *DB) GetBlock(hash string) (coin.Block, error) {
-	var out coin.Block
-	raw, err := d.Get("block:" + hash)
+	raw, err := d.Get(blockPrefix + hash)
 	if err != nil {
-		return out, err
+		return coin.Block{}, err
 	}
-	if err = json.Unmarshal(raw, &out); err != nil {
+	out, err := coin.DeserializeBlock(raw)
+	if err != nil {
 		return out, err
 	}
 	if err = out.Validate(); err != nil {
@@ -68,23 +85,20 @@ func (d *DB) GetBlock(hash string) (coin.Block, error) {
 // encountered during iteration results in an error.
 func (d *DB) ListBlocks() ([]coin.Block, error) {
 	var blocks []coin.Block
-	iter := d.db.NewIterator(util.BytesPrefix([]byte("block:")), nil)
+	iter := d.store.NewIterator([]byte(blockPrefix))
+	defer iter.Release()
 	for iter.Next() {
-		var b coin.Block
-		if err := json.Unmarshal(iter.Value(), &b); err != nil {
-			iter.Release()
+		b, err := coin.DeserializeBlock(iter.Value())
+		if err != nil {
 			return nil, err
 		}
 		if err := b.Validate(); err != nil {
-			iter.Release()
 			return nil, err
 		}
 		blocks = append(blocks, b)
 	}
 	if err := iter.Error(); err != nil {
-		iter.Release()
 		return nil, err
 	}
-	iter.Release()
 	return blocks, nil
 }
@@ -0,0 +1,102 @@
+package database
+
+import (
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// Batches are synced so a crash never leaves half of a batch on disk.
+var syncWrite = &opt.WriteOptions{Sync: true}
+
+// LevelStore is a Store backed by LevelDB.
+type LevelStore struct {
+	db *leveldb.DB
+}
+
+// OpenLevelStore opens or creates the LevelDB database at path.
+func OpenLevelStore(path string) (*LevelStore, error) {
+	d, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &LevelStore{db: d}, nil
+}
+
+func levelErr(err error) error {
+	if err == leveldb.ErrNotFound {
+		return ErrNotFound
+	}
+	return err
+}
+
+// Get implements Store.
+func (s *LevelStore) Get(key []byte) ([]byte, error) {
+	v, err := s.db.Get(key, nil)
+	return v, levelErr(err)
+}
+
+// Has implements Store.
+func (s *LevelStore) Has(key []byte) (bool, error) { return s.db.Has(key, nil) }
+
+// Put implements Store.
+func (s *LevelStore) Put(key, val []byte) error { return s.db.Put(key, val, nil) }
+
+// Delete implements Store.
+func (s *LevelStore) Delete(key []byte) error { return s.db.Delete(key, nil) }
+
+// Write implements Store.
+func (s *LevelStore) Write(b *Batch) error {
+	lb := new(leveldb.Batch)
+	for _, op := range b.ops {
+		if op.delete {
+			lb.Delete(op.key)
+		} else {
+			lb.Put(op.key, op.val)
+		}
+	}
+	return s.db.Write(lb, syncWrite)
+}
+
+// NewIterator implements Store.
+func (s *LevelStore) NewIterator(prefix []byte) Iterator {
+	return s.db.NewIterator(prefixRange(prefix), nil)
+}
+
+// GetSnapshot implements Store.
+func (s *LevelStore) GetSnapshot() (Snapshot, error) {
+	snap, err := s.db.GetSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	return levelSnapshot{snap}, nil
+}
+
+// Close implements Store.
+func (s *LevelStore) Close() error { return s.db.Close() }
+
+type levelSnapshot struct {
+	snap *leveldb.Snapshot
+}
+
+func (s levelSnapshot) Get(key []byte) ([]byte, error) {
+	v, err := s.snap.Get(key, nil)
+	return v, levelErr(err)
+}
+
+func (s levelSnapshot) Has(key []byte) (bool, error) { return s.snap.Has(key, nil) }
+
+func (s levelSnapshot) NewIterator(prefix []byte) Iterator {
+	return s.snap.NewIterator(prefixRange(prefix), nil)
+}
+
+func (s levelSnapshot) Release() { s.snap.Release() }
+
+func prefixRange(prefix []byte) *util.Range {
+	if len(prefix) == 0 {
+		return nil
+	}
+	return util.BytesPrefix(prefix)
+}
+
+var _ Store = (*LevelStore)(nil)
@@ -0,0 +1,144 @@
+package database
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MemStore is an in-memory Store for tests and simulations.
+type MemStore struct {
+	mu   sync.RWMutex
+	data map[string][]byte
+}
+
+// NewMemStore returns an empty in-memory store.
+func NewMemStore() *MemStore {
+	return &MemStore{data: make(map[string][]byte)}
+}
+
+// Get implements Store.
+func (s *MemStore) Get(key []byte) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	v, ok := s.data[string(key)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]byte(nil), v...), nil
+}
+
+// Has implements Store.
+func (s *MemStore) Has(key []byte) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.data[string(key)]
+	return ok, nil
+}
+
+// Put implements Store.
+func (s *MemStore) Put(key, val []byte) error {
+	s.mu.Lock()
+	s.data[string(key)] = append([]byte(nil), val...)
+	s.mu.Unlock()
+	return nil
+}
+
+// Delete implements Store.
+func (s *MemStore) Delete(key []byte) error {
+	s.mu.Lock()
+	delete(s.data, string(key))
+	s.mu.Unlock()
+	return nil
+}
+
+// Write implements Store.
+func (s *MemStore) Write(b *Batch) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, op := range b.ops {
+		if op.delete {
+			delete(s.data, string(op.key))
+		} else {
+			s.data[string(op.key)] = append([]byte(nil), op.val...)
+		}
+	}
+	return nil
+}
+
+// NewIterator implements Store. The iterator sees the store as it was when
+// the iterator was created.
+func (s *MemStore) NewIterator(prefix []byte) Iterator {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return newMemIterator(s.data, prefix)
+}
+
+// GetSnapshot implements Store.
+func (s *MemStore) GetSnapshot() (Snapshot, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	snap := &MemStore{data: make(map[string][]byte, len(s.data))}
+	for k, v := range s.data {
+		snap.data[k] = v
+	}
+	return memSnapshot{snap}, nil
+}
+
+// Close implements Store.
+func (s *MemStore) Close() error { return nil }
+
+type memSnapshot struct {
+	*MemStore
+}
+
+func (memSnapshot) Release() {}
+
+type memIterator struct {
+	keys []string
+	vals [][]byte
+	pos  int
+}
+
+func newMemIterator(data map[string][]byte, prefix []byte) *memIterator {
+	it := &memIterator{pos: -1}
+	p := string(prefix)
+	for k := range data {
+		if strings.HasPrefix(k, p) {
+			it.keys = append(it.keys, k)
+		}
+	}
+	sort.Strings(it.keys)
+	for _, k := range it.keys {
+		it.vals = append(it.vals, data[k])
+	}
+	return it
+}
+
+func (it *memIterator) Next() bool {
+	if it.pos < len(it.keys) {
+		it.pos++
+	}
+	return it.pos < len(it.keys)
+}
+
+func (it *memIterator) Key() []byte {
+	if it.pos < 0 || it.pos >= len(it.keys) {
+		return nil
+	}
+	return []byte(it.keys[it.pos])
+}
+
+func (it *memIterator) Value() []byte {
+	if it.pos < 0 || it.pos >= len(it.keys) {
+		return nil
+	}
+	return bytes.Clone(it.vals[it.pos])
+}
+
+func (it *memIterator) Error() error { return nil }
+
+func (it *memIterator) Release() { it.keys, it.vals = nil, nil }
+
+var _ Store = (*MemStore)(nil)
@@ -0,0 +1,177 @@
+package database
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+
+	"pila/pkg/coin"
+)
+
+// CurrentSchemaVersion is the layout written by this version of the code.
+//
+// Version 0 is the legacy layout without a metadata record, storing blocks
+// as JSON under the "block:" prefix. Version 1 stores blocks in the coin
+// binary encoding.
+const CurrentSchemaVersion uint32 = 1
+
+const (
+	metaVersionKey = "meta:version"
+	// metaCursorPrefix records how far an interrupted migration got.
+	metaCursorPrefix = "meta:migration:"
+
+	migrationBatchSize = 500
+)
+
+// migration upgrades a store from Version-1 to Version. Migrate must be
+// resumable: it receives the last key committed by an interrupted run (or
+// nil) and a commit function that atomically writes a batch together with
+// the new resume position.
+type migration struct {
+	Version     uint32
+	Description string
+	Migrate     func(s Store, resume []byte, commit func(b *Batch, cursor []byte) error) error
+}
+
+// migrations lists every schema upgrade in order.
+var migrations = []migration{
+	{
+		Version:     1,
+		Description: "store blocks in binary format",
+		Migrate:     migrateJSONBlocks,
+	},
+}
+
+// SchemaVersion returns the schema version recorded in the store. Stores
+// without a metadata record are at version 0.
+func (d *DB) SchemaVersion() (uint32, error) {
+	return readSchemaVersion(d.store)
+}
+
+func readSchemaVersion(s Store) (uint32, error) {
+	raw, err := s.Get([]byte(metaVersionKey))
+	if err == ErrNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(raw) != 4 {
+		return 0, fmt.Errorf("corrupt schema version record")
+	}
+	return binary.LittleEndian.Uint32(raw), nil
+}
+
+func encodeVersion(v uint32) []byte {
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], v)
+	return buf[:]
+}
+
+func isEmpty(s Store) bool {
+	it := s.NewIterator(nil)
+	defer it.Release()
+	return !it.Next()
+}
+
+// upgrade brings s to CurrentSchemaVersion. A fresh store is stamped with the
+// current version directly.
+func upgrade(s Store) error {
+	if isEmpty(s) {
+		return s.Put([]byte(metaVersionKey), encodeVersion(CurrentSchemaVersion))
+	}
+	return runMigrations(s, migrations, CurrentSchemaVersion)
+}
+
+func runMigrations(s Store, list []migration, target uint32) error {
+	version, err := readSchemaVersion(s)
+	if err != nil {
+		return err
+	}
+	if version > target {
+		return fmt.Errorf("database schema version %d is newer than supported version %d",
+			version, target)
+	}
+	for _, m := range list {
+		if m.Version <= version || m.Version > target {
+			continue
+		}
+		if m.Version != version+1 {
+			return fmt.Errorf("missing migration to schema version %d", version+1)
+		}
+		cursorKey := []byte(fmt.Sprintf("%s%d", metaCursorPrefix, m.Version))
+		resume, err := s.Get(cursorKey)
+		if err != nil && err != ErrNotFound {
+			return err
+		}
+		commit := func(b *Batch, cursor []byte) error {
+			b.Put(cursorKey, cursor)
+			return s.Write(b)
+		}
+		if err := m.Migrate(s, resume, commit); err != nil {
+			return fmt.Errorf("migration to schema version %d (%s): %v",
+				m.Version, m.Description, err)
+		}
+		// Bump the version and drop the cursor in one atomic write.
+		b := NewBatch()
+		b.Delete(cursorKey)
+		b.Put([]byte(metaVersionKey), encodeVersion(m.Version))
+		if err := s.Write(b); err != nil {
+			return err
+		}
+		version = m.Version
+	}
+	if version != target {
+		return fmt.Errorf("no migration path to schema version %d", target)
+	}
+	return nil
+}
+
+// migrateJSONBlocks rewrites every JSON encoded block in the binary format,
+// committing progress every migrationBatchSize blocks.
+func migrateJSONBlocks(s Store, resume []byte, commit func(*Batch, []byte) error) error {
+	snap, err := s.GetSnapshot()
+	if err != nil {
+		return err
+	}
+	defer snap.Release()
+
+	it := snap.NewIterator([]byte(blockPrefix))
+	defer it.Release()
+	b := NewBatch()
+	var last []byte
+	for it.Next() {
+		key := it.Key()
+		if resume != nil && bytes.Compare(key, resume) <= 0 {
+			continue
+		}
+		var blk coin.Block
+		if err := json.Unmarshal(it.Value(), &blk); err != nil {
+			return fmt.Errorf("decode %s: %v", key, err)
+		}
+		data, err := blk.Serialize()
+		if err != nil {
+			return fmt.Errorf("encode %s: %v", key, err)
+		}
+		// Refuse to rewrite a block that would no longer validate.
+		if check, err := coin.DeserializeBlock(data); err != nil || check.Validate() != nil {
+			return fmt.Errorf("block %s does not survive binary encoding", key)
+		}
+		b.Put(key, data)
+		last = append(last[:0], key...)
+		if b.Len() >= migrationBatchSize {
+			if err := commit(b, last); err != nil {
+				return err
+			}
+			b = NewBatch()
+		}
+	}
+	if err := it.Error(); err != nil {
+		return err
+	}
+	if b.Len() > 0 {
+		return commit(b, last)
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package database
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"pila/pkg/coin"
+)
+
+func legacyBlock(v uint32) coin.Block {
+	tx := coin.Transaction{Version: v}
+	b := coin.Block{Header: coin.BlockHeader{Version: 1}, Transactions: []coin.Transaction{tx}}
+	b.Header.MerkleRoot = b.BuildMerkleRoot()
+	return b
+}
+
+func putLegacy(t *testing.T, s Store, b coin.Block) {
+	t.Helper()
+	data, err := json.Marshal(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put([]byte(blockPrefix+b.Header.Hash()), data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMigrateLegacyJSONBlocks(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenLevelStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b1, b2 := legacyBlock(1), legacyBlock(2)
+	putLegacy(t, s, b1)
+	putLegacy(t, s, b2)
+	s.Close()
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	if v, err := db.SchemaVersion(); err != nil || v != CurrentSchemaVersion {
+		t.Fatalf("schema version %d err %v", v, err)
+	}
+	out, err := db.GetBlock(b2.Header.Hash())
+	if err != nil {
+		t.Fatalf("get migrated block: %v", err)
+	}
+	if out.BuildMerkleRoot() != b2.Header.MerkleRoot {
+		t.Fatalf("migrated block changed")
+	}
+	if blocks, err := db.ListBlocks(); err != nil || len(blocks) != 2 {
+		t.Fatalf("list: %d err %v", len(blocks), err)
+	}
+}
+
+// TestMigrateBaselineDatabase opens a database written by the first
+// release, whose block stub used the placeholder hashes "0" and "prev".
+func TestMigrateBaselineDatabase(t *testing.T) {
+	dir := t.TempDir()
+	files, err := os.ReadDir("testdata/baseline_db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		data, err := os.ReadFile(filepath.Join("testdata/baseline_db", f.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, f.Name()), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("open baseline database: %v", err)
+	}
+	blocks, err := db.ListBlocks()
+	if err != nil || len(blocks) != 1 {
+		t.Fatalf("list: %d blocks, err %v", len(blocks), err)
+	}
+	b := blocks[0]
+	if b.Header.PrevHash != "0" || b.Transactions[0].Inputs[0].PreviousOut.Hash != "prev" {
+		t.Fatalf("legacy hashes not preserved: %q %q", b.Header.PrevHash, b.Transactions[0].Inputs[0].PreviousOut.Hash)
+	}
+	if _, err := db.GetBlock(b.Header.Hash()); err != nil {
+		t.Fatalf("get migrated block: %v", err)
+	}
+	db.Close()
+
+	// The migrated database opens again without migrating.
+	db, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if v, err := db.SchemaVersion(); err != nil || v != CurrentSchemaVersion {
+		t.Fatalf("schema version %d err %v", v, err)
+	}
+}
+
+func TestMigrationResumesFromCursor(t *testing.T) {
+	s := NewMemStore()
+	b1, b2 := legacyBlock(1), legacyBlock(2)
+	putLegacy(t, s, b1)
+	putLegacy(t, s, b2)
+
+	// Simulate a crash after the first key was committed: its value is
+	// already binary and the cursor points at it.
+	first, second := b1, b2
+	if blockPrefix+b2.Header.Hash() < blockPrefix+b1.Header.Hash() {
+		first, second = b2, b1
+	}
+	data, _ := first.Serialize()
+	batch := NewBatch()
+	batch.Put([]byte(blockPrefix+first.Header.Hash()), data)
+	batch.Put([]byte(metaCursorPrefix+"1"), []byte(blockPrefix+first.Header.Hash()))
+	if err := s.Write(batch); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := New(s)
+	if err != nil {
+		t.Fatalf("resume migration: %v", err)
+	}
+	if _, err := db.GetBlock(second.Header.Hash()); err != nil {
+		t.Fatalf("second block not migrated: %v", err)
+	}
+	if ok, _ := s.Has([]byte(metaCursorPrefix + "1")); ok {
+		t.Fatalf("cursor should be removed after migration")
+	}
+}
+
+func TestNewerSchemaRejected(t *testing.T) {
+	s := NewMemStore()
+	s.Put([]byte(metaVersionKey), encodeVersion(CurrentSchemaVersion+1))
+	if _, err := New(s); err == nil {
+		t.Fatalf("expected error for newer schema")
+	}
+}
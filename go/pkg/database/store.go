@@ -0,0 +1,78 @@
+package database
+
+import "errors"
+
+// ErrNotFound is returned by Get when the key does not exist.
+var ErrNotFound = errors.New("database: not found")
+
+// Reader is the read side shared by stores and snapshots.
+type Reader interface {
+	// Get returns the value stored under key or ErrNotFound.
+	Get(key []byte) ([]byte, error)
+	// Has reports whether key exists.
+	Has(key []byte) (bool, error)
+	// NewIterator returns an iterator over all keys starting with prefix,
+	// in ascending key order. A nil prefix iterates the whole store.
+	NewIterator(prefix []byte) Iterator
+}
+
+// Store is a sorted key/value storage backend.
+type Store interface {
+	Reader
+	Put(key, val []byte) error
+	Delete(key []byte) error
+	// Write applies every operation of b atomically.
+	Write(b *Batch) error
+	// GetSnapshot returns a consistent read-only view of the store.
+	GetSnapshot() (Snapshot, error)
+	Close() error
+}
+
+// Snapshot is a frozen view of a Store. It must be released after use.
+type Snapshot interface {
+	Reader
+	Release()
+}
+
+// Iterator walks a range of keys. Key and Value are only valid until the
+// next call to Next. It must be released after use.
+type Iterator interface {
+	Next() bool
+	Key() []byte
+	Value() []byte
+	Error() error
+	Release()
+}
+
+type batchOp struct {
+	key    []byte
+	val    []byte
+	delete bool
+}
+
+// Batch collects writes that are applied atomically by Store.Write.
+type Batch struct {
+	ops []batchOp
+}
+
+// NewBatch returns an empty batch.
+func NewBatch() *Batch { return &Batch{} }
+
+// Put queues a write of val under key.
+func (b *Batch) Put(key, val []byte) {
+	b.ops = append(b.ops, batchOp{
+		key: append([]byte(nil), key...),
+		val: append([]byte(nil), val...),
+	})
+}
+
+// Delete queues the removal of key.
+func (b *Batch) Delete(key []byte) {
+	b.ops = append(b.ops, batchOp{key: append([]byte(nil), key...), delete: true})
+}
+
+// Len returns the number of queued operations.
+func (b *Batch) Len() int { return len(b.ops) }
+
+// Reset discards all queued operations.
+func (b *Batch) Reset() { b.ops = b.ops[:0] }
@@ -0,0 +1,95 @@
+package database
+
+import (
+	"testing"
+
+	"pila/pkg/coin"
+)
+
+func testStore(t *testing.T, s Store) {
+	t.Helper()
+	if _, err := s.Get([]byte("missing")); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if err := s.Put([]byte("a:1"), []byte("one")); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	b := NewBatch()
+	b.Put([]byte("a:2"), []byte("two"))
+	b.Put([]byte("b:1"), []byte("other"))
+	b.Put([]byte("a:3"), []byte("three"))
+	b.Delete([]byte("a:3"))
+	if err := s.Write(b); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if ok, _ := s.Has([]byte("a:3")); ok {
+		t.Fatalf("a:3 should be deleted")
+	}
+
+	snap, err := s.GetSnapshot()
+	if err != nil {
+		t.Fatalf("snapshot: %v", err)
+	}
+	defer snap.Release()
+	if err := s.Delete([]byte("a:1")); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if v, err := snap.Get([]byte("a:1")); err != nil || string(v) != "one" {
+		t.Fatalf("snapshot get: %q %v", v, err)
+	}
+
+	var keys []string
+	it := snap.NewIterator([]byte("a:"))
+	for it.Next() {
+		keys = append(keys, string(it.Key()))
+	}
+	it.Release()
+	if len(keys) != 2 || keys[0] != "a:1" || keys[1] != "a:2" {
+		t.Fatalf("unexpected snapshot keys %v", keys)
+	}
+
+	keys = nil
+	it = s.NewIterator(nil)
+	for it.Next() {
+		keys = append(keys, string(it.Key()))
+	}
+	if err := it.Error(); err != nil {
+		t.Fatalf("iter: %v", err)
+	}
+	it.Release()
+	if len(keys) != 2 || keys[0] != "a:2" || keys[1] != "b:1" {
+		t.Fatalf("unexpected keys %v", keys)
+	}
+}
+
+func TestLevelStore(t *testing.T) {
+	s, err := OpenLevelStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer s.Close()
+	testStore(t, s)
+}
+
+func TestMemStore(t *testing.T) {
+	testStore(t, NewMemStore())
+}
+
+func TestMemoryDBBlocks(t *testing.T) {
+	db, err := OpenMemory()
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+
+	tx := coin.Transaction{Version: 1}
+	blk := coin.Block{Header: coin.BlockHeader{Version: 1}, Transactions: []coin.Transaction{tx}}
+	blk.Header.MerkleRoot = blk.BuildMerkleRoot()
+	if err := db.PutBlock(blk); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	blocks, err := db.ListBlocks()
+	if err != nil || len(blocks) != 1 {
+		t.Fatalf("list: %d blocks, err %v", len(blocks), err)
+	}
+}
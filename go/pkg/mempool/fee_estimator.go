@@ -0,0 +1,288 @@
+package mempool
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"pila/pkg/coin"
+)
+
+const (
+	// MaxConfirmTarget is the largest target EstimateFee answers for.
+	MaxConfirmTarget = 25
+
+	// feeBucketSpacing is the ratio between consecutive bucket boundaries.
+	feeBucketSpacing = 1.25
+	// maxBucketFeeRate bounds the highest tracked fee rate.
+	maxBucketFeeRate = 10000 * coin.MinTxFee
+	// feeDecay is applied to all statistics once per block so old blocks
+	// weigh less than recent ones.
+	feeDecay = 0.998
+	// successThreshold is the fraction of transactions of a bucket that
+	// must confirm within the target for the bucket to be used.
+	successThreshold = 0.85
+	// minBucketSamples is the decayed number of transactions a bucket must
+	// have seen before it is trusted.
+	minBucketSamples = 1.0
+)
+
+// ErrNoEstimate is returned when there is not enough data for an estimate.
+var ErrNoEstimate = errors.New("insufficient data for fee estimate")
+
+type trackedTx struct {
+	height int32
+	bucket int
+}
+
+// FeeEstimator learns how many blocks transactions of each fee-rate bucket
+// take to confirm and uses that history to suggest fee rates.
+type FeeEstimator struct {
+	mu sync.Mutex
+
+	buckets []int64
+	// confirmed[t][b] is the decayed count of bucket b transactions that
+	// confirmed within t+1 blocks.
+	confirmed [][]float64
+	// total[b] is the decayed count of bucket b transactions that left the
+	// pool, confirmed or not. Evicted and expired transactions count here
+	// without counting as confirmed, so buckets whose transactions get
+	// stuck lose their success rate.
+	total []float64
+
+	tracked    map[string]trackedTx
+	bestHeight int32
+}
+
+// NewFeeEstimator returns an estimator with no history.
+func NewFeeEstimator() *FeeEstimator {
+	e := &FeeEstimator{tracked: make(map[string]trackedTx)}
+	for rate := float64(coin.MinRelayTxFee); rate <= float64(maxBucketFeeRate); rate *= feeBucketSpacing {
+		e.buckets = append(e.buckets, int64(rate))
+	}
+	e.reset()
+	return e
+}
+
+func (e *FeeEstimator) reset() {
+	e.total = make([]float64, len(e.buckets))
+	e.confirmed = make([][]float64, MaxConfirmTarget)
+	for i := range e.confirmed {
+		e.confirmed[i] = make([]float64, len(e.buckets))
+	}
+}
+
+// bucketIndex returns the highest bucket whose boundary is <= rate.
+func (e *FeeEstimator) bucketIndex(rate int64) int {
+	i := 0
+	for i+1 < len(e.buckets) && e.buckets[i+1] <= rate {
+		i++
+	}
+	return i
+}
+
+// ObserveTransaction starts tracking a transaction that entered the pool.
+func (e *FeeEstimator) ObserveTransaction(d *TxDesc) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, ok := e.tracked[d.Hash]; ok {
+		return
+	}
+	e.tracked[d.Hash] = trackedTx{height: d.Height, bucket: e.bucketIndex(d.FeeRate)}
+}
+
+// RemoveTransaction stops tracking a transaction that left the pool without
+// being confirmed, e.g. evicted, expired or conflicted, and records it as a
+// failure of its bucket.
+func (e *FeeEstimator) RemoveTransaction(hash string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	tt, ok := e.tracked[hash]
+	if !ok {
+		return
+	}
+	delete(e.tracked, hash)
+	e.total[tt.bucket]++
+}
+
+// ProcessBlock records the confirmation of the given transactions at
+// height.
+func (e *FeeEstimator) ProcessBlock(height int32, hashes []string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	// Ignore blocks seen out of order, e.g. during a reorg.
+	if height <= e.bestHeight {
+		for _, h := range hashes {
+			delete(e.tracked, h)
+		}
+		return
+	}
+	e.bestHeight = height
+
+	for b := range e.total {
+		e.total[b] *= feeDecay
+		for t := range e.confirmed {
+			e.confirmed[t][b] *= feeDecay
+		}
+	}
+	for _, h := range hashes {
+		tt, ok := e.tracked[h]
+		if !ok {
+			continue
+		}
+		delete(e.tracked, h)
+		blocks := int(height - tt.height)
+		if blocks < 1 {
+			continue
+		}
+		e.total[tt.bucket]++
+		for t := blocks - 1; t < MaxConfirmTarget; t++ {
+			e.confirmed[t][tt.bucket]++
+		}
+	}
+}
+
+// EstimateFee returns the fee rate per 1000 bytes that is expected to
+// confirm within targetBlocks blocks. Buckets are scanned from the highest
+// fee rate down and the cheapest one that still reached the success
+// threshold, with every more expensive bucket also doing so, is returned.
+func (e *FeeEstimator) EstimateFee(targetBlocks int) (int64, error) {
+	if targetBlocks < 1 {
+		targetBlocks = 1
+	}
+	if targetBlocks > MaxConfirmTarget {
+		targetBlocks = MaxConfirmTarget
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	best := -1
+	var confirmed, total float64
+	for b := len(e.buckets) - 1; b >= 0; b-- {
+		confirmed += e.confirmed[targetBlocks-1][b]
+		total += e.total[b]
+		if total < minBucketSamples {
+			continue
+		}
+		if confirmed/total < successThreshold {
+			break
+		}
+		best = b
+		confirmed, total = 0, 0
+	}
+	if best < 0 {
+		return 0, ErrNoEstimate
+	}
+	return e.buckets[best], nil
+}
+
+// EstimateFeeOrMin is EstimateFee falling back to coin.MinTxFee when there is
+// no estimate or the estimate is below it.
+func (e *FeeEstimator) EstimateFeeOrMin(targetBlocks int) int64 {
+	rate, err := e.EstimateFee(targetBlocks)
+	if err != nil || rate < coin.MinTxFee {
+		return coin.MinTxFee
+	}
+	return rate
+}
+
+type feeEstimatorState struct {
+	Buckets    []int64     `json:"buckets"`
+	Confirmed  [][]float64 `json:"confirmed"`
+	Total      []float64   `json:"total"`
+	BestHeight int32       `json:"best_height"`
+}
+
+// Save writes the confirmation history to w. Transactions still in the
+// pool are not saved; they are observed again when the pool is reloaded.
+func (e *FeeEstimator) Save(w io.Writer) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	state := feeEstimatorState{
+		Buckets:    e.buckets,
+		Confirmed:  e.confirmed,
+		Total:      e.total,
+		BestHeight: e.bestHeight,
+	}
+	return json.NewEncoder(w).Encode(&state)
+}
+
+// LoadFeeEstimator restores an estimator written by Save. State saved with a
+// different bucket layout is discarded and an empty estimator is returned.
+func LoadFeeEstimator(r io.Reader) (*FeeEstimator, error) {
+	var state feeEstimatorState
+	if err := json.NewDecoder(r).Decode(&state); err != nil {
+		return nil, err
+	}
+	e := NewFeeEstimator()
+	if !sameBuckets(e.buckets, state.Buckets) ||
+		len(state.Total) != len(e.buckets) || len(state.Confirmed) != MaxConfirmTarget {
+		return e, nil
+	}
+	for _, row := range state.Confirmed {
+		if len(row) != len(e.buckets) {
+			return e, nil
+		}
+	}
+	e.confirmed = state.Confirmed
+	e.total = state.Total
+	e.bestHeight = state.BestHeight
+	return e, nil
+}
+
+// FeeEstimatesFile is the name of the estimator state in the data
+// directory.
+const FeeEstimatesFile = "fee_estimates.dat"
+
+// LoadFeeEstimatorFile restores the estimator saved at path when the node
+// starts. A missing file gives an empty estimator.
+func LoadFeeEstimatorFile(path string) (*FeeEstimator, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return NewFeeEstimator(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return LoadFeeEstimator(f)
+}
+
+// SaveFile writes the estimator to path on shutdown. The previous state is
+// only replaced once the new one is completely written.
+func (e *FeeEstimator) SaveFile(path string) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := e.Save(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func sameBuckets(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,138 @@
+package mempool
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"pila/pkg/coin"
+)
+
+func feeDesc(i int, height int32, rate int64) *TxDesc {
+	return &TxDesc{Hash: fmt.Sprintf("%064x", i), Height: height, FeeRate: rate}
+}
+
+func TestFeeEstimator(t *testing.T) {
+	e := NewFeeEstimator()
+	if _, err := e.EstimateFee(1); err != ErrNoEstimate {
+		t.Fatalf("expected no estimate, got %v", err)
+	}
+
+	high := 20 * coin.MinTxFee
+	low := coin.MinTxFee
+	n := 0
+	for h := int32(1); h <= 50; h++ {
+		var confirmed []string
+		// High fee transactions confirm in the next block.
+		d := feeDesc(n, h-1, high)
+		n++
+		e.ObserveTransaction(d)
+		confirmed = append(confirmed, d.Hash)
+		// Low fee transactions take five blocks.
+		d = feeDesc(n, h-5, low)
+		n++
+		e.ObserveTransaction(d)
+		confirmed = append(confirmed, d.Hash)
+		e.ProcessBlock(h, confirmed)
+	}
+
+	fast, err := e.EstimateFee(1)
+	if err != nil {
+		t.Fatalf("estimate 1: %v", err)
+	}
+	if fast > high || fast <= low {
+		t.Fatalf("unexpected one block estimate %d", fast)
+	}
+	slow, err := e.EstimateFee(5)
+	if err != nil {
+		t.Fatalf("estimate 5: %v", err)
+	}
+	if slow > low {
+		t.Fatalf("five block estimate %d should reach the low bucket", slow)
+	}
+
+	var buf bytes.Buffer
+	if err := e.Save(&buf); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	restored, err := LoadFeeEstimator(&buf)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if got, _ := restored.EstimateFee(1); got != fast {
+		t.Fatalf("restored estimate %d != %d", got, fast)
+	}
+}
+
+func TestFeeEstimatorFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), FeeEstimatesFile)
+	e, err := LoadFeeEstimatorFile(path)
+	if err != nil {
+		t.Fatalf("load without a file: %v", err)
+	}
+	e.ProcessBlock(1, nil)
+	if err := e.SaveFile(path); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	restored, err := LoadFeeEstimatorFile(path)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if restored.bestHeight != 1 {
+		t.Fatalf("restored best height %d", restored.bestHeight)
+	}
+}
+
+func TestFeeEstimatorCountsFailures(t *testing.T) {
+	e := NewFeeEstimator()
+	high := 20 * coin.MinTxFee
+	low := coin.MinTxFee
+	n := 0
+	for h := int32(1); h <= 50; h++ {
+		d := feeDesc(n, h-1, high)
+		n++
+		e.ObserveTransaction(d)
+		confirmed := []string{d.Hash}
+		// One low fee transaction in four confirms, the others get
+		// stuck and are evicted.
+		d = feeDesc(n, h-1, low)
+		n++
+		e.ObserveTransaction(d)
+		if h%4 == 0 {
+			confirmed = append(confirmed, d.Hash)
+		} else {
+			e.RemoveTransaction(d.Hash)
+		}
+		e.ProcessBlock(h, confirmed)
+	}
+	for target := 1; target <= MaxConfirmTarget; target += 8 {
+		rate, err := e.EstimateFee(target)
+		if err != nil {
+			t.Fatalf("estimate %d: %v", target, err)
+		}
+		if rate <= low {
+			t.Fatalf("%d block estimate %d reaches the bucket that mostly gets stuck", target, rate)
+		}
+	}
+}
+
+func TestPoolFeedsEstimator(t *testing.T) {
+	view, outs := fundedView(t, 1)
+	est := NewFeeEstimator()
+	height := int32(10)
+	p := New(Config{View: view, FeeEstimator: est, BestHeight: func() int32 { return height }})
+
+	tx := spend(outs[0], 9*coin.Coin)
+	if _, err := p.Accept(tx); err != nil {
+		t.Fatal(err)
+	}
+	height++
+	p.BlockConnected(coin.Block{Transactions: []coin.Transaction{tx}})
+	if est.EstimateFeeOrMin(1) < coin.MinTxFee {
+		t.Fatalf("estimate below minimum")
+	}
+	if _, err := est.EstimateFee(1); err != nil {
+		t.Fatalf("expected estimate after a confirmation: %v", err)
+	}
+}
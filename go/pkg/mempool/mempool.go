@@ -0,0 +1,554 @@
+package mempool
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"pila/pkg/coin"
+)
+
+// Default limits used when the corresponding Config field is zero.
+const (
+	DefaultMaxPoolBytes  = 32 * 1024 * 1024
+	DefaultMaxOrphans    = 100
+	DefaultMaxOrphanSize = 100000
+	DefaultExpiry        = 72 * time.Hour
+	DefaultOrphanExpiry  = 20 * time.Minute
+)
+
+var (
+	// ErrAlreadyHave is returned when the transaction is already pooled.
+	ErrAlreadyHave = errors.New("transaction already in pool")
+	// ErrCoinBase is returned for coinbase transactions, which are only
+	// valid inside a block.
+	ErrCoinBase = errors.New("coin base as individual transaction")
+	// ErrCoinStake is returned for coinstake transactions, which are only
+	// valid inside a block.
+	ErrCoinStake = errors.New("coin stake as individual transaction")
+	// ErrDoubleSpend is returned when an input is already spent by a
+	// pooled transaction.
+	ErrDoubleSpend = errors.New("input already spent in pool")
+	// ErrInsufficientFee is returned when the fee is below the relay
+	// minimum.
+	ErrInsufficientFee = errors.New("not enough fees")
+	// ErrPoolFull is returned when the transaction pays too little to stay
+	// in a full pool.
+	ErrPoolFull = errors.New("pool full")
+	// ErrOrphanTooLarge is returned when an orphan exceeds MaxOrphanSize.
+	ErrOrphanTooLarge = errors.New("orphan transaction too large")
+)
+
+// Config holds the chain state and limits used by a Pool.
+type Config struct {
+	// View gives access to the confirmed unspent outputs.
+	View coin.UtxoView
+	// BestHeight returns the height of the current chain tip.
+	BestHeight func() int32
+	// Now returns the current time; defaults to time.Now.
+	Now func() time.Time
+	// SigCache, if set, records the signatures verified on entry so they
+	// are not checked again when the block containing them connects.
+	SigCache *coin.SignatureCache
+	// FeeEstimator, if set, is fed every accepted transaction and every
+	// connected block.
+	FeeEstimator *FeeEstimator
+
+	MaxPoolBytes  int
+	MaxOrphans    int
+	MaxOrphanSize int
+	Expiry        time.Duration
+	OrphanExpiry  time.Duration
+}
+
+// TxDesc describes a transaction accepted into the pool.
+type TxDesc struct {
+	Tx      coin.Transaction
+	Hash    string
+	Added   time.Time
+	Height  int32
+	Fee     int64
+	Size    int
+	FeeRate int64 // fee per 1000 bytes
+}
+
+type orphanTx struct {
+	tx      coin.Transaction
+	hash    string
+	size    int
+	expires time.Time
+}
+
+// Pool is the memory pool of validated transactions that are not yet in a
+// block, plus a pool of orphans waiting for their parents.
+type Pool struct {
+	cfg Config
+
+	mu         sync.RWMutex
+	pool       map[string]*TxDesc
+	outpoints  map[coin.PointOut]string
+	totalBytes int
+	updated    uint32
+
+	orphans       map[string]*orphanTx
+	orphansByPrev map[coin.PointOut]map[string]struct{}
+}
+
+// New returns an empty pool using cfg.
+func New(cfg Config) *Pool {
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+	if cfg.BestHeight == nil {
+		cfg.BestHeight = func() int32 { return 0 }
+	}
+	if cfg.MaxPoolBytes == 0 {
+		cfg.MaxPoolBytes = DefaultMaxPoolBytes
+	}
+	if cfg.MaxOrphans == 0 {
+		cfg.MaxOrphans = DefaultMaxOrphans
+	}
+	if cfg.MaxOrphanSize == 0 {
+		cfg.MaxOrphanSize = DefaultMaxOrphanSize
+	}
+	if cfg.Expiry == 0 {
+		cfg.Expiry = DefaultExpiry
+	}
+	if cfg.OrphanExpiry == 0 {
+		cfg.OrphanExpiry = DefaultOrphanExpiry
+	}
+	return &Pool{
+		cfg:           cfg,
+		pool:          make(map[string]*TxDesc),
+		outpoints:     make(map[coin.PointOut]string),
+		orphans:       make(map[string]*orphanTx),
+		orphansByPrev: make(map[coin.PointOut]map[string]struct{}),
+	}
+}
+
+// lookupUtxo resolves out against the confirmed view extended with the
+// outputs of pooled transactions. Outputs already spent inside the pool are
+// hidden. The caller must hold p.mu.
+func (p *Pool) lookupUtxo(out coin.PointOut) (coin.UtxoEntry, bool) {
+	if _, spent := p.outpoints[out]; spent {
+		return coin.UtxoEntry{}, false
+	}
+	if d, ok := p.pool[out.Hash]; ok {
+		if int(out.Index) >= len(d.Tx.Outputs) {
+			return coin.UtxoEntry{}, false
+		}
+		return coin.UtxoEntry{Output: d.Tx.Outputs[out.Index], Height: d.Height + 1}, true
+	}
+	if p.cfg.View == nil {
+		return coin.UtxoEntry{}, false
+	}
+	return p.cfg.View.LookupUtxo(out)
+}
+
+type poolView struct{ p *Pool }
+
+func (v poolView) LookupUtxo(out coin.PointOut) (coin.UtxoEntry, bool) {
+	return v.p.lookupUtxo(out)
+}
+
+// Accept validates tx and adds it to the pool. Transactions with missing
+// parents are kept as orphans and nil is returned with no error. On success
+// the returned slice holds tx followed by any orphans it made acceptable.
+func (p *Pool) Accept(tx coin.Transaction) ([]*TxDesc, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.orphans[tx.Hash()]; ok {
+		return nil, ErrAlreadyHave
+	}
+	desc, missing, err := p.maybeAccept(tx)
+	if err != nil {
+		return nil, err
+	}
+	if missing {
+		return nil, p.addOrphan(tx)
+	}
+	accepted := []*TxDesc{desc}
+	return append(accepted, p.processOrphans(desc.Hash)...), nil
+}
+
+// maybeAccept runs the acceptance checks and adds tx on success. It reports
+// missing=true when only the parents are missing. The caller must hold p.mu.
+func (p *Pool) maybeAccept(tx coin.Transaction) (*TxDesc, bool, error) {
+	if err := tx.Check(); err != nil {
+		return nil, false, err
+	}
+	if tx.IsCoinBase() {
+		return nil, false, ErrCoinBase
+	}
+	if tx.IsCoinStake() {
+		return nil, false, ErrCoinStake
+	}
+	hash := tx.Hash()
+	if _, ok := p.pool[hash]; ok {
+		return nil, false, ErrAlreadyHave
+	}
+	for _, in := range tx.Inputs {
+		if _, ok := p.outpoints[in.PreviousOut]; ok {
+			return nil, false, ErrDoubleSpend
+		}
+	}
+
+	height := p.cfg.BestHeight() + 1
+	fee, err := coin.CheckTransactionInputs(tx, poolView{p}, height)
+	if err != nil {
+		var missing *coin.MissingInputError
+		if errors.As(err, &missing) {
+			return nil, true, nil
+		}
+		return nil, false, err
+	}
+	size := tx.SerializeSize()
+	if minFee := coin.MinimumFee(size); fee < minFee {
+		return nil, false, fmt.Errorf("%w: %d < %d", ErrInsufficientFee, fee, minFee)
+	}
+
+	// Scripts are checked last as they are the most expensive part.
+	checks, err := coin.TransactionScriptChecks(tx, poolView{p}, coin.StandardScriptFlags)
+	if err != nil {
+		return nil, false, err
+	}
+	for _, c := range checks {
+		if err := c.Check(p.cfg.SigCache); err != nil {
+			return nil, false, err
+		}
+	}
+
+	desc := &TxDesc{
+		Tx:      tx,
+		Hash:    hash,
+		Added:   p.cfg.Now(),
+		Height:  height - 1,
+		Fee:     fee,
+		Size:    size,
+		FeeRate: fee * 1000 / int64(size),
+	}
+	p.addUnchecked(desc)
+	if !p.trimToSize() || p.pool[hash] == nil {
+		return nil, false, ErrPoolFull
+	}
+	if p.cfg.FeeEstimator != nil {
+		p.cfg.FeeEstimator.ObserveTransaction(desc)
+	}
+	return desc, false, nil
+}
+
+func (p *Pool) addUnchecked(desc *TxDesc) {
+	p.pool[desc.Hash] = desc
+	for _, in := range desc.Tx.Inputs {
+		p.outpoints[in.PreviousOut] = desc.Hash
+	}
+	p.totalBytes += desc.Size
+	p.updated++
+}
+
+// removeLocked drops hash from the pool, optionally with every transaction
+// spending its outputs. The caller must hold p.mu.
+func (p *Pool) removeLocked(hash string, withDescendants bool) []*TxDesc {
+	desc, ok := p.pool[hash]
+	if !ok {
+		return nil
+	}
+	var removed []*TxDesc
+	if withDescendants {
+		for i := range desc.Tx.Outputs {
+			out := coin.PointOut{Hash: hash, Index: uint32(i)}
+			if child, ok := p.outpoints[out]; ok {
+				removed = append(removed, p.removeLocked(child, true)...)
+			}
+		}
+	}
+	for _, in := range desc.Tx.Inputs {
+		delete(p.outpoints, in.PreviousOut)
+	}
+	delete(p.pool, hash)
+	p.totalBytes -= desc.Size
+	p.updated++
+	if p.cfg.FeeEstimator != nil {
+		p.cfg.FeeEstimator.RemoveTransaction(hash)
+	}
+	return append(removed, desc)
+}
+
+// trimToSize evicts the lowest fee-rate transactions and their descendants
+// until the pool fits within MaxPoolBytes.
+func (p *Pool) trimToSize() bool {
+	for p.totalBytes > p.cfg.MaxPoolBytes {
+		var worst *TxDesc
+		for _, d := range p.pool {
+			if worst == nil || d.FeeRate < worst.FeeRate ||
+				(d.FeeRate == worst.FeeRate && d.Added.After(worst.Added)) {
+				worst = d
+			}
+		}
+		if worst == nil {
+			return false
+		}
+		p.removeLocked(worst.Hash, true)
+	}
+	return true
+}
+
+func (p *Pool) addOrphan(tx coin.Transaction) error {
+	size := tx.SerializeSize()
+	if size > p.cfg.MaxOrphanSize {
+		return ErrOrphanTooLarge
+	}
+	p.expireOrphans()
+	for len(p.orphans) >= p.cfg.MaxOrphans {
+		// Evict an arbitrary orphan; map iteration order is random.
+		for hash := range p.orphans {
+			p.removeOrphan(hash)
+			break
+		}
+	}
+	o := &orphanTx{
+		tx:      tx,
+		hash:    tx.Hash(),
+		size:    size,
+		expires: p.cfg.Now().Add(p.cfg.OrphanExpiry),
+	}
+	p.orphans[o.hash] = o
+	for _, in := range tx.Inputs {
+		set, ok := p.orphansByPrev[in.PreviousOut]
+		if !ok {
+			set = make(map[string]struct{})
+			p.orphansByPrev[in.PreviousOut] = set
+		}
+		set[o.hash] = struct{}{}
+	}
+	return nil
+}
+
+func (p *Pool) removeOrphan(hash string) {
+	o, ok := p.orphans[hash]
+	if !ok {
+		return
+	}
+	for _, in := range o.tx.Inputs {
+		if set, ok := p.orphansByPrev[in.PreviousOut]; ok {
+			delete(set, hash)
+			if len(set) == 0 {
+				delete(p.orphansByPrev, in.PreviousOut)
+			}
+		}
+	}
+	delete(p.orphans, hash)
+}
+
+func (p *Pool) expireOrphans() {
+	now := p.cfg.Now()
+	for hash, o := range p.orphans {
+		if now.After(o.expires) {
+			p.removeOrphan(hash)
+		}
+	}
+}
+
+// processOrphans tries to accept every orphan that spends an output of
+// parent, recursively. The caller must hold p.mu.
+func (p *Pool) processOrphans(parent string) []*TxDesc {
+	var accepted []*TxDesc
+	queue := []string{parent}
+	for len(queue) > 0 {
+		hash := queue[0]
+		queue = queue[1:]
+		var candidates []string
+		for prev, set := range p.orphansByPrev {
+			if prev.Hash != hash {
+				continue
+			}
+			for h := range set {
+				candidates = append(candidates, h)
+			}
+		}
+		for _, h := range candidates {
+			o, ok := p.orphans[h]
+			if !ok {
+				continue
+			}
+			desc, missing, err := p.maybeAccept(o.tx)
+			if missing {
+				continue
+			}
+			p.removeOrphan(h)
+			if err != nil {
+				continue
+			}
+			accepted = append(accepted, desc)
+			queue = append(queue, desc.Hash)
+		}
+	}
+	return accepted
+}
+
+// Remove drops the transaction and everything spending its outputs.
+func (p *Pool) Remove(hash string) []*TxDesc {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.removeLocked(hash, true)
+}
+
+// BlockConnected must be called once b is the chain tip. It removes the
+// transactions confirmed by b and every pooled transaction that conflicts
+// with them, then retries orphans whose parents arrived in the block. It
+// returns the conflicting transactions that were dropped and the orphans
+// that were accepted.
+func (p *Pool) BlockConnected(b coin.Block) (conflicts, accepted []*TxDesc) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.cfg.FeeEstimator != nil {
+		hashes := make([]string, 0, len(b.Transactions))
+		for _, tx := range b.Transactions {
+			hashes = append(hashes, tx.Hash())
+		}
+		p.cfg.FeeEstimator.ProcessBlock(p.cfg.BestHeight(), hashes)
+	}
+	for _, tx := range b.Transactions {
+		hash := tx.Hash()
+		p.removeLocked(hash, false)
+		p.removeOrphan(hash)
+		if tx.IsCoinBase() {
+			continue
+		}
+		for _, in := range tx.Inputs {
+			if spender, ok := p.outpoints[in.PreviousOut]; ok {
+				conflicts = append(conflicts, p.removeLocked(spender, true)...)
+			}
+			if set, ok := p.orphansByPrev[in.PreviousOut]; ok {
+				for h := range set {
+					p.removeOrphan(h)
+				}
+			}
+		}
+	}
+	for _, tx := range b.Transactions {
+		accepted = append(accepted, p.processOrphans(tx.Hash())...)
+	}
+	return conflicts, accepted
+}
+
+// Expire removes transactions that have been pooled longer than the
+// configured expiry, together with their descendants, and stale orphans.
+func (p *Pool) Expire() []*TxDesc {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.expireOrphans()
+	cutoff := p.cfg.Now().Add(-p.cfg.Expiry)
+	var removed []*TxDesc
+	for hash, d := range p.pool {
+		if d.Added.Before(cutoff) {
+			removed = append(removed, p.removeLocked(hash, true)...)
+		}
+	}
+	return removed
+}
+
+// Clear empties the pool and the orphan pool.
+func (p *Pool) Clear() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pool = make(map[string]*TxDesc)
+	p.outpoints = make(map[coin.PointOut]string)
+	p.orphans = make(map[string]*orphanTx)
+	p.orphansByPrev = make(map[coin.PointOut]map[string]struct{})
+	p.totalBytes = 0
+	p.updated++
+}
+
+// Exists reports whether hash is in the pool.
+func (p *Pool) Exists(hash string) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	_, ok := p.pool[hash]
+	return ok
+}
+
+// HaveOrphan reports whether hash is waiting in the orphan pool.
+func (p *Pool) HaveOrphan(hash string) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	_, ok := p.orphans[hash]
+	return ok
+}
+
+// Lookup returns the descriptor for hash.
+func (p *Pool) Lookup(hash string) (*TxDesc, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	d, ok := p.pool[hash]
+	return d, ok
+}
+
+// SpentBy returns the hash of the pooled transaction spending out.
+func (p *Pool) SpentBy(out coin.PointOut) (string, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	h, ok := p.outpoints[out]
+	return h, ok
+}
+
+// LookupUtxo implements coin.UtxoView over the confirmed view and the pool.
+func (p *Pool) LookupUtxo(out coin.PointOut) (coin.UtxoEntry, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.lookupUtxo(out)
+}
+
+// Descs returns a snapshot of every pooled transaction.
+func (p *Pool) Descs() []*TxDesc {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	out := make([]*TxDesc, 0, len(p.pool))
+	for _, d := range p.pool {
+		out = append(out, d)
+	}
+	return out
+}
+
+// Hashes returns the hashes of all pooled transactions.
+func (p *Pool) Hashes() []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	out := make([]string, 0, len(p.pool))
+	for h := range p.pool {
+		out = append(out, h)
+	}
+	return out
+}
+
+// Size returns the number of pooled transactions.
+func (p *Pool) Size() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return len(p.pool)
+}
+
+// Bytes returns the total serialized size of pooled transactions.
+func (p *Pool) Bytes() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.totalBytes
+}
+
+// OrphanCount returns the number of orphans.
+func (p *Pool) OrphanCount() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return len(p.orphans)
+}
+
+// Updated returns a counter that changes whenever the pool contents change.
+func (p *Pool) Updated() uint32 {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.updated
+}
@@ -0,0 +1,150 @@
+package mempool
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"pila/pkg/coin"
+)
+
+func fundedView(t *testing.T, n int) (*coin.UtxoSet, []coin.PointOut) {
+	t.Helper()
+	view := coin.NewUtxoSet()
+	var outs []coin.PointOut
+	for i := 0; i < n; i++ {
+		src := coin.Transaction{Version: uint32(i + 1), Outputs: []coin.TxOut{{Value: 10 * coin.Coin, ScriptPubKey: []byte{coin.OP_TRUE}}}}
+		out := coin.PointOut{Hash: src.Hash(), Index: 0}
+		view.Add(out, coin.UtxoEntry{Output: src.Outputs[0]})
+		outs = append(outs, out)
+	}
+	return view, outs
+}
+
+func spend(prev coin.PointOut, value int64) coin.Transaction {
+	return coin.Transaction{
+		Version: 1,
+		Inputs:  []coin.TxIn{{PreviousOut: prev, ScriptSig: []byte{coin.OP_TRUE}, Sequence: 0xffffffff}},
+		Outputs: []coin.TxOut{{Value: value, ScriptPubKey: []byte{coin.OP_TRUE}}},
+	}
+}
+
+func TestAcceptAndDoubleSpend(t *testing.T) {
+	view, outs := fundedView(t, 1)
+	p := New(Config{View: view})
+
+	tx := spend(outs[0], 10*coin.Coin-coin.MinTxFee)
+	acc, err := p.Accept(tx)
+	if err != nil || len(acc) != 1 {
+		t.Fatalf("accept: %v %v", acc, err)
+	}
+	if !p.Exists(tx.Hash()) {
+		t.Fatalf("tx not pooled")
+	}
+	if _, err := p.Accept(tx); !errors.Is(err, ErrAlreadyHave) {
+		t.Fatalf("expected already have, got %v", err)
+	}
+	ds := spend(outs[0], 10*coin.Coin-2*coin.MinTxFee)
+	if _, err := p.Accept(ds); !errors.Is(err, ErrDoubleSpend) {
+		t.Fatalf("expected double spend, got %v", err)
+	}
+	low := spend(outs[0], 10*coin.Coin)
+	p.Remove(tx.Hash())
+	if _, err := p.Accept(low); !errors.Is(err, ErrInsufficientFee) {
+		t.Fatalf("expected insufficient fee, got %v", err)
+	}
+}
+
+func TestOrphanResolution(t *testing.T) {
+	view, outs := fundedView(t, 1)
+	p := New(Config{View: view})
+
+	parent := spend(outs[0], 9*coin.Coin)
+	child := spend(coin.PointOut{Hash: parent.Hash(), Index: 0}, 8*coin.Coin)
+
+	acc, err := p.Accept(child)
+	if err != nil || acc != nil {
+		t.Fatalf("orphan accept: %v %v", acc, err)
+	}
+	if !p.HaveOrphan(child.Hash()) {
+		t.Fatalf("child should be an orphan")
+	}
+	acc, err = p.Accept(parent)
+	if err != nil {
+		t.Fatalf("parent: %v", err)
+	}
+	if len(acc) != 2 || acc[1].Hash != child.Hash() {
+		t.Fatalf("expected orphan to resolve, got %d accepted", len(acc))
+	}
+	if p.OrphanCount() != 0 || p.Size() != 2 {
+		t.Fatalf("unexpected pool state orphans=%d size=%d", p.OrphanCount(), p.Size())
+	}
+}
+
+func TestEvictionByFeeRate(t *testing.T) {
+	view, outs := fundedView(t, 3)
+	cheap := spend(outs[0], 10*coin.Coin-coin.MinTxFee)
+	size := cheap.SerializeSize()
+	p := New(Config{View: view, MaxPoolBytes: 2 * size})
+
+	if _, err := p.Accept(cheap); err != nil {
+		t.Fatalf("cheap: %v", err)
+	}
+	if _, err := p.Accept(spend(outs[1], 9*coin.Coin)); err != nil {
+		t.Fatalf("rich: %v", err)
+	}
+	if _, err := p.Accept(spend(outs[2], 9*coin.Coin)); err != nil {
+		t.Fatalf("rich2: %v", err)
+	}
+	if p.Exists(cheap.Hash()) || p.Size() != 2 {
+		t.Fatalf("expected the cheapest transaction to be evicted")
+	}
+	if _, err := p.Accept(spend(outs[0], 10*coin.Coin-coin.MinTxFee)); !errors.Is(err, ErrPoolFull) {
+		t.Fatalf("expected pool full, got %v", err)
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	view, outs := fundedView(t, 1)
+	now := time.Unix(1000, 0)
+	p := New(Config{View: view, Now: func() time.Time { return now }, Expiry: time.Hour})
+
+	parent := spend(outs[0], 9*coin.Coin)
+	child := spend(coin.PointOut{Hash: parent.Hash(), Index: 0}, 8*coin.Coin)
+	if _, err := p.Accept(parent); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(30 * time.Minute)
+	if _, err := p.Accept(child); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(31 * time.Minute)
+	if removed := p.Expire(); len(removed) != 2 {
+		t.Fatalf("expected parent and child to expire, got %d", len(removed))
+	}
+}
+
+func TestBlockConnected(t *testing.T) {
+	view, outs := fundedView(t, 2)
+	p := New(Config{View: view})
+
+	confirmed := spend(outs[0], 9*coin.Coin)
+	conflict := spend(outs[1], 9*coin.Coin)
+	child := spend(coin.PointOut{Hash: conflict.Hash(), Index: 0}, 8*coin.Coin)
+	for _, tx := range []coin.Transaction{confirmed, conflict, child} {
+		if _, err := p.Accept(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rival := spend(outs[1], 5*coin.Coin)
+	blk := coin.Block{Transactions: []coin.Transaction{confirmed, rival}}
+	view.ConnectBlock(blk, 1)
+	conflicts, _ := p.BlockConnected(blk)
+	if len(conflicts) != 2 {
+		t.Fatalf("expected conflict and child removed, got %d", len(conflicts))
+	}
+	if p.Size() != 0 {
+		t.Fatalf("pool should be empty, has %d", p.Size())
+	}
+}
@@ -0,0 +1,59 @@
+// Package mining creates new blocks: proof-of-work blocks searched on the
+// CPU and proof-of-stake blocks minted from wallet coins (mining_manager).
+package mining
+
+import (
+	"strings"
+
+	"pila/pkg/coin"
+	"pila/pkg/wallet"
+)
+
+// Chain is the view of the active chain blocks are built on and the sink
+// for the blocks found.
+type Chain interface {
+	wallet.ChainSource
+	// NextTarget returns the compact target the next proof-of-work or
+	// proof-of-stake block must meet (get_next_target_required).
+	NextTarget(proofOfStake bool) uint32
+	// SubmitBlock hands a found block to the chain (process_block).
+	SubmitBlock(b coin.Block) error
+}
+
+// StakeChain is a Chain that also knows the stake modifiers.
+type StakeChain interface {
+	Chain
+	// StakeModifier returns the modifier a kernel staking an output of
+	// the block with hash commits to (get_kernel_stake_modifier).
+	StakeModifier(blockHash string) (uint64, error)
+}
+
+// tipHash returns the hash of the best block of chain.
+func tipHash(chain Chain) string {
+	b, err := chain.BlockAtHeight(chain.BestHeight())
+	if err != nil {
+		return ""
+	}
+	return b.Header.Hash()
+}
+
+// coinbaseScript returns the coinbase script signature of a block at
+// height (increment_extra_nonce).
+func coinbaseScript(height int32, extraNonce uint32) []byte {
+	var b coin.ScriptBuilder
+	return b.AddInt64(int64(height)).AddInt64(int64(extraNonce)).Script()
+}
+
+// newCoinbase returns a coinbase for a block at height paying value to
+// script.
+func newCoinbase(height int32, extraNonce uint32, value int64, script []byte) coin.Transaction {
+	return coin.Transaction{
+		Version: 1,
+		Inputs: []coin.TxIn{{
+			PreviousOut: coin.PointOut{Hash: strings.Repeat("0", 64), Index: ^uint32(0)},
+			ScriptSig:   coinbaseScript(height, extraNonce),
+			Sequence:    ^uint32(0),
+		}},
+		Outputs: []coin.TxOut{{Value: value, ScriptPubKey: script}},
+	}
+}
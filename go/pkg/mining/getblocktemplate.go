@@ -0,0 +1,282 @@
+package mining
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"pila/pkg/coin"
+	"pila/pkg/mempool"
+)
+
+const (
+	// templateRefresh is how long a cached template is kept while only
+	// the pool changes.
+	templateRefresh = 5 * time.Second
+	// staleSearchDepth bounds how far below the tip submitblock looks for
+	// the parent of a block, so one on an unknown parent cannot make it
+	// walk the whole chain.
+	staleSearchDepth = 100
+)
+
+// ErrTemplateMode is returned for getblocktemplate modes other than
+// "template".
+var ErrTemplateMode = errors.New("invalid parameter")
+
+// TemplateRequest holds the getblocktemplate parameters (BIP22).
+type TemplateRequest struct {
+	Mode string `json:"mode,omitempty"`
+	// LongPollID, when set to the longpollid of an earlier reply, makes
+	// the call wait until the template it identified is outdated.
+	LongPollID string `json:"longpollid,omitempty"`
+}
+
+// TemplateTx is a transaction of a getblocktemplate reply.
+type TemplateTx struct {
+	Data string `json:"data"`
+	Hash string `json:"hash"`
+	Fee  int64  `json:"fee"`
+	// Depends holds the 1 based positions of the template transactions
+	// this one spends.
+	Depends []int `json:"depends"`
+}
+
+// TemplateResult is the getblocktemplate reply.
+type TemplateResult struct {
+	Version           uint32            `json:"version"`
+	PreviousBlockHash string            `json:"previousblockhash"`
+	Transactions      []TemplateTx      `json:"transactions"`
+	CoinbaseAux       map[string]string `json:"coinbaseaux"`
+	CoinbaseValue     int64             `json:"coinbasevalue"`
+	LongPollID        string            `json:"longpollid"`
+	Target            string            `json:"target"`
+	MinTime           uint32            `json:"mintime"`
+	Mutable           []string          `json:"mutable"`
+	NonceRange        string            `json:"noncerange"`
+	SizeLimit         int               `json:"sizelimit"`
+	CurTime           uint32            `json:"curtime"`
+	Bits              string            `json:"bits"`
+	Height            int32             `json:"height"`
+}
+
+// TemplateServer answers getblocktemplate and submitblock for external
+// mining software (json_getblocktemplate, json_submitblock). It keeps the
+// last template and only rebuilds it when the tip moves or, after a few
+// seconds, when the pool changed.
+type TemplateServer struct {
+	chain  Chain
+	pool   *mempool.Pool
+	script []byte
+	// MaxSize bounds the serialized size of templates.
+	MaxSize int
+	// PollInterval is how often a long poll looks for a new tip or new
+	// pool transactions.
+	PollInterval time.Duration
+
+	mu    sync.Mutex
+	tmpl  *Template
+	built time.Time
+}
+
+// NewTemplateServer returns a server building templates on chain from the
+// transactions of pool. Templates pay the subsidy to script, which miners
+// replace with their own coinbase. pool may be nil.
+func NewTemplateServer(chain Chain, pool *mempool.Pool, script []byte) *TemplateServer {
+	return &TemplateServer{
+		chain:        chain,
+		pool:         pool,
+		script:       script,
+		MaxSize:      DefaultBlockMaxSize,
+		PollInterval: time.Second,
+	}
+}
+
+// longPollID identifies the current chain tip and pool state in the form
+// templateID gives a template built at them.
+func (s *TemplateServer) longPollID() string {
+	var updated uint32
+	if s.pool != nil {
+		updated = s.pool.Updated()
+	}
+	return fmt.Sprintf("%s%d", tipHash(s.chain), updated)
+}
+
+// templateID identifies the chain tip and pool state t was built at, which
+// a cached template may lag behind.
+func templateID(t *Template) string {
+	return fmt.Sprintf("%s%d", t.Block.Header.PrevHash, t.PoolUpdated)
+}
+
+// GetBlockTemplate returns a block template for req. A long poll blocks
+// until the tip or the pool differs from the state its id names, or stop
+// is closed.
+func (s *TemplateServer) GetBlockTemplate(stop <-chan struct{}, req TemplateRequest) (*TemplateResult, error) {
+	if req.Mode != "" && req.Mode != "template" {
+		return nil, ErrTemplateMode
+	}
+	if req.LongPollID != "" {
+		ticker := time.NewTicker(s.PollInterval)
+		for s.longPollID() == req.LongPollID {
+			select {
+			case <-stop:
+				ticker.Stop()
+				return nil, errors.New("long poll stopped")
+			case <-ticker.C:
+			}
+		}
+		ticker.Stop()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tmpl == nil || s.tmpl.Block.Header.PrevHash != tipHash(s.chain) ||
+		s.pool != nil && s.pool.Updated() != s.tmpl.PoolUpdated && time.Since(s.built) > templateRefresh {
+		t, err := NewBlockTemplate(s.chain, s.pool, s.script, s.MaxSize)
+		if err != nil {
+			return nil, err
+		}
+		s.tmpl, s.built = t, time.Now()
+	}
+	t := s.tmpl
+	h := t.Block.Header
+
+	// Update the time (update_time).
+	minTime := s.minTime(t.Height - 1)
+	if now := uint32(coin.InstanceTime().GetAdjusted()); now > minTime {
+		h.Timestamp = now
+	} else {
+		h.Timestamp = minTime
+	}
+
+	res := &TemplateResult{
+		Version:           h.Version,
+		PreviousBlockHash: h.PrevHash,
+		Transactions:      []TemplateTx{},
+		CoinbaseAux:       map[string]string{"flags": ""},
+		CoinbaseValue:     t.CoinbaseValue,
+		LongPollID:        templateID(t),
+		Target:            fmt.Sprintf("%064x", coin.CompactToBig(h.Bits)),
+		MinTime:           minTime,
+		Mutable:           []string{"time", "transactions", "prevblock"},
+		NonceRange:        "00000000ffffffff",
+		SizeLimit:         s.MaxSize,
+		CurTime:           h.Timestamp,
+		Bits:              fmt.Sprintf("%08x", h.Bits),
+		Height:            t.Height,
+	}
+	for i, tx := range t.Block.Transactions[1:] {
+		data, err := tx.Serialize()
+		if err != nil {
+			return nil, err
+		}
+		depends := t.Depends[i]
+		if depends == nil {
+			depends = []int{}
+		}
+		res.Transactions = append(res.Transactions, TemplateTx{
+			Data:    hex.EncodeToString(data),
+			Hash:    tx.Hash(),
+			Fee:     t.Fees[i],
+			Depends: depends,
+		})
+	}
+	return res, nil
+}
+
+// minTime returns the earliest timestamp a block on top of height may
+// carry: one second past the median of the last eleven blocks.
+func (s *TemplateServer) minTime(height int32) uint32 {
+	var times []uint32
+	for h := height; h >= 0 && h > height-11; h-- {
+		b, err := s.chain.BlockAtHeight(h)
+		if err != nil {
+			break
+		}
+		times = append(times, b.Header.Timestamp)
+	}
+	if len(times) == 0 {
+		return 0
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+	return times[len(times)/2] + 1
+}
+
+// SubmitBlock decodes a hex encoded block and hands it to the chain. It
+// returns the BIP22 reason the block was refused for, or "" once it was
+// accepted. Undecodable data is an error rather than a rejection.
+func (s *TemplateServer) SubmitBlock(data string) (string, error) {
+	raw, err := hex.DecodeString(data)
+	if err != nil {
+		return "", fmt.Errorf("block decode failed: %w", err)
+	}
+	b, err := coin.DeserializeBlock(raw)
+	if err != nil {
+		return "", fmt.Errorf("block decode failed: %w", err)
+	}
+	if reason := s.checkBlock(b); reason != "" {
+		return reason, nil
+	}
+	if err := s.chain.SubmitBlock(b); err != nil {
+		log.Printf("submitblock: %s rejected: %v", b.Header.Hash(), err)
+		return "rejected", nil
+	}
+	s.mu.Lock()
+	s.tmpl = nil
+	s.mu.Unlock()
+	return "", nil
+}
+
+// checkBlock returns the BIP22 reason b cannot extend the tip, or "". A
+// parent deeper than staleSearchDepth below the tip counts as unknown.
+func (s *TemplateServer) checkBlock(b coin.Block) string {
+	if len(b.Transactions) == 0 || !b.Transactions[0].IsCoinBase() {
+		return "bad-cb-missing"
+	}
+	for _, tx := range b.Transactions[1:] {
+		if tx.IsCoinBase() {
+			return "bad-cb-multiple"
+		}
+	}
+	if b.Header.MerkleRoot != b.BuildMerkleRoot() {
+		return "bad-txnmrklroot"
+	}
+	if b.IsProofOfStake() {
+		if !b.CheckSignature() {
+			return "bad-blk-sig"
+		}
+	} else if !coin.CheckProofOfWork(b.Header) {
+		return "high-hash"
+	}
+	if int64(b.Header.Timestamp) > int64(coin.InstanceTime().GetAdjusted())+coin.MaxClockDrift {
+		return "time-too-new"
+	}
+
+	hash := b.Header.Hash()
+	best := s.chain.BestHeight()
+	for h := best; h >= 0 && best-h < staleSearchDepth; h-- {
+		prev, err := s.chain.BlockAtHeight(h)
+		if err != nil {
+			return "inconclusive"
+		}
+		switch prev.Header.Hash() {
+		case hash:
+			return "duplicate"
+		case b.Header.PrevHash:
+			if h != best {
+				return "stale-prevblk"
+			}
+			if b.Header.Timestamp < s.minTime(h) {
+				return "time-too-old"
+			}
+			if !b.IsProofOfStake() && h+1 > coin.PowCutoffBlock {
+				return "pow-cutoff"
+			}
+			return ""
+		}
+	}
+	return "bad-prevblk"
+}
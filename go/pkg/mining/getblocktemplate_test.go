@@ -0,0 +1,173 @@
+package mining
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+
+	"pila/pkg/coin"
+	"pila/pkg/mempool"
+)
+
+// solvedHex mines a block on the tip of chain and returns it hex encoded.
+func solvedHex(t *testing.T, chain Chain) (coin.Block, string) {
+	tmpl, err := NewBlockTemplate(chain, nil, []byte{coin.OP_TRUE}, DefaultBlockMaxSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !NewMiner(chain, nil, nil).solve(tmpl, func() bool { return false }) {
+		t.Fatal("no nonce solves the template")
+	}
+	data, err := tmpl.Block.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tmpl.Block, hex.EncodeToString(data)
+}
+
+func TestGetBlockTemplate(t *testing.T) {
+	chain := &testChain{bits: 0x207fffff}
+	chain.add(1500000000, newCoinbase(0, 0, 0, nil))
+	s := NewTemplateServer(chain, nil, nil)
+	s.PollInterval = 10 * time.Millisecond
+
+	if _, err := s.GetBlockTemplate(nil, TemplateRequest{Mode: "proposal"}); err != ErrTemplateMode {
+		t.Fatalf("accepted mode proposal: %v", err)
+	}
+	res, err := s.GetBlockTemplate(nil, TemplateRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Height != 1 || res.PreviousBlockHash != chain.blocks[0].Header.Hash() || res.Bits != "207fffff" {
+		t.Fatalf("template at %d on %s bits %s", res.Height, res.PreviousBlockHash, res.Bits)
+	}
+	if res.CoinbaseValue != coin.ProofOfWorkReward(1) || len(res.Transactions) != 0 || res.MinTime != 1500000001 {
+		t.Fatalf("coinbase value %d, %d transactions, mintime %d", res.CoinbaseValue, len(res.Transactions), res.MinTime)
+	}
+	if res.Target[:4] != "7fff" || len(res.Target) != 64 {
+		t.Fatalf("target %s", res.Target)
+	}
+
+	// A long poll returns once the tip moves.
+	done := make(chan *TemplateResult)
+	go func() {
+		res, err := s.GetBlockTemplate(nil, TemplateRequest{LongPollID: res.LongPollID})
+		if err != nil {
+			t.Error(err)
+		}
+		done <- res
+	}()
+	select {
+	case <-done:
+		t.Fatal("long poll returned before the tip changed")
+	case <-time.After(50 * time.Millisecond):
+	}
+	stale, _ := solvedHex(t, chain)
+	_, data := solvedHex(t, chain)
+	if reason, err := s.SubmitBlock(data); err != nil || reason != "" {
+		t.Fatalf("submitblock: %q %v", reason, err)
+	}
+	select {
+	case next := <-done:
+		if next == nil || next.Height != 2 {
+			t.Fatalf("long poll returned %+v", next)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("long poll did not return after a new tip")
+	}
+
+	if reason, _ := s.SubmitBlock(data); reason != "duplicate" {
+		t.Fatalf("resubmitted block: %q", reason)
+	}
+	stale.Header.Nonce++
+	for !coin.CheckProofOfWork(stale.Header) {
+		stale.Header.Nonce++
+	}
+	raw, _ := stale.Serialize()
+	if reason, _ := s.SubmitBlock(hex.EncodeToString(raw)); reason != "stale-prevblk" {
+		t.Fatalf("block on an old tip: %q", reason)
+	}
+	b, _ := solvedHex(t, chain)
+	b.Header.MerkleRoot = stale.Header.MerkleRoot
+	raw, _ = b.Serialize()
+	if reason, _ := s.SubmitBlock(hex.EncodeToString(raw)); reason != "bad-txnmrklroot" {
+		t.Fatalf("block with a wrong merkle root: %q", reason)
+	}
+	if _, err := s.SubmitBlock("00ff"); err == nil {
+		t.Fatal("decoded a truncated block")
+	}
+}
+
+func TestLongPollCachedTemplate(t *testing.T) {
+	view := coin.NewUtxoSet()
+	src := coin.Transaction{Version: 1, Outputs: []coin.TxOut{{Value: 10 * coin.Coin, ScriptPubKey: []byte{coin.OP_TRUE}}}}
+	view.Add(coin.PointOut{Hash: src.Hash()}, coin.UtxoEntry{Output: src.Outputs[0]})
+	pool := mempool.New(mempool.Config{View: view})
+	chain := &testChain{bits: 0x207fffff}
+	chain.add(1500000000, newCoinbase(0, 0, 0, nil))
+	s := NewTemplateServer(chain, pool, nil)
+	s.PollInterval = 10 * time.Millisecond
+
+	first, err := s.GetBlockTemplate(nil, TemplateRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pool.Accept(spendTrue(coin.PointOut{Hash: src.Hash()}, 10*coin.Coin-coin.MinTxFee)); err != nil {
+		t.Fatal(err)
+	}
+	// The cached template does not have the new transaction, so its id
+	// must not name the current pool state.
+	cached, err := s.GetBlockTemplate(nil, TemplateRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cached.Transactions) != 0 || cached.LongPollID != first.LongPollID {
+		t.Fatalf("cached template with %d transactions has id %s, first had %s", len(cached.Transactions), cached.LongPollID, first.LongPollID)
+	}
+	done := make(chan struct{})
+	go func() {
+		if _, err := s.GetBlockTemplate(nil, TemplateRequest{LongPollID: cached.LongPollID}); err != nil {
+			t.Error(err)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("long poll on a cached template waited for a change it had missed")
+	}
+}
+
+// countingChain counts the blocks read from the chain.
+type countingChain struct {
+	*testChain
+	reads int
+}
+
+func (c *countingChain) BlockAtHeight(h int32) (coin.Block, error) {
+	c.reads++
+	return c.testChain.BlockAtHeight(h)
+}
+
+func TestSubmitBlockUnknownParent(t *testing.T) {
+	chain := &countingChain{testChain: &testChain{bits: 0x207fffff}}
+	for i := 0; i < 3*staleSearchDepth; i++ {
+		chain.add(1500000000+uint32(i), newCoinbase(int32(i), 0, 0, nil))
+	}
+	s := NewTemplateServer(chain, nil, nil)
+	b, _ := solvedHex(t, chain)
+	b.Header.PrevHash = strings.Repeat("ab", 32)
+	for !coin.CheckProofOfWork(b.Header) {
+		b.Header.Nonce++
+	}
+	raw, _ := b.Serialize()
+
+	chain.reads = 0
+	if reason, _ := s.SubmitBlock(hex.EncodeToString(raw)); reason != "bad-prevblk" {
+		t.Fatalf("block on an unknown parent: %q", reason)
+	}
+	if chain.reads > staleSearchDepth {
+		t.Fatalf("read %d blocks looking for the parent", chain.reads)
+	}
+}
@@ -0,0 +1,212 @@
+package mining
+
+import (
+	"errors"
+	"log"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"pila/pkg/coin"
+	"pila/pkg/mempool"
+)
+
+// refreshInterval is how often a worker looks for a new tip or new pool
+// transactions while searching nonces.
+const refreshInterval = 5 * time.Second
+
+// Miner searches proof-of-work blocks on the CPU, one template per worker
+// goroutine (the proof-of-work part of mining_manager).
+type Miner struct {
+	chain  Chain
+	pool   *mempool.Pool
+	script []byte
+	// Workers is the number of mining goroutines; runtime.NumCPU by
+	// default.
+	Workers int
+	// MaxSize bounds the serialized size of mined blocks.
+	MaxSize int
+
+	hashes atomic.Uint64
+	mu     sync.Mutex
+	rate   float64
+	// submitMu serializes block submission so two workers finding a
+	// block on the same tip do not both submit.
+	submitMu sync.Mutex
+}
+
+// NewMiner returns a miner building on chain from the transactions of pool
+// and paying the subsidy to script. pool may be nil.
+func NewMiner(chain Chain, pool *mempool.Pool, script []byte) *Miner {
+	return &Miner{
+		chain:   chain,
+		pool:    pool,
+		script:  script,
+		Workers: runtime.NumCPU(),
+		MaxSize: DefaultBlockMaxSize,
+	}
+}
+
+// solve searches the nonces of t for a header meeting its target and
+// reports whether one was found. It gives up when quit returns true,
+// which it asks every few thousand hashes. The part of the header before
+// the nonce is hashed once per extra nonce.
+func (m *Miner) solve(t *Template, quit func() bool) bool {
+	h := &t.Block.Header
+	target := coin.CompactToBig(h.Bits)
+	if target.Sign() <= 0 {
+		return false
+	}
+	hasher := coin.NewHeaderHasher(*h)
+	for nonce := uint32(0); ; nonce++ {
+		if coin.HashToBig(hasher.Hash(nonce)).Cmp(target) <= 0 {
+			h.Nonce = nonce
+			m.hashes.Add(uint64(nonce&0xfff) + 1)
+			return true
+		}
+		if nonce&0xfff == 0xfff {
+			m.hashes.Add(0x1000)
+			if quit() {
+				return false
+			}
+		}
+		if nonce == ^uint32(0) {
+			return false
+		}
+	}
+}
+
+// submit hands a solved block to the chain unless the tip moved on.
+func (m *Miner) submit(b coin.Block) error {
+	m.submitMu.Lock()
+	defer m.submitMu.Unlock()
+	tip, err := m.chain.BlockAtHeight(m.chain.BestHeight())
+	if err == nil && tip.Header.Hash() != b.Header.PrevHash {
+		return errors.New("mined block is stale")
+	}
+	return m.chain.SubmitBlock(b)
+}
+
+// Generate mines n blocks one after the other on the calling goroutine
+// and returns them (setgenerate on regtest).
+func (m *Miner) Generate(n int) ([]coin.Block, error) {
+	var blocks []coin.Block
+	for len(blocks) < n {
+		t, err := NewBlockTemplate(m.chain, m.pool, m.script, m.MaxSize)
+		if err != nil {
+			return blocks, err
+		}
+		found := false
+		for extraNonce := uint32(1); !found; extraNonce++ {
+			t.setExtraNonce(extraNonce)
+			found = m.solve(t, func() bool { return false })
+		}
+		if err := m.submit(t.Block); err != nil {
+			return blocks, err
+		}
+		blocks = append(blocks, t.Block)
+	}
+	return blocks, nil
+}
+
+// Run mines with Workers goroutines until stop is closed or the chain
+// reaches coin.PowCutoffBlock, logging the hash rate every interval.
+func (m *Miner) Run(stop <-chan struct{}, interval time.Duration) {
+	var wg sync.WaitGroup
+	workers := m.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	done := make(chan struct{})
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			m.work(stop, uint32(worker), uint32(workers))
+		}(i)
+	}
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	last, lastTime := m.hashes.Load(), time.Now()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			n := m.hashes.Load()
+			rate := float64(n-last) / now.Sub(lastTime).Seconds()
+			last, lastTime = n, now
+			m.mu.Lock()
+			m.rate = rate
+			m.mu.Unlock()
+			log.Printf("mining: %.0f hashes/s", rate)
+		}
+	}
+}
+
+// work is one mining goroutine. Workers take disjoint extra nonces so
+// they never search the same header.
+func (m *Miner) work(stop <-chan struct{}, worker, workers uint32) {
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		t, err := NewBlockTemplate(m.chain, m.pool, m.script, m.MaxSize)
+		if err == ErrPowCutoff {
+			log.Printf("mining: %v", err)
+			return
+		}
+		if err != nil {
+			log.Printf("mining: %v", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		deadline := time.Now().Add(refreshInterval)
+		stale := false
+		quit := func() bool {
+			select {
+			case <-stop:
+				stale = true
+			default:
+			}
+			if !stale && !time.Now().Before(deadline) {
+				deadline = time.Now().Add(refreshInterval)
+				stale = m.chain.BestHeight() != t.Height-1 || m.pool != nil && m.pool.Updated() != t.PoolUpdated
+			}
+			return stale
+		}
+		for extraNonce := worker + 1; ; extraNonce += workers {
+			t.setExtraNonce(extraNonce)
+			if m.solve(t, quit) {
+				if err := m.submit(t.Block); err != nil {
+					log.Printf("mining: %v", err)
+				} else {
+					log.Printf("mining: found proof-of-work block %s at height %d", t.Block.Header.Hash(), t.Height)
+				}
+				break
+			}
+			if stale {
+				break
+			}
+		}
+	}
+}
+
+// HashesPerSecond returns the hash rate measured over the last Run
+// interval (getmininginfo hashespersec).
+func (m *Miner) HashesPerSecond() float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.rate
+}
+
+// Hashes returns the number of headers hashed so far.
+func (m *Miner) Hashes() uint64 { return m.hashes.Load() }
@@ -0,0 +1,90 @@
+package mining
+
+import (
+	"testing"
+
+	"pila/pkg/coin"
+	"pila/pkg/mempool"
+)
+
+func spendTrue(prev coin.PointOut, value int64) coin.Transaction {
+	return coin.Transaction{
+		Version: 1,
+		Inputs:  []coin.TxIn{{PreviousOut: prev, ScriptSig: []byte{coin.OP_TRUE}, Sequence: 0xffffffff}},
+		Outputs: []coin.TxOut{{Value: value, ScriptPubKey: []byte{coin.OP_TRUE}}},
+	}
+}
+
+func TestBlockTemplate(t *testing.T) {
+	view := coin.NewUtxoSet()
+	src := coin.Transaction{Version: 1, Outputs: []coin.TxOut{{Value: 10 * coin.Coin, ScriptPubKey: []byte{coin.OP_TRUE}}}}
+	view.Add(coin.PointOut{Hash: src.Hash()}, coin.UtxoEntry{Output: src.Outputs[0]})
+	pool := mempool.New(mempool.Config{View: view})
+	parent := spendTrue(coin.PointOut{Hash: src.Hash()}, 10*coin.Coin-coin.MinTxFee)
+	// The child pays a higher fee rate but must follow its parent.
+	child := spendTrue(coin.PointOut{Hash: parent.Hash()}, 10*coin.Coin-coin.MinTxFee-coin.Cent)
+	for _, tx := range []coin.Transaction{parent, child} {
+		if _, err := pool.Accept(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	chain := &testChain{bits: 0x207fffff}
+	chain.add(1500000000, newCoinbase(0, 0, 0, nil))
+	tmpl, err := NewBlockTemplate(chain, pool, []byte{coin.OP_TRUE}, DefaultBlockMaxSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	txs := tmpl.Block.Transactions
+	if len(txs) != 3 || txs[1].Hash() != parent.Hash() || txs[2].Hash() != child.Hash() {
+		t.Fatalf("template has %d transactions in the wrong order", len(txs))
+	}
+	if len(tmpl.Depends[1]) != 1 || tmpl.Depends[1][0] != 1 || tmpl.Fees[1] != coin.Cent {
+		t.Fatalf("depends %v fees %v", tmpl.Depends, tmpl.Fees)
+	}
+	if tmpl.CoinbaseValue != coin.ProofOfWorkReward(1) || txs[0].Outputs[0].Value != tmpl.CoinbaseValue {
+		t.Fatalf("coinbase value %d", txs[0].Outputs[0].Value)
+	}
+	if tmpl.Block.Header.Timestamp <= chain.blocks[0].Header.Timestamp {
+		t.Fatalf("template time %d not after the tip", tmpl.Block.Header.Timestamp)
+	}
+}
+
+func TestMinerGenerate(t *testing.T) {
+	chain := &testChain{bits: 0x207fffff}
+	chain.add(1500000000, newCoinbase(0, 0, 0, nil))
+	m := NewMiner(chain, nil, []byte{coin.OP_TRUE})
+	blocks, err := m.Generate(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 3 || chain.BestHeight() != 3 {
+		t.Fatalf("generated %d blocks, tip at %d", len(blocks), chain.BestHeight())
+	}
+	for i, b := range blocks {
+		if !coin.CheckProofOfWork(b.Header) || b.Header.PrevHash != chain.blocks[i].Header.Hash() {
+			t.Fatalf("block %d does not meet its target or extend the chain", i+1)
+		}
+		if err := b.Validate(); err != nil {
+			t.Fatalf("block %d: %v", i+1, err)
+		}
+	}
+	if m.Hashes() < 3 {
+		t.Fatalf("hashed %d headers", m.Hashes())
+	}
+}
+
+func TestMinerStopsAtCutoff(t *testing.T) {
+	chain := &cutoffChain{testChain{bits: 0x207fffff}}
+	chain.add(1500000000, newCoinbase(0, 0, 0, nil))
+	if _, err := NewMiner(chain, nil, nil).Generate(1); err != ErrPowCutoff {
+		t.Fatalf("mined past the cutoff: %v", err)
+	}
+}
+
+// cutoffChain reports its tip at coin.PowCutoffBlock.
+type cutoffChain struct{ testChain }
+
+func (c *cutoffChain) BestHeight() int32 { return coin.PowCutoffBlock }
+
+func (c *cutoffChain) BlockAtHeight(int32) (coin.Block, error) { return c.blocks[0], nil }
@@ -0,0 +1,302 @@
+package mining
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"pila/pkg/coin"
+	"pila/pkg/mempool"
+	"pila/pkg/wallet"
+)
+
+// maxStakeInputs bounds the inputs combined into one coinstake.
+const maxStakeInputs = 100
+
+// ErrNoKernel is returned when no wallet coin meets the stake target in
+// the searched timestamp window.
+var ErrNoKernel = errors.New("no stake kernel found")
+
+// Staker mints proof-of-stake blocks from the coins of a wallet. It only
+// stakes while the wallet is unlocked, for staking only or fully.
+type Staker struct {
+	wallet *wallet.Wallet
+	chain  StakeChain
+	// Now returns the network adjusted time.
+	Now func() uint32
+	// Pool, if set, supplies the transactions minted blocks carry.
+	Pool *mempool.Pool
+
+	mu         sync.Mutex
+	lastSearch uint32
+	lastTip    string
+	extraNonce uint32
+}
+
+// NewStaker returns a staker minting from w on top of chain.
+func NewStaker(w *wallet.Wallet, chain StakeChain) *Staker {
+	return &Staker{
+		wallet: w,
+		chain:  chain,
+		Now:    func() uint32 { return uint32(coin.InstanceTime().GetAdjusted()) },
+	}
+}
+
+// stakeCandidate is a wallet coin with its kernel context.
+type stakeCandidate struct {
+	coin.StakeInput
+	script    []byte
+	blockHash string
+}
+
+// candidates returns the stakeable wallet coins with the context the
+// kernel commits to.
+func (s *Staker) candidates() []stakeCandidate {
+	var out []stakeCandidate
+	for _, c := range s.wallet.StakeCoins() {
+		wtx, ok := s.wallet.Transaction(c.Out.Hash)
+		if !ok || !wtx.IsConfirmed() {
+			continue
+		}
+		b, err := s.chain.BlockAtHeight(wtx.BlockHeight)
+		if err != nil || b.Header.Hash() != wtx.BlockHash {
+			continue
+		}
+		for i, tx := range b.Transactions {
+			if tx.Hash() != c.Out.Hash {
+				continue
+			}
+			out = append(out, stakeCandidate{
+				StakeInput: coin.StakeInput{
+					PrevOut:   c.Out,
+					Value:     c.Value(),
+					BlockTime: b.Header.Timestamp,
+					TxTime:    b.Header.Timestamp,
+					TxOffset:  b.TxOffset(i),
+				},
+				script:    c.Output.ScriptPubKey,
+				blockHash: wtx.BlockHash,
+			})
+			break
+		}
+	}
+	return out
+}
+
+// CreateCoinStake searches the wallet coins for a kernel meeting bits at
+// txTime or up to searchInterval seconds (at most
+// coin.MaxStakeSearchInterval) before it, and builds the signed coinstake
+// around it (wallet::create_coin_stake). Young kernels are split in two
+// outputs; small coins paying to the same key are combined into an old
+// one. The outputs carry the staked value plus the coin age reward. It
+// returns the coinstake and the time of the kernel found, which the block
+// takes as its timestamp.
+func (s *Staker) CreateCoinStake(bits uint32, searchInterval int64, txTime uint32) (coin.Transaction, uint32, error) {
+	if !s.wallet.CanStake() {
+		return coin.Transaction{}, 0, wallet.ErrLocked
+	}
+	if searchInterval > coin.MaxStakeSearchInterval {
+		searchInterval = coin.MaxStakeSearchInterval
+	}
+	candidates := s.candidates()
+
+	tx := coin.Transaction{Version: 1, Outputs: []coin.TxOut{{}}}
+	var staked []stakeCandidate
+	for _, c := range candidates {
+		if int64(c.BlockTime)+coin.MinStakeAge > int64(txTime)-coin.MaxStakeSearchInterval {
+			continue
+		}
+		modifier, err := s.chain.StakeModifier(c.blockHash)
+		if err != nil {
+			continue
+		}
+		for n := int64(0); n < searchInterval; n++ {
+			t := txTime - uint32(n)
+			if _, err := coin.CheckStakeKernelHash(bits, modifier, c.StakeInput, t); err != nil {
+				continue
+			}
+			script, err := s.wallet.CoinStakeScript(c.script)
+			if err != nil {
+				break
+			}
+			txTime = t
+			tx.Inputs = append(tx.Inputs, coin.TxIn{PreviousOut: c.PrevOut, Sequence: ^uint32(0)})
+			tx.Outputs = append(tx.Outputs, coin.TxOut{ScriptPubKey: script})
+			if int64(c.BlockTime)+coin.StakeSplitAge > int64(txTime) {
+				tx.Outputs = append(tx.Outputs, coin.TxOut{ScriptPubKey: script})
+			}
+			staked = append(staked, c)
+			break
+		}
+		if len(staked) > 0 {
+			break
+		}
+	}
+	if len(staked) == 0 {
+		return coin.Transaction{}, 0, ErrNoKernel
+	}
+	credit := staked[0].Value
+
+	// Combine small coins of the same key into an unsplit stake.
+	threshold := coin.ProofOfWorkReward(s.chain.BestHeight()) / 3
+	for _, c := range candidates {
+		if len(tx.Outputs) != 2 || len(tx.Inputs) >= maxStakeInputs || credit > threshold {
+			break
+		}
+		if c.PrevOut.Hash == tx.Inputs[0].PreviousOut.Hash || c.Value > threshold {
+			continue
+		}
+		if string(c.script) != string(staked[0].script) && string(c.script) != string(tx.Outputs[1].ScriptPubKey) {
+			continue
+		}
+		if int64(c.TxTime)+coin.MinStakeAge > int64(txTime) {
+			continue
+		}
+		tx.Inputs = append(tx.Inputs, coin.TxIn{PreviousOut: c.PrevOut, Sequence: ^uint32(0)})
+		staked = append(staked, c)
+		credit += c.Value
+	}
+
+	inputs := make([]coin.StakeInput, len(staked))
+	prevScripts := make([][]byte, len(staked))
+	for i, c := range staked {
+		inputs[i] = c.StakeInput
+		prevScripts[i] = c.script
+	}
+	credit += coin.ProofOfStakeReward(coin.CoinAge(inputs, txTime))
+
+	var minFee int64
+	for {
+		if len(tx.Outputs) == 3 {
+			tx.Outputs[1].Value = (credit - minFee) / 2 / coin.Cent * coin.Cent
+			tx.Outputs[2].Value = credit - minFee - tx.Outputs[1].Value
+		} else {
+			tx.Outputs[1].Value = credit - minFee
+		}
+		for i := range tx.Inputs {
+			tx.Inputs[i].ScriptSig = nil
+		}
+		if err := s.wallet.SignCoinStake(&tx, prevScripts); err != nil {
+			return coin.Transaction{}, 0, err
+		}
+		size := tx.SerializeSize()
+		if size > coin.MaxTransactionSize/3 {
+			return coin.Transaction{}, 0, errors.New("coinstake exceeds size limit")
+		}
+		if fee := coin.MinimumFee(size) - coin.MinTxFee; minFee < fee {
+			minFee = fee
+			continue
+		}
+		return tx, txTime, nil
+	}
+}
+
+// CreateBlock tries to mint a signed proof-of-stake block on the current
+// tip (block::create_new). It searches the seconds since the previous
+// attempt and fails with ErrNoKernel when no coin qualifies.
+func (s *Staker) CreateBlock() (coin.Block, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	best := s.chain.BestHeight()
+	prev, err := s.chain.BlockAtHeight(best)
+	if err != nil {
+		return coin.Block{}, err
+	}
+	now := s.Now()
+	if now <= s.lastSearch {
+		return coin.Block{}, ErrNoKernel
+	}
+	interval := int64(now - s.lastSearch)
+	s.lastSearch = now
+
+	bits := s.chain.NextTarget(true)
+	stake, txTime, err := s.CreateCoinStake(bits, interval, now)
+	if err != nil {
+		return coin.Block{}, err
+	}
+	if int64(txTime) < int64(prev.Header.Timestamp)-coin.MaxClockDrift {
+		return coin.Block{}, errors.New("coinstake is too old for the tip")
+	}
+	if s.extraNonce++; prev.Header.Hash() != s.lastTip {
+		s.extraNonce, s.lastTip = 1, prev.Header.Hash()
+	}
+	b := coin.Block{
+		Header: coin.BlockHeader{
+			Version:   coin.CurrentBlockVersion,
+			PrevHash:  prev.Header.Hash(),
+			Timestamp: txTime,
+			Bits:      bits,
+		},
+		Transactions: []coin.Transaction{newCoinbase(best+1, s.extraNonce, 0, nil), stake},
+	}
+	if s.Pool != nil {
+		staked := make(map[coin.PointOut]bool, len(stake.Inputs))
+		for _, in := range stake.Inputs {
+			staked[in.PreviousOut] = true
+		}
+		var descs []*mempool.TxDesc
+		for _, d := range s.Pool.Descs() {
+			if !spendsAny(d.Tx, staked) {
+				descs = append(descs, d)
+			}
+		}
+		t := Template{Block: b}
+		t.addTransactions(descs, DefaultBlockMaxSize)
+		b = t.Block
+	}
+	b.Header.MerkleRoot = b.BuildMerkleRoot()
+	if err := s.wallet.SignBlock(&b); err != nil {
+		return coin.Block{}, err
+	}
+	return b, nil
+}
+
+// spendsAny reports whether tx spends one of outs.
+func spendsAny(tx coin.Transaction, outs map[coin.PointOut]bool) bool {
+	for _, in := range tx.Inputs {
+		if outs[in.PreviousOut] {
+			return true
+		}
+	}
+	return false
+}
+
+// Mint creates a proof-of-stake block and submits it to the chain. It
+// fails with wallet.ErrLocked while the wallet is locked and with
+// ErrNoKernel when there was nothing to stake.
+func (s *Staker) Mint() (coin.Block, error) {
+	b, err := s.CreateBlock()
+	if err != nil {
+		return coin.Block{}, err
+	}
+	if b.Header.PrevHash != tipHash(s.chain) {
+		return coin.Block{}, errors.New("minted block is stale")
+	}
+	return b, s.chain.SubmitBlock(b)
+}
+
+// Run mints every interval until stop is closed (pos_tick), skipping the
+// attempt while the wallet is locked.
+func (s *Staker) Run(stop <-chan struct{}, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		if !s.wallet.CanStake() {
+			continue
+		}
+		b, err := s.Mint()
+		switch {
+		case err == ErrNoKernel:
+		case err != nil:
+			log.Printf("staking: %v", err)
+		default:
+			log.Printf("staking: found proof-of-stake block %s", b.Header.Hash())
+		}
+	}
+}
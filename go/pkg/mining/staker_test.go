@@ -0,0 +1,124 @@
+package mining
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"pila/pkg/coin"
+	"pila/pkg/wallet"
+)
+
+// testChain is an in-memory chain accepting every submitted block.
+type testChain struct {
+	mu     sync.Mutex
+	blocks []coin.Block
+	bits   uint32
+}
+
+func (c *testChain) BestHeight() int32 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return int32(len(c.blocks)) - 1
+}
+
+func (c *testChain) BlockAtHeight(h int32) (coin.Block, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if h < 0 || int(h) >= len(c.blocks) {
+		return coin.Block{}, fmt.Errorf("no block at %d", h)
+	}
+	return c.blocks[h], nil
+}
+
+func (c *testChain) NextTarget(bool) uint32 { return c.bits }
+
+func (c *testChain) SubmitBlock(b coin.Block) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.blocks = append(c.blocks, b)
+	return nil
+}
+
+func (c *testChain) StakeModifier(string) (uint64, error) { return 0x1234, nil }
+
+// add appends a block with txs at time t.
+func (c *testChain) add(t uint32, txs ...coin.Transaction) {
+	prev := strings.Repeat("0", 64)
+	if len(c.blocks) > 0 {
+		prev = c.blocks[len(c.blocks)-1].Header.Hash()
+	}
+	b := coin.Block{Header: coin.BlockHeader{Version: coin.CurrentBlockVersion, PrevHash: prev, Timestamp: t, Bits: c.bits}, Transactions: txs}
+	b.Header.MerkleRoot = b.BuildMerkleRoot()
+	c.blocks = append(c.blocks, b)
+}
+
+func TestStaker(t *testing.T) {
+	w, err := wallet.New(nil, wallet.Config{KeyPoolSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	address, err := w.NewAddress("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var a coin.Address
+	a.SetString(address)
+	script, _ := coin.PayToDestinationScript(a.Get())
+
+	const start = 1500000000
+	chain := &testChain{bits: 0x207fffff}
+	chain.add(start, newCoinbase(0, 0, 0, nil), coin.Transaction{Version: 1, Outputs: []coin.TxOut{{Value: 100 * coin.Coin, ScriptPubKey: script}}})
+	chain.add(start+200, newCoinbase(1, 0, 0, nil))
+	if err := w.Sync(chain); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.KeyStore().EncryptWallet("secret", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewStaker(w, chain)
+	s.Now = func() uint32 { return start + 60*60*4 }
+	if _, err := s.Mint(); err != wallet.ErrLocked {
+		t.Fatalf("minted with a locked wallet: %v", err)
+	}
+	if err := w.UnlockForStaking("secret", 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Mint(); err != ErrNoKernel {
+		t.Fatalf("staked a coin younger than the minimum age: %v", err)
+	}
+	if _, err := w.DumpPrivKey(address); err != wallet.ErrUnlockedForStaking {
+		t.Fatalf("key exported while unlocked for staking: %v", err)
+	}
+
+	s.Now = func() uint32 { return start + 10*24*60*60 }
+	b, err := s.Mint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chain.BestHeight() != 2 || !b.IsProofOfStake() || !b.CheckSignature() {
+		t.Fatalf("minted block not accepted or unsigned")
+	}
+	if b.Header.Timestamp > s.Now() || b.Header.Timestamp < s.Now()-coin.MaxStakeSearchInterval {
+		t.Fatalf("block time %d outside the search window", b.Header.Timestamp)
+	}
+	stake := b.Transactions[1]
+	if len(stake.Outputs) != 3 {
+		t.Fatalf("young stake not split: %d outputs", len(stake.Outputs))
+	}
+	reward := stake.ValueOut() - 100*coin.Coin
+	if reward <= 0 || reward > coin.ProofOfStakeReward(coin.CoinAge([]coin.StakeInput{{Value: 100 * coin.Coin, BlockTime: start, TxTime: start}}, b.Header.Timestamp)) {
+		t.Fatalf("stake reward %d", reward)
+	}
+
+	// The coin is spent by the new block, so there is nothing left to stake.
+	if err := w.BlockConnected(b, 2); err != nil {
+		t.Fatal(err)
+	}
+	s.Now = func() uint32 { return start + 10*24*60*60 + 30 }
+	if _, err := s.Mint(); err != ErrNoKernel {
+		t.Fatalf("restaked a spent coin: %v", err)
+	}
+}
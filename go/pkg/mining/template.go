@@ -0,0 +1,152 @@
+package mining
+
+import (
+	"errors"
+	"sort"
+
+	"pila/pkg/coin"
+	"pila/pkg/mempool"
+)
+
+// DefaultBlockMaxSize is the serialized size new block templates are
+// filled up to (-blockmaxsize).
+const DefaultBlockMaxSize = 250000
+
+// ErrPowCutoff is returned when the next block is past
+// coin.PowCutoffBlock and can no longer be mined by proof-of-work.
+var ErrPowCutoff = errors.New("proof-of-work ended at the cutoff block")
+
+// Template is a proof-of-work block ready for the nonce search together
+// with what getblocktemplate reports about its transactions.
+type Template struct {
+	Block  coin.Block
+	Height int32
+	// Fees holds the fee of every transaction after the coinbase.
+	Fees []int64
+	// Depends lists for every transaction after the coinbase the block
+	// positions (1 based, as getblocktemplate counts) of the earlier
+	// transactions it spends.
+	Depends [][]int
+	// CoinbaseValue is the subsidy the coinbase may claim. Fees are
+	// destroyed and not part of it.
+	CoinbaseValue int64
+	// PoolUpdated is the mempool update counter the template was built at.
+	PoolUpdated uint32
+}
+
+// NewBlockTemplate builds a proof-of-work block on the tip of chain paying
+// the subsidy to script (block::create_new). Pool transactions are taken
+// by fee rate as long as the block stays under maxSize, each after the
+// pooled transactions it depends on. pool may be nil for an empty block.
+func NewBlockTemplate(chain Chain, pool *mempool.Pool, script []byte, maxSize int) (*Template, error) {
+	best := chain.BestHeight()
+	height := best + 1
+	if height > coin.PowCutoffBlock {
+		return nil, ErrPowCutoff
+	}
+	prevHash := ""
+	var prevTime uint32
+	if best >= 0 {
+		prev, err := chain.BlockAtHeight(best)
+		if err != nil {
+			return nil, err
+		}
+		prevHash, prevTime = prev.Header.Hash(), prev.Header.Timestamp
+	}
+	value := coin.ProofOfWorkReward(height)
+	t := &Template{
+		Height:        height,
+		CoinbaseValue: value,
+		Block: coin.Block{
+			Header: coin.BlockHeader{
+				Version:  coin.CurrentBlockVersion,
+				PrevHash: prevHash,
+				Bits:     chain.NextTarget(false),
+			},
+			Transactions: []coin.Transaction{newCoinbase(height, 0, value, script)},
+		},
+	}
+	if now := uint32(coin.InstanceTime().GetAdjusted()); now > prevTime {
+		t.Block.Header.Timestamp = now
+	} else {
+		t.Block.Header.Timestamp = prevTime + 1
+	}
+	if pool != nil {
+		t.PoolUpdated = pool.Updated()
+		t.addTransactions(pool.Descs(), maxSize)
+	}
+	t.Block.Header.MerkleRoot = t.Block.BuildMerkleRoot()
+	return t, nil
+}
+
+// addTransactions fills the template from descs, highest fee rate first.
+// A transaction spending a pooled parent waits until the parent is in.
+func (t *Template) addTransactions(descs []*mempool.TxDesc, maxSize int) {
+	sort.Slice(descs, func(i, j int) bool {
+		if descs[i].FeeRate != descs[j].FeeRate {
+			return descs[i].FeeRate > descs[j].FeeRate
+		}
+		return descs[i].Hash < descs[j].Hash
+	})
+	pooled := make(map[string]bool, len(descs))
+	for _, d := range descs {
+		pooled[d.Hash] = true
+	}
+	size := 1000
+	for _, tx := range t.Block.Transactions {
+		size += tx.SerializeSize()
+	}
+	position := make(map[string]int)
+	for added := true; added; {
+		added = false
+		for _, d := range descs {
+			if _, ok := position[d.Hash]; ok || d.Tx.IsCoinBase() || d.Tx.IsCoinStake() {
+				continue
+			}
+			if size+d.Size >= maxSize {
+				continue
+			}
+			var depends []int
+			ready := true
+			for _, in := range d.Tx.Inputs {
+				if !pooled[in.PreviousOut.Hash] {
+					continue
+				}
+				n, ok := position[in.PreviousOut.Hash]
+				if !ok {
+					ready = false
+					break
+				}
+				depends = append(depends, n)
+			}
+			if !ready {
+				continue
+			}
+			sort.Ints(depends)
+			position[d.Hash] = len(t.Block.Transactions)
+			t.Block.Transactions = append(t.Block.Transactions, d.Tx)
+			t.Fees = append(t.Fees, d.Fee)
+			t.Depends = append(t.Depends, uniqueInts(depends))
+			size += d.Size
+			added = true
+		}
+	}
+}
+
+// uniqueInts drops repeated values from a sorted slice.
+func uniqueInts(s []int) []int {
+	out := s[:0]
+	for i, v := range s {
+		if i == 0 || v != s[i-1] {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// setExtraNonce rewrites the coinbase script with extraNonce and updates
+// the merkle root (increment_extra_nonce).
+func (t *Template) setExtraNonce(extraNonce uint32) {
+	t.Block.Transactions[0].Inputs[0].ScriptSig = coinbaseScript(t.Height, extraNonce)
+	t.Block.Header.MerkleRoot = t.Block.BuildMerkleRoot()
+}
@@ -0,0 +1,345 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"pila/pkg/coin"
+	"pila/pkg/coin/keys"
+	"pila/pkg/database"
+)
+
+// ErrAccountInsufficientFunds is returned when a send or move exceeds the
+// balance of the debited account.
+var ErrAccountInsufficientFunds = errors.New("account has insufficient funds")
+
+// AccountingEntry is an internal transfer between accounts that moves no
+// coins on the chain (accounting_entry).
+type AccountingEntry struct {
+	Account string
+	// CreditDebit is positive for a credit to Account.
+	CreditDebit  int64
+	Time         int64
+	OtherAccount string
+	Comment      string
+	OrderPos     int64
+	EntryNumber  uint64
+}
+
+// loadAccounts reads the address book and accounting entries and sets the
+// order position and entry counters. The caller must own w exclusively.
+func (w *Wallet) loadAccounts() error {
+	for _, wtx := range w.txs {
+		if wtx.OrderPos >= w.orderPosNext {
+			w.orderPosNext = wtx.OrderPos + 1
+		}
+	}
+	if w.db == nil {
+		return nil
+	}
+	return w.db.View(func(tx *DBTx) error {
+		err := tx.ForEachName(func(address, label string) error {
+			w.labels[address] = label
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return tx.ForEachAccountingEntry(func(e AccountingEntry) error {
+			w.entries = append(w.entries, e)
+			if e.OrderPos >= w.orderPosNext {
+				w.orderPosNext = e.OrderPos + 1
+			}
+			if e.EntryNumber >= w.entryNext {
+				w.entryNext = e.EntryNumber + 1
+			}
+			return nil
+		})
+	})
+}
+
+// nextOrderPos returns the order position of a new transaction or entry.
+// The caller must hold w.mu.
+func (w *Wallet) nextOrderPos() int64 {
+	n := w.orderPosNext
+	w.orderPosNext++
+	return n
+}
+
+// scriptAddress returns the address paid by script, or "" for scripts
+// without one.
+func scriptAddress(script []byte) string {
+	dest, ok := coin.ExtractDestination(script)
+	if !ok {
+		return ""
+	}
+	var a coin.Address
+	if !a.SetDestinationTx(dest) {
+		return ""
+	}
+	return a.String()
+}
+
+// SetLabel assigns address to account label (setaccount). Addresses of
+// the wallet without a label belong to the default account "".
+func (w *Wallet) SetLabel(address, label string) error {
+	var a coin.Address
+	if !a.SetString(address) || !a.IsValid() {
+		return fmt.Errorf("invalid address %q", address)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.update(func(tx *DBTx) error { return tx.WriteName(address, label) }); err != nil {
+		return err
+	}
+	w.labels[address] = label
+	return nil
+}
+
+// Label returns the account of address (getaccount).
+func (w *Wallet) Label(address string) string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.labels[address]
+}
+
+// labelOf returns the label of address and whether it is in the address book.
+func (w *Wallet) labelOf(address string) (string, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	label, ok := w.labels[address]
+	return label, ok
+}
+
+// AddressesByLabel returns the addresses of account label, sorted
+// (getaddressesbyaccount).
+func (w *Wallet) AddressesByLabel(label string) []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var out []string
+	for address, l := range w.labels {
+		if l == label {
+			out = append(out, address)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// NewAddress takes a key from the pool and files its address under label
+// (getnewaddress).
+func (w *Wallet) NewAddress(label string) (string, error) {
+	pub, err := w.pool.GetKey()
+	if err != nil {
+		return "", err
+	}
+	address := pub.Address().String()
+	if err := w.SetLabel(address, label); err != nil {
+		return "", err
+	}
+	return address, nil
+}
+
+// AccountAddress returns the receiving address of account, replacing it
+// with a new key once it has received coins (getaccountaddress).
+func (w *Wallet) AccountAddress(account string) (string, error) {
+	var raw []byte
+	if w.db != nil {
+		err := w.db.View(func(tx *DBTx) error {
+			var err error
+			raw, err = tx.ReadAccount(account)
+			return err
+		})
+		if err != nil && err != database.ErrNotFound {
+			return "", err
+		}
+	}
+	if len(raw) > 0 {
+		pub, err := keys.ParsePublicKey(raw)
+		if err != nil {
+			return "", err
+		}
+		script := coin.PayToPubKeyHashScript(pub.ID())
+		if !w.scriptUsed(script) {
+			return pub.Address().String(), nil
+		}
+	}
+	pub, err := w.pool.GetKey()
+	if err != nil {
+		return "", err
+	}
+	address := pub.Address().String()
+	if err := w.SetLabel(address, account); err != nil {
+		return "", err
+	}
+	err = w.update(func(tx *DBTx) error { return tx.WriteAccount(account, pub.Bytes()) })
+	return address, err
+}
+
+// scriptUsed reports whether a wallet transaction pays to script.
+func (w *Wallet) scriptUsed(script []byte) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, wtx := range w.txs {
+		for _, out := range wtx.Tx.Outputs {
+			if string(out.ScriptPubKey) == string(script) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Move transfers amount from one account to another without a transaction
+// (move). Both entries are written atomically.
+func (w *Wallet) Move(from, to string, amount int64, comment string) error {
+	if amount <= 0 || !coin.MoneyRange(amount) {
+		return errors.New("invalid amount")
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	now := time.Now().Unix()
+	debit := AccountingEntry{
+		Account:      from,
+		CreditDebit:  -amount,
+		Time:         now,
+		OtherAccount: to,
+		Comment:      comment,
+		OrderPos:     w.orderPosNext,
+		EntryNumber:  w.entryNext,
+	}
+	credit := debit
+	credit.Account, credit.OtherAccount = to, from
+	credit.CreditDebit = amount
+	credit.OrderPos++
+	credit.EntryNumber++
+	err := w.update(func(tx *DBTx) error {
+		if err := tx.WriteAccountingEntry(debit); err != nil {
+			return err
+		}
+		return tx.WriteAccountingEntry(credit)
+	})
+	if err != nil {
+		return err
+	}
+	w.orderPosNext += 2
+	w.entryNext += 2
+	w.entries = append(w.entries, debit, credit)
+	return nil
+}
+
+// AccountingEntries returns the entries of account in order, or of every
+// account for "*".
+func (w *Wallet) AccountingEntries(account string) []AccountingEntry {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var out []AccountingEntry
+	for _, e := range w.entries {
+		if account == "*" || e.Account == account {
+			out = append(out, e)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].OrderPos < out[j].OrderPos })
+	return out
+}
+
+// isChange reports whether out returns funds to the wallet: it is ours
+// and its address is not in the address book. The caller must hold w.mu.
+func (w *Wallet) isChange(out coin.TxOut) bool {
+	if !w.IsMine(out.ScriptPubKey) {
+		return false
+	}
+	_, labeled := w.labels[scriptAddress(out.ScriptPubKey)]
+	return !labeled
+}
+
+// accountAmounts splits what wtx means for account
+// (get_account_amounts). The caller must hold w.mu.
+func (w *Wallet) accountAmounts(wtx *WalletTx, account string) (generated, received, sent, fee int64) {
+	tx := wtx.Tx
+	if tx.IsCoinBase() || tx.IsCoinStake() {
+		if w.depth(wtx) > 0 && w.blocksToMaturity(wtx) == 0 && (account == "" || account == wtx.FromAccount) {
+			generated = w.credit(tx, MineSpendable) - w.debit(tx, MineSpendable)
+		}
+		return
+	}
+	debit := w.debit(tx, MineSpendable)
+	if debit > 0 && account == wtx.FromAccount {
+		fee = debit - tx.ValueOut()
+	}
+	for _, out := range tx.Outputs {
+		if debit > 0 && w.isChange(out) {
+			continue
+		}
+		if debit > 0 && account == wtx.FromAccount {
+			sent += out.Value
+		}
+		if !w.IsMine(out.ScriptPubKey) {
+			continue
+		}
+		if label, ok := w.labels[scriptAddress(out.ScriptPubKey)]; ok {
+			if label == account {
+				received += out.Value
+			}
+		} else if account == "" {
+			received += out.Value
+		}
+	}
+	return
+}
+
+// accountBalance is AccountBalance with w.mu held.
+func (w *Wallet) accountBalance(account string, minConf int32) int64 {
+	var balance int64
+	for _, wtx := range w.txs {
+		generated, received, sent, fee := w.accountAmounts(wtx, account)
+		if received != 0 && w.depth(wtx) >= minConf {
+			balance += received
+		}
+		balance += generated - sent - fee
+	}
+	for _, e := range w.entries {
+		if e.Account == account {
+			balance += e.CreditDebit
+		}
+	}
+	return balance
+}
+
+// AccountBalance returns the balance of account, counting received coins
+// with at least minConf confirmations (getbalance <account>).
+func (w *Wallet) AccountBalance(account string, minConf int32) int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.accountBalance(account, minConf)
+}
+
+// Accounts returns the balance of every account (listaccounts): those
+// labelling wallet addresses, those used by sends or moves and the default
+// account.
+func (w *Wallet) Accounts(minConf int32) map[string]int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	names := map[string]bool{"": true}
+	for address, label := range w.labels {
+		var a coin.Address
+		if a.SetString(address) {
+			if script, err := coin.PayToDestinationScript(a.Get()); err == nil && w.IsMine(script) {
+				names[label] = true
+			}
+		}
+	}
+	for _, wtx := range w.txs {
+		names[wtx.FromAccount] = true
+	}
+	for _, e := range w.entries {
+		names[e.Account] = true
+	}
+	balances := make(map[string]int64, len(names))
+	for name := range names {
+		balances[name] = w.accountBalance(name, minConf)
+	}
+	return balances
+}
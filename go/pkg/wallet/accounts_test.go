@@ -0,0 +1,71 @@
+package wallet
+
+import (
+	"testing"
+
+	"pila/pkg/coin"
+	"pila/pkg/database"
+)
+
+func TestAccountBalances(t *testing.T) {
+	db, _ := NewDB(database.NewMemStore())
+	cfg := DefaultConfig()
+	cfg.KeyPoolSize = 2
+	w, err := New(db, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	savings, err := w.NewAddress("savings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Label(savings) != "savings" || len(w.AddressesByLabel("savings")) != 1 {
+		t.Fatalf("label not set")
+	}
+	primary, _ := w.AccountAddress("")
+	if again, _ := w.AccountAddress(""); again != primary {
+		t.Fatalf("unused account address changed")
+	}
+
+	pay := func(address string, value int64, version uint32) coin.Transaction {
+		var a coin.Address
+		a.SetString(address)
+		script, _ := coin.PayToDestinationScript(a.Get())
+		return coin.Transaction{Version: version, Outputs: []coin.TxOut{{Value: value, ScriptPubKey: script}}}
+	}
+	blk := coin.Block{Transactions: []coin.Transaction{pay(savings, 7*coin.Coin, 1), pay(primary, 2*coin.Coin, 2)}}
+	if err := w.BlockConnected(blk, 0); err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := w.AccountAddress(""); again == primary {
+		t.Fatalf("used account address not replaced")
+	}
+	if n := w.AccountBalance("savings", 1); n != 7*coin.Coin {
+		t.Fatalf("savings balance %d", n)
+	}
+
+	if err := w.Move("savings", "ops", 3*coin.Coin, "payroll"); err != nil {
+		t.Fatal(err)
+	}
+	accounts := w.Accounts(1)
+	if accounts["savings"] != 4*coin.Coin || accounts["ops"] != 3*coin.Coin || accounts[""] != 2*coin.Coin {
+		t.Fatalf("accounts %v", accounts)
+	}
+	_, addr := testAddress(t)
+	_, err = w.BuildTransaction([]Recipient{{Address: addr, Amount: 4 * coin.Coin}}, BuildOptions{FromAccount: "ops"})
+	if err != ErrAccountInsufficientFunds {
+		t.Fatalf("expected insufficient account funds, got %v", err)
+	}
+
+	// Labels and entries survive a reload.
+	reloaded, err := New(db, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := reloaded.AccountBalance("ops", 1); n != 3*coin.Coin {
+		t.Fatalf("reloaded ops balance %d", n)
+	}
+	if entries := reloaded.AccountingEntries("*"); len(entries) != 2 || entries[0].Comment != "payroll" {
+		t.Fatalf("entries %+v", entries)
+	}
+}
@@ -0,0 +1,163 @@
+package wallet
+
+import "pila/pkg/coin"
+
+// Balance splits the wallet funds the way getinfo and getbalance report
+// them.
+type Balance struct {
+	// Confirmed is spendable: mature outputs of confirmed transactions and
+	// of unconfirmed ones the wallet sent itself.
+	Confirmed int64
+	// Unconfirmed is received in transactions not yet trusted.
+	Unconfirmed int64
+	// Immature is mined in coinbase transactions not yet mature.
+	Immature int64
+	// Stake is locked in coinstake transactions not yet mature.
+	Stake int64
+}
+
+// credit returns the value of the outputs of tx paying to the wallet as
+// selected by filter. The caller must hold w.mu.
+func (w *Wallet) credit(tx coin.Transaction, filter MineFilter) int64 {
+	var n int64
+	for _, out := range tx.Outputs {
+		if w.isMineFilter(out.ScriptPubKey, filter) {
+			n += out.Value
+		}
+	}
+	return n
+}
+
+// debit returns the value of the wallet outputs selected by filter spent
+// by tx. The caller must hold w.mu.
+func (w *Wallet) debit(tx coin.Transaction, filter MineFilter) int64 {
+	var n int64
+	for _, in := range tx.Inputs {
+		prev, ok := w.txs[in.PreviousOut.Hash]
+		if !ok || int(in.PreviousOut.Index) >= len(prev.Tx.Outputs) {
+			continue
+		}
+		out := prev.Tx.Outputs[in.PreviousOut.Index]
+		if w.isMineFilter(out.ScriptPubKey, filter) {
+			n += out.Value
+		}
+	}
+	return n
+}
+
+// availableCredit returns the value of the unspent wallet outputs of wtx
+// selected by filter. The caller must hold w.mu.
+func (w *Wallet) availableCredit(wtx *WalletTx, filter MineFilter) int64 {
+	hash := wtx.Hash()
+	var n int64
+	for i, out := range wtx.Tx.Outputs {
+		if _, spent := w.spent[coin.PointOut{Hash: hash, Index: uint32(i)}]; spent {
+			continue
+		}
+		if w.isMineFilter(out.ScriptPubKey, filter) {
+			n += out.Value
+		}
+	}
+	return n
+}
+
+// blocksToMaturity is Coin.BlocksToMaturity for a whole transaction. The
+// caller must hold w.mu.
+func (w *Wallet) blocksToMaturity(wtx *WalletTx) int32 {
+	c := Coin{Depth: w.depth(wtx), CoinBase: wtx.Tx.IsCoinBase(), CoinStake: wtx.Tx.IsCoinStake()}
+	return c.BlocksToMaturity()
+}
+
+// isTrusted reports whether the funds of wtx can be spent: it is confirmed,
+// or it is an unconfirmed transaction of the wallet spending only trusted
+// wallet outputs selected by filter. The caller must hold w.mu.
+func (w *Wallet) isTrusted(wtx *WalletTx, filter MineFilter, seen map[string]bool) bool {
+	if w.depth(wtx) > 0 {
+		return true
+	}
+	if wtx.Tx.IsCoinBase() || wtx.Tx.IsCoinStake() || len(wtx.Tx.Inputs) == 0 {
+		return false
+	}
+	hash := wtx.Hash()
+	if seen[hash] {
+		return false
+	}
+	seen[hash] = true
+	for _, in := range wtx.Tx.Inputs {
+		prev, ok := w.txs[in.PreviousOut.Hash]
+		if !ok || int(in.PreviousOut.Index) >= len(prev.Tx.Outputs) {
+			return false
+		}
+		if !w.isMineFilter(prev.Tx.Outputs[in.PreviousOut.Index].ScriptPubKey, filter) || !w.isTrusted(prev, filter, seen) {
+			return false
+		}
+	}
+	return true
+}
+
+// Credit returns the value tx pays to the wallet.
+func (w *Wallet) Credit(tx coin.Transaction) int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.credit(tx, MineSpendable)
+}
+
+// Debit returns the value of wallet outputs tx spends.
+func (w *Wallet) Debit(tx coin.Transaction) int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.debit(tx, MineSpendable)
+}
+
+// Confirmations returns the depth of the wallet transaction with hash, or
+// -1 when the wallet does not know it.
+func (w *Wallet) Confirmations(hash string) int32 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	wtx, ok := w.txs[hash]
+	if !ok {
+		return -1
+	}
+	return w.depth(wtx)
+}
+
+// Balance returns the balances of the funds the wallet can spend.
+func (w *Wallet) Balance() Balance {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.balance(MineSpendable)
+}
+
+// WatchOnlyBalance returns the balances of the watch-only funds.
+func (w *Wallet) WatchOnlyBalance() Balance {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.balance(MineWatchOnly)
+}
+
+// balance returns the balances of the outputs selected by filter. The
+// caller must hold w.mu.
+func (w *Wallet) balance(filter MineFilter) Balance {
+	var b Balance
+	for _, wtx := range w.txs {
+		if wtx.Tx.IsCoinBase() || wtx.Tx.IsCoinStake() {
+			if w.depth(wtx) == 0 {
+				continue
+			}
+			if w.blocksToMaturity(wtx) > 0 {
+				if wtx.Tx.IsCoinBase() {
+					b.Immature += w.credit(wtx.Tx, filter)
+				} else {
+					b.Stake += w.credit(wtx.Tx, filter)
+				}
+				continue
+			}
+		}
+		if w.isTrusted(wtx, filter, make(map[string]bool)) {
+			b.Confirmed += w.availableCredit(wtx, filter)
+		} else {
+			b.Unconfirmed += w.availableCredit(wtx, filter)
+		}
+	}
+	return b
+}
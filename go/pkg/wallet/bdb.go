@@ -0,0 +1,239 @@
+package wallet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Berkeley DB btree file layout, as written by BDB 4.x to 6.x.
+const (
+	bdbBtreeMagic = 0x053162
+
+	bdbPageHeaderSize = 26
+
+	// Page types.
+	bdbPageInternal  = 3
+	bdbPageLeaf      = 5
+	bdbPageOverflow  = 7
+	bdbPageBtreeMeta = 9
+
+	// Item types; bdbItemDeleted is a flag.
+	bdbItemKeyData  = 1
+	bdbItemOverflow = 3
+	bdbItemDeleted  = 0x80
+
+	// Offsets into the btree metadata page.
+	bdbMetaMagic    = 12
+	bdbMetaPageSize = 20
+	bdbMetaEncrypt  = 24
+	bdbMetaType     = 25
+	bdbMetaLastPage = 32
+	bdbMetaRoot     = 88
+
+	// bdbMainDatabase is the sub-database holding the wallet records.
+	bdbMainDatabase = "main"
+)
+
+// bdbRecord is a key/value pair read from a btree leaf.
+type bdbRecord struct {
+	Key, Value []byte
+}
+
+// bdbFile reads the btree databases of a Berkeley DB file held in memory.
+type bdbFile struct {
+	data     []byte
+	order    binary.ByteOrder
+	pageSize int
+	lastPage uint32
+}
+
+func openBDB(data []byte) (*bdbFile, error) {
+	if len(data) < 512 {
+		return nil, errors.New("bdb: file too short")
+	}
+	f := &bdbFile{data: data}
+	switch {
+	case binary.LittleEndian.Uint32(data[bdbMetaMagic:]) == bdbBtreeMagic:
+		f.order = binary.LittleEndian
+	case binary.BigEndian.Uint32(data[bdbMetaMagic:]) == bdbBtreeMagic:
+		f.order = binary.BigEndian
+	default:
+		return nil, errors.New("bdb: not a btree database")
+	}
+	if data[bdbMetaEncrypt] != 0 {
+		return nil, errors.New("bdb: encrypted databases are not supported")
+	}
+	f.pageSize = int(f.order.Uint32(data[bdbMetaPageSize:]))
+	if f.pageSize < 512 || f.pageSize > 65536 || f.pageSize&(f.pageSize-1) != 0 {
+		return nil, fmt.Errorf("bdb: invalid page size %d", f.pageSize)
+	}
+	f.lastPage = f.order.Uint32(data[bdbMetaLastPage:])
+	if uint64(f.lastPage+1)*uint64(f.pageSize) > uint64(len(data)) {
+		return nil, errors.New("bdb: file truncated")
+	}
+	return f, nil
+}
+
+func (f *bdbFile) page(n uint32) ([]byte, error) {
+	if n > f.lastPage {
+		return nil, fmt.Errorf("bdb: page %d out of range", n)
+	}
+	off := int(n) * f.pageSize
+	return f.data[off : off+f.pageSize], nil
+}
+
+// walletRecords returns the records of the wallet database. Files created
+// with a database name keep them in the "main" sub-database, listed in the
+// master database of page 0.
+func (f *bdbFile) walletRecords() ([]bdbRecord, error) {
+	master, err := f.readTree(0)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range master {
+		if string(r.Key) != bdbMainDatabase || len(r.Value) != 4 {
+			continue
+		}
+		// The sub-database page number is stored in network order by
+		// BDB, but accept either order as long as it leads to a meta page.
+		for _, order := range []binary.ByteOrder{binary.BigEndian, binary.LittleEndian} {
+			n := order.Uint32(r.Value)
+			if p, err := f.page(n); err == nil && n != 0 && p[bdbMetaType] == bdbPageBtreeMeta {
+				return f.readTree(n)
+			}
+		}
+		return nil, errors.New("bdb: main database not found")
+	}
+	return master, nil
+}
+
+// readTree returns every live record of the btree whose meta page is meta.
+func (f *bdbFile) readTree(meta uint32) ([]bdbRecord, error) {
+	p, err := f.page(meta)
+	if err != nil {
+		return nil, err
+	}
+	if p[bdbMetaType] != bdbPageBtreeMeta || f.order.Uint32(p[bdbMetaMagic:]) != bdbBtreeMagic {
+		return nil, fmt.Errorf("bdb: page %d is not a btree meta page", meta)
+	}
+	var out []bdbRecord
+	seen := make(map[uint32]bool)
+	err = f.walk(f.order.Uint32(p[bdbMetaRoot:]), seen, &out)
+	return out, err
+}
+
+func (f *bdbFile) walk(n uint32, seen map[uint32]bool, out *[]bdbRecord) error {
+	if seen[n] {
+		return fmt.Errorf("bdb: page %d referenced twice", n)
+	}
+	seen[n] = true
+	p, err := f.page(n)
+	if err != nil {
+		return err
+	}
+	entries := int(f.order.Uint16(p[20:]))
+	if bdbPageHeaderSize+2*entries > len(p) {
+		return fmt.Errorf("bdb: page %d has too many entries", n)
+	}
+	switch p[25] {
+	case bdbPageInternal:
+		for i := 0; i < entries; i++ {
+			item, err := f.item(p, i)
+			if err != nil {
+				return err
+			}
+			if len(item) < 12 {
+				return fmt.Errorf("bdb: short internal item on page %d", n)
+			}
+			if err := f.walk(f.order.Uint32(item[4:]), seen, out); err != nil {
+				return err
+			}
+		}
+	case bdbPageLeaf:
+		if entries%2 != 0 {
+			return fmt.Errorf("bdb: leaf page %d has an odd number of items", n)
+		}
+		for i := 0; i < entries; i += 2 {
+			key, keyDeleted, err := f.value(p, i)
+			if err != nil {
+				return err
+			}
+			val, valDeleted, err := f.value(p, i+1)
+			if err != nil {
+				return err
+			}
+			if !keyDeleted && !valDeleted {
+				*out = append(*out, bdbRecord{Key: key, Value: val})
+			}
+		}
+	default:
+		return fmt.Errorf("bdb: unexpected page type %d on page %d", p[25], n)
+	}
+	return nil
+}
+
+// item returns the raw bytes of item i of page p, starting at its header.
+func (f *bdbFile) item(p []byte, i int) ([]byte, error) {
+	off := int(f.order.Uint16(p[bdbPageHeaderSize+2*i:]))
+	if off < bdbPageHeaderSize || off+3 > len(p) {
+		return nil, fmt.Errorf("bdb: item offset %d out of range", off)
+	}
+	return p[off:], nil
+}
+
+// value decodes leaf item i, following overflow pages.
+func (f *bdbFile) value(p []byte, i int) (data []byte, deleted bool, err error) {
+	item, err := f.item(p, i)
+	if err != nil {
+		return nil, false, err
+	}
+	deleted = item[2]&bdbItemDeleted != 0
+	switch item[2] &^ bdbItemDeleted {
+	case bdbItemKeyData:
+		n := int(f.order.Uint16(item))
+		if 3+n > len(item) {
+			return nil, false, errors.New("bdb: item exceeds page")
+		}
+		return append([]byte(nil), item[3:3+n]...), deleted, nil
+	case bdbItemOverflow:
+		if len(item) < 12 {
+			return nil, false, errors.New("bdb: short overflow item")
+		}
+		data, err := f.overflow(f.order.Uint32(item[4:]), f.order.Uint32(item[8:]))
+		return data, deleted, err
+	default:
+		return nil, false, fmt.Errorf("bdb: unsupported item type %d", item[2])
+	}
+}
+
+// overflow reads a value of total bytes stored on a chain of overflow
+// pages starting at n.
+func (f *bdbFile) overflow(n, total uint32) ([]byte, error) {
+	if total > uint32(len(f.data)) {
+		return nil, errors.New("bdb: overflow item too large")
+	}
+	out := make([]byte, 0, total)
+	for steps := uint32(0); uint32(len(out)) < total; steps++ {
+		if steps > f.lastPage {
+			return nil, errors.New("bdb: overflow chain loops")
+		}
+		p, err := f.page(n)
+		if err != nil {
+			return nil, err
+		}
+		if p[25] != bdbPageOverflow {
+			return nil, fmt.Errorf("bdb: page %d is not an overflow page", n)
+		}
+		size := int(f.order.Uint16(p[22:]))
+		if bdbPageHeaderSize+size > len(p) {
+			return nil, errors.New("bdb: overflow page length out of range")
+		}
+		out = append(out, p[bdbPageHeaderSize:bdbPageHeaderSize+size]...)
+		n = f.order.Uint32(p[16:])
+	}
+	if uint32(len(out)) != total {
+		return nil, errors.New("bdb: overflow length mismatch")
+	}
+	return out, nil
+}
@@ -0,0 +1,301 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"pila/pkg/coin"
+)
+
+const (
+	// DefaultConfirmTarget is the confirmation target used for the fee
+	// estimate when a send gives no fee rate.
+	DefaultConfirmTarget = 6
+	// maxFeeRounds bounds the create, sign and measure loop.
+	maxFeeRounds = 20
+)
+
+var (
+	// ErrAmountTooSmall is returned for payments below
+	// coin.MinTxOutAmount.
+	ErrAmountTooSmall = errors.New("send amount too small")
+	// ErrTxTooLarge is returned when the signed transaction would not be
+	// relayed.
+	ErrTxTooLarge = errors.New("transaction too large")
+	// ErrCoinsPending is returned when the selected coins are already
+	// spent by another transaction awaiting Commit or Cancel.
+	ErrCoinsPending = errors.New("coins are spent by a pending transaction")
+)
+
+// Recipient is a payment to an address.
+type Recipient struct {
+	Address string
+	Amount  int64
+}
+
+// script returns the output script paying r.
+func (r Recipient) script() ([]byte, error) {
+	var a coin.Address
+	if !a.SetString(r.Address) || !a.IsValid() {
+		return nil, fmt.Errorf("invalid address %q", r.Address)
+	}
+	if r.Amount <= 0 || !coin.MoneyRange(r.Amount) {
+		return nil, fmt.Errorf("invalid amount for %s", r.Address)
+	}
+	if r.Amount < coin.MinTxOutAmount {
+		return nil, ErrAmountTooSmall
+	}
+	return coin.PayToDestinationScript(a.Get())
+}
+
+// BuildOptions configures BuildTransaction.
+type BuildOptions struct {
+	// FromAccount is the account debited (sendfrom).
+	FromAccount string
+	// MinConf is the confirmation depth of spent outputs; zero means
+	// DefaultMinConf.
+	MinConf int32
+	// FeeRate is the fee per 1000 bytes. Zero uses the fee estimator for
+	// ConfirmTarget, or coin.MinTxFee without one.
+	FeeRate       int64
+	ConfirmTarget int
+	Strategy      Strategy
+	Control       *CoinControl
+}
+
+// PendingTx is a signed transaction not yet sent. Its inputs are locked
+// against other builds until it is finished with Commit or Cancel.
+type PendingTx struct {
+	Tx  *WalletTx
+	Fee int64
+
+	wallet    *Wallet
+	change    *ReservedKey
+	inputs    []coin.PointOut
+	committed bool
+}
+
+// Commit keeps the change key, records the transaction in the wallet and
+// only then broadcasts it (commit_transaction), so a sent transaction is
+// never missing from the wallet. If recording fails the change key returns
+// to the pool and the inputs are unlocked; if the broadcast fails the
+// record is removed again, freeing the inputs.
+func (p *PendingTx) Commit(broadcast func(coin.Transaction) error) error {
+	if p.change != nil {
+		if err := p.change.Keep(); err != nil {
+			p.Cancel()
+			return err
+		}
+	}
+	if err := p.wallet.AddTransaction(p.Tx); err != nil {
+		p.Cancel()
+		return err
+	}
+	p.committed = true
+	if err := broadcast(p.Tx.Tx); err != nil {
+		if rerr := p.wallet.removeTransaction(p.Tx.Hash()); rerr != nil {
+			return fmt.Errorf("%w; unsent transaction %s is still recorded: %v", err, p.Tx.Hash(), rerr)
+		}
+		return err
+	}
+	return nil
+}
+
+// Cancel abandons the transaction, returns the change key to the pool and
+// unlocks the inputs. It does nothing once the transaction was recorded.
+func (p *PendingTx) Cancel() {
+	if p.committed {
+		return
+	}
+	if p.change != nil {
+		p.change.Return()
+	}
+	p.wallet.releaseCoins(p.inputs)
+	p.inputs = nil
+}
+
+// feeRate returns the fee per 1000 bytes to pay.
+func (w *Wallet) feeRate(opts BuildOptions) int64 {
+	if opts.FeeRate > 0 {
+		return opts.FeeRate
+	}
+	w.mu.Lock()
+	fees := w.fees
+	w.mu.Unlock()
+	if fees == nil {
+		return coin.MinTxFee
+	}
+	target := opts.ConfirmTarget
+	if target <= 0 {
+		target = DefaultConfirmTarget
+	}
+	return fees.EstimateFeeOrMin(target)
+}
+
+// BuildTransaction creates and signs a transaction paying outputs from the
+// wallet (create_transaction), covering sendtoaddress, sendmany and
+// sendfrom. The fee is the larger of the fee rate applied to the signed
+// size and the relay minimum; change goes to a key pool address or the
+// coin control change address. Every input is verified before returning,
+// and the selected coins stay locked until Commit or Cancel.
+func (w *Wallet) BuildTransaction(outputs []Recipient, opts BuildOptions) (*PendingTx, error) {
+	outs, value, err := paymentOutputs(outputs)
+	if err != nil {
+		return nil, err
+	}
+	if opts.FromAccount != "" {
+		minConf := opts.MinConf
+		if minConf <= 0 {
+			minConf = DefaultMinConf
+		}
+		if value > w.AccountBalance(opts.FromAccount, minConf) {
+			return nil, ErrAccountInsufficientFunds
+		}
+	}
+	p := &PendingTx{wallet: w}
+	change := func() ([]byte, error) { return p.changeScript(opts.Control) }
+	sign := func(tx *coin.Transaction, s *Selection) error {
+		for i, c := range s.Coins {
+			if err := SignInput(w.keys, tx, i, c.Output.ScriptPubKey, coin.SigHashAll); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	tx, s, fee, err := w.createTransaction(outs, value, opts, w.Coins(), change, sign)
+	if err != nil {
+		p.Cancel()
+		return nil, err
+	}
+	for i, c := range s.Coins {
+		if err := VerifyInput(tx, i, c.Output.ScriptPubKey); err != nil {
+			p.Cancel()
+			return nil, fmt.Errorf("verify input %d: %v", i, err)
+		}
+	}
+	if s.Change == 0 && p.change != nil {
+		p.change.Return()
+		p.change = nil
+	}
+	inputs := make([]coin.PointOut, len(s.Coins))
+	for i, c := range s.Coins {
+		inputs[i] = c.Out
+	}
+	if err := w.reserveCoins(inputs); err != nil {
+		p.Cancel()
+		return nil, err
+	}
+	p.inputs = inputs
+	p.Fee = fee
+	p.Tx = &WalletTx{Tx: tx, TimeReceived: time.Now().Unix(), FromAccount: opts.FromAccount}
+	return p, nil
+}
+
+// paymentOutputs returns the outputs paying outputs and their total.
+func paymentOutputs(outputs []Recipient) ([]coin.TxOut, int64, error) {
+	if len(outputs) == 0 {
+		return nil, 0, errors.New("no outputs")
+	}
+	var value int64
+	var outs []coin.TxOut
+	for _, r := range outputs {
+		script, err := r.script()
+		if err != nil {
+			return nil, 0, err
+		}
+		value += r.Amount
+		if !coin.MoneyRange(value) {
+			return nil, 0, errors.New("amount out of range")
+		}
+		outs = append(outs, coin.TxOut{Value: r.Amount, ScriptPubKey: script})
+	}
+	return outs, value, nil
+}
+
+// createTransaction runs the select, sign and measure loop of
+// create_transaction over coins until the fee paid covers the fee required
+// for the signed size. change returns the change script and sign fills the
+// signature scripts. It returns the transaction, the selected coins and
+// the fee including dust.
+func (w *Wallet) createTransaction(outs []coin.TxOut, value int64, opts BuildOptions, coins []Coin,
+	change func() ([]byte, error), sign func(tx *coin.Transaction, s *Selection) error) (coin.Transaction, *Selection, int64, error) {
+	sel := SelectOptions{
+		Strategy: opts.Strategy,
+		MinConf:  opts.MinConf,
+		Locked:   w.IsLockedCoin,
+		Control:  opts.Control,
+	}
+	if sel.MinConf <= 0 {
+		sel.MinConf = DefaultMinConf
+	}
+	rate := w.feeRate(opts)
+
+	fee := coin.MinimumFee(0)
+	for round := 0; round < maxFeeRounds; round++ {
+		s, err := SelectCoins(coins, value+fee, sel)
+		if err != nil {
+			return coin.Transaction{}, nil, 0, err
+		}
+		tx := coin.Transaction{Version: 1, Outputs: append([]coin.TxOut(nil), outs...)}
+		if s.Change > 0 {
+			script, err := change()
+			if err != nil {
+				return coin.Transaction{}, nil, 0, err
+			}
+			// Insert the change at a random position so it cannot be told
+			// apart from the payments.
+			pos := int(coin.RandomUint32(uint32(len(tx.Outputs) + 1)))
+			tx.Outputs = append(tx.Outputs, coin.TxOut{})
+			copy(tx.Outputs[pos+1:], tx.Outputs[pos:])
+			tx.Outputs[pos] = coin.TxOut{Value: s.Change, ScriptPubKey: script}
+		}
+		for _, c := range s.Coins {
+			tx.Inputs = append(tx.Inputs, coin.TxIn{PreviousOut: c.Out, Sequence: 0xffffffff})
+		}
+		if err := sign(&tx, s); err != nil {
+			return coin.Transaction{}, nil, 0, err
+		}
+
+		size := tx.SerializeSize()
+		if size > coin.MaxTransactionSize/3 {
+			return coin.Transaction{}, nil, 0, ErrTxTooLarge
+		}
+		required := rate * int64(size) / 1000
+		if min := coin.MinimumFee(size); required < min {
+			required = min
+		}
+		if paid := fee + s.Dust; paid < required {
+			fee = required
+			continue
+		}
+		return tx, s, fee + s.Dust, nil
+	}
+	return coin.Transaction{}, nil, 0, errors.New("fee did not converge")
+}
+
+// changeScript returns the script receiving change, reserving a key pool
+// key the first time one is needed.
+func (p *PendingTx) changeScript(control *CoinControl) ([]byte, error) {
+	if control != nil && control.ChangeAddress != "" {
+		return changeAddressScript(control.ChangeAddress)
+	}
+	if p.change == nil {
+		r, err := p.wallet.pool.Reserve()
+		if err != nil {
+			return nil, err
+		}
+		p.change = r
+	}
+	return coin.PayToPubKeyHashScript(p.change.PubKey().ID()), nil
+}
+
+// changeAddressScript returns the script paying the coin control change
+// address.
+func changeAddressScript(address string) ([]byte, error) {
+	var a coin.Address
+	if !a.SetString(address) || !a.IsValid() {
+		return nil, fmt.Errorf("invalid change address %q", address)
+	}
+	return coin.PayToDestinationScript(a.Get())
+}
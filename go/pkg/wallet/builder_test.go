@@ -0,0 +1,182 @@
+package wallet
+
+import (
+	"errors"
+	"testing"
+
+	"pila/pkg/coin"
+	"pila/pkg/coin/keys"
+	"pila/pkg/database"
+)
+
+// fundedWallet returns an in-memory wallet holding one confirmed output of
+// value per entry of values.
+func fundedWallet(t *testing.T, values ...int64) *Wallet {
+	t.Helper()
+	cfg := DefaultConfig()
+	cfg.KeyPoolSize = 2
+	w, err := New(nil, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fundWallet(t, w, values...)
+	return w
+}
+
+// fundWallet adds one confirmed output of value per entry of values to w.
+func fundWallet(t *testing.T, w *Wallet, values ...int64) {
+	t.Helper()
+	for i, v := range values {
+		pub, err := w.KeyPool().GetKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		tx := coin.Transaction{
+			Version: uint32(i + 1),
+			Outputs: []coin.TxOut{{Value: v, ScriptPubKey: coin.PayToPubKeyHashScript(pub.ID())}},
+		}
+		if err := w.AddTransaction(&WalletTx{Tx: tx, BlockHash: "00", BlockHeight: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.bestHeight = 10
+}
+
+func testAddress(t *testing.T) (*keys.PrivateKey, string) {
+	t.Helper()
+	k, err := keys.NewPrivateKey(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k, k.PubKey().Address().String()
+}
+
+func TestBuildTransaction(t *testing.T) {
+	w := fundedWallet(t, 5*coin.Coin, 3*coin.Coin)
+	_, addr := testAddress(t)
+
+	p, err := w.BuildTransaction([]Recipient{{Address: addr, Amount: 6 * coin.Coin}}, BuildOptions{FeeRate: coin.MinTxFee})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := p.Tx.Tx
+	if len(tx.Inputs) != 2 || len(tx.Outputs) != 2 {
+		t.Fatalf("unexpected shape %d in %d out", len(tx.Inputs), len(tx.Outputs))
+	}
+	if in := 8 * coin.Coin; in-tx.ValueOut() != p.Fee || p.Fee < coin.MinimumFee(tx.SerializeSize()) {
+		t.Fatalf("fee %d does not match inputs minus outputs %d", p.Fee, in-tx.ValueOut())
+	}
+
+	// The transaction is recorded before it is broadcast, and a failed
+	// broadcast removes it again.
+	failed := errors.New("rejected")
+	err = p.Commit(func(coin.Transaction) error {
+		if _, ok := w.Transaction(p.Tx.Hash()); !ok {
+			t.Fatalf("broadcast before the transaction was recorded")
+		}
+		return failed
+	})
+	if err != failed {
+		t.Fatalf("expected broadcast error, got %v", err)
+	}
+	if _, ok := w.Transaction(p.Tx.Hash()); ok {
+		t.Fatalf("unsent transaction still recorded")
+	}
+
+	p, _ = w.BuildTransaction([]Recipient{{Address: addr, Amount: 6 * coin.Coin}}, BuildOptions{})
+	if err := p.Commit(func(coin.Transaction) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	// Only the unconfirmed change is left.
+	if _, err := w.BuildTransaction([]Recipient{{Address: addr, Amount: coin.Coin}}, BuildOptions{}); err != ErrInsufficientFunds {
+		t.Fatalf("expected insufficient funds, got %v", err)
+	}
+	if _, err := w.BuildTransaction([]Recipient{{Address: addr, Amount: 1}}, BuildOptions{}); err != ErrAmountTooSmall {
+		t.Fatalf("expected amount too small, got %v", err)
+	}
+}
+
+func TestSignRawTransactionOffline(t *testing.T) {
+	k, addr := testAddress(t)
+	prev := coin.PointOut{Hash: coin.Transaction{Version: 7}.Hash(), Index: 0}
+	prevScript := coin.PayToPubKeyHashScript(k.PubKey().ID())
+
+	tx, err := CreateRawTransaction([]coin.PointOut{prev}, []Recipient{{Address: addr, Amount: coin.Coin}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	scripts := map[coin.PointOut][]byte{prev: prevScript}
+	if done, err := SignRawTransaction(&tx, scripts, NewKeyList(), 0); err != nil || done {
+		t.Fatalf("signed without the key: %v %v", done, err)
+	}
+	if done, err := SignRawTransaction(&tx, scripts, NewKeyList(k), 0); err != nil || !done {
+		t.Fatalf("sign: %v %v", done, err)
+	}
+}
+
+func TestBuildTransactionLocksCoins(t *testing.T) {
+	w := fundedWallet(t, 5*coin.Coin, 3*coin.Coin)
+	_, addr := testAddress(t)
+
+	p, err := w.BuildTransaction([]Recipient{{Address: addr, Amount: 6 * coin.Coin}}, BuildOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Both coins stay locked until the first transaction is finished.
+	if _, err := w.BuildTransaction([]Recipient{{Address: addr, Amount: coin.Coin}}, BuildOptions{}); err != ErrInsufficientFunds {
+		t.Fatalf("expected insufficient funds, got %v", err)
+	}
+	p.Cancel()
+	if _, err := w.BuildTransaction([]Recipient{{Address: addr, Amount: coin.Coin}}, BuildOptions{}); err != nil {
+		t.Fatalf("cancel did not unlock the coins: %v", err)
+	}
+}
+
+// failingStore fails every batch write while fail is set.
+type failingStore struct {
+	*database.MemStore
+	fail bool
+}
+
+func (s *failingStore) Write(b *database.Batch) error {
+	if s.fail {
+		return errors.New("disk full")
+	}
+	return s.MemStore.Write(b)
+}
+
+func TestCommitNotRecorded(t *testing.T) {
+	store := &failingStore{MemStore: database.NewMemStore()}
+	db, err := NewDB(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := DefaultConfig()
+	cfg.KeyPoolSize = 2
+	w, err := New(db, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fundWallet(t, w, 5*coin.Coin)
+	_, addr := testAddress(t)
+	p, err := w.BuildTransaction([]Recipient{{Address: addr, Amount: coin.Coin}}, BuildOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A transaction the wallet cannot record is never sent.
+	store.fail = true
+	sent := false
+	if err := p.Commit(func(coin.Transaction) error { sent = true; return nil }); err == nil {
+		t.Fatal("commit succeeded without recording the transaction")
+	}
+	if sent {
+		t.Fatal("unrecorded transaction was broadcast")
+	}
+	if w.IsLockedCoin(p.Tx.Tx.Inputs[0].PreviousOut) {
+		t.Fatalf("input of an unsent transaction stayed locked")
+	}
+	if _, ok := w.Transaction(p.Tx.Hash()); ok {
+		t.Fatalf("failed commit recorded the transaction")
+	}
+}
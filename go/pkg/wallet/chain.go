@@ -0,0 +1,422 @@
+package wallet
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"pila/pkg/coin"
+	"pila/pkg/database"
+)
+
+const (
+	// bestHeightSetting records the height of the best block.
+	bestHeightSetting = "bestheight"
+	// rescanFromSetting records the birth time of the oldest key imported
+	// without a chain source; the next Sync rescans from it.
+	rescanFromSetting = "rescanfrom"
+	// rescanTimeMargin is subtracted from a key birth time before a rescan
+	// to allow for inaccurate block timestamps.
+	rescanTimeMargin = 2 * time.Hour
+)
+
+// ChainSource gives the wallet read access to the active chain for
+// rescans.
+type ChainSource interface {
+	BestHeight() int32
+	BlockAtHeight(height int32) (coin.Block, error)
+}
+
+// loadBestHeight restores the height the wallet is synced to. The caller
+// must own w exclusively.
+func (w *Wallet) loadBestHeight() error {
+	if w.db == nil {
+		return nil
+	}
+	return w.db.View(func(tx *DBTx) error {
+		val, err := tx.ReadSetting(bestHeightSetting)
+		if err == database.ErrNotFound {
+			w.bestHeight = -1
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(val) != 4 {
+			return errors.New("invalid best height record")
+		}
+		w.bestHeight = int32(binary.LittleEndian.Uint32(val))
+		return nil
+	})
+}
+
+// BestHeight returns the height of the last block the wallet has seen, -1
+// before the first one.
+func (w *Wallet) BestHeight() int32 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.bestHeight
+}
+
+// writeBestBlock records hash at height as the wallet tip.
+func writeBestBlock(tx *DBTx, hash string, height int32) error {
+	if err := tx.WriteBestBlock(BlockLocator{hash}); err != nil {
+		return err
+	}
+	return tx.WriteSetting(bestHeightSetting, encodeUint32(uint32(height)))
+}
+
+// isFromMe reports whether tx spends an output of the wallet, watch-only
+// outputs included. The caller must hold w.mu.
+func (w *Wallet) isFromMe(tx coin.Transaction) bool {
+	return w.debit(tx, MineAll) > 0
+}
+
+// isInvolving reports whether tx pays to or spends from the wallet or its
+// watch-only scripts. The caller must hold w.mu.
+func (w *Wallet) isInvolving(tx coin.Transaction) bool {
+	for _, out := range tx.Outputs {
+		if w.isMineFilter(out.ScriptPubKey, MineAll) {
+			return true
+		}
+	}
+	return !tx.IsCoinBase() && w.isFromMe(tx)
+}
+
+// syncTransaction records tx if it involves the wallet, confirmed in
+// blockHash at height or unconfirmed when blockHash is empty. Unconfirmed
+// wallet transactions spending the same outputs as a confirmed tx are
+// removed as conflicts. The caller must hold w.mu.
+func (w *Wallet) syncTransaction(dbtx *DBTx, tx coin.Transaction, blockHash string, height int32) error {
+	hash := tx.Hash()
+	if blockHash != "" && !tx.IsCoinBase() {
+		for _, in := range tx.Inputs {
+			spender, ok := w.spent[in.PreviousOut]
+			if !ok || spender == hash {
+				continue
+			}
+			if other := w.txs[spender]; other != nil && !other.IsConfirmed() {
+				if err := w.removeTx(dbtx, spender); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	wtx, ok := w.txs[hash]
+	if !ok {
+		if !w.isInvolving(tx) {
+			return nil
+		}
+		wtx = &WalletTx{Tx: tx, TimeReceived: time.Now().Unix(), OrderPos: w.nextOrderPos()}
+	} else if wtx.BlockHash == blockHash && (blockHash == "" || wtx.BlockHeight == height) {
+		return nil
+	}
+	wtx.BlockHash = blockHash
+	wtx.BlockHeight = 0
+	if blockHash != "" {
+		wtx.BlockHeight = height
+	}
+	if err := w.markWatchUsed(dbtx, tx); err != nil {
+		return err
+	}
+	if dbtx != nil {
+		if err := dbtx.WriteTx(wtx); err != nil {
+			return err
+		}
+	}
+	w.indexTx(wtx)
+	return nil
+}
+
+// removeTx drops the transaction with hash and every wallet transaction
+// spending its outputs. The caller must hold w.mu.
+func (w *Wallet) removeTx(dbtx *DBTx, hash string) error {
+	wtx, ok := w.txs[hash]
+	if !ok {
+		return nil
+	}
+	for i := range wtx.Tx.Outputs {
+		if spender, ok := w.spent[coin.PointOut{Hash: hash, Index: uint32(i)}]; ok {
+			if err := w.removeTx(dbtx, spender); err != nil {
+				return err
+			}
+		}
+	}
+	if dbtx != nil {
+		if err := dbtx.EraseTx(hash); err != nil {
+			return err
+		}
+	}
+	delete(w.txs, hash)
+	for _, in := range wtx.Tx.Inputs {
+		if w.spent[in.PreviousOut] == hash {
+			delete(w.spent, in.PreviousOut)
+		}
+	}
+	return nil
+}
+
+// updateTx runs fn with a database transaction, or with nil for an
+// in-memory wallet.
+func (w *Wallet) updateTx(fn func(tx *DBTx) error) error {
+	if w.db == nil {
+		return fn(nil)
+	}
+	return w.db.Update(fn)
+}
+
+// BlockConnected records the wallet transactions of b, the new tip at
+// height.
+func (w *Wallet) BlockConnected(b coin.Block, height int32) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.connectBlock(b, height)
+}
+
+func (w *Wallet) connectBlock(b coin.Block, height int32) error {
+	blockHash := b.Header.Hash()
+	err := w.updateTx(func(dbtx *DBTx) error {
+		for _, tx := range b.Transactions {
+			if err := w.syncTransaction(dbtx, tx, blockHash, height); err != nil {
+				return err
+			}
+		}
+		if dbtx == nil {
+			return nil
+		}
+		return writeBestBlock(dbtx, blockHash, height)
+	})
+	if err != nil {
+		return err
+	}
+	w.bestHeight = height
+	return nil
+}
+
+// BlockDisconnected undoes BlockConnected for b at height during a reorg.
+// Its transactions become unconfirmed, except coinbase and coinstake
+// transactions, which are only valid in their block and are removed
+// together with their spenders.
+func (w *Wallet) BlockDisconnected(b coin.Block, height int32) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	blockHash := b.Header.Hash()
+	err := w.updateTx(func(dbtx *DBTx) error {
+		for _, tx := range b.Transactions {
+			hash := tx.Hash()
+			wtx, ok := w.txs[hash]
+			if !ok || wtx.BlockHash != blockHash {
+				continue
+			}
+			if tx.IsCoinBase() || tx.IsCoinStake() {
+				if err := w.removeTx(dbtx, hash); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := w.syncTransaction(dbtx, tx, "", 0); err != nil {
+				return err
+			}
+		}
+		if dbtx == nil {
+			return nil
+		}
+		return writeBestBlock(dbtx, b.Header.PrevHash, height-1)
+	})
+	if err != nil {
+		return err
+	}
+	w.bestHeight = height - 1
+	return nil
+}
+
+// TransactionAccepted records a transaction that entered the memory pool
+// if it involves the wallet.
+func (w *Wallet) TransactionAccepted(tx coin.Transaction) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if wtx, ok := w.txs[tx.Hash()]; ok && wtx.IsConfirmed() {
+		return nil
+	}
+	return w.updateTx(func(dbtx *DBTx) error {
+		return w.syncTransaction(dbtx, tx, "", 0)
+	})
+}
+
+// Rescan scans the blocks of src from height from up to its tip for
+// wallet transactions, e.g. after keys have been imported.
+func (w *Wallet) Rescan(src ChainSource, from int32) error {
+	if from < 0 {
+		from = 0
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for h := from; h <= src.BestHeight(); h++ {
+		b, err := src.BlockAtHeight(h)
+		if err != nil {
+			return err
+		}
+		if err := w.connectBlock(b, h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RescanFromTime rescans from the first block less than two hours older
+// than t, the birth time of the oldest imported key.
+func (w *Wallet) RescanFromTime(src ChainSource, t time.Time) error {
+	h, err := heightAtTime(src, t)
+	if err != nil {
+		return err
+	}
+	return w.Rescan(src, h)
+}
+
+// heightAtTime returns the height of the first block less than two hours
+// older than t.
+func heightAtTime(src ChainSource, t time.Time) (int32, error) {
+	limit := t.Add(-rescanTimeMargin).Unix()
+	best := src.BestHeight()
+	h := int32(0)
+	for ; h <= best; h++ {
+		b, err := src.BlockAtHeight(h)
+		if err != nil {
+			return 0, err
+		}
+		if int64(b.Header.Timestamp) >= limit {
+			break
+		}
+	}
+	return h, nil
+}
+
+// scheduleRescan has the next Sync rescan from the unix time created,
+// the birth of keys imported without a chain source, unless an older
+// rescan is already pending.
+func (w *Wallet) scheduleRescan(created int64) error {
+	return w.update(func(tx *DBTx) error {
+		val, err := tx.ReadSetting(rescanFromSetting)
+		switch {
+		case err == nil && len(val) == 8 && int64(binary.LittleEndian.Uint64(val)) <= created:
+			return nil
+		case err != nil && err != database.ErrNotFound:
+			return err
+		}
+		return tx.WriteSetting(rescanFromSetting, binary.LittleEndian.AppendUint64(nil, uint64(created)))
+	})
+}
+
+// pendingRescan returns the birth time scheduleRescan recorded.
+func (w *Wallet) pendingRescan() (time.Time, bool, error) {
+	if w.db == nil {
+		return time.Time{}, false, nil
+	}
+	var val []byte
+	err := w.db.View(func(tx *DBTx) error {
+		var err error
+		val, err = tx.ReadSetting(rescanFromSetting)
+		return err
+	})
+	if err == database.ErrNotFound {
+		return time.Time{}, false, nil
+	}
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	if len(val) != 8 {
+		return time.Time{}, false, errors.New("invalid rescan record")
+	}
+	return time.Unix(int64(binary.LittleEndian.Uint64(val)), 0), true, nil
+}
+
+// Sync brings the wallet up to the tip of src at startup. With
+// wallet.rescan, or when the recorded tip is no longer in the chain, the
+// confirmations are reset and the whole chain is rescanned. Keys imported
+// without a chain source are rescanned from their birth time.
+func (w *Wallet) Sync(src ChainSource) error {
+	w.mu.Lock()
+	from := w.bestHeight + 1
+	full := w.config.Rescan
+	if !full && w.bestHeight >= 0 {
+		full = !w.onChain(src)
+	}
+	if full {
+		from = 0
+		err := w.updateTx(func(dbtx *DBTx) error { return w.resetConfirmations(dbtx) })
+		if err != nil {
+			w.mu.Unlock()
+			return err
+		}
+	}
+	w.mu.Unlock()
+	birth, pending, err := w.pendingRescan()
+	if err != nil {
+		return err
+	}
+	if pending && !full {
+		h, err := heightAtTime(src, birth)
+		if err != nil {
+			return err
+		}
+		from = min(from, h)
+	}
+	if err := w.Rescan(src, from); err != nil {
+		return err
+	}
+	if !pending {
+		return nil
+	}
+	return w.update(func(tx *DBTx) error { return tx.EraseSetting(rescanFromSetting) })
+}
+
+// onChain reports whether the recorded wallet tip is a block of src. The
+// caller must hold w.mu.
+func (w *Wallet) onChain(src ChainSource) bool {
+	if w.db == nil {
+		return true
+	}
+	if w.bestHeight > src.BestHeight() {
+		return false
+	}
+	var locator BlockLocator
+	err := w.db.View(func(tx *DBTx) error {
+		var err error
+		locator, err = tx.ReadBestBlock()
+		return err
+	})
+	if err != nil || len(locator) == 0 {
+		return false
+	}
+	b, err := src.BlockAtHeight(w.bestHeight)
+	return err == nil && b.Header.Hash() == locator[0]
+}
+
+// resetConfirmations marks every wallet transaction unconfirmed and drops
+// generated ones, ready for a full rescan. The caller must hold w.mu.
+func (w *Wallet) resetConfirmations(dbtx *DBTx) error {
+	var generated []string
+	for hash, wtx := range w.txs {
+		if wtx.Tx.IsCoinBase() || wtx.Tx.IsCoinStake() {
+			generated = append(generated, hash)
+		}
+	}
+	for _, hash := range generated {
+		if err := w.removeTx(dbtx, hash); err != nil {
+			return err
+		}
+	}
+	for _, wtx := range w.txs {
+		if !wtx.IsConfirmed() {
+			continue
+		}
+		wtx.BlockHash, wtx.BlockHeight = "", 0
+		if dbtx != nil {
+			if err := dbtx.WriteTx(wtx); err != nil {
+				return err
+			}
+		}
+	}
+	w.bestHeight = -1
+	return nil
+}
@@ -0,0 +1,116 @@
+package wallet
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+
+	"pila/pkg/coin"
+	"pila/pkg/database"
+)
+
+type testChain []coin.Block
+
+func (c testChain) BestHeight() int32 { return int32(len(c)) - 1 }
+
+func (c testChain) BlockAtHeight(h int32) (coin.Block, error) {
+	if h < 0 || int(h) >= len(c) {
+		return coin.Block{}, fmt.Errorf("no block at %d", h)
+	}
+	return c[h], nil
+}
+
+func (c testChain) add(txs ...coin.Transaction) testChain {
+	prev := strings.Repeat("0", 64)
+	if len(c) > 0 {
+		prev = c[len(c)-1].Header.Hash()
+	}
+	b := coin.Block{Header: coin.BlockHeader{Version: 1, PrevHash: prev, Timestamp: uint32(len(c))}, Transactions: txs}
+	return append(c, b)
+}
+
+func coinbaseTo(script []byte, value int64, extra byte) coin.Transaction {
+	return coin.Transaction{
+		Version: 1,
+		Inputs: []coin.TxIn{{
+			PreviousOut: coin.PointOut{Hash: strings.Repeat("0", 64), Index: math.MaxUint32},
+			ScriptSig:   []byte{extra},
+		}},
+		Outputs: []coin.TxOut{{Value: value, ScriptPubKey: script}},
+	}
+}
+
+func TestWalletFollowsChain(t *testing.T) {
+	db, _ := NewDB(database.NewMemStore())
+	cfg := DefaultConfig()
+	cfg.KeyPoolSize = 1
+	w, err := New(db, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, _ := w.KeyPool().GetKey()
+	mine := coin.PayToPubKeyHashScript(pub.ID())
+
+	foreign := coin.PointOut{Hash: coin.Transaction{Version: 9}.Hash(), Index: 0}
+	payment := coin.Transaction{
+		Version: 1,
+		Inputs:  []coin.TxIn{{PreviousOut: foreign, Sequence: 0xffffffff}},
+		Outputs: []coin.TxOut{{Value: 5 * coin.Coin, ScriptPubKey: mine}},
+	}
+	chain := testChain{}.add(coinbaseTo(mine, 10*coin.Coin, 0)).add(coinbaseTo(nil, 0, 1), payment)
+
+	if err := w.TransactionAccepted(payment); err != nil {
+		t.Fatal(err)
+	}
+	if b := w.Balance(); b.Unconfirmed != 5*coin.Coin {
+		t.Fatalf("unconfirmed balance %+v", b)
+	}
+	for h, b := range chain {
+		if err := w.BlockConnected(b, int32(h)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if b := w.Balance(); b.Confirmed != 5*coin.Coin || b.Immature != 10*coin.Coin || b.Unconfirmed != 0 {
+		t.Fatalf("balance after connect %+v", b)
+	}
+	if n := w.Confirmations(payment.Hash()); n != 1 {
+		t.Fatalf("confirmations %d", n)
+	}
+
+	// Reorg both blocks away: the payment goes back to unconfirmed and the
+	// coinbase disappears.
+	if err := w.BlockDisconnected(chain[1], 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.BlockDisconnected(chain[0], 0); err != nil {
+		t.Fatal(err)
+	}
+	if b := w.Balance(); b.Unconfirmed != 5*coin.Coin || b.Immature != 0 || w.BestHeight() != -1 {
+		t.Fatalf("balance after reorg %+v height %d", b, w.BestHeight())
+	}
+
+	// A rival spend of the same input confirms and the payment is dropped.
+	rival := payment
+	rival.Outputs = []coin.TxOut{{Value: 5 * coin.Coin, ScriptPubKey: []byte{coin.OP_TRUE}}}
+	fork := testChain{}.add(coinbaseTo(nil, 0, 2), rival)
+	if err := w.BlockConnected(fork[0], 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := w.Transaction(payment.Hash()); ok {
+		t.Fatalf("conflicted payment still in the wallet")
+	}
+
+	// A reopened wallet with wallet.rescan finds everything again.
+	cfg.Rescan = true
+	w2, err := New(db, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w2.Sync(chain); err != nil {
+		t.Fatal(err)
+	}
+	if b := w2.Balance(); b.Confirmed != 5*coin.Coin || b.Immature != 10*coin.Coin {
+		t.Fatalf("balance after rescan %+v", b)
+	}
+}
@@ -0,0 +1,141 @@
+package wallet
+
+import (
+	"sort"
+
+	"pila/pkg/coin"
+)
+
+// SpentMismatch is a confirmed wallet output whose spent state disagrees
+// with the UTXO set.
+type SpentMismatch struct {
+	Out   coin.PointOut
+	Value int64
+	// WalletSpent is the state the wallet recorded: true when the wallet
+	// considers the output spent although it is still in the UTXO set,
+	// false when the wallet counts an output the UTXO set no longer has.
+	WalletSpent bool
+}
+
+// CheckResult is the outcome of cross-referencing the wallet with the
+// UTXO set (checkwallet).
+type CheckResult struct {
+	Mismatches []SpentMismatch
+	// WalletAmount is the value of the confirmed outputs the wallet
+	// considers unspent.
+	WalletAmount int64
+	// ChainAmount is the value of the confirmed wallet outputs present in
+	// the UTXO set.
+	ChainAmount int64
+}
+
+// IsConsistent reports whether the wallet agrees with the UTXO set.
+func (r CheckResult) IsConsistent() bool {
+	return len(r.Mismatches) == 0 && r.WalletAmount == r.ChainAmount
+}
+
+// CheckWallet compares the spent state of every confirmed wallet output,
+// watch-only ones included, with view (checkwallet). Outputs spent by an
+// unconfirmed wallet transaction count as unspent, since the spend is not
+// in the chain yet.
+func (w *Wallet) CheckWallet(view coin.UtxoView) CheckResult {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.check(view)
+}
+
+// check implements CheckWallet. The caller must hold w.mu.
+func (w *Wallet) check(view coin.UtxoView) CheckResult {
+	var r CheckResult
+	for hash, wtx := range w.txs {
+		if !wtx.IsConfirmed() {
+			continue
+		}
+		for i, out := range wtx.Tx.Outputs {
+			if out.IsEmpty() || !w.isMineFilter(out.ScriptPubKey, MineAll) {
+				continue
+			}
+			op := coin.PointOut{Hash: hash, Index: uint32(i)}
+			walletSpent := w.spentInChain(op)
+			_, inChain := view.LookupUtxo(op)
+			if !walletSpent {
+				r.WalletAmount += out.Value
+			}
+			if inChain {
+				r.ChainAmount += out.Value
+			}
+			if walletSpent == inChain {
+				r.Mismatches = append(r.Mismatches, SpentMismatch{Out: op, Value: out.Value, WalletSpent: walletSpent})
+			}
+		}
+	}
+	sort.Slice(r.Mismatches, func(i, j int) bool {
+		a, b := r.Mismatches[i].Out, r.Mismatches[j].Out
+		return a.Hash < b.Hash || a.Hash == b.Hash && a.Index < b.Index
+	})
+	return r
+}
+
+// spentInChain reports whether the wallet records out as spent by a
+// confirmed transaction or outside the wallet. The caller must hold w.mu.
+func (w *Wallet) spentInChain(out coin.PointOut) bool {
+	spender, ok := w.spent[out]
+	if !ok {
+		return false
+	}
+	if spender == "" {
+		return true
+	}
+	wtx := w.txs[spender]
+	return wtx != nil && wtx.IsConfirmed()
+}
+
+// RepairWallet checks the wallet against view and fixes every mismatch
+// (repairwallet), returning what the check found. Outputs the UTXO set no
+// longer has are marked spent outside the wallet, which removes phantom
+// balance. A confirmed wallet transaction spending outputs that are still
+// unspent never made it into the chain and is removed with its
+// descendants. Keeping it, even as unconfirmed, would leave its change
+// spendable next to the outputs it claims to spend.
+func (w *Wallet) RepairWallet(view coin.UtxoView) (CheckResult, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	r := w.check(view)
+	if len(r.Mismatches) == 0 {
+		return r, nil
+	}
+	err := w.updateTx(func(dbtx *DBTx) error {
+		// Phantom spenders go first: their own outputs are mismatches too
+		// and must not be marked spent before they are removed.
+		for _, m := range r.Mismatches {
+			if spender := w.spent[m.Out]; m.WalletSpent && spender != "" {
+				if err := w.removeTx(dbtx, spender); err != nil {
+					return err
+				}
+			}
+		}
+		for _, m := range r.Mismatches {
+			if _, ok := w.txs[m.Out.Hash]; !ok {
+				// Removed with a phantom spender.
+				continue
+			}
+			if !m.WalletSpent {
+				if dbtx != nil {
+					if err := dbtx.WriteSpent(m.Out); err != nil {
+						return err
+					}
+				}
+				w.spent[m.Out] = ""
+			} else if w.spent[m.Out] == "" {
+				if dbtx != nil {
+					if err := dbtx.EraseSpent(m.Out); err != nil {
+						return err
+					}
+				}
+				delete(w.spent, m.Out)
+			}
+		}
+		return nil
+	})
+	return r, err
+}
@@ -0,0 +1,94 @@
+package wallet
+
+import (
+	"testing"
+
+	"pila/pkg/coin"
+	"pila/pkg/database"
+)
+
+func TestCheckAndRepairWallet(t *testing.T) {
+	db, _ := NewDB(database.NewMemStore())
+	cfg := DefaultConfig()
+	cfg.KeyPoolSize = 1
+	w, err := New(db, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	address, err := w.NewAddress("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain := testChain{}.add(coinbaseTo(nil, 0, 0), payTo(t, address, 3*coin.Coin, 1), payTo(t, address, 2*coin.Coin, 2))
+	if err := w.Sync(chain); err != nil {
+		t.Fatal(err)
+	}
+	utxos := coin.NewUtxoSet()
+	for h, b := range chain {
+		utxos.ConnectBlock(b, int32(h))
+	}
+	if r := w.CheckWallet(utxos); !r.IsConsistent() || r.WalletAmount != 5*coin.Coin {
+		t.Fatalf("synced wallet inconsistent: %+v", r)
+	}
+
+	// A copy of the wallet spent the first output, and a spend of the
+	// second was recorded as confirmed but never reached the chain.
+	first := coin.PointOut{Hash: chain[0].Transactions[1].Hash(), Index: 0}
+	second := coin.PointOut{Hash: chain[0].Transactions[2].Hash(), Index: 0}
+	utxos.Spend(first)
+	_, dest := testAddress(t)
+	// The phantom spend pays change back to the wallet, which a later
+	// wallet transaction spends in turn.
+	phantom := payTo(t, dest, coin.Coin, 1)
+	phantom.Inputs = []coin.TxIn{{PreviousOut: second}}
+	phantom.Outputs = append(phantom.Outputs, payTo(t, address, coin.Coin/2, 1).Outputs...)
+	if err := w.AddTransaction(&WalletTx{Tx: phantom, BlockHash: "ff", BlockHeight: 0}); err != nil {
+		t.Fatal(err)
+	}
+	child := payTo(t, address, coin.Coin/4, 1)
+	child.Inputs = []coin.TxIn{{PreviousOut: coin.PointOut{Hash: phantom.Hash(), Index: 1}}}
+	if err := w.AddTransaction(&WalletTx{Tx: child}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := w.CheckWallet(utxos)
+	if len(r.Mismatches) != 3 || r.WalletAmount != 3*coin.Coin+coin.Coin/2 || r.ChainAmount != 2*coin.Coin {
+		t.Fatalf("check %+v", r)
+	}
+	for _, m := range r.Mismatches {
+		if m.WalletSpent != (m.Out == second) {
+			t.Fatalf("mismatch %+v", m)
+		}
+	}
+	if _, err := w.RepairWallet(utxos); err != nil {
+		t.Fatal(err)
+	}
+	if r := w.CheckWallet(utxos); !r.IsConsistent() {
+		t.Fatalf("repaired wallet inconsistent: %+v", r)
+	}
+	if b := w.Balance(); b.Confirmed != 2*coin.Coin {
+		t.Fatalf("repaired balance %+v", b)
+	}
+	// The spend that never reached the chain goes with its descendants, and
+	// only the output it claimed to spend is left to select.
+	for _, tx := range []coin.Transaction{phantom, child} {
+		if _, ok := w.Transaction(tx.Hash()); ok {
+			t.Fatalf("phantom spend %s kept", tx.Hash())
+		}
+	}
+	if coins := w.Coins(); len(coins) != 1 || coins[0].Out != second {
+		t.Fatalf("repaired coins %+v", coins)
+	}
+
+	// The repair survives a reload.
+	reloaded, err := New(db, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := reloaded.CheckWallet(utxos); !r.IsConsistent() {
+		t.Fatalf("reloaded wallet inconsistent: %+v", r)
+	}
+	if b := reloaded.Balance(); b.Confirmed != 2*coin.Coin {
+		t.Fatalf("reloaded balance %+v", b)
+	}
+}
@@ -0,0 +1,309 @@
+package wallet
+
+import (
+	"errors"
+	"sort"
+
+	"pila/pkg/coin"
+)
+
+// Strategy chooses how SelectCoins picks outputs.
+type Strategy int
+
+const (
+	// StrategyDefault looks for an exact match first and falls back to
+	// largest-first.
+	StrategyDefault Strategy = iota
+	// StrategyLargestFirst spends the largest outputs first, keeping the
+	// number of inputs low.
+	StrategyLargestFirst
+	// StrategyBranchAndBound only succeeds with a set of outputs that
+	// needs no change output.
+	StrategyBranchAndBound
+	// StrategyPrivacy spends all outputs of an address together and
+	// mixes as few addresses as possible so a spend links fewer of them.
+	StrategyPrivacy
+)
+
+const (
+	// DefaultMinConf is the confirmation depth required by default.
+	DefaultMinConf = 1
+	// bnbMaxTries bounds the branch-and-bound search.
+	bnbMaxTries = 100000
+	// maturityMargin is the number of blocks the wallet waits on top of
+	// the coinbase maturity before spending generated outputs, as the C++
+	// wallet does.
+	maturityMargin = 20
+)
+
+var (
+	// ErrInsufficientFunds is returned when the eligible outputs do not
+	// cover the target.
+	ErrInsufficientFunds = errors.New("insufficient funds")
+	// ErrNoExactMatch is returned by StrategyBranchAndBound when no set of
+	// outputs avoids change.
+	ErrNoExactMatch = errors.New("no exact match found")
+)
+
+// Coin is a wallet output that may be spent.
+type Coin struct {
+	Out    coin.PointOut
+	Output coin.TxOut
+	// Depth is the number of confirmations, zero while unconfirmed.
+	Depth     int32
+	CoinBase  bool
+	CoinStake bool
+}
+
+// Value returns the amount of the output.
+func (c Coin) Value() int64 { return c.Output.Value }
+
+// BlocksToMaturity returns how many more blocks a coinbase or coinstake
+// output needs before it can be spent.
+func (c Coin) BlocksToMaturity() int32 {
+	if !c.CoinBase && !c.CoinStake {
+		return 0
+	}
+	maturity := int32(coin.CoinbaseMaturity)
+	if coin.TestNet {
+		maturity = coin.CoinbaseMaturityTestNetwork
+	}
+	if n := maturity + maturityMargin - c.Depth; n > 0 {
+		return n
+	}
+	return 0
+}
+
+// SelectOptions configures SelectCoins.
+type SelectOptions struct {
+	Strategy Strategy
+	// MinConf is the minimum confirmation depth of spent outputs.
+	MinConf int32
+	// Locked reports outputs that must not be spent, e.g. those locked
+	// for a pending payout. It may be nil.
+	Locked func(coin.PointOut) bool
+	// Control limits the spend to the selected outputs when it has any.
+	Control *CoinControl
+	// ChangeWindow is the excess an exact match may have; it is paid as
+	// fee instead of creating change. Zero means coin.MinTxOutAmount.
+	ChangeWindow int64
+}
+
+// Selection is the result of SelectCoins.
+type Selection struct {
+	Coins []Coin
+	// Total is the sum of the selected outputs.
+	Total int64
+	// Change is the amount to return to the wallet.
+	Change int64
+	// Dust is change below coin.MinTxOutAmount that is left to the fee.
+	Dust int64
+}
+
+func newSelection(coins []Coin, target int64) *Selection {
+	s := &Selection{Coins: coins}
+	for _, c := range coins {
+		s.Total += c.Value()
+	}
+	s.Change = s.Total - target
+	if s.Change < coin.MinTxOutAmount {
+		s.Dust, s.Change = s.Change, 0
+	}
+	return s
+}
+
+// EligibleCoins filters out the outputs SelectCoins may not spend: those
+// below the confirmation depth, immature coinbase and coinstake outputs,
+// locked outputs and, with coin control, unselected outputs.
+func EligibleCoins(coins []Coin, opts SelectOptions) []Coin {
+	control := opts.Control != nil && opts.Control.HasSelected()
+	var out []Coin
+	for _, c := range coins {
+		if c.Depth < opts.MinConf || c.BlocksToMaturity() > 0 || c.Value() <= 0 {
+			continue
+		}
+		if opts.Locked != nil && opts.Locked(c.Out) {
+			continue
+		}
+		if control && !opts.Control.IsSelected(c.Out) {
+			continue
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+// SelectCoins chooses outputs of coins worth at least target. With coin
+// control every selected output is spent.
+func SelectCoins(coins []Coin, target int64, opts SelectOptions) (*Selection, error) {
+	if target <= 0 {
+		return nil, errors.New("invalid amount")
+	}
+	eligible := EligibleCoins(coins, opts)
+	if opts.Control != nil && opts.Control.HasSelected() {
+		s := newSelection(eligible, target)
+		if s.Total < target {
+			return nil, ErrInsufficientFunds
+		}
+		return s, nil
+	}
+	var total int64
+	for _, c := range eligible {
+		total += c.Value()
+	}
+	if total < target {
+		return nil, ErrInsufficientFunds
+	}
+	window := opts.ChangeWindow
+	if window <= 0 {
+		window = coin.MinTxOutAmount
+	}
+
+	switch opts.Strategy {
+	case StrategyLargestFirst:
+		return newSelection(largestFirst(eligible, target), target), nil
+	case StrategyBranchAndBound:
+		picked := branchAndBound(eligible, target, window)
+		if picked == nil {
+			return nil, ErrNoExactMatch
+		}
+		return newSelection(picked, target), nil
+	case StrategyPrivacy:
+		return newSelection(privacyFirst(eligible, target), target), nil
+	default:
+		if picked := branchAndBound(eligible, target, window); picked != nil {
+			return newSelection(picked, target), nil
+		}
+		return newSelection(largestFirst(eligible, target), target), nil
+	}
+}
+
+// sortByValue orders coins from the largest to the smallest value, breaking
+// ties by outpoint so selections are reproducible.
+func sortByValue(coins []Coin) []Coin {
+	sorted := append([]Coin(nil), coins...)
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.Value() != b.Value() {
+			return a.Value() > b.Value()
+		}
+		if a.Out.Hash != b.Out.Hash {
+			return a.Out.Hash < b.Out.Hash
+		}
+		return a.Out.Index < b.Out.Index
+	})
+	return sorted
+}
+
+func largestFirst(coins []Coin, target int64) []Coin {
+	var picked []Coin
+	var sum int64
+	for _, c := range sortByValue(coins) {
+		if sum >= target {
+			break
+		}
+		picked = append(picked, c)
+		sum += c.Value()
+	}
+	return picked
+}
+
+// branchAndBound searches depth first for the set of coins whose sum is in
+// [target, target+window] with the smallest excess. It returns nil when
+// there is none or the search gives up.
+func branchAndBound(coins []Coin, target, window int64) []Coin {
+	sorted := sortByValue(coins)
+	// remaining[i] is the sum of sorted[i:].
+	remaining := make([]int64, len(sorted)+1)
+	for i := len(sorted) - 1; i >= 0; i-- {
+		remaining[i] = remaining[i+1] + sorted[i].Value()
+	}
+
+	var (
+		best      []int
+		bestWaste int64 = -1
+		current   []int
+		tries     int
+	)
+	var search func(i int, sum int64) bool
+	search = func(i int, sum int64) bool {
+		tries++
+		if tries > bnbMaxTries {
+			return false
+		}
+		if sum > target+window || sum+remaining[i] < target {
+			return true
+		}
+		if sum >= target {
+			if waste := sum - target; bestWaste < 0 || waste < bestWaste {
+				bestWaste = waste
+				best = append(best[:0], current...)
+			}
+			return bestWaste != 0
+		}
+		if i == len(sorted) {
+			return true
+		}
+		current = append(current, i)
+		if !search(i+1, sum+sorted[i].Value()) {
+			return false
+		}
+		current = current[:len(current)-1]
+		// Leaving out sorted[i] and including an equal coin instead gives
+		// the same sums, so skip the equal coins as well.
+		j := i + 1
+		for j < len(sorted) && sorted[j].Value() == sorted[i].Value() {
+			j++
+		}
+		return search(j, sum)
+	}
+	search(0, 0)
+	if bestWaste < 0 {
+		return nil
+	}
+	picked := make([]Coin, len(best))
+	for k, i := range best {
+		picked[k] = sorted[i]
+	}
+	return picked
+}
+
+// privacyFirst spends whole addresses. The cheapest single address that
+// covers target is used when there is one; otherwise addresses are added
+// largest first.
+func privacyFirst(coins []Coin, target int64) []Coin {
+	type group struct {
+		coins []Coin
+		total int64
+	}
+	byScript := make(map[string]*group)
+	var groups []*group
+	for _, c := range sortByValue(coins) {
+		key := string(c.Output.ScriptPubKey)
+		g := byScript[key]
+		if g == nil {
+			g = &group{}
+			byScript[key] = g
+			groups = append(groups, g)
+		}
+		g.coins = append(g.coins, c)
+		g.total += c.Value()
+	}
+	sort.SliceStable(groups, func(i, j int) bool { return groups[i].total > groups[j].total })
+
+	for i := len(groups) - 1; i >= 0; i-- {
+		if groups[i].total >= target {
+			return groups[i].coins
+		}
+	}
+	var picked []Coin
+	var sum int64
+	for _, g := range groups {
+		if sum >= target {
+			break
+		}
+		picked = append(picked, g.coins...)
+		sum += g.total
+	}
+	return picked
+}
@@ -0,0 +1,86 @@
+package wallet
+
+import (
+	"fmt"
+	"testing"
+
+	"pila/pkg/coin"
+)
+
+func testCoin(n int, value int64, script byte) Coin {
+	return Coin{
+		Out:    coin.PointOut{Hash: fmt.Sprintf("%064x", n), Index: 0},
+		Output: coin.TxOut{Value: value, ScriptPubKey: []byte{script}},
+		Depth:  10,
+	}
+}
+
+func TestSelectCoinsStrategies(t *testing.T) {
+	coins := []Coin{
+		testCoin(1, 5*coin.Coin, 1),
+		testCoin(2, 3*coin.Coin, 2),
+		testCoin(3, 2*coin.Coin, 3),
+		testCoin(4, 1*coin.Coin, 3),
+	}
+
+	s, err := SelectCoins(coins, 4*coin.Coin, SelectOptions{Strategy: StrategyBranchAndBound})
+	if err != nil || s.Total != 4*coin.Coin || s.Change != 0 || len(s.Coins) != 2 {
+		t.Fatalf("branch and bound: %+v %v", s, err)
+	}
+	if _, err := SelectCoins(coins, 4*coin.Coin+coin.Cent, SelectOptions{Strategy: StrategyBranchAndBound}); err != ErrNoExactMatch {
+		t.Fatalf("expected no exact match, got %v", err)
+	}
+
+	s, err = SelectCoins(coins, 6*coin.Coin, SelectOptions{Strategy: StrategyLargestFirst})
+	if err != nil || len(s.Coins) != 2 || s.Change != 2*coin.Coin {
+		t.Fatalf("largest first: %+v %v", s, err)
+	}
+
+	// Address 3 covers the target on its own and both its outputs go.
+	s, err = SelectCoins(coins, 2*coin.Coin+coin.Cent, SelectOptions{Strategy: StrategyPrivacy})
+	if err != nil || len(s.Coins) != 2 || s.Total != 3*coin.Coin {
+		t.Fatalf("privacy: %+v %v", s, err)
+	}
+
+	// Change below the smallest output is left to the fee.
+	s, err = SelectCoins(coins, 5*coin.Coin-coin.MinTxOutAmount/2, SelectOptions{})
+	if err != nil || s.Change != 0 || s.Dust != coin.MinTxOutAmount/2 {
+		t.Fatalf("dust change: %+v %v", s, err)
+	}
+	if _, err := SelectCoins(coins, 12*coin.Coin, SelectOptions{}); err != ErrInsufficientFunds {
+		t.Fatalf("expected insufficient funds, got %v", err)
+	}
+}
+
+func TestSelectCoinsFilters(t *testing.T) {
+	unconfirmed := testCoin(1, 5*coin.Coin, 1)
+	unconfirmed.Depth = 0
+	stake := testCoin(2, 5*coin.Coin, 1)
+	stake.CoinStake = true
+	stake.Depth = coin.CoinbaseMaturity
+	locked := testCoin(3, 5*coin.Coin, 1)
+	spendable := testCoin(4, 1*coin.Coin, 1)
+	coins := []Coin{unconfirmed, stake, locked, spendable}
+
+	opts := SelectOptions{
+		MinConf: DefaultMinConf,
+		Locked:  func(out coin.PointOut) bool { return out == locked.Out },
+	}
+	if got := EligibleCoins(coins, opts); len(got) != 1 || got[0].Out != spendable.Out {
+		t.Fatalf("eligible: %+v", got)
+	}
+	stake.Depth = coin.CoinbaseMaturity + maturityMargin
+	if stake.BlocksToMaturity() != 0 {
+		t.Fatalf("stake should be mature")
+	}
+
+	control := NewCoinControl()
+	control.Select(locked.Out)
+	control.Select(spendable.Out)
+	opts.Locked = nil
+	opts.Control = control
+	s, err := SelectCoins(coins, coin.Coin, opts)
+	if err != nil || len(s.Coins) != 2 || s.Change != 5*coin.Coin {
+		t.Fatalf("coin control: %+v %v", s, err)
+	}
+}
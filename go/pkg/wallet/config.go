@@ -0,0 +1,29 @@
+// Package wallet implements the key wallet: key generation, persistence,
+// coin selection, transaction creation and chain following.
+package wallet
+
+// DefaultKeyPoolSize is the default of wallet.keypool.size.
+const DefaultKeyPoolSize = 100
+
+// Config holds the wallet options of the configuration file.
+type Config struct {
+	// Deterministic derives new keys from an HD master key
+	// (wallet.deterministic). When false every key is random and must be
+	// backed up individually.
+	Deterministic bool
+	// Seed, when set, derives the key chain of a new wallet from a BIP39
+	// seed (see keys.MnemonicSeed) instead of a random master key. It
+	// implies Deterministic and is ignored for existing wallets.
+	Seed []byte
+	// KeyPoolSize is the number of pre-generated keys kept in the key
+	// pool (wallet.keypool.size).
+	KeyPoolSize int
+	// Rescan rescans the whole chain for wallet transactions when the
+	// wallet is synced at startup (wallet.rescan).
+	Rescan bool
+}
+
+// DefaultConfig returns the defaults of configuration.cpp.
+func DefaultConfig() Config {
+	return Config{Deterministic: true, KeyPoolSize: DefaultKeyPoolSize}
+}
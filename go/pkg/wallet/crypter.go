@@ -0,0 +1,210 @@
+package wallet
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"pila/pkg/coin"
+	"pila/pkg/crypto"
+)
+
+const (
+	// WalletKeySize is the size of the wallet master key and of the AES
+	// keys derived from passphrases.
+	WalletKeySize = 32
+	// WalletSaltSize is the size of the passphrase salt.
+	WalletSaltSize = 8
+
+	// MinDeriveIterations is the lowest passphrase iteration count used
+	// for new master keys.
+	MinDeriveIterations = 25000
+)
+
+// Passphrase derivation methods of MasterKey.
+const (
+	// DeriveEVPSHA512 is EVP_BytesToKey with SHA-512, used by wallets
+	// encrypted with the C++ client.
+	DeriveEVPSHA512 uint32 = 0
+	// DerivePBKDF2SHA256 is PBKDF2 with HMAC-SHA256.
+	DerivePBKDF2SHA256 uint32 = 1
+)
+
+// ErrDecrypt is returned when a ciphertext does not decrypt, which for
+// passphrase derived keys means a wrong passphrase.
+var ErrDecrypt = errors.New("decryption failed")
+
+// MasterKey holds the wallet master key encrypted with a passphrase
+// (key_wallet_master).
+type MasterKey struct {
+	CryptedKey       []byte
+	Salt             []byte
+	DerivationMethod uint32
+	DeriveIterations uint32
+}
+
+// passphraseKey derives the AES key and IV protecting the master key.
+func (m MasterKey) passphraseKey(passphrase []byte) (key, iv []byte, err error) {
+	if m.DeriveIterations < 1 {
+		return nil, nil, errors.New("invalid derive iterations")
+	}
+	switch m.DerivationMethod {
+	case DeriveEVPSHA512:
+		// EVP_BytesToKey: a single SHA-512 block covers key and IV.
+		h := sha512.Sum512(append(append([]byte(nil), passphrase...), m.Salt...))
+		for i := uint32(1); i < m.DeriveIterations; i++ {
+			h = sha512.Sum512(h[:])
+		}
+		return h[:WalletKeySize], h[WalletKeySize : WalletKeySize+aes.BlockSize], nil
+	case DerivePBKDF2SHA256:
+		b := crypto.PBKDF2SHA256(passphrase, m.Salt, int(m.DeriveIterations), WalletKeySize+aes.BlockSize)
+		return b[:WalletKeySize], b[WalletKeySize:], nil
+	default:
+		return nil, nil, fmt.Errorf("unknown key derivation method %d", m.DerivationMethod)
+	}
+}
+
+// Decrypt returns the master key protected by passphrase.
+func (m MasterKey) Decrypt(passphrase []byte) ([]byte, error) {
+	key, iv, err := m.passphraseKey(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	master, err := decryptAES(key, iv, m.CryptedKey)
+	if err != nil || len(master) != WalletKeySize {
+		return nil, ErrDecrypt
+	}
+	return master, nil
+}
+
+// newMasterKey encrypts master under passphrase with a fresh salt.
+func newMasterKey(master, passphrase []byte, iterations uint32) (MasterKey, error) {
+	m := MasterKey{
+		Salt:             make([]byte, WalletSaltSize),
+		DerivationMethod: DerivePBKDF2SHA256,
+		DeriveIterations: iterations,
+	}
+	if _, err := rand.Read(m.Salt); err != nil {
+		return MasterKey{}, err
+	}
+	key, iv, err := m.passphraseKey(passphrase)
+	if err != nil {
+		return MasterKey{}, err
+	}
+	if m.CryptedKey, err = encryptAES(key, iv, master); err != nil {
+		return MasterKey{}, err
+	}
+	return m, nil
+}
+
+// Encode returns the record encoding of the master key.
+func (m MasterKey) Encode() []byte {
+	var buf bytes.Buffer
+	coin.WriteVarBytes(&buf, m.CryptedKey)
+	coin.WriteVarBytes(&buf, m.Salt)
+	binary.Write(&buf, binary.LittleEndian, m.DerivationMethod)
+	binary.Write(&buf, binary.LittleEndian, m.DeriveIterations)
+	return buf.Bytes()
+}
+
+// DecodeMasterKey parses a record written by Encode.
+func DecodeMasterKey(b []byte) (MasterKey, error) {
+	var m MasterKey
+	r := bytes.NewReader(b)
+	var err error
+	if m.CryptedKey, err = coin.ReadVarBytes(r); err != nil {
+		return MasterKey{}, err
+	}
+	if m.Salt, err = coin.ReadVarBytes(r); err != nil {
+		return MasterKey{}, err
+	}
+	if err := binary.Read(r, binary.LittleEndian, &m.DerivationMethod); err != nil {
+		return MasterKey{}, err
+	}
+	if err := binary.Read(r, binary.LittleEndian, &m.DeriveIterations); err != nil {
+		return MasterKey{}, err
+	}
+	return m, nil
+}
+
+// CalibrateIterations returns the PBKDF2 iteration count taking roughly
+// target on this machine, and at least MinDeriveIterations.
+func CalibrateIterations(target time.Duration) uint32 {
+	const probe = 25000
+	start := time.Now()
+	crypto.PBKDF2SHA256([]byte("calibrate"), make([]byte, WalletSaltSize), probe, WalletKeySize)
+	elapsed := time.Since(start)
+	if elapsed <= 0 {
+		return MinDeriveIterations
+	}
+	n := uint64(probe) * uint64(target) / uint64(elapsed)
+	if n < MinDeriveIterations {
+		return MinDeriveIterations
+	}
+	if n > 1<<31 {
+		n = 1 << 31
+	}
+	return uint32(n)
+}
+
+// secretIV returns the IV for the secret of pub, the first bytes of its
+// double SHA-256 as in crypter::encrypt_secret.
+func secretIV(pub []byte) []byte {
+	h := coin.DoubleSHA256(pub)
+	return h[:aes.BlockSize]
+}
+
+// encryptSecret encrypts a private key under the wallet master key.
+func encryptSecret(master, secret, pub []byte) ([]byte, error) {
+	return encryptAES(master, secretIV(pub), secret)
+}
+
+// decryptSecret reverses encryptSecret.
+func decryptSecret(master, crypted, pub []byte) ([]byte, error) {
+	return decryptAES(master, secretIV(pub), crypted)
+}
+
+// encryptAES encrypts with AES-256-CBC and PKCS#7 padding.
+func encryptAES(key, iv, plain []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	pad := aes.BlockSize - len(plain)%aes.BlockSize
+	buf := make([]byte, len(plain)+pad)
+	copy(buf, plain)
+	for i := len(plain); i < len(buf); i++ {
+		buf[i] = byte(pad)
+	}
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(buf, buf)
+	return buf, nil
+}
+
+// decryptAES reverses encryptAES.
+func decryptAES(key, iv, data []byte) ([]byte, error) {
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, ErrDecrypt
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(buf, data)
+	pad := int(buf[len(buf)-1])
+	if pad == 0 || pad > aes.BlockSize {
+		return nil, ErrDecrypt
+	}
+	for _, b := range buf[len(buf)-pad:] {
+		if int(b) != pad {
+			return nil, ErrDecrypt
+		}
+	}
+	return buf[:len(buf)-pad], nil
+}
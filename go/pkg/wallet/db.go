@@ -0,0 +1,178 @@
+package wallet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"pila/pkg/database"
+)
+
+// DBVersion is the wallet record layout written by this code.
+const DBVersion uint32 = 1
+
+const dbVersionKey = "version"
+
+// ErrReadOnly is returned when writing inside a View transaction.
+var ErrReadOnly = errors.New("wallet database transaction is read-only")
+
+// DB persists the wallet (db_wallet). Every change goes through Update,
+// which applies all writes of a transaction in one atomic, synced batch so
+// a crash never leaves a half written send or key rotation behind.
+type DB struct {
+	// mu serializes write transactions.
+	mu    sync.Mutex
+	store database.Store
+}
+
+// OpenDB opens the LevelDB wallet database at path.
+func OpenDB(path string) (*DB, error) {
+	s, err := database.OpenLevelStore(path)
+	if err != nil {
+		return nil, err
+	}
+	d, err := NewDB(s)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+// NewDB wraps store, stamping a new store with DBVersion and refusing
+// stores written by a newer version.
+func NewDB(store database.Store) (*DB, error) {
+	raw, err := store.Get([]byte(dbVersionKey))
+	switch {
+	case err == database.ErrNotFound:
+		if err := store.Put([]byte(dbVersionKey), encodeUint32(DBVersion)); err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, err
+	case len(raw) != 4:
+		return nil, errors.New("corrupt wallet version record")
+	case binary.LittleEndian.Uint32(raw) > DBVersion:
+		return nil, fmt.Errorf("wallet version %d is newer than supported version %d",
+			binary.LittleEndian.Uint32(raw), DBVersion)
+	}
+	return &DB{store: store}, nil
+}
+
+// Close closes the underlying store.
+func (d *DB) Close() error { return d.store.Close() }
+
+// Update runs fn in a write transaction. The writes of fn are committed
+// atomically when it returns nil and discarded otherwise.
+func (d *DB) Update(fn func(tx *DBTx) error) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	tx := &DBTx{r: d.store, writable: true, pending: make(map[string][]byte)}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if len(tx.pending) == 0 {
+		return nil
+	}
+	b := database.NewBatch()
+	for k, v := range tx.pending {
+		if v == nil {
+			b.Delete([]byte(k))
+		} else {
+			b.Put([]byte(k), v)
+		}
+	}
+	return d.store.Write(b)
+}
+
+// View runs fn against a consistent snapshot of the database.
+func (d *DB) View(fn func(tx *DBTx) error) error {
+	snap, err := d.store.GetSnapshot()
+	if err != nil {
+		return err
+	}
+	defer snap.Release()
+	return fn(&DBTx{r: snap})
+}
+
+// DBTx is a wallet database transaction. Reads observe the transaction's
+// own uncommitted writes.
+type DBTx struct {
+	r        database.Reader
+	writable bool
+	// pending holds uncommitted writes; a nil value is a delete.
+	pending map[string][]byte
+}
+
+func (tx *DBTx) get(key string) ([]byte, error) {
+	if v, ok := tx.pending[key]; ok {
+		if v == nil {
+			return nil, database.ErrNotFound
+		}
+		return v, nil
+	}
+	return tx.r.Get([]byte(key))
+}
+
+func (tx *DBTx) put(key string, val []byte) error {
+	if !tx.writable {
+		return ErrReadOnly
+	}
+	if val == nil {
+		val = []byte{}
+	}
+	tx.pending[key] = val
+	return nil
+}
+
+func (tx *DBTx) delete(key string) error {
+	if !tx.writable {
+		return ErrReadOnly
+	}
+	tx.pending[key] = nil
+	return nil
+}
+
+// forEach calls fn in key order for every record under prefix with the key
+// suffix and value.
+func (tx *DBTx) forEach(prefix string, fn func(key, val []byte) error) error {
+	records := make(map[string][]byte)
+	it := tx.r.NewIterator([]byte(prefix))
+	for it.Next() {
+		records[string(it.Key())] = append([]byte(nil), it.Value()...)
+	}
+	err := it.Error()
+	it.Release()
+	if err != nil {
+		return err
+	}
+	for k, v := range tx.pending {
+		if len(k) < len(prefix) || k[:len(prefix)] != prefix {
+			continue
+		}
+		if v == nil {
+			delete(records, k)
+		} else {
+			records[k] = v
+		}
+	}
+	keys := make([]string, 0, len(records))
+	for k := range records {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if err := fn([]byte(k[len(prefix):]), records[k]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func encodeUint32(v uint32) []byte {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	return b[:]
+}
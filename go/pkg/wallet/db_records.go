@@ -0,0 +1,471 @@
+package wallet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+
+	"pila/pkg/coin"
+	"pila/pkg/coin/keys"
+)
+
+// Wallet record key prefixes, named after the db_wallet record types.
+const (
+	keyPrefix          = "key:"
+	cryptedKeyPrefix   = "ckey:"
+	masterKeyPrefix    = "mkey:"
+	keyMetaPrefix      = "keymeta:"
+	poolPrefix         = "pool:"
+	namePrefix         = "name:"
+	txPrefix           = "tx:"
+	settingPrefix      = "setting:"
+	accountPrefix      = "acc:"
+	acentryPrefix      = "acentry:"
+	watchScriptPrefix  = "watchs:"
+	scriptPrefix       = "cscript:"
+	watchXPubPrefix    = "watchx:"
+	spentPrefix        = "spent:"
+	bestBlockKey       = "bestblock"
+	hdConfigurationKey = "hdconfiguration"
+)
+
+// KeyPoolEntry is a pre-generated key waiting to be handed out.
+type KeyPoolEntry struct {
+	Time   int64
+	PubKey []byte
+}
+
+// BlockLocator lists block hashes from the tip backwards, thinning out
+// exponentially, so the wallet can find the fork point after a restart.
+type BlockLocator []string
+
+func idKey(prefix string, id coin.IDKey) string { return prefix + string(id[:]) }
+
+func indexKey(prefix string, n uint64) string {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], n)
+	return prefix + string(b[:])
+}
+
+// WriteKey stores a plaintext private key.
+func (tx *DBTx) WriteKey(k *keys.PrivateKey) error {
+	var buf bytes.Buffer
+	coin.WriteVarBytes(&buf, k.PubKey().Bytes())
+	coin.WriteVarBytes(&buf, k.Bytes())
+	return tx.put(idKey(keyPrefix, k.PubKey().ID()), buf.Bytes())
+}
+
+// ForEachKey calls fn for every plaintext key.
+func (tx *DBTx) ForEachKey(fn func(k *keys.PrivateKey) error) error {
+	return tx.forEach(keyPrefix, func(_, val []byte) error {
+		r := bytes.NewReader(val)
+		pub, err := coin.ReadVarBytes(r)
+		if err != nil {
+			return err
+		}
+		secret, err := coin.ReadVarBytes(r)
+		if err != nil {
+			return err
+		}
+		k, err := checkedKey(secret, pub)
+		if err != nil {
+			return err
+		}
+		return fn(k)
+	})
+}
+
+// WriteCryptedKey stores an encrypted private key and erases the plaintext
+// record of the same key.
+func (tx *DBTx) WriteCryptedKey(pub *keys.PublicKey, secret []byte) error {
+	var buf bytes.Buffer
+	coin.WriteVarBytes(&buf, pub.Bytes())
+	coin.WriteVarBytes(&buf, secret)
+	if err := tx.put(idKey(cryptedKeyPrefix, pub.ID()), buf.Bytes()); err != nil {
+		return err
+	}
+	return tx.delete(idKey(keyPrefix, pub.ID()))
+}
+
+// ForEachCryptedKey calls fn for every encrypted key.
+func (tx *DBTx) ForEachCryptedKey(fn func(pub *keys.PublicKey, secret []byte) error) error {
+	return tx.forEach(cryptedKeyPrefix, func(_, val []byte) error {
+		r := bytes.NewReader(val)
+		raw, err := coin.ReadVarBytes(r)
+		if err != nil {
+			return err
+		}
+		pub, err := keys.ParsePublicKey(raw)
+		if err != nil {
+			return err
+		}
+		secret, err := coin.ReadVarBytes(r)
+		if err != nil {
+			return err
+		}
+		return fn(pub, secret)
+	})
+}
+
+// WriteMasterKey stores master key id.
+func (tx *DBTx) WriteMasterKey(id uint32, m MasterKey) error {
+	return tx.put(indexKey(masterKeyPrefix, uint64(id)), m.Encode())
+}
+
+// EraseMasterKey removes master key id.
+func (tx *DBTx) EraseMasterKey(id uint32) error {
+	return tx.delete(indexKey(masterKeyPrefix, uint64(id)))
+}
+
+// ForEachMasterKey calls fn for every master key.
+func (tx *DBTx) ForEachMasterKey(fn func(id uint32, m MasterKey) error) error {
+	return tx.forEach(masterKeyPrefix, func(key, val []byte) error {
+		if len(key) != 8 {
+			return errors.New("invalid master key record")
+		}
+		m, err := DecodeMasterKey(val)
+		if err != nil {
+			return err
+		}
+		return fn(uint32(binary.BigEndian.Uint64(key)), m)
+	})
+}
+
+// WritePool stores key pool entry index.
+func (tx *DBTx) WritePool(index int64, e KeyPoolEntry) error {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, e.Time)
+	coin.WriteVarBytes(&buf, e.PubKey)
+	return tx.put(indexKey(poolPrefix, uint64(index)), buf.Bytes())
+}
+
+func decodePoolEntry(val []byte) (KeyPoolEntry, error) {
+	var e KeyPoolEntry
+	r := bytes.NewReader(val)
+	if err := binary.Read(r, binary.LittleEndian, &e.Time); err != nil {
+		return KeyPoolEntry{}, err
+	}
+	var err error
+	e.PubKey, err = coin.ReadVarBytes(r)
+	return e, err
+}
+
+// ReadPool returns key pool entry index.
+func (tx *DBTx) ReadPool(index int64) (KeyPoolEntry, error) {
+	val, err := tx.get(indexKey(poolPrefix, uint64(index)))
+	if err != nil {
+		return KeyPoolEntry{}, err
+	}
+	return decodePoolEntry(val)
+}
+
+// ErasePool removes key pool entry index.
+func (tx *DBTx) ErasePool(index int64) error {
+	return tx.delete(indexKey(poolPrefix, uint64(index)))
+}
+
+// ForEachPool calls fn for every key pool entry in index order.
+func (tx *DBTx) ForEachPool(fn func(index int64, e KeyPoolEntry) error) error {
+	return tx.forEach(poolPrefix, func(key, val []byte) error {
+		if len(key) != 8 {
+			return errors.New("invalid key pool record")
+		}
+		e, err := decodePoolEntry(val)
+		if err != nil {
+			return err
+		}
+		return fn(int64(binary.BigEndian.Uint64(key)), e)
+	})
+}
+
+// WriteKeyMeta stores the creation time of the key id.
+func (tx *DBTx) WriteKeyMeta(id coin.IDKey, created int64) error {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], uint64(created))
+	return tx.put(idKey(keyMetaPrefix, id), b[:])
+}
+
+// ForEachKeyMeta calls fn for every key creation time.
+func (tx *DBTx) ForEachKeyMeta(fn func(id coin.IDKey, created int64) error) error {
+	return tx.forEach(keyMetaPrefix, func(key, val []byte) error {
+		if len(key) != len(coin.IDKey{}) || len(val) != 8 {
+			return errors.New("invalid key metadata record")
+		}
+		var id coin.IDKey
+		copy(id[:], key)
+		return fn(id, int64(binary.LittleEndian.Uint64(val)))
+	})
+}
+
+// WriteName sets the address book label of address.
+func (tx *DBTx) WriteName(address, label string) error {
+	return tx.put(namePrefix+address, []byte(label))
+}
+
+// EraseName removes address from the address book.
+func (tx *DBTx) EraseName(address string) error {
+	return tx.delete(namePrefix + address)
+}
+
+// ForEachName calls fn for every address book entry.
+func (tx *DBTx) ForEachName(fn func(address, label string) error) error {
+	return tx.forEach(namePrefix, func(key, val []byte) error {
+		return fn(string(key), string(val))
+	})
+}
+
+// WriteAccount stores the receiving public key of account.
+func (tx *DBTx) WriteAccount(account string, pub []byte) error {
+	return tx.put(accountPrefix+account, pub)
+}
+
+// ReadAccount returns the receiving public key of account.
+func (tx *DBTx) ReadAccount(account string) ([]byte, error) {
+	return tx.get(accountPrefix + account)
+}
+
+func acentryKey(account string, number uint64) string {
+	var buf bytes.Buffer
+	buf.WriteString(acentryPrefix)
+	coin.WriteVarBytes(&buf, []byte(account))
+	binary.Write(&buf, binary.BigEndian, number)
+	return buf.String()
+}
+
+// WriteAccountingEntry stores e under its account and entry number.
+func (tx *DBTx) WriteAccountingEntry(e AccountingEntry) error {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, e.CreditDebit)
+	binary.Write(&buf, binary.LittleEndian, e.Time)
+	coin.WriteVarBytes(&buf, []byte(e.OtherAccount))
+	coin.WriteVarBytes(&buf, []byte(e.Comment))
+	binary.Write(&buf, binary.LittleEndian, e.OrderPos)
+	return tx.put(acentryKey(e.Account, e.EntryNumber), buf.Bytes())
+}
+
+// ForEachAccountingEntry calls fn for every accounting entry in account
+// and entry number order.
+func (tx *DBTx) ForEachAccountingEntry(fn func(e AccountingEntry) error) error {
+	return tx.forEach(acentryPrefix, func(key, val []byte) error {
+		var e AccountingEntry
+		kr := bytes.NewReader(key)
+		account, err := coin.ReadVarBytes(kr)
+		if err != nil {
+			return err
+		}
+		if err := binary.Read(kr, binary.BigEndian, &e.EntryNumber); err != nil || kr.Len() != 0 {
+			return errors.New("invalid accounting entry key")
+		}
+		e.Account = string(account)
+		r := bytes.NewReader(val)
+		if err := binary.Read(r, binary.LittleEndian, &e.CreditDebit); err != nil {
+			return err
+		}
+		if err := binary.Read(r, binary.LittleEndian, &e.Time); err != nil {
+			return err
+		}
+		other, err := coin.ReadVarBytes(r)
+		if err != nil {
+			return err
+		}
+		comment, err := coin.ReadVarBytes(r)
+		if err != nil {
+			return err
+		}
+		e.OtherAccount, e.Comment = string(other), string(comment)
+		if err := binary.Read(r, binary.LittleEndian, &e.OrderPos); err != nil {
+			return err
+		}
+		return fn(e)
+	})
+}
+
+// WriteScript stores a redeem script under its hash.
+func (tx *DBTx) WriteScript(redeem []byte) error {
+	id := coin.ScriptHash(redeem)
+	return tx.put(scriptPrefix+string(id[:]), redeem)
+}
+
+// ForEachScript calls fn for every redeem script.
+func (tx *DBTx) ForEachScript(fn func(redeem []byte) error) error {
+	return tx.forEach(scriptPrefix, func(_, val []byte) error {
+		return fn(val)
+	})
+}
+
+// WriteWatchScript stores a watch-only script.
+func (tx *DBTx) WriteWatchScript(script []byte) error {
+	return tx.put(watchScriptPrefix+string(script), nil)
+}
+
+// EraseWatchScript removes a watch-only script.
+func (tx *DBTx) EraseWatchScript(script []byte) error {
+	return tx.delete(watchScriptPrefix + string(script))
+}
+
+// ForEachWatchScript calls fn for every watch-only script.
+func (tx *DBTx) ForEachWatchScript(fn func(script []byte) error) error {
+	return tx.forEach(watchScriptPrefix, func(key, _ []byte) error {
+		return fn(append([]byte(nil), key...))
+	})
+}
+
+// WriteWatchXPub stores a watched extended public key with the number of
+// used keys on its receive and change branches.
+func (tx *DBTx) WriteWatchXPub(xpub string, used [2]uint32) error {
+	var b [8]byte
+	binary.LittleEndian.PutUint32(b[:4], used[0])
+	binary.LittleEndian.PutUint32(b[4:], used[1])
+	return tx.put(watchXPubPrefix+xpub, b[:])
+}
+
+// ForEachWatchXPub calls fn for every watched extended public key.
+func (tx *DBTx) ForEachWatchXPub(fn func(xpub string, used [2]uint32) error) error {
+	return tx.forEach(watchXPubPrefix, func(key, val []byte) error {
+		if len(val) != 8 {
+			return errors.New("invalid watched xpub record")
+		}
+		used := [2]uint32{binary.LittleEndian.Uint32(val[:4]), binary.LittleEndian.Uint32(val[4:])}
+		return fn(string(key), used)
+	})
+}
+
+// WriteTx stores a wallet transaction under its hash.
+func (tx *DBTx) WriteTx(w *WalletTx) error {
+	data, err := w.serialize()
+	if err != nil {
+		return err
+	}
+	return tx.put(txPrefix+w.Hash(), data)
+}
+
+// ReadTx returns the wallet transaction with hash.
+func (tx *DBTx) ReadTx(hash string) (*WalletTx, error) {
+	val, err := tx.get(txPrefix + hash)
+	if err != nil {
+		return nil, err
+	}
+	w := new(WalletTx)
+	if err := w.Decode(bytes.NewReader(val)); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// EraseTx removes the wallet transaction with hash.
+func (tx *DBTx) EraseTx(hash string) error {
+	return tx.delete(txPrefix + hash)
+}
+
+// ForEachTx calls fn for every wallet transaction.
+func (tx *DBTx) ForEachTx(fn func(w *WalletTx) error) error {
+	return tx.forEach(txPrefix, func(_, val []byte) error {
+		w := new(WalletTx)
+		if err := w.Decode(bytes.NewReader(val)); err != nil {
+			return err
+		}
+		return fn(w)
+	})
+}
+
+func outKey(prefix string, out coin.PointOut) string {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], out.Index)
+	return prefix + out.Hash + string(b[:])
+}
+
+// parseOutKey decodes the part of an outKey after its prefix.
+func parseOutKey(key []byte) (coin.PointOut, error) {
+	if len(key) < 4 {
+		return coin.PointOut{}, errors.New("invalid outpoint record")
+	}
+	n := len(key) - 4
+	return coin.PointOut{Hash: string(key[:n]), Index: binary.BigEndian.Uint32(key[n:])}, nil
+}
+
+// WriteSpent marks out as spent by a transaction outside the wallet.
+func (tx *DBTx) WriteSpent(out coin.PointOut) error {
+	return tx.put(outKey(spentPrefix, out), nil)
+}
+
+// EraseSpent removes the mark written by WriteSpent.
+func (tx *DBTx) EraseSpent(out coin.PointOut) error {
+	return tx.delete(outKey(spentPrefix, out))
+}
+
+// ForEachSpent calls fn for every output marked by WriteSpent.
+func (tx *DBTx) ForEachSpent(fn func(out coin.PointOut) error) error {
+	return tx.forEach(spentPrefix, func(key, _ []byte) error {
+		out, err := parseOutKey(key)
+		if err != nil {
+			return err
+		}
+		return fn(out)
+	})
+}
+
+// WriteBestBlock records the chain position the wallet is synced to.
+func (tx *DBTx) WriteBestBlock(l BlockLocator) error {
+	var buf bytes.Buffer
+	coin.WriteVarInt(&buf, uint64(len(l)))
+	for _, h := range l {
+		coin.WriteVarBytes(&buf, []byte(h))
+	}
+	return tx.put(bestBlockKey, buf.Bytes())
+}
+
+// ReadBestBlock returns the locator written by WriteBestBlock.
+func (tx *DBTx) ReadBestBlock() (BlockLocator, error) {
+	val, err := tx.get(bestBlockKey)
+	if err != nil {
+		return nil, err
+	}
+	r := bytes.NewReader(val)
+	n, err := coin.ReadVarInt(r)
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(len(val)) {
+		return nil, errors.New("invalid block locator")
+	}
+	l := make(BlockLocator, n)
+	for i := range l {
+		h, err := coin.ReadVarBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		l[i] = string(h)
+	}
+	return l, nil
+}
+
+// WriteSetting stores a wallet setting.
+func (tx *DBTx) WriteSetting(name string, val []byte) error {
+	return tx.put(settingPrefix+name, val)
+}
+
+// ReadSetting returns a wallet setting.
+func (tx *DBTx) ReadSetting(name string) ([]byte, error) {
+	return tx.get(settingPrefix + name)
+}
+
+// EraseSetting removes a wallet setting.
+func (tx *DBTx) EraseSetting(name string) error {
+	return tx.delete(settingPrefix + name)
+}
+
+// WriteHDConfiguration stores the key chain state.
+func (tx *DBTx) WriteHDConfiguration(c keys.HDConfiguration) error {
+	return tx.put(hdConfigurationKey, c.Encode())
+}
+
+// ReadHDConfiguration returns the key chain state.
+func (tx *DBTx) ReadHDConfiguration() (keys.HDConfiguration, error) {
+	var c keys.HDConfiguration
+	val, err := tx.get(hdConfigurationKey)
+	if err != nil {
+		return c, err
+	}
+	err = c.Decode(val)
+	return c, err
+}
@@ -0,0 +1,91 @@
+package wallet
+
+import (
+	"errors"
+	"testing"
+
+	"pila/pkg/coin"
+	"pila/pkg/coin/keys"
+	"pila/pkg/database"
+)
+
+func TestDBUpdateIsAtomic(t *testing.T) {
+	db, err := NewDB(database.NewMemStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	wtx := &WalletTx{
+		Tx:           coin.Transaction{Version: 1, Outputs: []coin.TxOut{{Value: coin.Coin, ScriptPubKey: []byte{coin.OP_TRUE}}}},
+		BlockHash:    "00ff",
+		BlockHeight:  7,
+		TimeReceived: 1234,
+		FromAccount:  "savings",
+	}
+	failed := errors.New("crash")
+	err = db.Update(func(tx *DBTx) error {
+		if err := tx.WriteTx(wtx); err != nil {
+			return err
+		}
+		if got, err := tx.ReadTx(wtx.Hash()); err != nil || got.BlockHeight != 7 {
+			t.Fatalf("transaction does not see its own write: %v", err)
+		}
+		return failed
+	})
+	if err != failed {
+		t.Fatalf("unexpected error %v", err)
+	}
+	db.View(func(tx *DBTx) error {
+		if _, err := tx.ReadTx(wtx.Hash()); err != database.ErrNotFound {
+			t.Fatalf("aborted write was committed: %v", err)
+		}
+		if err := tx.WriteName("addr", "x"); err != ErrReadOnly {
+			t.Fatalf("expected read-only view, got %v", err)
+		}
+		return nil
+	})
+
+	k, _ := keys.NewPrivateKey(true)
+	err = db.Update(func(tx *DBTx) error {
+		tx.WriteTx(wtx)
+		tx.WriteKey(k)
+		tx.WriteName("addr", "label")
+		tx.WritePool(2, KeyPoolEntry{Time: 5, PubKey: k.PubKey().Bytes()})
+		tx.WritePool(1, KeyPoolEntry{Time: 4, PubKey: k.PubKey().Bytes()})
+		tx.WriteBestBlock(BlockLocator{"aa", "bb"})
+		return tx.WriteSetting("fee", []byte{1})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.View(func(tx *DBTx) error {
+		got, err := tx.ReadTx(wtx.Hash())
+		if err != nil || got.FromAccount != "savings" || got.TimeReceived != 1234 || got.Hash() != wtx.Hash() {
+			t.Fatalf("wallet tx round trip: %+v %v", got, err)
+		}
+		var pool []int64
+		tx.ForEachPool(func(i int64, e KeyPoolEntry) error {
+			pool = append(pool, i)
+			return nil
+		})
+		if len(pool) != 2 || pool[0] != 1 {
+			t.Fatalf("key pool order %v", pool)
+		}
+		if l, err := tx.ReadBestBlock(); err != nil || len(l) != 2 || l[1] != "bb" {
+			t.Fatalf("best block %v %v", l, err)
+		}
+		n := 0
+		tx.ForEachKey(func(*keys.PrivateKey) error { n++; return nil })
+		if n != 1 {
+			t.Fatalf("expected one key, got %d", n)
+		}
+		return nil
+	})
+}
+
+func TestDBRejectsNewerVersion(t *testing.T) {
+	store := database.NewMemStore()
+	store.Put([]byte(dbVersionKey), encodeUint32(DBVersion+1))
+	if _, err := NewDB(store); err == nil {
+		t.Fatalf("newer wallet accepted")
+	}
+}
@@ -0,0 +1,269 @@
+package wallet
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"pila/pkg/coin"
+	"pila/pkg/coin/keys"
+	"pila/pkg/database"
+)
+
+// dumpTimeFormat is the time format of dump files.
+const dumpTimeFormat = "2006-01-02T15:04:05Z"
+
+// keyAddress returns the address of the key id.
+func keyAddress(id coin.IDKey) string {
+	var a coin.Address
+	a.SetIDKey(id)
+	return a.String()
+}
+
+// DumpPrivKey returns the WIF encoded private key of address
+// (dumpprivkey). The wallet must be unlocked.
+func (w *Wallet) DumpPrivKey(address string) (string, error) {
+	var a coin.Address
+	if !a.SetString(address) || !a.IsValid() {
+		return "", fmt.Errorf("invalid address %q", address)
+	}
+	id, ok := a.GetIDKey()
+	if !ok {
+		return "", errors.New("address does not refer to a key")
+	}
+	k, err := w.keys.GetKey(id)
+	if err != nil {
+		return "", err
+	}
+	return keys.EncodeWIF(k), nil
+}
+
+// DumpWalletSeed returns the hex encoded seed of the HD key chain
+// (dumpwalletseed), which with the hd configuration recreates every
+// derived key. The wallet must be unlocked.
+func (w *Wallet) DumpWalletSeed() (string, error) {
+	hd, err := w.readHDConfiguration()
+	if err != nil {
+		return "", err
+	}
+	if hd.IsEmpty() {
+		return "", ErrNotDeterministic
+	}
+	k, err := w.keys.GetKey(hd.IDKeyMaster)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(k.Bytes()), nil
+}
+
+// importKey adds k created at the unix time created and files its address
+// under label. It reports whether the key is new.
+func (w *Wallet) importKey(k *keys.PrivateKey, created int64, label string) (bool, error) {
+	id := k.PubKey().ID()
+	if err := w.SetLabel(keyAddress(id), label); err != nil {
+		return false, err
+	}
+	if w.keys.HaveKey(id) {
+		return false, nil
+	}
+	return true, w.keys.addKey(k, created)
+}
+
+// ImportPrivKey adds a WIF encoded key and files its address under label
+// (importprivkey). The blocks from birth on are rescanned for payments to
+// the key, a zero birth meaning the whole chain: right away when src is
+// not nil, otherwise on the next Sync.
+func (w *Wallet) ImportPrivKey(wif, label string, src ChainSource, birth time.Time) error {
+	k, err := keys.DecodeWIF(wif)
+	if err != nil {
+		return err
+	}
+	created := birth.Unix()
+	if birth.IsZero() {
+		created = 0
+	}
+	added, err := w.importKey(k, created, label)
+	if err != nil || !added {
+		return err
+	}
+	if src == nil {
+		return w.scheduleRescan(created)
+	}
+	if birth.IsZero() {
+		return w.Rescan(src, 0)
+	}
+	return w.RescanFromTime(src, birth)
+}
+
+// DumpWallet writes every key of the wallet to out as text (dumpwallet):
+// one line per key with the WIF secret, the creation time, its label or
+// role and the address. The HD seed heads the dump. The wallet must be
+// unlocked.
+func (w *Wallet) DumpWallet(out io.Writer) error {
+	ids := w.keys.KeyIDs()
+	sort.Slice(ids, func(i, j int) bool { return w.keys.KeyTime(ids[i]) < w.keys.KeyTime(ids[j]) })
+	hd, err := w.readHDConfiguration()
+	if err != nil {
+		return err
+	}
+	pool := w.pool.keyIDs()
+
+	bw := bufio.NewWriter(out)
+	fmt.Fprintf(bw, "# Wallet dump created by pila\n")
+	fmt.Fprintf(bw, "# * Created on %s\n", time.Now().UTC().Format(dumpTimeFormat))
+	fmt.Fprintf(bw, "# * Best block at time of backup was %d\n", w.BestHeight())
+	if !hd.IsEmpty() {
+		seed, err := w.DumpWalletSeed()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(bw, "# hd seed: %s\n", seed)
+		if hd.ChainCode != nil {
+			fmt.Fprintf(bw, "# hd chain code: %x\n", hd.ChainCode)
+		}
+		fmt.Fprintf(bw, "# hd key index: %d\n", hd.Index)
+	}
+	fmt.Fprintln(bw)
+	for _, id := range ids {
+		k, err := w.keys.GetKey(id)
+		if err != nil {
+			return err
+		}
+		address := keyAddress(id)
+		created := time.Unix(w.keys.KeyTime(id), 0).UTC().Format(dumpTimeFormat)
+		var role string
+		label, labeled := w.labelOf(address)
+		switch {
+		case id == hd.IDKeyMaster:
+			role = "hdmaster=1"
+		case labeled:
+			role = "label=" + url.QueryEscape(label)
+		case pool[id]:
+			role = "reserve=1"
+		default:
+			role = "change=1"
+		}
+		fmt.Fprintf(bw, "%s %s %s # addr=%s\n", keys.EncodeWIF(k), created, role, address)
+	}
+	fmt.Fprintf(bw, "\n# End of dump\n")
+	return bw.Flush()
+}
+
+// ImportWallet adds the keys and labels of a dump written by DumpWallet
+// (importwallet). The chain is rescanned from the creation time of the
+// oldest key that was not already in the wallet: right away when src is
+// not nil, otherwise on the next Sync.
+func (w *Wallet) ImportWallet(in io.Reader, src ChainSource) error {
+	var oldest int64 = -1
+	scanner := bufio.NewScanner(in)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if i := strings.Index(text, "#"); i >= 0 {
+			text = text[:i]
+		}
+		fields := strings.Fields(text)
+		if len(fields) < 2 {
+			return fmt.Errorf("line %d: missing fields", line)
+		}
+		k, err := keys.DecodeWIF(fields[0])
+		if err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		t, err := time.Parse(dumpTimeFormat, fields[1])
+		if err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		label, labeled := "", false
+		for _, f := range fields[2:] {
+			if v, ok := strings.CutPrefix(f, "label="); ok {
+				if label, err = url.QueryUnescape(v); err != nil {
+					return fmt.Errorf("line %d: %v", line, err)
+				}
+				labeled = true
+			}
+		}
+		var added bool
+		if labeled {
+			added, err = w.importKey(k, t.Unix(), label)
+		} else if !w.keys.HaveKey(k.PubKey().ID()) {
+			added, err = true, w.keys.addKey(k, t.Unix())
+		}
+		if err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		if added && (oldest < 0 || t.Unix() < oldest) {
+			oldest = t.Unix()
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if oldest < 0 {
+		return nil
+	}
+	if src == nil {
+		return w.scheduleRescan(oldest)
+	}
+	return w.RescanFromTime(src, time.Unix(oldest, 0))
+}
+
+// Backup copies a consistent snapshot of the database to dst.
+func (d *DB) Backup(dst database.Store) error {
+	return d.View(func(tx *DBTx) error {
+		it := tx.r.NewIterator(nil)
+		defer it.Release()
+		b := database.NewBatch()
+		for it.Next() {
+			b.Put(it.Key(), it.Value())
+			if b.Len() >= 1000 {
+				if err := dst.Write(b); err != nil {
+					return err
+				}
+				b.Reset()
+			}
+		}
+		if err := it.Error(); err != nil {
+			return err
+		}
+		return dst.Write(b)
+	})
+}
+
+// Backup writes a snapshot of the wallet database to a new LevelDB store
+// at path (backupwallet) while the wallet keeps running. When path is an
+// existing directory the backup is created inside it with a timestamped
+// name. Encrypted keys stay encrypted in the backup.
+func (w *Wallet) Backup(path string) (string, error) {
+	if w.db == nil {
+		return "", errors.New("wallet is not stored")
+	}
+	if fi, err := os.Stat(path); err == nil {
+		if !fi.IsDir() {
+			return "", fmt.Errorf("%s already exists", path)
+		}
+		path = filepath.Join(path, "wallet-"+time.Now().UTC().Format("20060102-150405"))
+		if _, err := os.Stat(path); err == nil {
+			return "", fmt.Errorf("%s already exists", path)
+		}
+	}
+	store, err := database.OpenLevelStore(path)
+	if err != nil {
+		return "", err
+	}
+	if err := w.db.Backup(store); err != nil {
+		store.Close()
+		return "", err
+	}
+	return path, store.Close()
+}
@@ -0,0 +1,176 @@
+package wallet
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"pila/pkg/coin"
+	"pila/pkg/coin/keys"
+	"pila/pkg/database"
+)
+
+// payTo returns a transaction paying value to address.
+func payTo(t *testing.T, address string, value int64, version uint32) coin.Transaction {
+	t.Helper()
+	var a coin.Address
+	if !a.SetString(address) {
+		t.Fatalf("bad address %s", address)
+	}
+	script, _ := coin.PayToDestinationScript(a.Get())
+	return coin.Transaction{Version: version, Outputs: []coin.TxOut{{Value: value, ScriptPubKey: script}}}
+}
+
+func TestDumpAndImportWallet(t *testing.T) {
+	db, _ := NewDB(database.NewMemStore())
+	cfg := DefaultConfig()
+	cfg.KeyPoolSize = 2
+	w, err := New(db, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	address, err := w.NewAddress("savings & more")
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain := testChain{}.add(coinbaseTo(nil, 0, 0), payTo(t, address, 3*coin.Coin, 1))
+	// Rescans after an import start at the key creation time.
+	for i := range chain {
+		chain[i].Header.Timestamp += uint32(time.Now().Unix())
+	}
+	if err := w.Sync(chain); err != nil {
+		t.Fatal(err)
+	}
+
+	var dump bytes.Buffer
+	if err := w.DumpWallet(&dump); err != nil {
+		t.Fatal(err)
+	}
+	seed, err := w.DumpWalletSeed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(dump.String(), "# hd seed: "+seed) || !strings.Contains(dump.String(), "label=savings+%26+more") {
+		t.Fatalf("dump missing seed or label:\n%s", dump.String())
+	}
+
+	other, err := New(nil, Config{KeyPoolSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := other.ImportWallet(&dump, chain); err != nil {
+		t.Fatal(err)
+	}
+	if other.Label(address) != "savings & more" {
+		t.Fatalf("label %q", other.Label(address))
+	}
+	if b := other.Balance(); b.Confirmed != 3*coin.Coin {
+		t.Fatalf("imported balance %+v", b)
+	}
+	a, _ := w.DumpPrivKey(address)
+	b, err := other.DumpPrivKey(address)
+	if err != nil || a != b {
+		t.Fatalf("imported key %s, want %s: %v", b, a, err)
+	}
+}
+
+func TestImportPrivKey(t *testing.T) {
+	w, err := New(nil, Config{KeyPoolSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, address := testAddress(t)
+	chain := testChain{}.add(coinbaseTo(nil, 0, 0), payTo(t, address, 2*coin.Coin, 1))
+	if err := w.Sync(chain); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.DumpWalletSeed(); err != ErrNotDeterministic {
+		t.Fatalf("seed of a non-HD wallet: %v", err)
+	}
+	if err := w.ImportPrivKey("not a key", "", nil, time.Time{}); err == nil {
+		t.Fatalf("invalid key accepted")
+	}
+	if err := w.ImportPrivKey(keys.EncodeWIF(k), "paper", chain, time.Unix(0, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if b := w.Balance(); b.Confirmed != 2*coin.Coin || w.Label(address) != "paper" {
+		t.Fatalf("balance %+v label %q after import", b, w.Label(address))
+	}
+	if wif, err := w.DumpPrivKey(address); err != nil || wif != keys.EncodeWIF(k) {
+		t.Fatalf("dumped %s: %v", wif, err)
+	}
+}
+
+func TestImportPrivKeyRescansOnSync(t *testing.T) {
+	db, _ := NewDB(database.NewMemStore())
+	w, err := New(db, Config{KeyPoolSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, address := testAddress(t)
+	chain := testChain{}.add(coinbaseTo(nil, 0, 0), payTo(t, address, 2*coin.Coin, 1))
+	if err := w.Sync(chain); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a chain source the import leaves the rescan to the next sync.
+	if err := w.ImportPrivKey(keys.EncodeWIF(k), "", nil, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if b := w.Balance(); b.Confirmed != 0 {
+		t.Fatalf("balance %+v before the rescan", b)
+	}
+	reopened, err := New(db, Config{KeyPoolSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reopened.Sync(chain); err != nil {
+		t.Fatal(err)
+	}
+	if b := reopened.Balance(); b.Confirmed != 2*coin.Coin {
+		t.Fatalf("balance %+v after the rescan", b)
+	}
+	if _, pending, err := reopened.pendingRescan(); pending || err != nil {
+		t.Fatalf("rescan still pending: %v", err)
+	}
+}
+
+func TestBackup(t *testing.T) {
+	db, _ := NewDB(database.NewMemStore())
+	cfg := DefaultConfig()
+	cfg.KeyPoolSize = 1
+	w, err := New(db, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	address, err := w.NewAddress("backed up")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := database.NewMemStore()
+	if err := db.Backup(store); err != nil {
+		t.Fatal(err)
+	}
+	copied, _ := NewDB(store)
+	restored, err := New(copied, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Label(address) != "backed up" || !restored.IsMine(payTo(t, address, 0, 1).Outputs[0].ScriptPubKey) {
+		t.Fatalf("backup is missing %s", address)
+	}
+	dir := t.TempDir()
+	if _, err := w.Backup(dir); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "wallet.dat")
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Backup(file); err == nil {
+		t.Fatalf("backup overwrote %s", file)
+	}
+}
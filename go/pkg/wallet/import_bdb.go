@@ -0,0 +1,413 @@
+package wallet
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"pila/pkg/coin"
+	"pila/pkg/coin/keys"
+)
+
+// ImportSummary counts the records converted by ImportBerkeleyWallet.
+type ImportSummary struct {
+	Keys         int
+	CryptedKeys  int
+	MasterKeys   int
+	Pool         int
+	Names        int
+	Scripts      int
+	Transactions int
+	BestBlock    bool
+	HDChain      bool
+	// Skipped counts records by type that were not imported, either
+	// because the type has no Go equivalent or because they are corrupt.
+	Skipped map[string]int
+}
+
+// ImportBerkeleyWallet converts the C++ client's Berkeley DB wallet.dat at
+// path into db. All records are written in a single transaction.
+//
+// Transactions are re-keyed by their Go hash, and outpoints spending other
+// imported transactions are rewritten to match. Outpoints into transactions
+// the wallet did not keep retain their C++ hashes, and the C++ transaction
+// time field has no Go equivalent and is dropped; a rescan rebuilds
+// confirmation data.
+func ImportBerkeleyWallet(path string, db *DB) (*ImportSummary, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return importBerkeleyWallet(data, db)
+}
+
+func importBerkeleyWallet(data []byte, db *DB) (*ImportSummary, error) {
+	f, err := openBDB(data)
+	if err != nil {
+		return nil, err
+	}
+	records, err := f.walletRecords()
+	if err != nil {
+		return nil, err
+	}
+	sum := &ImportSummary{Skipped: make(map[string]int)}
+	txs := make(map[string]*WalletTx)
+	err = db.Update(func(tx *DBTx) error {
+		for _, r := range records {
+			if err := importRecord(tx, r, sum, txs); err != nil {
+				return err
+			}
+		}
+		return writeImportedTxs(tx, txs)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sum, nil
+}
+
+// importRecord converts one wallet.dat record. Transactions are collected
+// in txs by C++ hash and written once every record has been read.
+func importRecord(tx *DBTx, r bdbRecord, sum *ImportSummary, txs map[string]*WalletTx) error {
+	key := &cppReader{r: bytes.NewReader(r.Key)}
+	val := &cppReader{r: bytes.NewReader(r.Value)}
+	typ := string(key.varBytes())
+	if key.err != nil {
+		sum.Skipped["?"]++
+		return nil
+	}
+	switch typ {
+	case "key":
+		pub := key.varBytes()
+		der := val.varBytes()
+		if err := firstErr(key.err, val.err); err != nil {
+			return fmt.Errorf("key record: %v", err)
+		}
+		secret, err := parseDERPrivateKey(der)
+		if err != nil {
+			return fmt.Errorf("key record: %v", err)
+		}
+		k, err := checkedKey(secret, pub)
+		if err != nil {
+			return fmt.Errorf("key record: %v", err)
+		}
+		sum.Keys++
+		return tx.WriteKey(k)
+	case "ckey":
+		raw := key.varBytes()
+		secret := val.varBytes()
+		if err := firstErr(key.err, val.err); err != nil {
+			return fmt.Errorf("ckey record: %v", err)
+		}
+		pub, err := keys.ParsePublicKey(raw)
+		if err != nil {
+			return fmt.Errorf("ckey record: %v", err)
+		}
+		sum.CryptedKeys++
+		return tx.WriteCryptedKey(pub, secret)
+	case "mkey":
+		id := key.uint32()
+		var m MasterKey
+		m.CryptedKey = val.varBytes()
+		m.Salt = val.varBytes()
+		m.DerivationMethod = val.uint32()
+		m.DeriveIterations = val.uint32()
+		if err := firstErr(key.err, val.err); err != nil {
+			return fmt.Errorf("mkey record: %v", err)
+		}
+		sum.MasterKeys++
+		return tx.WriteMasterKey(id, m)
+	case "pool":
+		index := key.int64()
+		val.uint32() // client version
+		e := KeyPoolEntry{Time: val.int64(), PubKey: val.varBytes()}
+		if err := firstErr(key.err, val.err); err != nil {
+			return fmt.Errorf("pool record: %v", err)
+		}
+		sum.Pool++
+		return tx.WritePool(index, e)
+	case "name":
+		address := string(key.varBytes())
+		label := string(val.varBytes())
+		if err := firstErr(key.err, val.err); err != nil {
+			return fmt.Errorf("name record: %v", err)
+		}
+		sum.Names++
+		return tx.WriteName(address, label)
+	case "cscript":
+		id := key.bytes(len(coin.IDScript{}))
+		redeem := val.varBytes()
+		if err := firstErr(key.err, val.err); err != nil {
+			return fmt.Errorf("cscript record: %v", err)
+		}
+		if hash := coin.ScriptHash(redeem); !bytes.Equal(hash[:], id) {
+			sum.Skipped[typ]++
+			return nil
+		}
+		sum.Scripts++
+		return tx.WriteScript(redeem)
+	case "tx":
+		hash := key.hash()
+		wtx, raw := readCppWalletTx(val)
+		if err := firstErr(key.err, val.err); err != nil {
+			return fmt.Errorf("tx record: %v", err)
+		}
+		if coin.DoubleSHA256(raw) != hash {
+			sum.Skipped[typ]++
+			return nil
+		}
+		sum.Transactions++
+		txs[cppHashString(hash)] = wtx
+		return nil
+	case "bestblock":
+		val.uint32() // client version
+		n := val.varInt()
+		if n > uint64(len(r.Value)) {
+			return errors.New("bestblock record: invalid length")
+		}
+		l := make(BlockLocator, 0, n)
+		for i := uint64(0); i < n; i++ {
+			l = append(l, cppHashString(val.hash()))
+		}
+		if val.err != nil {
+			return fmt.Errorf("bestblock record: %v", val.err)
+		}
+		sum.BestBlock = true
+		return tx.WriteBestBlock(l)
+	case "hdconfiguration", "hdchain":
+		if typ == "hdconfiguration" {
+			val.varInt()
+		}
+		var c keys.HDConfiguration
+		rest := val.bytes(4 + 4 + 20)
+		if val.err != nil {
+			return fmt.Errorf("%s record: %v", typ, val.err)
+		}
+		if err := c.Decode(rest); err != nil {
+			return err
+		}
+		// Every C++ chain derives hardened keys, whatever its version.
+		c.Version = keys.HDConfigurationVersionHardened
+		sum.HDChain = true
+		return tx.WriteHDConfiguration(c)
+	default:
+		sum.Skipped[typ]++
+		return nil
+	}
+}
+
+// writeImportedTxs stores the transactions of tx records under their Go
+// hash. Their inputs name parents by C++ hash, so every outpoint into an
+// imported parent is first rewritten to the parent's Go hash, which in turn
+// depends on the parent's own inputs; otherwise spends would not link and
+// spent outputs would count as balance.
+func writeImportedTxs(tx *DBTx, txs map[string]*WalletTx) error {
+	goHashes := make(map[string]string, len(txs))
+	var resolve func(cppHash string) string
+	resolve = func(cppHash string) string {
+		if h, ok := goHashes[cppHash]; ok {
+			return h
+		}
+		w := txs[cppHash]
+		for i := range w.Tx.Inputs {
+			prev := &w.Tx.Inputs[i].PreviousOut
+			if _, ok := txs[prev.Hash]; ok {
+				prev.Hash = resolve(prev.Hash)
+			}
+		}
+		h := w.Hash()
+		goHashes[cppHash] = h
+		return h
+	}
+	for cppHash, w := range txs {
+		resolve(cppHash)
+		if err := tx.WriteTx(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readCppWalletTx decodes a C++ transaction_wallet and returns it together
+// with the raw bytes of its transaction, which hash to the record key.
+func readCppWalletTx(r *cppReader) (*WalletTx, []byte) {
+	w := new(WalletTx)
+	var raw []byte
+	w.Tx, raw = readCppTransaction(r)
+	if block := r.hash(); block != ([32]byte{}) {
+		w.BlockHash = cppHashString(block)
+	}
+	r.skipMerkleBranch()
+	// Previous transactions kept for relaying unconfirmed chains.
+	for n := r.varInt(); n > 0 && r.err == nil; n-- {
+		readCppTransaction(r)
+		r.hash()
+		r.skipMerkleBranch()
+	}
+	values := make(map[string]string)
+	for n := r.varInt(); n > 0 && r.err == nil; n-- {
+		k := string(r.varBytes())
+		values[k] = string(r.varBytes())
+	}
+	for n := r.varInt(); n > 0 && r.err == nil; n-- {
+		r.varBytes()
+		r.varBytes()
+	}
+	r.uint32() // time received is tx time
+	w.TimeReceived = int64(r.uint32())
+	w.FromAccount = values["fromaccount"]
+	w.OrderPos = -1
+	if n, err := strconv.ParseInt(values["n"], 10, 64); err == nil {
+		w.OrderPos = n
+	}
+	return w, raw
+}
+
+// readCppTransaction decodes a C++ transaction, which carries a time field
+// after the version.
+func readCppTransaction(r *cppReader) (coin.Transaction, []byte) {
+	start := r.offset()
+	var tx coin.Transaction
+	tx.Version = r.uint32()
+	r.uint32() // time
+	for n := r.varInt(); n > 0 && r.err == nil; n-- {
+		var in coin.TxIn
+		in.PreviousOut.Hash = cppHashString(r.hash())
+		in.PreviousOut.Index = r.uint32()
+		in.ScriptSig = r.varBytes()
+		in.Sequence = r.uint32()
+		tx.Inputs = append(tx.Inputs, in)
+	}
+	for n := r.varInt(); n > 0 && r.err == nil; n-- {
+		var out coin.TxOut
+		out.Value = r.int64()
+		out.ScriptPubKey = r.varBytes()
+		tx.Outputs = append(tx.Outputs, out)
+	}
+	tx.LockTime = r.uint32()
+	return tx, r.since(start)
+}
+
+// cppHashString formats a digest the way sha256::to_string does, byte
+// reversed.
+func cppHashString(h [32]byte) string {
+	var rev [32]byte
+	for i := range h {
+		rev[i] = h[31-i]
+	}
+	return hex.EncodeToString(rev[:])
+}
+
+// parseDERPrivateKey extracts the secret of an OpenSSL DER ECPrivateKey as
+// stored in "key" records.
+func parseDERPrivateKey(der []byte) ([]byte, error) {
+	var k struct {
+		Version    int
+		PrivateKey []byte
+		Params     asn1.RawValue `asn1:"optional,explicit,tag:0"`
+		PublicKey  asn1.RawValue `asn1:"optional,explicit,tag:1"`
+	}
+	if _, err := asn1.Unmarshal(der, &k); err != nil {
+		return nil, fmt.Errorf("invalid DER private key: %v", err)
+	}
+	if k.Version != 1 || len(k.PrivateKey) > keys.PrivateKeySize {
+		return nil, errors.New("unsupported DER private key")
+	}
+	secret := make([]byte, keys.PrivateKeySize)
+	copy(secret[keys.PrivateKeySize-len(k.PrivateKey):], k.PrivateKey)
+	return secret, nil
+}
+
+// cppReader decodes the C++ data_buffer format. The first error sticks and
+// makes every later read return zero values.
+type cppReader struct {
+	r   *bytes.Reader
+	err error
+}
+
+func firstErr(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *cppReader) offset() int64 {
+	return c.r.Size() - int64(c.r.Len())
+}
+
+// since returns the bytes read after offset start.
+func (c *cppReader) since(start int64) []byte {
+	end := c.offset()
+	buf := make([]byte, end-start)
+	c.r.ReadAt(buf, start)
+	return buf
+}
+
+func (c *cppReader) bytes(n int) []byte {
+	if c.err != nil {
+		return nil
+	}
+	if n > c.r.Len() {
+		c.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	b := make([]byte, n)
+	io.ReadFull(c.r, b)
+	return b
+}
+
+func (c *cppReader) varInt() uint64 {
+	if c.err != nil {
+		return 0
+	}
+	n, err := coin.ReadVarInt(c.r)
+	c.err = err
+	return n
+}
+
+func (c *cppReader) varBytes() []byte {
+	n := c.varInt()
+	if n > uint64(c.r.Len()) {
+		c.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	return c.bytes(int(n))
+}
+
+func (c *cppReader) uint32() uint32 {
+	b := c.bytes(4)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(b)
+}
+
+func (c *cppReader) int64() int64 {
+	b := c.bytes(8)
+	if b == nil {
+		return 0
+	}
+	return int64(binary.LittleEndian.Uint64(b))
+}
+
+func (c *cppReader) hash() [32]byte {
+	var h [32]byte
+	copy(h[:], c.bytes(32))
+	return h
+}
+
+func (c *cppReader) skipMerkleBranch() {
+	for n := c.varInt(); n > 0 && c.err == nil; n-- {
+		c.hash()
+	}
+	c.uint32() // index
+}
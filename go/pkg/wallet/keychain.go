@@ -0,0 +1,190 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"pila/pkg/coin"
+	"pila/pkg/coin/keys"
+)
+
+var (
+	// ErrNotDeterministic is returned for HD operations on a wallet
+	// created with wallet.deterministic off.
+	ErrNotDeterministic = errors.New("wallet is not deterministic")
+	// ErrHardenedKeyChain is returned by AccountXPub for chains that only
+	// derive hardened keys, which no extended public key can follow.
+	ErrHardenedKeyChain = errors.New("key chain derives hardened keys only")
+)
+
+// KeyChain hands out new wallet keys. Deterministic chains derive key n as
+// m/0'/0'/0/n from the master key, or as m/0'/0'/n' for chains created by
+// the C++ wallet, so every key can be recovered from the master alone;
+// other chains generate random keys.
+type KeyChain struct {
+	mu     sync.Mutex
+	config keys.HDConfiguration
+	master *keys.ExtendedKey
+	// account is m/0'/0', the key AccountXPub exports.
+	account *keys.ExtendedKey
+	// external is m/0'/0'/0, the parent of every derived key, or nil for
+	// hardened chains, whose keys are children of account.
+	external *keys.ExtendedKey
+}
+
+// NewKeyChain creates the key chain of a new wallet following cfg. A
+// deterministic chain comes from cfg.Seed when set and from a random master
+// key otherwise. The master key is returned for the caller to store with
+// the other keys; random chains return a nil key.
+func NewKeyChain(cfg Config) (*KeyChain, *keys.PrivateKey, error) {
+	if cfg.Seed != nil {
+		c, err := KeyChainFromSeed(cfg.Seed, keys.HDConfiguration{})
+		if err != nil {
+			return nil, nil, err
+		}
+		master, err := c.master.PrivateKey()
+		if err != nil {
+			return nil, nil, err
+		}
+		return c, master, nil
+	}
+	if !cfg.Deterministic {
+		return &KeyChain{}, nil, nil
+	}
+	master, err := keys.NewPrivateKey(true)
+	if err != nil {
+		return nil, nil, err
+	}
+	c, err := RestoreKeyChain(master, keys.HDConfiguration{})
+	if err != nil {
+		return nil, nil, err
+	}
+	return c, master, nil
+}
+
+// RestoreKeyChain loads a deterministic key chain from its master key and
+// saved configuration. When the configuration holds no chain code the seed
+// of the chain is the secret of master, as in the C++ wallet.
+func RestoreKeyChain(master *keys.PrivateKey, config keys.HDConfiguration) (*KeyChain, error) {
+	id := master.PubKey().ID()
+	if config.IsEmpty() {
+		config.Version = keys.HDConfigurationVersion
+	} else if config.IDKeyMaster != id {
+		return nil, errors.New("master key does not match the hd configuration")
+	}
+	config.IDKeyMaster = id
+
+	var ext *keys.ExtendedKey
+	var err error
+	if config.ChainCode != nil {
+		ext, err = keys.MasterKeyFromChainCode(master, config.ChainCode)
+	} else {
+		ext, err = keys.MasterKeyFromPrivateKey(master)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newKeyChain(ext, config)
+}
+
+// KeyChainFromSeed creates a deterministic chain from a BIP39 seed. The
+// configuration keeps the chain code of the seed, so RestoreKeyChain later
+// rebuilds the same chain from the master key alone.
+func KeyChainFromSeed(seed []byte, config keys.HDConfiguration) (*KeyChain, error) {
+	ext, err := keys.NewMasterKey(seed)
+	if err != nil {
+		return nil, err
+	}
+	master, err := ext.PrivateKey()
+	if err != nil {
+		return nil, err
+	}
+	config.ChainCode = ext.ChainCode()
+	return RestoreKeyChain(master, config)
+}
+
+func newKeyChain(master *keys.ExtendedKey, config keys.HDConfiguration) (*KeyChain, error) {
+	account, err := master.Derive("m/0'/0'")
+	if err != nil {
+		return nil, err
+	}
+	c := &KeyChain{config: config, master: master, account: account}
+	if config.Version != keys.HDConfigurationVersionHardened {
+		if c.external, err = account.Child(0); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// IsDeterministic reports whether keys are derived from a master key.
+func (c *KeyChain) IsDeterministic() bool { return c.master != nil }
+
+// Configuration returns the state to persist after NewKey.
+func (c *KeyChain) Configuration() keys.HDConfiguration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.config
+}
+
+// Master returns the master extended private key.
+func (c *KeyChain) Master() (*keys.ExtendedKey, error) {
+	if c.master == nil {
+		return nil, ErrNotDeterministic
+	}
+	return c.master, nil
+}
+
+// AccountXPub returns the extended public key of m/0'/0' for watch-only
+// services, which follow the wallet keys as xpub/0/n. Chains of the C++
+// wallet derive hardened keys and fail with ErrHardenedKeyChain.
+func (c *KeyChain) AccountXPub() (string, error) {
+	if c.account == nil {
+		return "", ErrNotDeterministic
+	}
+	if c.external == nil {
+		return "", ErrHardenedKeyChain
+	}
+	return c.account.Public().String(), nil
+}
+
+// NewKey returns the next key. Derived keys for which have reports true,
+// e.g. after restoring an older backup, are skipped.
+func (c *KeyChain) NewKey(have func(coin.IDKey) bool) (*keys.PrivateKey, error) {
+	if c.master == nil {
+		return keys.NewPrivateKey(true)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for {
+		index := c.config.Index
+		if index >= keys.HardenedKeyStart {
+			return nil, errors.New("key chain exhausted")
+		}
+		c.config.Index++
+		child, err := c.child(index)
+		if err == keys.ErrInvalidChild {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("derive key %d: %v", index, err)
+		}
+		k, err := child.PrivateKey()
+		if err != nil {
+			return nil, err
+		}
+		if have != nil && have(k.PubKey().ID()) {
+			continue
+		}
+		return k, nil
+	}
+}
+
+// child derives key index of the chain.
+func (c *KeyChain) child(index uint32) (*keys.ExtendedKey, error) {
+	if c.external == nil {
+		return c.account.Child(keys.HardenedKeyStart | index)
+	}
+	return c.external.Child(index)
+}
@@ -0,0 +1,146 @@
+package wallet
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"pila/pkg/coin"
+	"pila/pkg/coin/keys"
+	"pila/pkg/database"
+)
+
+func TestKeyChainDeterministic(t *testing.T) {
+	chain, master, err := NewKeyChain(DefaultConfig())
+	if err != nil || master == nil || !chain.IsDeterministic() {
+		t.Fatalf("new chain: %v", err)
+	}
+	first, _ := chain.NewKey(nil)
+	second, _ := chain.NewKey(nil)
+	if chain.Configuration().Index != 2 {
+		t.Fatalf("index %d", chain.Configuration().Index)
+	}
+
+	restored, err := RestoreKeyChain(master, chainConfigAt(chain, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first key is already in the wallet and must be skipped.
+	have := func(id coin.IDKey) bool { return id == first.PubKey().ID() }
+	k, err := restored.NewKey(have)
+	if err != nil || !bytes.Equal(k.Bytes(), second.Bytes()) {
+		t.Fatalf("restored chain derived a different key: %v", err)
+	}
+}
+
+func chainConfigAt(c *KeyChain, index uint32) keys.HDConfiguration {
+	cfg := c.Configuration()
+	cfg.Index = index
+	return cfg
+}
+
+func TestKeyChainRandom(t *testing.T) {
+	chain, master, err := NewKeyChain(Config{Deterministic: false})
+	if err != nil || master != nil || chain.IsDeterministic() {
+		t.Fatalf("expected a random chain: %v", err)
+	}
+	a, _ := chain.NewKey(nil)
+	b, _ := chain.NewKey(nil)
+	if bytes.Equal(a.Bytes(), b.Bytes()) {
+		t.Fatalf("random keys repeat")
+	}
+	if _, err := chain.AccountXPub(); err != ErrNotDeterministic {
+		t.Fatalf("expected ErrNotDeterministic, got %v", err)
+	}
+}
+
+func TestKeyChainAccountXPub(t *testing.T) {
+	chain, _, err := NewKeyChain(DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := chain.AccountXPub()
+	if err != nil {
+		t.Fatal(err)
+	}
+	xpub, err := keys.ParseExtendedKey(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A watch-only service following xpub/0/n sees every wallet key.
+	for n := 0; n < 3; n++ {
+		k, err := chain.NewKey(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		child, err := xpub.Derive(fmt.Sprintf("M/0/%d", n))
+		if err != nil {
+			t.Fatal(err)
+		}
+		pub, _ := child.PublicKey()
+		if pub.ID() != k.PubKey().ID() {
+			t.Fatalf("key %d is not xpub/0/%d", n, n)
+		}
+	}
+}
+
+func TestKeyChainHardened(t *testing.T) {
+	master, _ := keys.NewPrivateKey(true)
+	hd := keys.HDConfiguration{Version: keys.HDConfigurationVersionHardened, IDKeyMaster: master.PubKey().ID()}
+	chain, err := RestoreKeyChain(master, hd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := chain.NewKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ext, _ := keys.MasterKeyFromPrivateKey(master)
+	want, _ := ext.Derive("m/0'/0'/0'")
+	wantKey, _ := want.PrivateKey()
+	if !bytes.Equal(k.Bytes(), wantKey.Bytes()) {
+		t.Fatalf("C++ chain did not derive m/0'/0'/0'")
+	}
+	if _, err := chain.AccountXPub(); err != ErrHardenedKeyChain {
+		t.Fatalf("expected ErrHardenedKeyChain, got %v", err)
+	}
+}
+
+func TestKeyChainSeedRestore(t *testing.T) {
+	seed, err := keys.MnemonicSeed("abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about", "TREZOR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := NewDB(database.NewMemStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := DefaultConfig()
+	cfg.Seed = seed
+	if _, err := New(db, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reopening without the seed must rebuild the chain of the seed.
+	w, err := New(db, DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	master, err := w.pool.chain.Master()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := keys.NewMasterKey(seed)
+	if master.String() != want.String() {
+		t.Fatalf("restored master %s, want %s", master, want)
+	}
+	k, err := w.pool.chain.NewKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	child, _ := want.Derive(fmt.Sprintf("m/0'/0'/0/%d", w.pool.chain.Configuration().Index-1))
+	wantKey, _ := child.PrivateKey()
+	if !bytes.Equal(k.Bytes(), wantKey.Bytes()) {
+		t.Fatalf("restored chain derived a different key")
+	}
+}
@@ -0,0 +1,315 @@
+package wallet
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"pila/pkg/coin"
+	"pila/pkg/coin/keys"
+)
+
+// KeyPoolRefillInterval is how often the background refill checks whether
+// the wallet is unlocked and the pool needs new keys.
+const KeyPoolRefillInterval = 10 * time.Second
+
+// ErrKeyPoolEmpty is returned when the pool has no key left and new keys
+// cannot be generated because the wallet is locked.
+var ErrKeyPoolEmpty = errors.New("keypool ran out, please unlock the wallet to refill it")
+
+// KeyPool keeps pre-generated keys so new addresses and change outputs are
+// already covered by a backup taken before they were handed out
+// (key_pool). Keys are taken from the lowest index and new keys are
+// appended after the highest one.
+type KeyPool struct {
+	mu    sync.Mutex
+	db    *DB
+	store *KeyStore
+	chain *KeyChain
+	size  int
+
+	entries map[int64]KeyPoolEntry
+	// indexes holds the indexes of entries not reserved, sorted.
+	indexes []int64
+	next    int64
+
+	wake chan struct{}
+}
+
+// NewKeyPool loads the pool entries of db. Generated keys are added to store
+// from chain, which may be nil until the wallet is unlocked. A nil db keeps
+// the pool in memory only.
+func NewKeyPool(db *DB, store *KeyStore, chain *KeyChain, size int) (*KeyPool, error) {
+	if size < 0 {
+		size = 0
+	}
+	p := &KeyPool{
+		db:      db,
+		store:   store,
+		chain:   chain,
+		size:    size,
+		entries: make(map[int64]KeyPoolEntry),
+		next:    1,
+		wake:    make(chan struct{}, 1),
+	}
+	if db == nil {
+		return p, nil
+	}
+	err := db.View(func(tx *DBTx) error {
+		return tx.ForEachPool(func(index int64, e KeyPoolEntry) error {
+			p.entries[index] = e
+			p.indexes = append(p.indexes, index)
+			if index >= p.next {
+				p.next = index + 1
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *KeyPool) update(fn func(tx *DBTx) error) error {
+	if p.db == nil {
+		return nil
+	}
+	return p.db.Update(fn)
+}
+
+func (p *KeyPool) hasChain() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.chain != nil
+}
+
+func (p *KeyPool) setChain(c *KeyChain) {
+	p.mu.Lock()
+	p.chain = c
+	p.mu.Unlock()
+}
+
+// Size returns the number of keys available in the pool.
+func (p *KeyPool) Size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.indexes)
+}
+
+// keyIDs returns the ids of the keys waiting in the pool.
+func (p *KeyPool) keyIDs() map[coin.IDKey]bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ids := make(map[coin.IDKey]bool, len(p.indexes))
+	for _, index := range p.indexes {
+		ids[coin.SHA256RIPEMD160(p.entries[index].PubKey)] = true
+	}
+	return ids
+}
+
+// OldestKeyTime returns the creation time of the oldest key in the pool, or
+// the current time when the pool is empty.
+func (p *KeyPool) OldestKeyTime() int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.indexes) == 0 {
+		return time.Now().Unix()
+	}
+	return p.entries[p.indexes[0]].Time
+}
+
+// TopUp generates keys until the pool holds its configured size. It
+// returns ErrLocked when the wallet is locked.
+func (p *KeyPool) TopUp() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.topUp()
+}
+
+func (p *KeyPool) topUp() error {
+	if p.store.IsLocked() || p.chain == nil {
+		return ErrLocked
+	}
+	// The C++ wallet keeps one key more than configured.
+	for len(p.indexes) < p.size+1 {
+		k, err := p.newKey()
+		if err != nil {
+			return err
+		}
+		index := p.next
+		e := KeyPoolEntry{Time: time.Now().Unix(), PubKey: k.PubKey().Bytes()}
+		err = p.update(func(tx *DBTx) error {
+			if p.chain.IsDeterministic() {
+				if err := tx.WriteHDConfiguration(p.chain.Configuration()); err != nil {
+					return err
+				}
+			}
+			return tx.WritePool(index, e)
+		})
+		if err != nil {
+			return err
+		}
+		p.next++
+		p.entries[index] = e
+		p.indexes = append(p.indexes, index)
+	}
+	return nil
+}
+
+// newKey generates a key from the chain and adds it to the key store.
+func (p *KeyPool) newKey() (*keys.PrivateKey, error) {
+	k, err := p.chain.NewKey(p.store.HaveKey)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.store.AddKey(k); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Reserve takes the oldest key out of the pool. The key must be released
+// with Keep once it has been used or Return when it was not, e.g. because
+// sending failed.
+func (p *KeyPool) Reserve() (*ReservedKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.indexes) == 0 {
+		if err := p.topUp(); err != nil && err != ErrLocked {
+			return nil, err
+		}
+		if len(p.indexes) == 0 {
+			return nil, ErrKeyPoolEmpty
+		}
+	}
+	index := p.indexes[0]
+	pub, err := keys.ParsePublicKey(p.entries[index].PubKey)
+	if err != nil {
+		return nil, err
+	}
+	if !p.store.HaveKey(pub.ID()) {
+		return nil, errors.New("unknown key in key pool")
+	}
+	p.indexes = p.indexes[1:]
+	p.signal()
+	return &ReservedKey{pool: p, index: index, pub: pub}, nil
+}
+
+// GetKey returns a key from the pool for immediate use, generating a new
+// key when the pool is empty and the wallet is unlocked.
+func (p *KeyPool) GetKey() (*keys.PublicKey, error) {
+	r, err := p.Reserve()
+	if err == ErrKeyPoolEmpty {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if p.store.IsLocked() || p.chain == nil {
+			return nil, ErrKeyPoolEmpty
+		}
+		k, err := p.newKey()
+		if err != nil {
+			return nil, err
+		}
+		return k.PubKey(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := r.Keep(); err != nil {
+		return nil, err
+	}
+	return r.PubKey(), nil
+}
+
+// keep removes a used key from the pool.
+func (p *KeyPool) keep(index int64) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.update(func(tx *DBTx) error { return tx.ErasePool(index) }); err != nil {
+		return err
+	}
+	delete(p.entries, index)
+	return nil
+}
+
+// give puts a reserved key back into the pool.
+func (p *KeyPool) give(index int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.entries[index]; !ok {
+		return
+	}
+	i := sort.Search(len(p.indexes), func(i int) bool { return p.indexes[i] >= index })
+	if i < len(p.indexes) && p.indexes[i] == index {
+		return
+	}
+	p.indexes = append(p.indexes, 0)
+	copy(p.indexes[i+1:], p.indexes[i:])
+	p.indexes[i] = index
+}
+
+// signal wakes the background refill without blocking.
+func (p *KeyPool) signal() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Wake asks the background refill to top the pool up now, e.g. right after
+// the wallet has been unlocked.
+func (p *KeyPool) Wake() { p.signal() }
+
+// Refill tops the pool up in the background whenever the wallet is
+// unlocked, checking every interval and whenever a key is reserved. It
+// returns when stop is closed.
+func (p *KeyPool) Refill(stop <-chan struct{}, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		case <-p.wake:
+		}
+		if p.store.IsLocked() {
+			continue
+		}
+		p.TopUp()
+	}
+}
+
+// ReservedKey is a key taken out of the pool and not yet committed to
+// (key_reserved).
+type ReservedKey struct {
+	pool  *KeyPool
+	index int64
+	pub   *keys.PublicKey
+	done  bool
+}
+
+// PubKey returns the reserved public key.
+func (r *ReservedKey) PubKey() *keys.PublicKey { return r.pub }
+
+// Keep removes the key from the pool for good.
+func (r *ReservedKey) Keep() error {
+	if r.done {
+		return nil
+	}
+	if err := r.pool.keep(r.index); err != nil {
+		return err
+	}
+	r.done = true
+	return nil
+}
+
+// Return puts the key back into the pool so it is handed out again.
+func (r *ReservedKey) Return() {
+	if r.done {
+		return
+	}
+	r.pool.give(r.index)
+	r.done = true
+}
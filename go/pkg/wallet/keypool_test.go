@@ -0,0 +1,122 @@
+package wallet
+
+import (
+	"testing"
+	"time"
+
+	"pila/pkg/database"
+)
+
+func TestKeyPoolReserve(t *testing.T) {
+	store := database.NewMemStore()
+	db, err := NewDB(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ks, _ := NewKeyStore(db)
+	chain, _, _ := NewKeyChain(DefaultConfig())
+	pool, err := NewKeyPool(db, ks, chain, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.TopUp(); err != nil || pool.Size() != 4 {
+		t.Fatalf("top up: size %d %v", pool.Size(), err)
+	}
+
+	r, err := pool.Reserve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := r.PubKey().ID()
+	r.Return()
+	r, _ = pool.Reserve()
+	if r.PubKey().ID() != first {
+		t.Fatalf("returned key was not handed out again")
+	}
+	if err := r.Keep(); err != nil {
+		t.Fatal(err)
+	}
+	if pool.Size() != 3 {
+		t.Fatalf("size after keep %d", pool.Size())
+	}
+
+	// The kept key is gone after reloading, the others survive.
+	reloaded, err := NewKeyPool(db, ks, chain, 3)
+	if err != nil || reloaded.Size() != 3 {
+		t.Fatalf("reload: size %d %v", reloaded.Size(), err)
+	}
+	r, _ = reloaded.Reserve()
+	if r.PubKey().ID() == first {
+		t.Fatalf("kept key still in the pool")
+	}
+	var saved bool
+	db.View(func(tx *DBTx) error {
+		cfg, err := tx.ReadHDConfiguration()
+		saved = err == nil && cfg.Index == 4
+		return nil
+	})
+	if !saved {
+		t.Fatalf("hd configuration not saved with the pool")
+	}
+}
+
+func TestKeyPoolLocked(t *testing.T) {
+	ks, _ := NewKeyStore(nil)
+	chain, _, _ := NewKeyChain(Config{})
+	pool, _ := NewKeyPool(nil, ks, chain, 1)
+	if err := ks.EncryptWallet("secret", 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pool.Reserve(); err != ErrKeyPoolEmpty {
+		t.Fatalf("expected empty pool, got %v", err)
+	}
+	if err := ks.Unlock("secret", 0); err != nil {
+		t.Fatal(err)
+	}
+	stop := make(chan struct{})
+	go pool.Refill(stop, time.Hour)
+	pool.Wake()
+	for i := 0; pool.Size() != 2; i++ {
+		if i == 100 {
+			t.Fatalf("background refill did not top up")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(stop)
+	if _, err := pool.GetKey(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWalletRunRefillsPool(t *testing.T) {
+	w, err := New(nil, Config{Deterministic: true, KeyPoolSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.KeyStore().EncryptWallet("secret", 1); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		r, err := w.KeyPool().Reserve()
+		if err == ErrKeyPoolEmpty {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Keep()
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go w.Run(stop)
+	if err := w.Unlock("secret", 0); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; w.KeyPool().Size() == 0; i++ {
+		if i == 100 {
+			t.Fatalf("unlock did not refill the pool")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
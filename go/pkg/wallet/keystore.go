@@ -0,0 +1,513 @@
+package wallet
+
+import (
+	"crypto/rand"
+	"errors"
+	"sync"
+	"time"
+
+	"pila/pkg/coin"
+	"pila/pkg/coin/keys"
+)
+
+// DefaultUnlockIterationTarget is how long deriving the master key from a
+// passphrase should take when no iteration count is given.
+const DefaultUnlockIterationTarget = 100 * time.Millisecond
+
+var (
+	// ErrLocked is returned when a private key is needed while the wallet
+	// is locked.
+	ErrLocked = errors.New("wallet is locked")
+	// ErrNotEncrypted is returned by passphrase operations on a plaintext
+	// wallet.
+	ErrNotEncrypted = errors.New("wallet is not encrypted")
+	// ErrAlreadyEncrypted is returned when encrypting twice.
+	ErrAlreadyEncrypted = errors.New("wallet is already encrypted")
+	// ErrWrongPassphrase is returned when no master key decrypts.
+	ErrWrongPassphrase = errors.New("passphrase incorrect")
+	// ErrKeyNotFound is returned for keys the wallet does not hold.
+	ErrKeyNotFound = errors.New("key not found")
+	// ErrScriptNotFound is returned for redeem scripts the wallet does
+	// not hold.
+	ErrScriptNotFound = errors.New("redeem script not found")
+	// ErrUnlockedForStaking is returned when a private key is needed for
+	// anything but staking while the wallet is unlocked for staking only.
+	ErrUnlockedForStaking = errors.New("wallet is unlocked for staking only")
+)
+
+// KeyStore holds the wallet keys, encrypted at rest once EncryptWallet has
+// been called (key_store_crypto). While locked only public keys are
+// available.
+type KeyStore struct {
+	mu sync.Mutex
+	db *DB
+
+	keys map[coin.IDKey]*keys.PrivateKey
+	// crypted maps key ids to the public key and encrypted secret.
+	crypted    map[coin.IDKey]cryptedKey
+	masterKeys map[uint32]MasterKey
+	// scripts holds the P2SH redeem scripts by hash.
+	scripts map[coin.IDScript][]byte
+	// created holds key creation times (keymeta).
+	created map[coin.IDKey]int64
+
+	// masterKey is the decrypted wallet master key, nil while locked.
+	masterKey     []byte
+	relock        *time.Timer
+	unlockedUntil time.Time
+	// stakingOnly restricts the unlocked keys to staking.
+	stakingOnly bool
+}
+
+type cryptedKey struct {
+	pub    *keys.PublicKey
+	secret []byte
+}
+
+// NewKeyStore loads the keys found in db. A nil db keeps keys in memory
+// only.
+func NewKeyStore(db *DB) (*KeyStore, error) {
+	ks := &KeyStore{
+		db:         db,
+		keys:       make(map[coin.IDKey]*keys.PrivateKey),
+		crypted:    make(map[coin.IDKey]cryptedKey),
+		masterKeys: make(map[uint32]MasterKey),
+		scripts:    make(map[coin.IDScript][]byte),
+		created:    make(map[coin.IDKey]int64),
+	}
+	if db == nil {
+		return ks, nil
+	}
+	err := db.View(func(tx *DBTx) error {
+		err := tx.ForEachKey(func(k *keys.PrivateKey) error {
+			ks.keys[k.PubKey().ID()] = k
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		err = tx.ForEachCryptedKey(func(pub *keys.PublicKey, secret []byte) error {
+			ks.crypted[pub.ID()] = cryptedKey{pub: pub, secret: secret}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		err = tx.ForEachKeyMeta(func(id coin.IDKey, created int64) error {
+			ks.created[id] = created
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		err = tx.ForEachScript(func(redeem []byte) error {
+			ks.scripts[coin.ScriptHash(redeem)] = redeem
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return tx.ForEachMasterKey(func(id uint32, m MasterKey) error {
+			ks.masterKeys[id] = m
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+// checkedKey rebuilds a private key and verifies it matches pub.
+func checkedKey(secret, pub []byte) (*keys.PrivateKey, error) {
+	p, err := keys.ParsePublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+	k, err := keys.PrivateKeyFromBytes(secret, p.IsCompressed())
+	if err != nil {
+		return nil, err
+	}
+	if !k.PubKey().IsEqual(p) {
+		return nil, errors.New("private key does not match public key")
+	}
+	return k, nil
+}
+
+// update runs fn in a wallet database transaction, or not at all for an
+// in-memory key store.
+func (ks *KeyStore) update(fn func(tx *DBTx) error) error {
+	if ks.db == nil {
+		return nil
+	}
+	return ks.db.Update(fn)
+}
+
+// IsCrypted reports whether the wallet has been encrypted.
+func (ks *KeyStore) IsCrypted() bool {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	return len(ks.masterKeys) > 0
+}
+
+// IsLocked reports whether private keys are unavailable.
+func (ks *KeyStore) IsLocked() bool {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	return len(ks.masterKeys) > 0 && ks.masterKey == nil
+}
+
+// UnlockedUntil returns when a timed unlock expires, or the zero time when
+// the wallet is locked or unlocked without a timeout.
+func (ks *KeyStore) UnlockedUntil() time.Time {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if ks.masterKey == nil {
+		return time.Time{}
+	}
+	return ks.unlockedUntil
+}
+
+// AddKey stores k, encrypted when the wallet is encrypted, with the
+// current time as its creation time.
+func (ks *KeyStore) AddKey(k *keys.PrivateKey) error {
+	return ks.addKey(k, time.Now().Unix())
+}
+
+// addKey stores k created at the unix time created.
+func (ks *KeyStore) addKey(k *keys.PrivateKey, created int64) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	id := k.PubKey().ID()
+	if len(ks.masterKeys) == 0 {
+		err := ks.update(func(tx *DBTx) error {
+			if err := tx.WriteKey(k); err != nil {
+				return err
+			}
+			return tx.WriteKeyMeta(id, created)
+		})
+		if err != nil {
+			return err
+		}
+		ks.keys[id] = k
+		ks.created[id] = created
+		return nil
+	}
+	if ks.masterKey == nil {
+		return ErrLocked
+	}
+	secret, err := encryptSecret(ks.masterKey, k.Bytes(), k.PubKey().Bytes())
+	if err != nil {
+		return err
+	}
+	ck := cryptedKey{pub: k.PubKey(), secret: secret}
+	err = ks.update(func(tx *DBTx) error {
+		if err := tx.WriteCryptedKey(ck.pub, ck.secret); err != nil {
+			return err
+		}
+		return tx.WriteKeyMeta(id, created)
+	})
+	if err != nil {
+		return err
+	}
+	ks.crypted[id] = ck
+	ks.created[id] = created
+	return nil
+}
+
+// KeyTime returns the creation time of the key id, or 0 when unknown.
+func (ks *KeyStore) KeyTime(id coin.IDKey) int64 {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	return ks.created[id]
+}
+
+// HaveKey reports whether the private key of id is in the store.
+func (ks *KeyStore) HaveKey(id coin.IDKey) bool {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	_, plain := ks.keys[id]
+	_, crypted := ks.crypted[id]
+	return plain || crypted
+}
+
+// KeyIDs returns the ids of all keys.
+func (ks *KeyStore) KeyIDs() []coin.IDKey {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ids := make([]coin.IDKey, 0, len(ks.keys)+len(ks.crypted))
+	for id := range ks.keys {
+		ids = append(ids, id)
+	}
+	for id := range ks.crypted {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// GetKey returns the private key of id. It fails with ErrLocked while the
+// wallet is locked and with ErrUnlockedForStaking while it is unlocked for
+// staking only.
+func (ks *KeyStore) GetKey(id coin.IDKey) (*keys.PrivateKey, error) {
+	return ks.getKey(id, false)
+}
+
+// stakingKey returns the private key of id for signing a coinstake or a
+// block, also while the wallet is unlocked for staking only.
+func (ks *KeyStore) stakingKey(id coin.IDKey) (*keys.PrivateKey, error) {
+	return ks.getKey(id, true)
+}
+
+func (ks *KeyStore) getKey(id coin.IDKey, staking bool) (*keys.PrivateKey, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if k, ok := ks.keys[id]; ok {
+		return k, nil
+	}
+	ck, ok := ks.crypted[id]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	if ks.masterKey == nil {
+		return nil, ErrLocked
+	}
+	if ks.stakingOnly && !staking {
+		return nil, ErrUnlockedForStaking
+	}
+	secret, err := decryptSecret(ks.masterKey, ck.secret, ck.pub.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return checkedKey(secret, ck.pub.Bytes())
+}
+
+// GetPubKey returns the public key of id, also while locked.
+func (ks *KeyStore) GetPubKey(id coin.IDKey) (*keys.PublicKey, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if k, ok := ks.keys[id]; ok {
+		return k.PubKey(), nil
+	}
+	if ck, ok := ks.crypted[id]; ok {
+		return ck.pub, nil
+	}
+	return nil, ErrKeyNotFound
+}
+
+// AddScript stores a P2SH redeem script. Scripts are public and are not
+// encrypted.
+func (ks *KeyStore) AddScript(redeem []byte) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if err := ks.update(func(tx *DBTx) error { return tx.WriteScript(redeem) }); err != nil {
+		return err
+	}
+	ks.scripts[coin.ScriptHash(redeem)] = append([]byte(nil), redeem...)
+	return nil
+}
+
+// HaveScript reports whether the redeem script of id is stored.
+func (ks *KeyStore) HaveScript(id coin.IDScript) bool {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	_, ok := ks.scripts[id]
+	return ok
+}
+
+// GetScript returns the redeem script of id.
+func (ks *KeyStore) GetScript(id coin.IDScript) ([]byte, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if redeem, ok := ks.scripts[id]; ok {
+		return redeem, nil
+	}
+	return nil, ErrScriptNotFound
+}
+
+// EncryptWallet encrypts every key under a new random master key protected
+// by passphrase and locks the wallet. iterations of 0 calibrates the count
+// to DefaultUnlockIterationTarget. The store is rewritten in one atomic
+// transaction, so a crash leaves either the plaintext or the encrypted wallet.
+func (ks *KeyStore) EncryptWallet(passphrase string, iterations uint32) error {
+	if passphrase == "" {
+		return errors.New("passphrase must not be empty")
+	}
+	if iterations == 0 {
+		iterations = CalibrateIterations(DefaultUnlockIterationTarget)
+	}
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if len(ks.masterKeys) > 0 {
+		return ErrAlreadyEncrypted
+	}
+	master := make([]byte, WalletKeySize)
+	if _, err := rand.Read(master); err != nil {
+		return err
+	}
+	mk, err := newMasterKey(master, []byte(passphrase), iterations)
+	if err != nil {
+		return err
+	}
+
+	crypted := make(map[coin.IDKey]cryptedKey, len(ks.keys))
+	for id, k := range ks.keys {
+		secret, err := encryptSecret(master, k.Bytes(), k.PubKey().Bytes())
+		if err != nil {
+			return err
+		}
+		crypted[id] = cryptedKey{pub: k.PubKey(), secret: secret}
+	}
+	err = ks.update(func(tx *DBTx) error {
+		for _, ck := range crypted {
+			if err := tx.WriteCryptedKey(ck.pub, ck.secret); err != nil {
+				return err
+			}
+		}
+		return tx.WriteMasterKey(1, mk)
+	})
+	if err != nil {
+		return err
+	}
+	ks.keys = make(map[coin.IDKey]*keys.PrivateKey)
+	ks.crypted = crypted
+	ks.masterKeys[1] = mk
+	return nil
+}
+
+// Unlock decrypts the master key with passphrase. A positive timeout locks
+// the wallet again after that long (walletpassphrase); zero keeps it
+// unlocked until Lock.
+func (ks *KeyStore) Unlock(passphrase string, timeout time.Duration) error {
+	return ks.unlock(passphrase, timeout, false)
+}
+
+// UnlockForStaking unlocks the wallet like Unlock but only lets the keys
+// sign coinstakes and blocks; everything else fails with
+// ErrUnlockedForStaking.
+func (ks *KeyStore) UnlockForStaking(passphrase string, timeout time.Duration) error {
+	return ks.unlock(passphrase, timeout, true)
+}
+
+// IsStakingOnly reports whether the wallet is unlocked for staking only.
+func (ks *KeyStore) IsStakingOnly() bool {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	return ks.stakingOnly
+}
+
+func (ks *KeyStore) unlock(passphrase string, timeout time.Duration, stakingOnly bool) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if len(ks.masterKeys) == 0 {
+		return ErrNotEncrypted
+	}
+	master, err := ks.decryptMasterKey([]byte(passphrase))
+	if err != nil {
+		return err
+	}
+	ks.lock()
+	ks.masterKey = master
+	ks.stakingOnly = stakingOnly
+	if timeout > 0 {
+		ks.unlockedUntil = time.Now().Add(timeout)
+		var t *time.Timer
+		t = time.AfterFunc(timeout, func() {
+			ks.mu.Lock()
+			defer ks.mu.Unlock()
+			// Ignore a timer replaced by a later unlock.
+			if ks.relock == t {
+				ks.lock()
+			}
+		})
+		ks.relock = t
+	}
+	return nil
+}
+
+// decryptMasterKey tries every master key record and checks the result
+// against the stored keys.
+func (ks *KeyStore) decryptMasterKey(passphrase []byte) ([]byte, error) {
+	for _, mk := range ks.masterKeys {
+		master, err := mk.Decrypt(passphrase)
+		if err != nil {
+			continue
+		}
+		if ks.checkMasterKey(master) {
+			return master, nil
+		}
+	}
+	return nil, ErrWrongPassphrase
+}
+
+func (ks *KeyStore) checkMasterKey(master []byte) bool {
+	for _, ck := range ks.crypted {
+		secret, err := decryptSecret(master, ck.secret, ck.pub.Bytes())
+		if err != nil {
+			return false
+		}
+		if _, err := checkedKey(secret, ck.pub.Bytes()); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Lock forgets the master key (walletlock).
+func (ks *KeyStore) Lock() {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.lock()
+}
+
+func (ks *KeyStore) lock() {
+	for i := range ks.masterKey {
+		ks.masterKey[i] = 0
+	}
+	ks.masterKey = nil
+	ks.unlockedUntil = time.Time{}
+	ks.stakingOnly = false
+	if ks.relock != nil {
+		ks.relock.Stop()
+		ks.relock = nil
+	}
+}
+
+// ChangePassphrase re-encrypts the master key under newPassphrase
+// (walletpassphrasechange). The key records are untouched and every master
+// key record is replaced in one atomic write. The wallet is locked
+// afterwards.
+func (ks *KeyStore) ChangePassphrase(oldPassphrase, newPassphrase string, iterations uint32) error {
+	if newPassphrase == "" {
+		return errors.New("passphrase must not be empty")
+	}
+	if iterations == 0 {
+		iterations = CalibrateIterations(DefaultUnlockIterationTarget)
+	}
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if len(ks.masterKeys) == 0 {
+		return ErrNotEncrypted
+	}
+	master, err := ks.decryptMasterKey([]byte(oldPassphrase))
+	if err != nil {
+		return err
+	}
+	mk, err := newMasterKey(master, []byte(newPassphrase), iterations)
+	if err != nil {
+		return err
+	}
+	err = ks.update(func(tx *DBTx) error {
+		for id := range ks.masterKeys {
+			if err := tx.EraseMasterKey(id); err != nil {
+				return err
+			}
+		}
+		return tx.WriteMasterKey(1, mk)
+	})
+	if err != nil {
+		return err
+	}
+	ks.masterKeys = map[uint32]MasterKey{1: mk}
+	ks.lock()
+	return nil
+}
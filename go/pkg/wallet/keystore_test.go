@@ -0,0 +1,97 @@
+package wallet
+
+import (
+	"testing"
+	"time"
+
+	"pila/pkg/coin/keys"
+	"pila/pkg/database"
+)
+
+func TestKeyStoreEncryption(t *testing.T) {
+	store := database.NewMemStore()
+	db, err := NewDB(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ks, err := NewKeyStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, _ := keys.NewPrivateKey(true)
+	if err := ks.AddKey(k); err != nil {
+		t.Fatal(err)
+	}
+	id := k.PubKey().ID()
+
+	if err := ks.EncryptWallet("secret", MinDeriveIterations); err != nil {
+		t.Fatal(err)
+	}
+	if !ks.IsLocked() {
+		t.Fatalf("wallet should be locked after encryption")
+	}
+	if _, err := ks.GetKey(id); err != ErrLocked {
+		t.Fatalf("expected ErrLocked, got %v", err)
+	}
+	if _, err := ks.GetPubKey(id); err != nil {
+		t.Fatalf("public key unavailable while locked: %v", err)
+	}
+	if has, _ := store.Has([]byte(idKey(keyPrefix, id))); has {
+		t.Fatalf("plaintext key left in store")
+	}
+	if err := ks.Unlock("wrong", 0); err != ErrWrongPassphrase {
+		t.Fatalf("expected wrong passphrase, got %v", err)
+	}
+
+	// Reload from the store and unlock with a timeout.
+	ks, err = NewKeyStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.Unlock("secret", 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ks.GetKey(id)
+	if err != nil || string(got.Bytes()) != string(k.Bytes()) {
+		t.Fatalf("decrypted key mismatch: %v", err)
+	}
+	k2, _ := keys.NewPrivateKey(true)
+	if err := ks.AddKey(k2); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if !ks.IsLocked() {
+		t.Fatalf("timed unlock did not expire")
+	}
+
+	if err := ks.ChangePassphrase("secret", "better", MinDeriveIterations); err != nil {
+		t.Fatal(err)
+	}
+	ks, _ = NewKeyStore(db)
+	if err := ks.Unlock("secret", 0); err != ErrWrongPassphrase {
+		t.Fatalf("old passphrase still works: %v", err)
+	}
+	if err := ks.Unlock("better", 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ks.GetKey(k2.PubKey().ID()); err != nil {
+		t.Fatalf("key added while unlocked lost: %v", err)
+	}
+}
+
+func TestMasterKeyEVP(t *testing.T) {
+	master := make([]byte, WalletKeySize)
+	m := MasterKey{Salt: make([]byte, WalletSaltSize), DerivationMethod: DeriveEVPSHA512, DeriveIterations: 10}
+	key, iv, err := m.passphraseKey([]byte("pass"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.CryptedKey, _ = encryptAES(key, iv, master)
+	dec, err := DecodeMasterKey(m.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := dec.Decrypt([]byte("pass")); err != nil || len(got) != WalletKeySize {
+		t.Fatalf("decrypt: %v", err)
+	}
+}
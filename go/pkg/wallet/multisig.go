@@ -0,0 +1,89 @@
+package wallet
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"pila/pkg/coin"
+	"pila/pkg/coin/keys"
+)
+
+// MaxRedeemScriptSize is the largest redeem script a P2SH input can push.
+const MaxRedeemScriptSize = 520
+
+// CreateMultisig returns the P2SH address and redeem script requiring m of
+// pubKeys to sign (createmultisig).
+func CreateMultisig(m int, pubKeys []*keys.PublicKey) (string, []byte, error) {
+	if m < 1 {
+		return "", nil, fmt.Errorf("a multisignature address must require at least one key")
+	}
+	if len(pubKeys) < m {
+		return "", nil, fmt.Errorf("not enough keys supplied (got %d keys, but need at least %d to redeem)", len(pubKeys), m)
+	}
+	raw := make([][]byte, len(pubKeys))
+	for i, pub := range pubKeys {
+		raw[i] = pub.Bytes()
+	}
+	redeem, err := coin.MultiSigScript(m, raw)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(redeem) > MaxRedeemScriptSize {
+		return "", nil, fmt.Errorf("redeem script exceeds size limit: %d > %d", len(redeem), MaxRedeemScriptSize)
+	}
+	var a coin.Address
+	a.SetIDScript(coin.ScriptHash(redeem))
+	return a.String(), redeem, nil
+}
+
+// multisigKey resolves a key given to AddMultisigAddress: an address of a
+// wallet key or a hex encoded public key.
+func (w *Wallet) multisigKey(s string) (*keys.PublicKey, error) {
+	var a coin.Address
+	if a.SetString(s) && a.IsValid() {
+		id, ok := a.GetIDKey()
+		if !ok {
+			return nil, fmt.Errorf("%s does not refer to a key", s)
+		}
+		pub, err := w.keys.GetPubKey(id)
+		if err != nil {
+			return nil, fmt.Errorf("no full public key for address %s", s)
+		}
+		return pub, nil
+	}
+	raw, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %s", s)
+	}
+	pub, err := keys.ParsePublicKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %s", s)
+	}
+	return pub, nil
+}
+
+// AddMultisigAddress creates an m-of-n P2SH address from wallet addresses
+// or hex public keys, stores its redeem script and files it under label
+// (addmultisigaddress). Outputs paying to it are spendable when the
+// wallet holds every key and watch-only otherwise.
+func (w *Wallet) AddMultisigAddress(m int, keyStrings []string, label string) (string, error) {
+	pubs := make([]*keys.PublicKey, len(keyStrings))
+	for i, s := range keyStrings {
+		pub, err := w.multisigKey(s)
+		if err != nil {
+			return "", err
+		}
+		pubs[i] = pub
+	}
+	address, redeem, err := CreateMultisig(m, pubs)
+	if err != nil {
+		return "", err
+	}
+	if err := w.keys.AddScript(redeem); err != nil {
+		return "", err
+	}
+	if err := w.SetLabel(address, label); err != nil {
+		return "", err
+	}
+	return address, nil
+}
@@ -0,0 +1,95 @@
+package wallet
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"pila/pkg/coin"
+	"pila/pkg/database"
+)
+
+func TestMultisigPartialSigning(t *testing.T) {
+	db, _ := NewDB(database.NewMemStore())
+	cfg := DefaultConfig()
+	cfg.KeyPoolSize = 1
+	w, err := New(db, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	own, err := w.NewAddress("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	k2, _ := testAddress(t)
+	k3, _ := testAddress(t)
+	address, err := w.AddMultisigAddress(2, []string{
+		own,
+		hex.EncodeToString(k2.PubKey().Bytes()),
+		hex.EncodeToString(k3.PubKey().Bytes()),
+	}, "treasury")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.AddMultisigAddress(4, []string{own}, ""); err == nil {
+		t.Fatalf("4 of 1 multisig accepted")
+	}
+
+	var a coin.Address
+	a.SetString(address)
+	script, _ := coin.PayToDestinationScript(a.Get())
+	funding := coin.Transaction{Version: 1, Outputs: []coin.TxOut{{Value: 10 * coin.Coin, ScriptPubKey: script}}}
+	if err := w.Sync(testChain{}.add(coinbaseTo(nil, 0, 0), funding)); err != nil {
+		t.Fatal(err)
+	}
+	if b, wb := w.Balance(), w.WatchOnlyBalance(); b.Confirmed != 0 || wb.Confirmed != 10*coin.Coin {
+		t.Fatalf("balance %+v watch-only %+v", b, wb)
+	}
+
+	_, dest := testAddress(t)
+	control := NewCoinControl()
+	control.ChangeAddress = address
+	u, err := w.BuildUnsignedTransaction([]Recipient{{Address: dest, Amount: 4 * coin.Coin}}, BuildOptions{Control: control})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The wallet and the holder of k2 sign independently.
+	mine := withoutSignatures(u.Tx)
+	if complete, err := w.SignRawTransaction(&mine, nil, 0); err != nil || complete {
+		t.Fatalf("one of two signatures complete=%v: %v", complete, err)
+	}
+	redeem := redeemOf(t, w, script)
+	cosigner := NewKeyList(k2)
+	cosigner.AddScript(redeem)
+	theirs := withoutSignatures(u.Tx)
+	if complete, err := SignRawTransaction(&theirs, u.PrevScripts, cosigner, 0); err != nil || complete {
+		t.Fatalf("cosigner alone complete=%v: %v", complete, err)
+	}
+	merged, err := CombineRawTransactions(u.PrevScripts, mine, theirs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, in := range merged.Inputs {
+		if err := VerifyInput(merged, i, u.PrevScripts[in.PreviousOut]); err != nil {
+			t.Fatalf("combined input %d: %v", i, err)
+		}
+	}
+
+	// Signing on top of a partial signature combines the two.
+	third := NewKeyList(k3)
+	third.AddScript(redeem)
+	if complete, err := SignRawTransaction(&mine, u.PrevScripts, third, 0); err != nil || !complete {
+		t.Fatalf("sequential signing complete=%v: %v", complete, err)
+	}
+}
+
+// redeemOf returns the redeem script the wallet stores for a P2SH script.
+func redeemOf(t *testing.T, w *Wallet, script []byte) []byte {
+	t.Helper()
+	_, data := coin.ExtractScript(script)
+	redeem, ok := w.redeemScript(data[0])
+	if !ok {
+		t.Fatalf("redeem script not stored")
+	}
+	return redeem
+}
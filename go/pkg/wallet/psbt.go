@@ -0,0 +1,717 @@
+package wallet
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"pila/pkg/coin"
+	"pila/pkg/coin/keys"
+)
+
+// psbtMagic starts every serialized PSBT.
+var psbtMagic = []byte{'p', 's', 'b', 't', 0xff}
+
+// Record types of the PSBT maps, following BIP174 where it applies. There
+// is no segwit, so inputs always carry the whole previous transaction.
+const (
+	psbtGlobalUnsignedTx = 0x00
+
+	psbtInPrevTx         = 0x00
+	psbtInPartialSig     = 0x02
+	psbtInSigHashType    = 0x03
+	psbtInRedeemScript   = 0x04
+	psbtInDerivation     = 0x06
+	psbtInFinalScriptSig = 0x07
+
+	psbtOutRedeemScript = 0x00
+	psbtOutDerivation   = 0x02
+)
+
+// ErrPSBTIncomplete is returned when extracting a PSBT whose inputs are not
+// all finalized.
+var ErrPSBTIncomplete = errors.New("psbt is not finalized")
+
+// KeyOrigin is the BIP32 derivation of a key: the fingerprint of the
+// extended key the path starts from and the child indexes.
+type KeyOrigin struct {
+	Fingerprint uint32
+	Path        []uint32
+}
+
+// PSBTInput carries what a signer needs to know about one input.
+type PSBTInput struct {
+	// PrevTx is the transaction holding the spent output. Its value and
+	// script are only used once it hashes to the outpoint of the input.
+	PrevTx       *coin.Transaction
+	RedeemScript []byte
+	// Derivations maps hex public keys to their origin.
+	Derivations map[string]KeyOrigin
+	// PartialSigs maps hex public keys to signatures ending with the hash
+	// type byte.
+	PartialSigs map[string][]byte
+	// SigHashType is the hash type to sign with; zero means SigHashAll.
+	SigHashType    uint32
+	FinalScriptSig []byte
+}
+
+// PSBTOutput describes an output, so a signer can recognise its change.
+type PSBTOutput struct {
+	RedeemScript []byte
+	Derivations  map[string]KeyOrigin
+}
+
+// PSBT is a partially signed transaction moved between the wallet that
+// creates it, the signers and the machine that broadcasts it. It follows
+// the create, update, sign, combine, finalize and extract roles of BIP174.
+type PSBT struct {
+	Tx      coin.Transaction
+	Inputs  []PSBTInput
+	Outputs []PSBTOutput
+}
+
+// NewPSBT wraps an unsigned transaction (the creator role).
+func NewPSBT(tx coin.Transaction) (*PSBT, error) {
+	for i, in := range tx.Inputs {
+		if len(in.ScriptSig) > 0 {
+			return nil, fmt.Errorf("input %d is already signed", i)
+		}
+	}
+	tx.Inputs = append([]coin.TxIn(nil), tx.Inputs...)
+	tx.Outputs = append([]coin.TxOut(nil), tx.Outputs...)
+	return &PSBT{
+		Tx:      tx,
+		Inputs:  make([]PSBTInput, len(tx.Inputs)),
+		Outputs: make([]PSBTOutput, len(tx.Outputs)),
+	}, nil
+}
+
+// CreatePSBT builds an unsigned transaction from the watch-only outputs of
+// the wallet as BuildUnsignedTransaction does and returns it updated.
+func (w *Wallet) CreatePSBT(outputs []Recipient, opts BuildOptions) (*PSBT, error) {
+	u, err := w.BuildUnsignedTransaction(outputs, opts)
+	if err != nil {
+		return nil, err
+	}
+	p, err := NewPSBT(u.Tx)
+	if err != nil {
+		return nil, err
+	}
+	w.UpdatePSBT(p)
+	return p, nil
+}
+
+// UpdatePSBT adds what the wallet knows to p (the updater role): the
+// previous wallet transactions, redeem scripts, and the derivations of
+// keys from watched xpubs, for inputs as well as change outputs.
+func (w *Wallet) UpdatePSBT(p *PSBT) {
+	for i, in := range p.Tx.Inputs {
+		pin := &p.Inputs[i]
+		if pin.FinalScriptSig != nil {
+			continue
+		}
+		if pin.PrevTx == nil {
+			if prev, ok := w.Transaction(in.PreviousOut.Hash); ok {
+				tx := prev.Tx
+				pin.PrevTx = &tx
+			}
+		}
+		out, err := p.prevOut(i)
+		if err != nil || out == nil {
+			continue
+		}
+		pin.RedeemScript, pin.Derivations = w.scriptInfo(out.ScriptPubKey, pin.RedeemScript, pin.Derivations)
+	}
+	for i, out := range p.Tx.Outputs {
+		pout := &p.Outputs[i]
+		pout.RedeemScript, pout.Derivations = w.scriptInfo(out.ScriptPubKey, pout.RedeemScript, pout.Derivations)
+	}
+}
+
+// prevOut returns the output input i spends, or nil when its previous
+// transaction is unknown. The previous transaction must hash to the
+// outpoint, or a forged one could misstate the value and script signed
+// for.
+func (p *PSBT) prevOut(i int) (*coin.TxOut, error) {
+	prev := p.Inputs[i].PrevTx
+	if prev == nil {
+		return nil, nil
+	}
+	op := p.Tx.Inputs[i].PreviousOut
+	if prev.Hash() != op.Hash {
+		return nil, fmt.Errorf("input %d: previous transaction does not match the outpoint", i)
+	}
+	if int(op.Index) >= len(prev.Outputs) {
+		return nil, fmt.Errorf("input %d: previous transaction has no output %d", i, op.Index)
+	}
+	return &prev.Outputs[op.Index], nil
+}
+
+// scriptInfo adds the redeem script of a P2SH script and the derivations
+// of its keys to redeem and origins.
+func (w *Wallet) scriptInfo(script, redeem []byte, origins map[string]KeyOrigin) ([]byte, map[string]KeyOrigin) {
+	class, data := coin.ExtractScript(script)
+	if class == coin.ScriptHashTy {
+		if redeem == nil {
+			redeem, _ = w.redeemScript(data[0])
+		}
+		if redeem == nil {
+			return nil, origins
+		}
+		script = redeem
+	}
+	class, data = coin.ExtractScript(script)
+	var pubs [][]byte
+	switch class {
+	case coin.PubKeyTy:
+		pubs = data
+	case coin.PubKeyHashTy:
+		if pub, ok := w.watch.pubKey(script); ok {
+			pubs = [][]byte{pub}
+		}
+	case coin.MultiSigTy:
+		pubs = data[1:]
+	}
+	for _, pub := range pubs {
+		origin, ok := w.watch.origin(pub)
+		if !ok {
+			continue
+		}
+		if origins == nil {
+			origins = make(map[string]KeyOrigin)
+		}
+		origins[hex.EncodeToString(pub)] = origin
+	}
+	return redeem, origins
+}
+
+// Sign adds the signatures of the keys src holds to every input that is
+// not finalized (the signer role). Inputs without a previous transaction
+// are skipped and one that does not match its outpoint is an error.
+// Redeem scripts missing from an input are looked up in src.
+func (p *PSBT) Sign(src KeySource) error {
+	for i := range p.Inputs {
+		pin := &p.Inputs[i]
+		if pin.FinalScriptSig != nil {
+			continue
+		}
+		prev, err := p.prevOut(i)
+		if err != nil {
+			return err
+		}
+		if prev == nil {
+			continue
+		}
+		script := prev.ScriptPubKey
+		class, data := coin.ExtractScript(script)
+		if class == coin.ScriptHashTy {
+			if pin.RedeemScript == nil {
+				var id coin.IDScript
+				copy(id[:], data[0])
+				if redeem, err := src.GetScript(id); err == nil {
+					pin.RedeemScript = redeem
+				}
+			}
+			if pin.RedeemScript == nil {
+				continue
+			}
+			if id := coin.ScriptHash(pin.RedeemScript); !bytes.Equal(id[:], data[0]) {
+				return fmt.Errorf("input %d: redeem script does not match", i)
+			}
+			script = pin.RedeemScript
+			class, data = coin.ExtractScript(script)
+		}
+		var ids []coin.IDKey
+		switch class {
+		case coin.PubKeyTy:
+			ids = append(ids, coin.SHA256RIPEMD160(data[0]))
+		case coin.PubKeyHashTy:
+			var id coin.IDKey
+			copy(id[:], data[0])
+			ids = append(ids, id)
+		case coin.MultiSigTy:
+			for _, pub := range data[1:] {
+				ids = append(ids, coin.SHA256RIPEMD160(pub))
+			}
+		default:
+			continue
+		}
+		hashType := pin.SigHashType
+		if hashType == 0 {
+			hashType = coin.SigHashAll
+		}
+		for _, id := range ids {
+			k, err := src.GetKey(id)
+			if err == ErrKeyNotFound {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			sig, err := k.SignTx(p.Tx, i, script, hashType)
+			if err != nil {
+				return err
+			}
+			if pin.PartialSigs == nil {
+				pin.PartialSigs = make(map[string][]byte)
+			}
+			pin.PartialSigs[hex.EncodeToString(k.PubKey().Bytes())] = sig
+		}
+	}
+	return nil
+}
+
+// DeriveKeys returns the private keys below master named by the
+// derivations of p, together with its redeem scripts, so a signer holding
+// only an extended private key can sign.
+func (p *PSBT) DeriveKeys(master *keys.ExtendedKey) (*KeyList, error) {
+	if !master.IsPrivate() {
+		return nil, errors.New("signing needs an extended private key")
+	}
+	fingerprint := master.Fingerprint()
+	list := NewKeyList()
+	add := func(redeem []byte, origins map[string]KeyOrigin) error {
+		if redeem != nil {
+			list.AddScript(redeem)
+		}
+		for pub, origin := range origins {
+			if origin.Fingerprint != fingerprint {
+				continue
+			}
+			child := master
+			for _, index := range origin.Path {
+				var err error
+				if child, err = child.Child(index); err != nil {
+					return err
+				}
+			}
+			k, err := child.PrivateKey()
+			if err != nil {
+				return err
+			}
+			if hex.EncodeToString(k.PubKey().Bytes()) != pub {
+				return fmt.Errorf("derived key does not match %s", pub)
+			}
+			list.keys[k.PubKey().ID()] = k
+		}
+		return nil
+	}
+	for _, in := range p.Inputs {
+		if err := add(in.RedeemScript, in.Derivations); err != nil {
+			return nil, err
+		}
+	}
+	return list, nil
+}
+
+// CombinePSBT merges copies of the same PSBT updated or signed by
+// different parties (the combiner role).
+func CombinePSBT(psbts ...*PSBT) (*PSBT, error) {
+	if len(psbts) == 0 {
+		return nil, errors.New("no psbts")
+	}
+	out, err := NewPSBT(psbts[0].Tx)
+	if err != nil {
+		return nil, err
+	}
+	hash := out.Tx.Hash()
+	for _, p := range psbts {
+		if p.Tx.Hash() != hash || len(p.Inputs) != len(out.Inputs) || len(p.Outputs) != len(out.Outputs) {
+			return nil, errors.New("psbts are for different transactions")
+		}
+		for i, in := range p.Inputs {
+			dst := &out.Inputs[i]
+			if dst.PrevTx == nil && in.PrevTx != nil {
+				prev := *in.PrevTx
+				dst.PrevTx = &prev
+			}
+			if dst.RedeemScript == nil {
+				dst.RedeemScript = in.RedeemScript
+			}
+			if dst.SigHashType == 0 {
+				dst.SigHashType = in.SigHashType
+			}
+			if dst.FinalScriptSig == nil {
+				dst.FinalScriptSig = in.FinalScriptSig
+			}
+			dst.Derivations = mergeOrigins(dst.Derivations, in.Derivations)
+			for pub, sig := range in.PartialSigs {
+				if dst.PartialSigs == nil {
+					dst.PartialSigs = make(map[string][]byte)
+				}
+				dst.PartialSigs[pub] = sig
+			}
+		}
+		for i, o := range p.Outputs {
+			dst := &out.Outputs[i]
+			if dst.RedeemScript == nil {
+				dst.RedeemScript = o.RedeemScript
+			}
+			dst.Derivations = mergeOrigins(dst.Derivations, o.Derivations)
+		}
+	}
+	return out, nil
+}
+
+func mergeOrigins(dst, src map[string]KeyOrigin) map[string]KeyOrigin {
+	for pub, origin := range src {
+		if dst == nil {
+			dst = make(map[string]KeyOrigin)
+		}
+		dst[pub] = origin
+	}
+	return dst
+}
+
+// Finalize builds the signature script of every input that has enough
+// partial signatures and checks it (the finalizer role). Finalized inputs
+// drop their signing data. It reports whether every input is final.
+func (p *PSBT) Finalize() bool {
+	complete := true
+	for i := range p.Inputs {
+		pin := &p.Inputs[i]
+		if pin.FinalScriptSig != nil {
+			continue
+		}
+		prev, err := p.prevOut(i)
+		ok := err == nil && prev != nil
+		var sig []byte
+		if ok {
+			sig, ok = pin.finalScript(prev.ScriptPubKey)
+		}
+		if ok {
+			tx := withoutSignatures(p.Tx)
+			tx.Inputs[i].ScriptSig = sig
+			ok = VerifyInput(tx, i, prev.ScriptPubKey) == nil
+		}
+		if !ok {
+			complete = false
+			continue
+		}
+		*pin = PSBTInput{PrevTx: pin.PrevTx, FinalScriptSig: sig}
+	}
+	return complete
+}
+
+// finalScript assembles the signature script spending script from the
+// partial signatures.
+func (pin *PSBTInput) finalScript(script []byte) ([]byte, bool) {
+	class, data := coin.ExtractScript(script)
+	var redeem []byte
+	if class == coin.ScriptHashTy {
+		if pin.RedeemScript == nil {
+			return nil, false
+		}
+		redeem = pin.RedeemScript
+		class, data = coin.ExtractScript(redeem)
+	}
+	b := new(coin.ScriptBuilder)
+	switch class {
+	case coin.PubKeyTy:
+		sig, ok := pin.PartialSigs[hex.EncodeToString(data[0])]
+		if !ok {
+			return nil, false
+		}
+		b.AddData(sig)
+	case coin.PubKeyHashTy:
+		found := false
+		for pub, sig := range pin.PartialSigs {
+			raw, err := hex.DecodeString(pub)
+			if err != nil {
+				continue
+			}
+			if id := coin.SHA256RIPEMD160(raw); bytes.Equal(id[:], data[0]) {
+				b.AddData(sig).AddData(raw)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, false
+		}
+	case coin.MultiSigTy:
+		required := int(data[0][0])
+		b.AddOp(coin.OP_0)
+		signed := 0
+		for _, pub := range data[1:] {
+			if signed == required {
+				break
+			}
+			if sig, ok := pin.PartialSigs[hex.EncodeToString(pub)]; ok {
+				b.AddData(sig)
+				signed++
+			}
+		}
+		if signed < required {
+			return nil, false
+		}
+	default:
+		return nil, false
+	}
+	if redeem != nil {
+		b.AddData(redeem)
+	}
+	return b.Script(), true
+}
+
+// Extract returns the signed transaction of a finalized PSBT (the
+// extractor role).
+func (p *PSBT) Extract() (coin.Transaction, error) {
+	tx := withoutSignatures(p.Tx)
+	for i, in := range p.Inputs {
+		if in.FinalScriptSig == nil {
+			return coin.Transaction{}, ErrPSBTIncomplete
+		}
+		tx.Inputs[i].ScriptSig = in.FinalScriptSig
+	}
+	return tx, nil
+}
+
+// Fee returns the fee of the transaction, which needs every spent output.
+func (p *PSBT) Fee() (int64, error) {
+	var in int64
+	for i := range p.Inputs {
+		prev, err := p.prevOut(i)
+		if err != nil {
+			return 0, err
+		}
+		if prev == nil {
+			return 0, fmt.Errorf("spent output of input %d unknown", i)
+		}
+		in += prev.Value
+	}
+	return in - p.Tx.ValueOut(), nil
+}
+
+// writeRecord writes one key-value pair of a PSBT map.
+func writeRecord(w io.Writer, typ byte, keyData, value []byte) {
+	coin.WriteVarBytes(w, append([]byte{typ}, keyData...))
+	coin.WriteVarBytes(w, value)
+}
+
+func encodeOrigin(o KeyOrigin) []byte {
+	b := make([]byte, 4, 4+4*len(o.Path))
+	binary.BigEndian.PutUint32(b, o.Fingerprint)
+	for _, index := range o.Path {
+		b = binary.LittleEndian.AppendUint32(b, index)
+	}
+	return b
+}
+
+func decodeOrigin(b []byte) (KeyOrigin, error) {
+	if len(b) < 4 || len(b)%4 != 0 {
+		return KeyOrigin{}, errors.New("invalid key origin")
+	}
+	o := KeyOrigin{Fingerprint: binary.BigEndian.Uint32(b)}
+	for i := 4; i < len(b); i += 4 {
+		o.Path = append(o.Path, binary.LittleEndian.Uint32(b[i:]))
+	}
+	return o, nil
+}
+
+// writeOrigins writes derivation records in key order.
+func writeOrigins(w io.Writer, typ byte, origins map[string]KeyOrigin) error {
+	pubs := make([]string, 0, len(origins))
+	for pub := range origins {
+		pubs = append(pubs, pub)
+	}
+	sort.Strings(pubs)
+	for _, pub := range pubs {
+		raw, err := hex.DecodeString(pub)
+		if err != nil {
+			return err
+		}
+		writeRecord(w, typ, raw, encodeOrigin(origins[pub]))
+	}
+	return nil
+}
+
+// Serialize returns the binary form of p: the magic, then the global,
+// input and output maps, each a list of key-value records ended by an
+// empty key.
+func (p *PSBT) Serialize() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Write(psbtMagic)
+	tx, err := withoutSignatures(p.Tx).Serialize()
+	if err != nil {
+		return nil, err
+	}
+	writeRecord(&buf, psbtGlobalUnsignedTx, nil, tx)
+	buf.WriteByte(0)
+	for _, in := range p.Inputs {
+		if in.PrevTx != nil {
+			prev, err := in.PrevTx.Serialize()
+			if err != nil {
+				return nil, err
+			}
+			writeRecord(&buf, psbtInPrevTx, nil, prev)
+		}
+		pubs := make([]string, 0, len(in.PartialSigs))
+		for pub := range in.PartialSigs {
+			pubs = append(pubs, pub)
+		}
+		sort.Strings(pubs)
+		for _, pub := range pubs {
+			raw, err := hex.DecodeString(pub)
+			if err != nil {
+				return nil, err
+			}
+			writeRecord(&buf, psbtInPartialSig, raw, in.PartialSigs[pub])
+		}
+		if in.SigHashType != 0 {
+			writeRecord(&buf, psbtInSigHashType, nil, binary.LittleEndian.AppendUint32(nil, in.SigHashType))
+		}
+		if in.RedeemScript != nil {
+			writeRecord(&buf, psbtInRedeemScript, nil, in.RedeemScript)
+		}
+		if err := writeOrigins(&buf, psbtInDerivation, in.Derivations); err != nil {
+			return nil, err
+		}
+		if in.FinalScriptSig != nil {
+			writeRecord(&buf, psbtInFinalScriptSig, nil, in.FinalScriptSig)
+		}
+		buf.WriteByte(0)
+	}
+	for _, out := range p.Outputs {
+		if out.RedeemScript != nil {
+			writeRecord(&buf, psbtOutRedeemScript, nil, out.RedeemScript)
+		}
+		if err := writeOrigins(&buf, psbtOutDerivation, out.Derivations); err != nil {
+			return nil, err
+		}
+		buf.WriteByte(0)
+	}
+	return buf.Bytes(), nil
+}
+
+// readMap reads the records of one PSBT map, calling fn for each.
+func readMap(r *bytes.Reader, fn func(typ byte, keyData, value []byte) error) error {
+	seen := make(map[string]bool)
+	for {
+		key, err := coin.ReadVarBytes(r)
+		if err != nil {
+			return err
+		}
+		if len(key) == 0 {
+			return nil
+		}
+		if seen[string(key)] {
+			return errors.New("duplicate psbt record")
+		}
+		seen[string(key)] = true
+		value, err := coin.ReadVarBytes(r)
+		if err != nil {
+			return err
+		}
+		if err := fn(key[0], key[1:], value); err != nil {
+			return err
+		}
+	}
+}
+
+// DeserializePSBT decodes a PSBT produced by Serialize. Records of unknown
+// types are skipped.
+func DeserializePSBT(data []byte) (*PSBT, error) {
+	if !bytes.HasPrefix(data, psbtMagic) {
+		return nil, errors.New("invalid psbt magic")
+	}
+	r := bytes.NewReader(data[len(psbtMagic):])
+	var p *PSBT
+	err := readMap(r, func(typ byte, keyData, value []byte) error {
+		if typ != psbtGlobalUnsignedTx || len(keyData) != 0 {
+			return nil
+		}
+		tx, err := coin.DeserializeTransaction(value)
+		if err != nil {
+			return err
+		}
+		p, err = NewPSBT(tx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return nil, errors.New("psbt has no unsigned transaction")
+	}
+	for i := range p.Inputs {
+		pin := &p.Inputs[i]
+		err := readMap(r, func(typ byte, keyData, value []byte) error {
+			switch typ {
+			case psbtInPrevTx:
+				prev, err := coin.DeserializeTransaction(value)
+				if err != nil {
+					return err
+				}
+				pin.PrevTx = &prev
+			case psbtInPartialSig:
+				if pin.PartialSigs == nil {
+					pin.PartialSigs = make(map[string][]byte)
+				}
+				pin.PartialSigs[hex.EncodeToString(keyData)] = value
+			case psbtInSigHashType:
+				if len(value) != 4 {
+					return errors.New("invalid sighash type")
+				}
+				pin.SigHashType = binary.LittleEndian.Uint32(value)
+			case psbtInRedeemScript:
+				pin.RedeemScript = value
+			case psbtInDerivation:
+				origin, err := decodeOrigin(value)
+				if err != nil {
+					return err
+				}
+				pin.Derivations = mergeOrigins(pin.Derivations, map[string]KeyOrigin{hex.EncodeToString(keyData): origin})
+			case psbtInFinalScriptSig:
+				pin.FinalScriptSig = value
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("input %d: %v", i, err)
+		}
+	}
+	for i := range p.Outputs {
+		pout := &p.Outputs[i]
+		err := readMap(r, func(typ byte, keyData, value []byte) error {
+			switch typ {
+			case psbtOutRedeemScript:
+				pout.RedeemScript = value
+			case psbtOutDerivation:
+				origin, err := decodeOrigin(value)
+				if err != nil {
+					return err
+				}
+				pout.Derivations = mergeOrigins(pout.Derivations, map[string]KeyOrigin{hex.EncodeToString(keyData): origin})
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("output %d: %v", i, err)
+		}
+	}
+	return p, nil
+}
+
+// Base64 returns the base64 encoding of the serialized PSBT.
+func (p *PSBT) Base64() (string, error) {
+	data, err := p.Serialize()
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+// DecodePSBTBase64 decodes a PSBT from Base64.
+func DecodePSBTBase64(s string) (*PSBT, error) {
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return DeserializePSBT(data)
+}
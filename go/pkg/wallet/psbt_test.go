@@ -0,0 +1,169 @@
+package wallet
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"pila/pkg/coin"
+	"pila/pkg/coin/keys"
+	"pila/pkg/database"
+)
+
+func TestPSBTColdStorage(t *testing.T) {
+	seed, err := keys.NewMasterKey([]byte("psbt cold storage test seed 0001"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	account, err := seed.Derive("m/44'/0'/0'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	receive := func(i uint32) []byte {
+		child, err := account.Derive("m/0")
+		if err == nil {
+			child, err = child.Child(i)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		pub, _ := child.PublicKey()
+		return pub.Bytes()
+	}
+
+	db, _ := NewDB(database.NewMemStore())
+	cfg := DefaultConfig()
+	cfg.KeyPoolSize = 1
+	w, err := New(db, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.ImportXPub(account.Public().String()); err != nil {
+		t.Fatal(err)
+	}
+	k2, _ := testAddress(t)
+	k3, _ := testAddress(t)
+	multisig, err := w.AddMultisigAddress(2, []string{
+		hex.EncodeToString(receive(1)),
+		hex.EncodeToString(k2.PubKey().Bytes()),
+		hex.EncodeToString(k3.PubKey().Bytes()),
+	}, "vault")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var a coin.Address
+	a.SetString(multisig)
+	vault, _ := coin.PayToDestinationScript(a.Get())
+	single := coin.PayToPubKeyHashScript(coin.SHA256RIPEMD160(receive(0)))
+	funding := coin.Transaction{Version: 1, Outputs: []coin.TxOut{
+		{Value: 5 * coin.Coin, ScriptPubKey: single},
+		{Value: 10 * coin.Coin, ScriptPubKey: vault},
+	}}
+	if err := w.Sync(testChain{}.add(coinbaseTo(nil, 0, 0), funding)); err != nil {
+		t.Fatal(err)
+	}
+
+	_, dest := testAddress(t)
+	p, err := w.CreatePSBT([]Recipient{{Address: dest, Amount: 12 * coin.Coin}}, BuildOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fee, err := p.Fee(); err != nil || fee < coin.MinTxFee {
+		t.Fatalf("fee %d: %v", fee, err)
+	}
+	changeOrigins := 0
+	for _, out := range p.Outputs {
+		changeOrigins += len(out.Derivations)
+	}
+	if changeOrigins != 1 {
+		t.Fatalf("change output derivations %d", changeOrigins)
+	}
+	encoded, err := p.Base64()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The air-gapped signer holds only the account xprv.
+	cold, err := DecodePSBTBase64(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := cold.DeriveKeys(account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cold.Sign(list); err != nil {
+		t.Fatal(err)
+	}
+	if cold.Finalize() {
+		t.Fatalf("finalized with one of two vault signatures")
+	}
+
+	cosigned, _ := DecodePSBTBase64(encoded)
+	if err := cosigned.Sign(NewKeyList(k2)); err != nil {
+		t.Fatal(err)
+	}
+	merged, err := CombinePSBT(cold, cosigned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !merged.Finalize() {
+		t.Fatalf("combined psbt not final")
+	}
+	tx, err := merged.Extract()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range merged.Inputs {
+		prev, err := merged.prevOut(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := VerifyInput(tx, i, prev.ScriptPubKey); err != nil {
+			t.Fatalf("input %d: %v", i, err)
+		}
+	}
+	if _, err := cosigned.Extract(); err != ErrPSBTIncomplete {
+		t.Fatalf("extracted an unfinalized psbt: %v", err)
+	}
+}
+
+func TestPSBTSignChecksPrevTx(t *testing.T) {
+	k, _ := testAddress(t)
+	prev := coin.Transaction{Version: 1, Outputs: []coin.TxOut{
+		{Value: coin.Coin, ScriptPubKey: coin.PayToPubKeyHashScript(k.PubKey().ID())},
+	}}
+	_, dest := testAddress(t)
+	var a coin.Address
+	a.SetString(dest)
+	script, _ := coin.PayToDestinationScript(a.Get())
+	p, err := NewPSBT(coin.Transaction{
+		Version: 1,
+		Inputs:  []coin.TxIn{{PreviousOut: coin.PointOut{Hash: prev.Hash()}, Sequence: 0xffffffff}},
+		Outputs: []coin.TxOut{{Value: coin.Coin / 2, ScriptPubKey: script}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A previous transaction inflating the spent value must not be signed.
+	forged := prev
+	forged.Outputs = []coin.TxOut{{Value: 100 * coin.Coin, ScriptPubKey: prev.Outputs[0].ScriptPubKey}}
+	p.Inputs[0].PrevTx = &forged
+	if err := p.Sign(NewKeyList(k)); err == nil {
+		t.Fatalf("signed against a forged previous transaction")
+	}
+	if _, err := p.Fee(); err == nil {
+		t.Fatalf("fee computed from a forged previous transaction")
+	}
+
+	p.Inputs[0].PrevTx = &prev
+	if err := p.Sign(NewKeyList(k)); err != nil {
+		t.Fatal(err)
+	}
+	if !p.Finalize() {
+		t.Fatalf("psbt not final")
+	}
+	if fee, err := p.Fee(); err != nil || fee != coin.Coin/2 {
+		t.Fatalf("fee %d: %v", fee, err)
+	}
+}
@@ -0,0 +1,199 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+
+	"pila/pkg/coin"
+)
+
+// CreateRawTransaction returns an unsigned transaction spending inputs and
+// paying outputs (createrawtransaction). No inputs are chosen and no change
+// is added; the caller is responsible for the fee.
+func CreateRawTransaction(inputs []coin.PointOut, outputs []Recipient) (coin.Transaction, error) {
+	tx := coin.Transaction{Version: 1}
+	for _, in := range inputs {
+		if len(in.Hash) != 64 || !coin.IsHex(in.Hash) {
+			return coin.Transaction{}, errors.New("invalid input hash " + in.Hash)
+		}
+		tx.Inputs = append(tx.Inputs, coin.TxIn{PreviousOut: in, Sequence: 0xffffffff})
+	}
+	for _, r := range outputs {
+		script, err := r.script()
+		if err != nil {
+			return coin.Transaction{}, err
+		}
+		tx.Outputs = append(tx.Outputs, coin.TxOut{Value: r.Amount, ScriptPubKey: script})
+	}
+	return tx, nil
+}
+
+// SignRawTransaction signs every input of tx it has a key for
+// (signrawtransaction). prevScripts gives the script of each spent output.
+// New signatures are combined with those already in the input, so the
+// parties of a multisig output can sign in turn; inputs that cannot be
+// signed keep their signature script. It reports whether all inputs now
+// verify, so an offline signer can tell whether the transaction is ready
+// to broadcast.
+func SignRawTransaction(tx *coin.Transaction, prevScripts map[coin.PointOut][]byte, src KeySource, hashType uint32) (bool, error) {
+	if hashType == 0 {
+		hashType = coin.SigHashAll
+	}
+	complete := true
+	for i, in := range tx.Inputs {
+		script, ok := prevScripts[in.PreviousOut]
+		if !ok {
+			complete = false
+			continue
+		}
+		prev := in.ScriptSig
+		err := SignInput(src, tx, i, script, hashType)
+		if err != nil && !errors.Is(err, ErrCannotSign) {
+			return false, err
+		}
+		if err == nil && len(prev) > 0 {
+			tx.Inputs[i].ScriptSig = CombineSignatures(script, *tx, i, tx.Inputs[i].ScriptSig, prev)
+		}
+		if VerifyInput(*tx, i, script) != nil {
+			complete = false
+		}
+	}
+	return complete, nil
+}
+
+// SignRawTransaction signs tx with the wallet keys. Scripts of outputs of
+// wallet transactions are looked up and need not be in prevScripts.
+func (w *Wallet) SignRawTransaction(tx *coin.Transaction, prevScripts map[coin.PointOut][]byte, hashType uint32) (bool, error) {
+	scripts := make(map[coin.PointOut][]byte, len(tx.Inputs))
+	for _, in := range tx.Inputs {
+		if script, err := w.prevScript(in.PreviousOut); err == nil {
+			scripts[in.PreviousOut] = script
+		}
+	}
+	for out, script := range prevScripts {
+		scripts[out] = script
+	}
+	return SignRawTransaction(tx, scripts, w.keys, hashType)
+}
+
+// CombineRawTransactions merges the signatures of copies of one
+// transaction signed by different parties (combining signrawtransaction
+// hex strings). prevScripts gives the script of each spent output.
+func CombineRawTransactions(prevScripts map[coin.PointOut][]byte, txs ...coin.Transaction) (coin.Transaction, error) {
+	if len(txs) == 0 {
+		return coin.Transaction{}, errors.New("no transactions")
+	}
+	hash := withoutSignatures(txs[0]).Hash()
+	for _, tx := range txs[1:] {
+		if withoutSignatures(tx).Hash() != hash {
+			return coin.Transaction{}, errors.New("transactions differ")
+		}
+	}
+	merged := txs[0]
+	merged.Inputs = append([]coin.TxIn(nil), txs[0].Inputs...)
+	for i, in := range merged.Inputs {
+		script, ok := prevScripts[in.PreviousOut]
+		if !ok {
+			return coin.Transaction{}, fmt.Errorf("missing script of input %d", i)
+		}
+		for _, tx := range txs[1:] {
+			merged.Inputs[i].ScriptSig = CombineSignatures(script, merged, i, merged.Inputs[i].ScriptSig, tx.Inputs[i].ScriptSig)
+		}
+	}
+	return merged, nil
+}
+
+// withoutSignatures returns a copy of tx with empty signature scripts.
+func withoutSignatures(tx coin.Transaction) coin.Transaction {
+	tx.Inputs = append([]coin.TxIn(nil), tx.Inputs...)
+	for i := range tx.Inputs {
+		tx.Inputs[i].ScriptSig = nil
+	}
+	return tx
+}
+
+// UnsignedTx is a transaction spending watch-only outputs, to be signed
+// offline with SignRawTransaction.
+type UnsignedTx struct {
+	Tx coin.Transaction
+	// PrevScripts holds the script of every spent output.
+	PrevScripts map[coin.PointOut][]byte
+	// Fee is paid assuming signatures of the largest size.
+	Fee int64
+}
+
+// BuildUnsignedTransaction creates a transaction paying outputs from the
+// watch-only outputs of the wallet. Coins are selected and the fee is
+// computed as in BuildTransaction, with placeholder signatures standing in
+// for the ones the offline signer adds. Change goes to the coin control
+// change address or the next change address of the first watched xpub.
+func (w *Wallet) BuildUnsignedTransaction(outputs []Recipient, opts BuildOptions) (*UnsignedTx, error) {
+	outs, value, err := paymentOutputs(outputs)
+	if err != nil {
+		return nil, err
+	}
+	change := func() ([]byte, error) {
+		if opts.Control != nil && opts.Control.ChangeAddress != "" {
+			return changeAddressScript(opts.Control.ChangeAddress)
+		}
+		return w.watch.changeScript()
+	}
+	sign := func(tx *coin.Transaction, s *Selection) error {
+		for i, c := range s.Coins {
+			script, err := placeholderSignature(w.keys, c.Output.ScriptPubKey)
+			if err != nil {
+				return err
+			}
+			tx.Inputs[i].ScriptSig = script
+		}
+		return nil
+	}
+	tx, s, fee, err := w.createTransaction(outs, value, opts, w.WatchOnlyCoins(), change, sign)
+	if err != nil {
+		return nil, err
+	}
+	u := &UnsignedTx{PrevScripts: make(map[coin.PointOut][]byte, len(s.Coins)), Fee: fee}
+	for i, c := range s.Coins {
+		tx.Inputs[i].ScriptSig = nil
+		u.PrevScripts[c.Out] = c.Output.ScriptPubKey
+	}
+	u.Tx = tx
+	return u, nil
+}
+
+// placeholderSignature returns a signature script of the largest size a
+// signature of an output with prevScript can take. Redeem scripts of P2SH
+// outputs are looked up in src.
+func placeholderSignature(src KeySource, prevScript []byte) ([]byte, error) {
+	class, data := coin.ExtractScript(prevScript)
+	b := new(coin.ScriptBuilder)
+	switch class {
+	case coin.PubKeyTy:
+		b.AddData(make([]byte, 73))
+	case coin.PubKeyHashTy:
+		b.AddData(make([]byte, 73)).AddData(make([]byte, 65))
+	case coin.MultiSigTy:
+		b.AddOp(coin.OP_0)
+		for i := 0; i < int(data[0][0]); i++ {
+			b.AddData(make([]byte, 73))
+		}
+	case coin.ScriptHashTy:
+		var id coin.IDScript
+		copy(id[:], data[0])
+		redeem, err := src.GetScript(id)
+		if err != nil {
+			return nil, fmt.Errorf("%w: missing redeem script", ErrCannotSign)
+		}
+		if class, _ := coin.ExtractScript(redeem); class == coin.ScriptHashTy {
+			return nil, fmt.Errorf("%w: nested %s", ErrCannotSign, class)
+		}
+		sig, err := placeholderSignature(src, redeem)
+		if err != nil {
+			return nil, err
+		}
+		return append(sig, b.AddData(redeem).Script()...), nil
+	default:
+		return nil, fmt.Errorf("%w: %s output", ErrCannotSign, class)
+	}
+	return b.Script(), nil
+}
@@ -0,0 +1,273 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+
+	"pila/pkg/coin"
+	"pila/pkg/coin/keys"
+)
+
+// ErrCannotSign is returned for inputs whose script the signer does not
+// support or whose keys it does not have.
+var ErrCannotSign = errors.New("cannot sign input")
+
+// KeySource provides the private keys and redeem scripts used for signing.
+// KeyStore implements it.
+type KeySource interface {
+	GetKey(id coin.IDKey) (*keys.PrivateKey, error)
+	GetScript(id coin.IDScript) ([]byte, error)
+}
+
+// KeyList is a KeySource over a fixed set of keys and redeem scripts, e.g.
+// the private keys given to signrawtransaction on an offline machine.
+type KeyList struct {
+	keys    map[coin.IDKey]*keys.PrivateKey
+	scripts map[coin.IDScript][]byte
+}
+
+// NewKeyList returns a KeyList holding list.
+func NewKeyList(list ...*keys.PrivateKey) *KeyList {
+	l := &KeyList{
+		keys:    make(map[coin.IDKey]*keys.PrivateKey, len(list)),
+		scripts: make(map[coin.IDScript][]byte),
+	}
+	for _, k := range list {
+		l.keys[k.PubKey().ID()] = k
+	}
+	return l
+}
+
+// AddScript adds a P2SH redeem script.
+func (l *KeyList) AddScript(redeem []byte) {
+	l.scripts[coin.ScriptHash(redeem)] = redeem
+}
+
+// GetKey implements KeySource.
+func (l *KeyList) GetKey(id coin.IDKey) (*keys.PrivateKey, error) {
+	if k, ok := l.keys[id]; ok {
+		return k, nil
+	}
+	return nil, ErrKeyNotFound
+}
+
+// GetScript implements KeySource.
+func (l *KeyList) GetScript(id coin.IDScript) ([]byte, error) {
+	if redeem, ok := l.scripts[id]; ok {
+		return redeem, nil
+	}
+	return nil, ErrScriptNotFound
+}
+
+// SignInput sets the signature script of input n of tx spending an output
+// with prevScript (script::sign_signature). P2SH outputs are signed with
+// their redeem script from src. A multisig input is signed with the keys
+// src holds even when they are fewer than required; CombineSignatures
+// merges the signatures of the other parties.
+func SignInput(src KeySource, tx *coin.Transaction, n int, prevScript []byte, hashType uint32) error {
+	if n < 0 || n >= len(tx.Inputs) {
+		return fmt.Errorf("input %d out of range", n)
+	}
+	class, data := coin.ExtractScript(prevScript)
+	if class != coin.ScriptHashTy {
+		sig, err := signScript(src, *tx, n, prevScript, hashType)
+		if err != nil {
+			return err
+		}
+		tx.Inputs[n].ScriptSig = sig
+		return nil
+	}
+	var id coin.IDScript
+	copy(id[:], data[0])
+	redeem, err := src.GetScript(id)
+	if err == ErrScriptNotFound {
+		return fmt.Errorf("%w: missing redeem script", ErrCannotSign)
+	}
+	if err != nil {
+		return err
+	}
+	if class, _ := coin.ExtractScript(redeem); class == coin.ScriptHashTy {
+		return fmt.Errorf("%w: nested %s", ErrCannotSign, class)
+	}
+	sig, err := signScript(src, *tx, n, redeem, hashType)
+	if err != nil {
+		return err
+	}
+	tx.Inputs[n].ScriptSig = append(sig, new(coin.ScriptBuilder).AddData(redeem).Script()...)
+	return nil
+}
+
+// signScript returns the signatures satisfying script, which is both the
+// script to solve and the script signed (solver).
+func signScript(src KeySource, tx coin.Transaction, n int, script []byte, hashType uint32) ([]byte, error) {
+	class, data := coin.ExtractScript(script)
+	b := new(coin.ScriptBuilder)
+	switch class {
+	case coin.PubKeyTy, coin.PubKeyHashTy:
+		var id coin.IDKey
+		if class == coin.PubKeyTy {
+			id = coin.SHA256RIPEMD160(data[0])
+		} else {
+			copy(id[:], data[0])
+		}
+		k, err := src.GetKey(id)
+		if err == ErrKeyNotFound {
+			return nil, fmt.Errorf("%w: missing key", ErrCannotSign)
+		}
+		if err != nil {
+			return nil, err
+		}
+		sig, err := k.SignTx(tx, n, script, hashType)
+		if err != nil {
+			return nil, err
+		}
+		b.AddData(sig)
+		if class == coin.PubKeyHashTy {
+			b.AddData(k.PubKey().Bytes())
+		}
+	case coin.MultiSigTy:
+		required := int(data[0][0])
+		// The extra OP_0 is consumed by the off-by-one pop of
+		// OP_CHECKMULTISIG.
+		b.AddOp(coin.OP_0)
+		signed := 0
+		for _, pub := range data[1:] {
+			if signed == required {
+				break
+			}
+			k, err := src.GetKey(coin.SHA256RIPEMD160(pub))
+			if err == ErrKeyNotFound {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			sig, err := k.SignTx(tx, n, script, hashType)
+			if err != nil {
+				return nil, err
+			}
+			b.AddData(sig)
+			signed++
+		}
+		if signed == 0 {
+			return nil, fmt.Errorf("%w: missing keys", ErrCannotSign)
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s output", ErrCannotSign, class)
+	}
+	return b.Script(), nil
+}
+
+// scriptPushes returns the data pushed by a push only script.
+func scriptPushes(script []byte) [][]byte {
+	ops, err := coin.ParseScript(script)
+	if err != nil {
+		return nil
+	}
+	var stack [][]byte
+	for _, op := range ops {
+		if op.Code > coin.OP_PUSHDATA4 {
+			return nil
+		}
+		stack = append(stack, op.Data)
+	}
+	return stack
+}
+
+// CombineSignatures merges two signature scripts of input n of tx spending
+// prevScript (combine_signatures). Multisig signatures of both are kept in
+// key order; for other scripts the larger one wins, since a signature is
+// larger than an empty script.
+func CombineSignatures(prevScript []byte, tx coin.Transaction, n int, a, b []byte) []byte {
+	stack := combineStacks(prevScript, tx, n, scriptPushes(a), scriptPushes(b))
+	sb := new(coin.ScriptBuilder)
+	for _, v := range stack {
+		sb.AddData(v)
+	}
+	return sb.Script()
+}
+
+func combineStacks(script []byte, tx coin.Transaction, n int, a, b [][]byte) [][]byte {
+	class, data := coin.ExtractScript(script)
+	switch class {
+	case coin.ScriptHashTy:
+		if len(a) == 0 {
+			return b
+		}
+		if len(b) == 0 {
+			return a
+		}
+		redeem := a[len(a)-1]
+		if class, _ := coin.ExtractScript(redeem); class == coin.ScriptHashTy {
+			return a
+		}
+		stack := combineStacks(redeem, tx, n, a[:len(a)-1], b[:len(b)-1])
+		return append(stack, redeem)
+	case coin.MultiSigTy:
+		return combineMultiSig(script, data, tx, n, a, b)
+	}
+	if len(b) > len(a) {
+		return b
+	}
+	return a
+}
+
+// combineMultiSig matches every signature of a and b to the public key it
+// verifies against and returns them in key order, padding missing
+// signatures with empty pushes.
+func combineMultiSig(script []byte, data [][]byte, tx coin.Transaction, n int, a, b [][]byte) [][]byte {
+	var sigs [][]byte
+	for _, stack := range [][][]byte{a, b} {
+		for _, v := range stack {
+			if len(v) > 0 {
+				sigs = append(sigs, v)
+			}
+		}
+	}
+	required := int(data[0][0])
+	stack := [][]byte{nil}
+	for _, pub := range data[1:] {
+		if len(stack)-1 == required {
+			break
+		}
+		for i, sig := range sigs {
+			if sig == nil {
+				continue
+			}
+			hash, err := coin.SignatureHash(script, tx, n, uint32(sig[len(sig)-1]))
+			if err == nil && coin.VerifySignature(hash, sig[:len(sig)-1], pub, false) {
+				stack = append(stack, sig)
+				sigs[i] = nil
+				break
+			}
+		}
+	}
+	for len(stack)-1 < required {
+		stack = append(stack, nil)
+	}
+	return stack
+}
+
+// VerifyInput checks the signature script of input n of tx against
+// prevScript with the standard rules.
+func VerifyInput(tx coin.Transaction, n int, prevScript []byte) error {
+	return coin.VerifyScript(tx.Inputs[n].ScriptSig, prevScript, tx, n, coin.StandardScriptFlags, nil)
+}
+
+// SignMessage signs message with the key of address (signmessage). The
+// wallet must be unlocked.
+func (w *Wallet) SignMessage(address, message string) (string, error) {
+	var a coin.Address
+	if !a.SetString(address) || !a.IsValid() {
+		return "", fmt.Errorf("invalid address %q", address)
+	}
+	id, ok := a.GetIDKey()
+	if !ok {
+		return "", errors.New("address does not refer to a key")
+	}
+	k, err := w.keys.GetKey(id)
+	if err != nil {
+		return "", err
+	}
+	return keys.SignMessage(k, message), nil
+}
@@ -0,0 +1,95 @@
+package wallet
+
+import (
+	"errors"
+	"time"
+
+	"pila/pkg/coin"
+	"pila/pkg/coin/keys"
+)
+
+// UnlockForStaking unlocks the wallet so the minter can sign coinstakes
+// and blocks while every other use of the private keys keeps failing
+// (walletpassphrase with mintonly).
+func (w *Wallet) UnlockForStaking(passphrase string, timeout time.Duration) error {
+	return w.keys.UnlockForStaking(passphrase, timeout)
+}
+
+// CanStake reports whether the private keys are available to the minter.
+func (w *Wallet) CanStake() bool { return !w.keys.IsLocked() }
+
+// StakeCoins returns the confirmed, mature and unlocked outputs the minter
+// may stake. Only outputs paying to a public key or key hash of the wallet
+// can stake.
+func (w *Wallet) StakeCoins() []Coin {
+	var out []Coin
+	for _, c := range w.Coins() {
+		if c.Depth < 1 || c.BlocksToMaturity() > 0 || w.IsLockedCoin(c.Out) {
+			continue
+		}
+		if class, _ := coin.ExtractScript(c.Output.ScriptPubKey); class != coin.PubKeyTy && class != coin.PubKeyHashTy {
+			continue
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+// CoinStakeScript returns the script the coinstake pays a staked output
+// back to: a pay-to-public-key script, so the block signature can be
+// checked against it.
+func (w *Wallet) CoinStakeScript(kernel []byte) ([]byte, error) {
+	class, data := coin.ExtractScript(kernel)
+	switch class {
+	case coin.PubKeyTy:
+		return kernel, nil
+	case coin.PubKeyHashTy:
+		var id coin.IDKey
+		copy(id[:], data[0])
+		if !w.keys.HaveKey(id) {
+			return nil, ErrKeyNotFound
+		}
+		pub, err := w.keys.GetPubKey(id)
+		if err != nil {
+			return nil, err
+		}
+		return coin.PayToPubKeyScript(pub.Bytes()), nil
+	}
+	return nil, errors.New("kernel script cannot stake")
+}
+
+// stakingKeys is the KeySource the minter signs with.
+type stakingKeys struct{ ks *KeyStore }
+
+func (s stakingKeys) GetKey(id coin.IDKey) (*keys.PrivateKey, error) { return s.ks.stakingKey(id) }
+
+func (s stakingKeys) GetScript(id coin.IDScript) ([]byte, error) { return s.ks.GetScript(id) }
+
+// SignCoinStake signs every input of a coinstake; prevScripts holds the
+// script of the output each input spends.
+func (w *Wallet) SignCoinStake(tx *coin.Transaction, prevScripts [][]byte) error {
+	for i := range tx.Inputs {
+		if err := SignInput(stakingKeys{w.keys}, tx, i, prevScripts[i], coin.SigHashAll); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SignBlock signs a proof-of-stake block with the key its coinstake pays
+// to (block::sign).
+func (w *Wallet) SignBlock(b *coin.Block) error {
+	if !b.IsProofOfStake() {
+		return errors.New("block is not proof-of-stake")
+	}
+	class, data := coin.ExtractScript(b.Transactions[1].Outputs[1].ScriptPubKey)
+	if class != coin.PubKeyTy {
+		return errors.New("coinstake does not pay to a public key")
+	}
+	k, err := w.keys.stakingKey(coin.SHA256RIPEMD160(data[0]))
+	if err != nil {
+		return err
+	}
+	b.Signature = k.Sign(b.SignatureHash())
+	return nil
+}
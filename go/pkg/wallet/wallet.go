@@ -0,0 +1,414 @@
+package wallet
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"pila/pkg/coin"
+	"pila/pkg/coin/keys"
+	"pila/pkg/database"
+	"pila/pkg/mempool"
+)
+
+// Wallet ties the key store, key pool and wallet transactions together.
+type Wallet struct {
+	mu     sync.Mutex
+	config Config
+	db     *DB
+	keys   *KeyStore
+	pool   *KeyPool
+	watch  *watchSet
+
+	txs map[string]*WalletTx
+	// spent maps outputs spent by wallet transactions to the spender, or
+	// to "" for outputs repair found spent outside the wallet.
+	spent map[coin.PointOut]string
+	// locked holds outputs excluded from coin selection (lockunspent).
+	locked map[coin.PointOut]bool
+	// pending holds outputs spent by built transactions that were neither
+	// committed nor cancelled yet.
+	pending map[coin.PointOut]bool
+
+	// labels is the address book mapping addresses to account names.
+	labels       map[string]string
+	entries      []AccountingEntry
+	orderPosNext int64
+	entryNext    uint64
+
+	bestHeight int32
+	fees       *mempool.FeeEstimator
+}
+
+// New loads the wallet stored in db, or creates a new one following cfg
+// when db holds no keys. A nil db keeps the wallet in memory only.
+func New(db *DB, cfg Config) (*Wallet, error) {
+	ks, err := NewKeyStore(db)
+	if err != nil {
+		return nil, err
+	}
+	w := &Wallet{
+		config:  cfg,
+		db:      db,
+		keys:    ks,
+		watch:   newWatchSet(),
+		txs:     make(map[string]*WalletTx),
+		spent:   make(map[coin.PointOut]string),
+		locked:  make(map[coin.PointOut]bool),
+		pending: make(map[coin.PointOut]bool),
+		labels:  make(map[string]string),
+
+		bestHeight: -1,
+	}
+	var chain *KeyChain
+	hd, err := w.readHDConfiguration()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case len(ks.KeyIDs()) == 0 && hd.IsEmpty():
+		c, master, err := NewKeyChain(cfg)
+		if err != nil {
+			return nil, err
+		}
+		if master != nil {
+			if err := ks.AddKey(master); err != nil {
+				return nil, err
+			}
+			err := w.update(func(tx *DBTx) error { return tx.WriteHDConfiguration(c.Configuration()) })
+			if err != nil {
+				return nil, err
+			}
+		}
+		chain = c
+	default:
+		// A locked wallet restores its chain on Unlock.
+		if chain, err = w.restoreKeyChain(hd); err != nil && err != ErrLocked {
+			return nil, err
+		}
+	}
+	if w.pool, err = NewKeyPool(db, ks, chain, cfg.KeyPoolSize); err != nil {
+		return nil, err
+	}
+	if err := w.loadBestHeight(); err != nil {
+		return nil, err
+	}
+	if err := w.loadWatch(); err != nil {
+		return nil, err
+	}
+	if db != nil {
+		err = db.View(func(tx *DBTx) error {
+			err := tx.ForEachTx(func(wtx *WalletTx) error {
+				w.indexTx(wtx)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			return tx.ForEachSpent(func(out coin.PointOut) error {
+				if _, ok := w.spent[out]; !ok {
+					w.spent[out] = ""
+				}
+				return nil
+			})
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := w.loadAccounts(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *Wallet) update(fn func(tx *DBTx) error) error {
+	if w.db == nil {
+		return nil
+	}
+	return w.db.Update(fn)
+}
+
+func (w *Wallet) readHDConfiguration() (keys.HDConfiguration, error) {
+	var hd keys.HDConfiguration
+	if w.db == nil {
+		return hd, nil
+	}
+	err := w.db.View(func(tx *DBTx) error {
+		var err error
+		hd, err = tx.ReadHDConfiguration()
+		return err
+	})
+	if err == database.ErrNotFound {
+		return keys.HDConfiguration{}, nil
+	}
+	return hd, err
+}
+
+// restoreKeyChain rebuilds the key chain described by hd, which needs the
+// master key and so an unlocked wallet.
+func (w *Wallet) restoreKeyChain(hd keys.HDConfiguration) (*KeyChain, error) {
+	if hd.IsEmpty() {
+		return &KeyChain{}, nil
+	}
+	master, err := w.keys.GetKey(hd.IDKeyMaster)
+	if err != nil {
+		return nil, err
+	}
+	return RestoreKeyChain(master, hd)
+}
+
+// KeyStore returns the wallet keys.
+func (w *Wallet) KeyStore() *KeyStore { return w.keys }
+
+// KeyPool returns the pool of pre-generated keys.
+func (w *Wallet) KeyPool() *KeyPool { return w.pool }
+
+// SetFeeEstimator sets the estimator used for fee rates when a send does
+// not give one.
+func (w *Wallet) SetFeeEstimator(e *mempool.FeeEstimator) {
+	w.mu.Lock()
+	w.fees = e
+	w.mu.Unlock()
+}
+
+// Run keeps the key pool topped up in the background while the wallet is
+// unlocked, checking every KeyPoolRefillInterval and right after Unlock. It
+// returns when stop is closed.
+func (w *Wallet) Run(stop <-chan struct{}) {
+	w.pool.Refill(stop, KeyPoolRefillInterval)
+}
+
+// Unlock unlocks the key store and has Run refill the key pool
+// (walletpassphrase).
+func (w *Wallet) Unlock(passphrase string, timeout time.Duration) error {
+	if err := w.keys.Unlock(passphrase, timeout); err != nil {
+		return err
+	}
+	if !w.pool.hasChain() {
+		hd, err := w.readHDConfiguration()
+		if err != nil {
+			return err
+		}
+		chain, err := w.restoreKeyChain(hd)
+		if err != nil {
+			return err
+		}
+		w.pool.setChain(chain)
+	}
+	w.pool.Wake()
+	return nil
+}
+
+// Lock locks the key store (walletlock).
+func (w *Wallet) Lock() { w.keys.Lock() }
+
+// IsMine reports whether the wallet can spend outputs paying to script.
+// Multisig scripts count only when the wallet holds every key, so a
+// co-signer cannot spend coins out from under it; partly owned P2SH
+// multisig outputs are watch-only instead.
+func (w *Wallet) IsMine(script []byte) bool {
+	class, data := coin.ExtractScript(script)
+	switch class {
+	case coin.PubKeyTy:
+		return w.keys.HaveKey(coin.SHA256RIPEMD160(data[0]))
+	case coin.PubKeyHashTy:
+		var id coin.IDKey
+		copy(id[:], data[0])
+		return w.keys.HaveKey(id)
+	case coin.MultiSigTy:
+		for _, pub := range data[1:] {
+			if !w.keys.HaveKey(coin.SHA256RIPEMD160(pub)) {
+				return false
+			}
+		}
+		return true
+	case coin.ScriptHashTy:
+		redeem, ok := w.redeemScript(data[0])
+		return ok && w.IsMine(redeem)
+	}
+	return false
+}
+
+// redeemScript returns the stored redeem script with hash id, refusing
+// nested P2SH.
+func (w *Wallet) redeemScript(id []byte) ([]byte, bool) {
+	var sid coin.IDScript
+	copy(sid[:], id)
+	redeem, err := w.keys.GetScript(sid)
+	if err != nil {
+		return nil, false
+	}
+	if class, _ := coin.ExtractScript(redeem); class == coin.ScriptHashTy {
+		return nil, false
+	}
+	return redeem, true
+}
+
+// AddTransaction stores wtx and marks the outputs it spends, replacing an
+// earlier record of the same transaction.
+func (w *Wallet) AddTransaction(wtx *WalletTx) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if old, ok := w.txs[wtx.Hash()]; ok {
+		wtx.OrderPos = old.OrderPos
+	} else {
+		wtx.OrderPos = w.nextOrderPos()
+	}
+	if err := w.update(func(tx *DBTx) error { return tx.WriteTx(wtx) }); err != nil {
+		return err
+	}
+	w.indexTx(wtx)
+	return nil
+}
+
+// removeTransaction removes a wallet transaction and its descendants,
+// marking the outputs it spent unspent again.
+func (w *Wallet) removeTransaction(hash string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.updateTx(func(dbtx *DBTx) error { return w.removeTx(dbtx, hash) })
+}
+
+// indexTx adds wtx to the in-memory maps. The caller must hold w.mu or
+// own w exclusively.
+func (w *Wallet) indexTx(wtx *WalletTx) {
+	hash := wtx.Hash()
+	w.txs[hash] = wtx
+	if wtx.Tx.IsCoinBase() {
+		return
+	}
+	for _, in := range wtx.Tx.Inputs {
+		w.spent[in.PreviousOut] = hash
+		delete(w.pending, in.PreviousOut)
+	}
+}
+
+// Transaction returns the wallet transaction with hash.
+func (w *Wallet) Transaction(hash string) (*WalletTx, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	wtx, ok := w.txs[hash]
+	return wtx, ok
+}
+
+// depth returns the confirmations of wtx. The caller must hold w.mu.
+func (w *Wallet) depth(wtx *WalletTx) int32 {
+	if !wtx.IsConfirmed() || wtx.BlockHeight > w.bestHeight {
+		return 0
+	}
+	return w.bestHeight - wtx.BlockHeight + 1
+}
+
+// Coins returns the unspent outputs the wallet can spend, including
+// unconfirmed and immature ones.
+func (w *Wallet) Coins() []Coin {
+	return w.coins(MineSpendable)
+}
+
+// WatchOnlyCoins returns the unspent watch-only outputs.
+func (w *Wallet) WatchOnlyCoins() []Coin {
+	return w.coins(MineWatchOnly)
+}
+
+// coins returns the unspent outputs selected by filter.
+func (w *Wallet) coins(filter MineFilter) []Coin {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var coins []Coin
+	for hash, wtx := range w.txs {
+		depth := w.depth(wtx)
+		for i, out := range wtx.Tx.Outputs {
+			op := coin.PointOut{Hash: hash, Index: uint32(i)}
+			if _, ok := w.spent[op]; ok || out.IsEmpty() || !w.isMineFilter(out.ScriptPubKey, filter) {
+				continue
+			}
+			coins = append(coins, Coin{
+				Out:       op,
+				Output:    out,
+				Depth:     depth,
+				CoinBase:  wtx.Tx.IsCoinBase(),
+				CoinStake: wtx.Tx.IsCoinStake(),
+			})
+		}
+	}
+	return coins
+}
+
+// LockCoin excludes out from coin selection until UnlockCoin. Locks are
+// not persisted.
+func (w *Wallet) LockCoin(out coin.PointOut) {
+	w.mu.Lock()
+	w.locked[out] = true
+	w.mu.Unlock()
+}
+
+// UnlockCoin makes out available to coin selection again.
+func (w *Wallet) UnlockCoin(out coin.PointOut) {
+	w.mu.Lock()
+	delete(w.locked, out)
+	w.mu.Unlock()
+}
+
+// IsLockedCoin reports whether out is locked, by LockCoin or by a
+// transaction between BuildTransaction and Commit or Cancel.
+func (w *Wallet) IsLockedCoin(out coin.PointOut) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.locked[out] || w.pending[out]
+}
+
+// reserveCoins locks outs for a pending transaction. It fails without
+// locking any when one of them is already pending, e.g. because a
+// concurrent build selected it.
+func (w *Wallet) reserveCoins(outs []coin.PointOut) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, out := range outs {
+		if w.pending[out] {
+			return ErrCoinsPending
+		}
+	}
+	for _, out := range outs {
+		w.pending[out] = true
+	}
+	return nil
+}
+
+// releaseCoins unlocks the outputs of a cancelled transaction.
+func (w *Wallet) releaseCoins(outs []coin.PointOut) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, out := range outs {
+		delete(w.pending, out)
+	}
+}
+
+// LockedCoins returns the locked outputs (listlockunspent).
+func (w *Wallet) LockedCoins() []coin.PointOut {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	outs := make([]coin.PointOut, 0, len(w.locked))
+	for out := range w.locked {
+		outs = append(outs, out)
+	}
+	sort.Slice(outs, func(i, j int) bool {
+		if outs[i].Hash != outs[j].Hash {
+			return outs[i].Hash < outs[j].Hash
+		}
+		return outs[i].Index < outs[j].Index
+	})
+	return outs
+}
+
+// prevScript returns the script of an output of a wallet transaction.
+func (w *Wallet) prevScript(out coin.PointOut) ([]byte, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	wtx, ok := w.txs[out.Hash]
+	if !ok || int(out.Index) >= len(wtx.Tx.Outputs) {
+		return nil, errors.New("unknown previous output " + out.Hash)
+	}
+	return wtx.Tx.Outputs[out.Index].ScriptPubKey, nil
+}
@@ -0,0 +1,82 @@
+package wallet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"pila/pkg/coin"
+)
+
+// WalletTx is a transaction relevant to the wallet together with where it
+// was confirmed (transaction_wallet).
+type WalletTx struct {
+	Tx coin.Transaction
+	// BlockHash is the hash of the containing block, empty while the
+	// transaction is unconfirmed.
+	BlockHash   string
+	BlockHeight int32
+	// TimeReceived is the unix time the wallet first saw the transaction.
+	TimeReceived int64
+	// FromAccount is the account debited by sends from this wallet.
+	FromAccount string
+	// OrderPos orders transactions and accounting entries for listing.
+	OrderPos int64
+}
+
+// Hash returns the transaction hash.
+func (w *WalletTx) Hash() string { return w.Tx.Hash() }
+
+// IsConfirmed reports whether the transaction is in a block.
+func (w *WalletTx) IsConfirmed() bool { return w.BlockHash != "" }
+
+// Encode writes the record encoding of w.
+func (w *WalletTx) Encode(wr io.Writer) error {
+	if err := w.Tx.Encode(wr); err != nil {
+		return err
+	}
+	if err := coin.WriteVarBytes(wr, []byte(w.BlockHash)); err != nil {
+		return err
+	}
+	if err := binary.Write(wr, binary.LittleEndian, w.BlockHeight); err != nil {
+		return err
+	}
+	if err := binary.Write(wr, binary.LittleEndian, w.TimeReceived); err != nil {
+		return err
+	}
+	if err := coin.WriteVarBytes(wr, []byte(w.FromAccount)); err != nil {
+		return err
+	}
+	return binary.Write(wr, binary.LittleEndian, w.OrderPos)
+}
+
+// Decode reads a record written by Encode.
+func (w *WalletTx) Decode(r io.Reader) error {
+	if err := w.Tx.Decode(r); err != nil {
+		return err
+	}
+	b, err := coin.ReadVarBytes(r)
+	if err != nil {
+		return err
+	}
+	w.BlockHash = string(b)
+	if err := binary.Read(r, binary.LittleEndian, &w.BlockHeight); err != nil {
+		return err
+	}
+	if err := binary.Read(r, binary.LittleEndian, &w.TimeReceived); err != nil {
+		return err
+	}
+	if b, err = coin.ReadVarBytes(r); err != nil {
+		return err
+	}
+	w.FromAccount = string(b)
+	return binary.Read(r, binary.LittleEndian, &w.OrderPos)
+}
+
+func (w *WalletTx) serialize() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := w.Encode(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,307 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"pila/pkg/coin"
+	"pila/pkg/coin/keys"
+)
+
+// WatchLookahead is the number of unused keys derived ahead on each branch
+// of a watched extended public key.
+const WatchLookahead = 20
+
+// MineFilter selects wallet outputs by how the wallet owns them
+// (isminefilter).
+type MineFilter uint8
+
+const (
+	// MineSpendable selects outputs the wallet holds the keys for.
+	MineSpendable MineFilter = 1 << iota
+	// MineWatchOnly selects watched outputs without keys.
+	MineWatchOnly
+	// MineAll selects both.
+	MineAll = MineSpendable | MineWatchOnly
+)
+
+// watchedXPub is an imported extended public key. Addresses are derived
+// on the receive branch xpub/0/n and the change branch xpub/1/n.
+type watchedXPub struct {
+	xpub        string
+	fingerprint uint32
+	branches    [2]*keys.ExtendedKey
+	// used is one past the highest index seen in a transaction.
+	used [2]uint32
+	// derived is the number of keys derived on each branch.
+	derived [2]uint32
+}
+
+// xpubKey locates a derived script.
+type xpubKey struct {
+	x      *watchedXPub
+	branch int
+	index  uint32
+	pub    []byte
+}
+
+// watchSet holds the watch-only scripts. It has its own lock because
+// IsWatched is called both with and without Wallet.mu held.
+type watchSet struct {
+	mu      sync.RWMutex
+	scripts map[string]bool
+	derived map[string]xpubKey
+	xpubs   []*watchedXPub
+}
+
+func newWatchSet() *watchSet {
+	return &watchSet{scripts: make(map[string]bool), derived: make(map[string]xpubKey)}
+}
+
+// has reports whether script is watched.
+func (s *watchSet) has(script []byte) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.scripts[string(script)] {
+		return true
+	}
+	_, ok := s.derived[string(script)]
+	return ok
+}
+
+// addXPub starts watching xpub, deriving keys up to used plus the
+// lookahead. It reports false when xpub is already watched.
+func (s *watchSet) addXPub(xpub string, used [2]uint32) (bool, error) {
+	key, err := keys.ParseExtendedKey(xpub)
+	if err != nil {
+		return false, err
+	}
+	if key.IsPrivate() {
+		return false, errors.New("watch-only import needs an extended public key")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, x := range s.xpubs {
+		if x.xpub == xpub {
+			return false, nil
+		}
+	}
+	x := &watchedXPub{xpub: xpub, fingerprint: key.Fingerprint(), used: used}
+	for i := range x.branches {
+		if x.branches[i], err = key.Child(uint32(i)); err != nil {
+			return false, err
+		}
+		if err := s.derive(x, i); err != nil {
+			return false, err
+		}
+	}
+	s.xpubs = append(s.xpubs, x)
+	return true, nil
+}
+
+// derive extends branch of x to the lookahead. The caller must hold s.mu.
+func (s *watchSet) derive(x *watchedXPub, branch int) error {
+	for x.derived[branch] < x.used[branch]+WatchLookahead {
+		index := x.derived[branch]
+		x.derived[branch]++
+		child, err := x.branches[branch].Child(index)
+		if err == keys.ErrInvalidChild {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		pub, err := child.PublicKey()
+		if err != nil {
+			return err
+		}
+		script := coin.PayToPubKeyHashScript(pub.ID())
+		s.derived[string(script)] = xpubKey{x: x, branch: branch, index: index, pub: pub.Bytes()}
+	}
+	return nil
+}
+
+// markUsed records that script appeared in a transaction. When it moves
+// the used index of a watched xpub, the xpub and its new counts are
+// returned for storing.
+func (s *watchSet) markUsed(script []byte) (string, [2]uint32, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, ok := s.derived[string(script)]
+	if !ok || k.index < k.x.used[k.branch] {
+		return "", [2]uint32{}, false, nil
+	}
+	k.x.used[k.branch] = k.index + 1
+	if err := s.derive(k.x, k.branch); err != nil {
+		return "", [2]uint32{}, false, err
+	}
+	return k.x.xpub, k.x.used, true, nil
+}
+
+// origin returns the derivation of pub from a watched xpub.
+func (s *watchSet) origin(pub []byte) (KeyOrigin, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	k, ok := s.derived[string(coin.PayToPubKeyHashScript(coin.SHA256RIPEMD160(pub)))]
+	if !ok {
+		return KeyOrigin{}, false
+	}
+	return KeyOrigin{Fingerprint: k.x.fingerprint, Path: []uint32{uint32(k.branch), k.index}}, true
+}
+
+// pubKey returns the public key of a derived P2PKH script.
+func (s *watchSet) pubKey(script []byte) ([]byte, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	k, ok := s.derived[string(script)]
+	return k.pub, ok
+}
+
+// changeScript returns the first unused change script of the first
+// watched xpub.
+func (s *watchSet) changeScript() ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if len(s.xpubs) == 0 {
+		return nil, errors.New("no change address for a watch-only transaction")
+	}
+	x := s.xpubs[0]
+	for index := x.used[1]; ; index++ {
+		child, err := x.branches[1].Child(index)
+		if err == keys.ErrInvalidChild {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		pub, err := child.PublicKey()
+		if err != nil {
+			return nil, err
+		}
+		return coin.PayToPubKeyHashScript(pub.ID()), nil
+	}
+}
+
+// loadWatch reads the watch-only scripts and xpubs. The caller must own w
+// exclusively.
+func (w *Wallet) loadWatch() error {
+	if w.db == nil {
+		return nil
+	}
+	return w.db.View(func(tx *DBTx) error {
+		err := tx.ForEachWatchScript(func(script []byte) error {
+			w.watch.scripts[string(script)] = true
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return tx.ForEachWatchXPub(func(xpub string, used [2]uint32) error {
+			_, err := w.watch.addXPub(xpub, used)
+			return err
+		})
+	})
+}
+
+// IsWatched reports whether outputs paying to script are watched without
+// being spendable by the wallet. P2SH outputs whose redeem script the
+// wallet stores, such as a multisig the wallet holds only some keys of,
+// are watched.
+func (w *Wallet) IsWatched(script []byte) bool {
+	if w.IsMine(script) {
+		return false
+	}
+	if w.watch.has(script) {
+		return true
+	}
+	class, data := coin.ExtractScript(script)
+	if class != coin.ScriptHashTy {
+		return false
+	}
+	_, ok := w.redeemScript(data[0])
+	return ok
+}
+
+// isMineFilter reports whether script is owned the way filter selects.
+func (w *Wallet) isMineFilter(script []byte, filter MineFilter) bool {
+	if filter&MineSpendable != 0 && w.IsMine(script) {
+		return true
+	}
+	return filter&MineWatchOnly != 0 && w.IsWatched(script)
+}
+
+// ImportScript watches outputs paying to script and files its address,
+// if it has one, under label. Past payments are found by a rescan.
+func (w *Wallet) ImportScript(script []byte, label string) error {
+	if len(script) == 0 {
+		return errors.New("empty script")
+	}
+	if w.IsMine(script) {
+		return errors.New("the wallet already contains the key for this script")
+	}
+	err := w.update(func(tx *DBTx) error { return tx.WriteWatchScript(script) })
+	if err != nil {
+		return err
+	}
+	w.watch.mu.Lock()
+	w.watch.scripts[string(script)] = true
+	w.watch.mu.Unlock()
+	if address := scriptAddress(script); address != "" {
+		return w.SetLabel(address, label)
+	}
+	return nil
+}
+
+// ImportAddress watches address (importaddress). Past payments are found
+// by a rescan.
+func (w *Wallet) ImportAddress(address, label string) error {
+	var a coin.Address
+	if !a.SetString(address) || !a.IsValid() {
+		return fmt.Errorf("invalid address %q", address)
+	}
+	script, err := coin.PayToDestinationScript(a.Get())
+	if err != nil {
+		return err
+	}
+	return w.ImportScript(script, label)
+}
+
+// ImportXPub watches the addresses derived from an extended public key,
+// keeping WatchLookahead unused addresses ahead on the receive and change
+// branches. Past payments are found by a rescan.
+func (w *Wallet) ImportXPub(xpub string) error {
+	added, err := w.watch.addXPub(xpub, [2]uint32{})
+	if err != nil || !added {
+		return err
+	}
+	return w.update(func(tx *DBTx) error { return tx.WriteWatchXPub(xpub, [2]uint32{}) })
+}
+
+// WatchedXPubs returns the watched extended public keys.
+func (w *Wallet) WatchedXPubs() []string {
+	w.watch.mu.RLock()
+	defer w.watch.mu.RUnlock()
+	out := make([]string, len(w.watch.xpubs))
+	for i, x := range w.watch.xpubs {
+		out[i] = x.xpub
+	}
+	return out
+}
+
+// markWatchUsed advances the watched xpubs past the scripts tx pays to.
+// The caller must hold w.mu.
+func (w *Wallet) markWatchUsed(dbtx *DBTx, tx coin.Transaction) error {
+	for _, out := range tx.Outputs {
+		xpub, used, moved, err := w.watch.markUsed(out.ScriptPubKey)
+		if err != nil {
+			return err
+		}
+		if moved && dbtx != nil {
+			if err := dbtx.WriteWatchXPub(xpub, used); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package wallet
+
+import (
+	"testing"
+
+	"pila/pkg/coin"
+	"pila/pkg/coin/keys"
+	"pila/pkg/database"
+)
+
+func TestWatchOnly(t *testing.T) {
+	master, err := keys.NewMasterKey(make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	receive := func(i uint32) *keys.PrivateKey {
+		child, err := master.Derive("m/0")
+		if err == nil {
+			child, err = child.Child(i)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		k, _ := child.PrivateKey()
+		return k
+	}
+	cold, coldAddress := testAddress(t)
+
+	db, _ := NewDB(database.NewMemStore())
+	cfg := DefaultConfig()
+	cfg.KeyPoolSize = 1
+	w, err := New(db, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.ImportXPub(master.String()); err == nil {
+		t.Fatalf("extended private key accepted")
+	}
+	if err := w.ImportXPub(master.Public().String()); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.ImportAddress(coldAddress, "cold"); err != nil {
+		t.Fatal(err)
+	}
+
+	pay := func(k *keys.PrivateKey, value int64, version uint32) coin.Transaction {
+		script := coin.PayToPubKeyHashScript(k.PubKey().ID())
+		return coin.Transaction{Version: version, Outputs: []coin.TxOut{{Value: value, ScriptPubKey: script}}}
+	}
+	// Index 30 is past the lookahead until index 19 has been used.
+	chain := testChain{}.
+		add(coinbaseTo(nil, 0, 0), pay(receive(19), 4*coin.Coin, 1), pay(cold, 3*coin.Coin, 2)).
+		add(coinbaseTo(nil, 0, 1), pay(receive(30), 2*coin.Coin, 3))
+	if err := w.Sync(chain); err != nil {
+		t.Fatal(err)
+	}
+	if b := w.Balance(); b.Confirmed != 0 {
+		t.Fatalf("watch-only funds reported as spendable: %+v", b)
+	}
+	if b := w.WatchOnlyBalance(); b.Confirmed != 9*coin.Coin {
+		t.Fatalf("watch-only balance %+v", b)
+	}
+
+	_, dest := testAddress(t)
+	u, err := w.BuildUnsignedTransaction([]Recipient{{Address: dest, Amount: 5 * coin.Coin}}, BuildOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Fee < coin.MinTxFee {
+		t.Fatalf("fee %d", u.Fee)
+	}
+	signer := NewKeyList(receive(19), receive(30), cold)
+	complete, err := SignRawTransaction(&u.Tx, u.PrevScripts, signer, 0)
+	if err != nil || !complete {
+		t.Fatalf("offline signing complete=%v: %v", complete, err)
+	}
+	if _, err := w.BuildTransaction([]Recipient{{Address: dest, Amount: coin.Coin}}, BuildOptions{}); err != ErrInsufficientFunds {
+		t.Fatalf("spent watch-only funds with keys: %v", err)
+	}
+
+	// The watch list and xpub progress survive a reload.
+	cfg.Rescan = true
+	reloaded, err := New(db, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reloaded.Sync(chain); err != nil {
+		t.Fatal(err)
+	}
+	if b := reloaded.WatchOnlyBalance(); b.Confirmed != 9*coin.Coin || len(reloaded.WatchedXPubs()) != 1 {
+		t.Fatalf("reloaded watch-only balance %+v", b)
+	}
+}